@@ -0,0 +1,82 @@
+// bolt2sqlite copies all users from bbolt database into SQLite database.
+//
+// Usage: bolt2sqlite <bolt db path> <sqlite db path> [tgbot config path]
+//
+// Encrypted texts are copied only if the config of the bot is passed,
+// its secret and keyring_path are used to decrypt and encrypt data keys of users.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/pechorka/adhd-reader/internal/storage"
+	"github.com/pechorka/adhd-reader/pkg/encryptor"
+)
+
+// config is the part of the tgbot config with encryption settings
+type config struct {
+	Secret      string `json:"secret"`
+	KeyringPath string `json:"keyring_path"`
+}
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	if len(os.Args) != 3 && len(os.Args) != 4 {
+		return fmt.Errorf("usage: %s <bolt db path> <sqlite db path> [tgbot config path]", os.Args[0])
+	}
+	var keys *encryptor.Encryptor
+	if len(os.Args) == 4 {
+		var err error
+		if keys, err = openEncryptor(os.Args[3]); err != nil {
+			return err
+		}
+	}
+	src, err := storage.NewStorage(os.Args[1])
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := storage.NewSQLiteStorage(os.Args[2])
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	if keys != nil {
+		src.SetKeyWrapper(keys)
+		dst.SetKeyWrapper(keys)
+	}
+
+	copied, err := storage.CopyUsers(dst, src)
+	if err != nil {
+		return fmt.Errorf("copied %d users before error: %w", copied, err)
+	}
+	fmt.Printf("copied %d users\n", copied)
+	return nil
+}
+
+// openEncryptor opens the encryptor the same way as tgbot does
+func openEncryptor(cfgPath string) (*encryptor.Encryptor, error) {
+	data, err := os.ReadFile(cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	var cfg config
+	if err = json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Secret == "" {
+		return nil, fmt.Errorf("secret is not set in %s", cfgPath)
+	}
+	if cfg.KeyringPath == "" {
+		return encryptor.NewEncryptor(cfg.Secret), nil
+	}
+	return encryptor.LoadEncryptor(cfg.Secret, cfg.KeyringPath)
+}
@@ -3,9 +3,13 @@ package bot
 import (
 	"bytes"
 	"encoding/csv"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pechorka/adhd-reader/pkg/sizeconverter"
 )
 
 func (b *Bot) analytics(msg *tgbotapi.Message) {
@@ -57,3 +61,96 @@ Average ChunkSize among users: %d`,
 		),
 	))
 }
+
+func (b *Bot) collectGarbage(msg *tgbotapi.Message) {
+	report, err := b.service.CollectGarbage()
+	if err != nil {
+		b.replyError(msg, "could not collect garbage", err)
+		return
+	}
+	b.send(tgbotapi.NewMessage(msg.Chat.ID,
+		fmt.Sprintf(`
+Removed texts: %d
+Removed processed files: %d
+Database size: %s -> %s
+Reclaimed: %s`,
+			report.Texts,
+			report.Files,
+			sizeconverter.HumanReadableSizeInMB(report.SizeBefore),
+			sizeconverter.HumanReadableSizeInMB(report.SizeAfter),
+			sizeconverter.HumanReadableSizeInMB(report.Reclaimed()),
+		),
+	))
+}
+
+var errSnapshotsDisabled = errors.New("snapshots directory is not configured")
+
+// maxListedSnapshots keeps the list of snapshots in one message
+const maxListedSnapshots = 50
+
+func (b *Bot) takeSnapshot(msg *tgbotapi.Message) {
+	if b.snapshots == nil {
+		b.replyError(msg, "could not take snapshot", errSnapshotsDisabled)
+		return
+	}
+	snapshot, err := b.snapshots.Take()
+	if err != nil {
+		b.replyError(msg, "could not take snapshot", err)
+		return
+	}
+	b.send(tgbotapi.NewMessage(msg.Chat.ID,
+		fmt.Sprintf("Snapshot %s taken, size %s", snapshot.Name, sizeconverter.HumanReadableSizeInMB(snapshot.Size)),
+	))
+}
+
+func (b *Bot) listSnapshots(msg *tgbotapi.Message) {
+	if b.snapshots == nil {
+		b.replyError(msg, "could not list snapshots", errSnapshotsDisabled)
+		return
+	}
+	snapshots, err := b.snapshots.List()
+	if err != nil {
+		b.replyError(msg, "could not list snapshots", err)
+		return
+	}
+	if len(snapshots) == 0 {
+		b.send(tgbotapi.NewMessage(msg.Chat.ID, "No snapshots"))
+		return
+	}
+	var sb strings.Builder
+	if len(snapshots) > maxListedSnapshots {
+		fmt.Fprintf(&sb, "%d newest of %d snapshots:\n", maxListedSnapshots, len(snapshots))
+		snapshots = snapshots[:maxListedSnapshots]
+	}
+	for _, snapshot := range snapshots {
+		fmt.Fprintf(&sb, "%s, %s, %s\n",
+			snapshot.Name,
+			snapshot.CreatedAt.Format(time.DateTime),
+			sizeconverter.HumanReadableSizeInMB(snapshot.Size),
+		)
+	}
+	sb.WriteString("\nSet restore_snapshot in config to restore one of them on the next start")
+	b.send(tgbotapi.NewMessage(msg.Chat.ID, sb.String()))
+}
+
+func (b *Bot) rotateKey(msg *tgbotapi.Message) {
+	version, count, err := b.service.RotateEncryptionKey()
+	if err != nil {
+		b.replyError(msg, "could not rotate encryption key", err)
+		return
+	}
+	b.send(tgbotapi.NewMessage(msg.Chat.ID,
+		fmt.Sprintf("Encryption key is rotated to version %d, data keys of %d users are re-encrypted. Tokens issued before stay valid until /retirekeys", version, count),
+	))
+}
+
+func (b *Bot) retireKeys(msg *tgbotapi.Message) {
+	version, count, err := b.service.RetireEncryptionKeys()
+	if err != nil {
+		b.replyError(msg, "could not retire encryption keys", err)
+		return
+	}
+	b.send(tgbotapi.NewMessage(msg.Chat.ID,
+		fmt.Sprintf("Keys up to version %d are retired, data keys of %d users are re-encrypted. Tokens issued with retired keys are invalid and have to be reissued", version, count),
+	))
+}
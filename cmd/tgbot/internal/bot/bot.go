@@ -1,13 +1,15 @@
 package bot
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
 	"unicode/utf8"
 
 	"log"
+	"math"
 	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -24,9 +26,12 @@ import (
 	"github.com/pechorka/adhd-reader/pkg/fileparser/pdf"
 	"github.com/pechorka/adhd-reader/pkg/fileparser/plaintext"
 	"github.com/pechorka/adhd-reader/pkg/i18n"
+	"github.com/pechorka/adhd-reader/pkg/markup"
+	"github.com/pechorka/adhd-reader/pkg/notesexport"
 	"github.com/pechorka/adhd-reader/pkg/queue"
 	"github.com/pechorka/adhd-reader/pkg/runeslice"
 	"github.com/pechorka/adhd-reader/pkg/sizeconverter"
+	"github.com/pechorka/adhd-reader/pkg/snapshot"
 	"github.com/pechorka/adhd-reader/pkg/webscraper"
 )
 
@@ -37,11 +42,33 @@ const (
 	prevChunk  = "prev-chunk"
 	rereadText = "reread-text:"
 	nextPage   = "next-page:"
+
+	bookmarkChunk  = "bookmark-chunk"
+	bookmarkOpen   = "bookmark-open:"
+	bookmarkDelete = "bookmark-delete:"
+
+	searchOpen = "search-open:"
+
+	textState = "text-state:"
+
+	listTag     = "list-tag:"
+	listOptions = "list-options"
+	listSort    = "list-sort:"
+	listFilter  = "list-filter:"
+
+	reminderDelete = "reminder-delete:"
+
+	statSpend = "stat-spend:"
 )
 
 const (
 	defaultMaxFileSize = 20 * 1024 * 1024 // 20 MB
 	defaultPageSize    = 40
+	searchPageSize     = 10
+
+	maxButtonTextLength     = 60
+	maxListButtonTextLength = 97
+	maxQuotePreviewLength   = 200
 )
 
 type Bot struct {
@@ -52,6 +79,7 @@ type Bot struct {
 	i18n        *i18n.Localies
 	maxFileSize int
 	adminUsers  map[int64]struct{}
+	snapshots   *snapshot.Manager
 }
 
 type Config struct {
@@ -62,6 +90,7 @@ type Config struct {
 	I18n        *i18n.Localies
 	MaxFileSize int
 	AdminUsers  []int64
+	Snapshots   *snapshot.Manager // optional, snapshot commands are disabled without it
 }
 
 func NewBot(cfg Config) (*Bot, error) {
@@ -91,6 +120,7 @@ func NewBot(cfg Config) (*Bot, error) {
 		i18n:        cfg.I18n,
 		maxFileSize: cfg.MaxFileSize,
 		adminUsers:  adminUsers,
+		snapshots:   cfg.Snapshots,
 	}, nil
 }
 
@@ -150,6 +180,11 @@ func (b *Bot) handleMsg(msg *tgbotapi.Message) {
 	defer b.handlePanic(msg.From)
 
 	if msg.Document != nil {
+		// backup is sent with /restore in caption
+		if args, ok := strings.CutPrefix(msg.Caption, "/restore"); ok {
+			b.restore(msg, args)
+			return
+		}
 		b.saveTextFromDocument(msg)
 		return
 	}
@@ -165,6 +200,22 @@ func (b *Bot) handleMsg(msg *tgbotapi.Message) {
 		b.onPageCommand(msg)
 	case cmd == "chunk":
 		b.chunk(msg)
+	case cmd == "speed":
+		b.speed(msg)
+	case cmd == "bookmarks":
+		b.bookmarks(msg)
+	case cmd == "search":
+		b.search(msg)
+	case cmd == "tag":
+		b.tag(msg)
+	case cmd == "untag":
+		b.untag(msg)
+	case cmd == "tags":
+		b.tags(msg)
+	case cmd == "state":
+		b.state(msg)
+	case cmd == "quote":
+		b.quote(msg)
 	case cmd == "delete":
 		b.delete(msg)
 	case cmd == "rename":
@@ -173,6 +224,20 @@ func (b *Bot) handleMsg(msg *tgbotapi.Message) {
 		b.download(msg)
 	case cmd == "cdownload":
 		b.cdownload(msg)
+	case cmd == "epub":
+		b.epub(msg)
+	case cmd == "restore":
+		b.restore(msg, msg.CommandArguments())
+	case cmd == "export":
+		b.export(msg)
+	case cmd == "encrypt":
+		b.encrypt(msg)
+	case cmd == "remind":
+		b.remind(msg)
+	case cmd == "goal":
+		b.goal(msg)
+	case cmd == "history":
+		b.history(msg)
 	case cmd == "help":
 		b.help(msg)
 	case strings.HasPrefix(cmd, "random"):
@@ -183,6 +248,8 @@ func (b *Bot) handleMsg(msg *tgbotapi.Message) {
 		b.loot(msg)
 	case cmd == "stats":
 		b.stats(msg)
+	case cmd == "craft":
+		b.craft(msg)
 	default:
 		if cmd != "" {
 			if b.handleAdminMsg(msg) {
@@ -198,12 +265,28 @@ func (b *Bot) handleMsg(msg *tgbotapi.Message) {
 	// command for bot father to add command help
 	/*
 		/setcommands
-		list - list all texts
+		progress - reading statistics and time to finish texts
+		list - list texts, pass sort (recent, newest, closest, longest, name) or filter (#tag, state, unfinished, started, unopened, file, url, text, all) to change the list
 		page - set page number, pass page number as argument
-		chunk - set chunk size, pass chunk size as argument
+		chunk - set chunk size, pass chunk size or reading time (e.g. 2m) as argument
+		speed - set reading speed, pass characters per minute or auto as argument
+		bookmarks - list bookmarks
+		search - find chunks of your texts, pass words to search as argument
+		tag - add tags to the current text, pass #tags as argument
+		untag - remove tags from the current text, pass #tags as argument
+		tags - list tags
+		state - change state of the current text
+		quote - save current chunk or replied fragment as quote, pass note as argument
 		delete - delete text, pass text name as argument
 		rename - rename text, pass new name as argument
-		download - download all texts in json format
+		epub - download current text as epub book
+		download - download backup of all texts and progress
+		restore - restore backup, send it with /restore caption or reply to it, pass replace to replace current texts
+		export - download quotes and notes, pass md, csv or json as argument
+		encrypt - encrypt texts and notes, pass off to turn it off
+		remind - get the next chunk on schedule, e.g. weekdays 08:30 Europe/Moscow
+		goal - set daily reading goal in chunks or minutes, e.g. 10 or 15m
+		history - reading history and streaks
 		help - troubleshooting and support
 	*/
 }
@@ -215,6 +298,16 @@ func (b *Bot) handleAdminMsg(msg *tgbotapi.Message) bool {
 	switch cmd := msg.Command(); cmd {
 	case "analytics":
 		b.analytics(msg)
+	case "gc":
+		b.collectGarbage(msg)
+	case "snapshot":
+		b.takeSnapshot(msg)
+	case "snapshots":
+		b.listSnapshots(msg)
+	case "rotatekey":
+		b.rotateKey(msg)
+	case "retirekeys":
+		b.retireKeys(msg)
 	default:
 		return false
 	}
@@ -238,6 +331,28 @@ func (b *Bot) handleCallback(cb *tgbotapi.CallbackQuery) {
 		b.rereadText(cb)
 	case strings.HasPrefix(cb.Data, nextPage):
 		b.nextListPage(cb)
+	case cb.Data == bookmarkChunk:
+		b.bookmarkChunk(cb.From)
+	case strings.HasPrefix(cb.Data, bookmarkOpen):
+		b.openBookmark(cb.From, strings.TrimPrefix(cb.Data, bookmarkOpen))
+	case strings.HasPrefix(cb.Data, bookmarkDelete):
+		b.deleteBookmark(cb.From, strings.TrimPrefix(cb.Data, bookmarkDelete))
+	case strings.HasPrefix(cb.Data, searchOpen):
+		b.openSearchResult(cb.From, strings.TrimPrefix(cb.Data, searchOpen))
+	case strings.HasPrefix(cb.Data, textState):
+		b.setTextState(cb.From, strings.TrimPrefix(cb.Data, textState))
+	case strings.HasPrefix(cb.Data, listTag):
+		b.updateList(cb.From, "#"+strings.TrimPrefix(cb.Data, listTag))
+	case cb.Data == listOptions:
+		b.showListOptions(cb.From)
+	case strings.HasPrefix(cb.Data, listSort):
+		b.updateList(cb.From, strings.TrimPrefix(cb.Data, listSort))
+	case strings.HasPrefix(cb.Data, listFilter):
+		b.toggleListFilter(cb.From, strings.TrimPrefix(cb.Data, listFilter))
+	case strings.HasPrefix(cb.Data, reminderDelete):
+		b.deleteReminder(cb.From, strings.TrimPrefix(cb.Data, reminderDelete))
+	case strings.HasPrefix(cb.Data, statSpend):
+		b.spendFreePoint(cb.From, strings.TrimPrefix(cb.Data, statSpend))
 	}
 	// Respond to the callback query, telling Telegram to show the user
 	// a message with the data received.
@@ -305,7 +420,29 @@ func (b *Bot) nextChunk(from *tgbotapi.User) {
 		}
 	}
 
-	b.chunkReply(from, b.service.NextChunk)
+	var read bool
+	b.chunkReply(from, func(userID int64) (storage.Text, string, service.ChunkType, error) {
+		text, chunk, chunkType, err := b.service.NextChunk(userID)
+		read = err == nil
+		return text, chunk, chunkType, err
+	})
+	if read {
+		b.celebrateStreak(from)
+	}
+}
+
+// celebrateStreak congratulates the user if the shown chunk made the streak reach a milestone
+func (b *Bot) celebrateStreak(from *tgbotapi.User) {
+	milestone, err := b.service.StreakMilestone(from.ID)
+	if err != nil {
+		log.Println("Failed to check streak milestone: ", err)
+		return
+	}
+	if milestone > 0 {
+		b.replyToUserWithI18nWithArgs(from, streakMilestoneMsgId, map[string]string{
+			"days": strconv.Itoa(milestone),
+		})
+	}
 }
 
 func (b *Bot) prevChunk(from *tgbotapi.User) {
@@ -327,7 +464,9 @@ func (b *Bot) rereadText(cb *tgbotapi.CallbackQuery) {
 }
 
 func (b *Bot) nextListPage(cb *tgbotapi.CallbackQuery) {
-	page, err := strconv.Atoi(strings.TrimPrefix(cb.Data, nextPage))
+	// buttons sent before list options were saved have filter after the page number
+	strPage, _, _ := strings.Cut(strings.TrimPrefix(cb.Data, nextPage), ":")
+	page, err := strconv.Atoi(strPage)
 	if err != nil {
 		b.replyErrorToUserWithI18n(cb.From, errorOnParsingListPageMsgId, err)
 		return
@@ -343,6 +482,7 @@ func (b *Bot) chunkReply(from *tgbotapi.User, chunkSelector chunkSelectorFunc) {
 	nextBtn := tgbotapi.NewInlineKeyboardButtonData(b.getText(from, nextButtonMsgId), nextChunk)
 	deleteBtn := tgbotapi.NewInlineKeyboardButtonData(b.getText(from, deleteButtonMsgId), deleteText+currentText.UUID)
 	rereadBtn := tgbotapi.NewInlineKeyboardButtonData(b.getText(from, rereadButtonMsgId), rereadText+currentText.UUID)
+	bookmarkBtn := tgbotapi.NewInlineKeyboardButtonData(b.getText(from, bookmarkButtonMsgId), bookmarkChunk)
 	// #29 TODO code for reread button
 	switch err {
 	case service.ErrFirstChunk:
@@ -365,24 +505,111 @@ func (b *Bot) chunkReply(from *tgbotapi.User, chunkSelector chunkSelectorFunc) {
 		return
 	}
 
-	const maxTelegramMessageSize = 4096
-	for len(chunkText) > maxTelegramMessageSize {
-		curMsgText := runeslice.NRunes(chunkText, maxTelegramMessageSize/2)
-		chunkText = chunkText[len(curMsgText):]
-
-		b.replyWithPlainText(from, curMsgText)
-	}
-
 	switch chunkType {
 	case service.ChunkTypeFirst:
-		b.replyWithPlainText(from, chunkText, nextBtn)
+		b.sendChunk(from, currentText.UUID, chunkText, nextBtn, bookmarkBtn)
 	case service.ChunkTypeLast:
-		b.replyWithPlainText(from, chunkText)
+		b.sendChunk(from, currentText.UUID, chunkText, bookmarkBtn)
 		b.replyToUserWithI18nWithArgs(from, lastChunkMsgId, map[string]string{
 			"text_name": currentText.Name,
 		}, prevBtn, deleteBtn, rereadBtn)
 	default:
-		b.replyWithPlainText(from, chunkText, prevBtn, nextBtn)
+		b.sendChunk(from, currentText.UUID, chunkText, prevBtn, nextBtn, bookmarkBtn)
+	}
+}
+
+// sendChunk sends chunk text as html messages and images from it as photos.
+// Buttons are attached to the last message.
+func (b *Bot) sendChunk(to *tgbotapi.User, textUUID, chunkText string, buttons ...tgbotapi.InlineKeyboardButton) {
+	parts := splitChunkMessages(markup.Segments(chunkText))
+	for i, part := range parts {
+		var partButtons []tgbotapi.InlineKeyboardButton
+		if i == len(parts)-1 {
+			partButtons = buttons
+		}
+		if len(part) == 1 && part[0].Type == markup.SegmentImage {
+			b.sendImage(to, textUUID, part[0], partButtons...)
+			continue
+		}
+		b.sendToUser(to.ID, markup.TelegramHTML(part), partButtons...)
+	}
+}
+
+// splitChunkMessages groups chunk segments into messages. Every image is a separate message,
+// text between images is split into messages that fit into Telegram message size limit.
+func splitChunkMessages(segments []markup.Segment) [][]markup.Segment {
+	const maxMessageLength = 4096 / 2 // leave space for new lines and entities
+	var (
+		messages [][]markup.Segment
+		current  []markup.Segment
+		length   int
+	)
+	flush := func() {
+		var text strings.Builder
+		for _, s := range current {
+			text.WriteString(s.PlainText())
+		}
+		if strings.TrimSpace(text.String()) != "" {
+			messages = append(messages, current)
+		}
+		current, length = nil, 0
+	}
+	for _, segment := range segments {
+		switch segment.Type {
+		case markup.SegmentImage:
+			flush()
+			messages = append(messages, []markup.Segment{segment})
+			continue
+		case markup.SegmentLink:
+			linkLength := utf8.RuneCountInString(segment.PlainText())
+			if length+linkLength > maxMessageLength {
+				flush()
+			}
+			current = append(current, segment)
+			length += linkLength
+			continue
+		}
+		for segment.Text != "" {
+			part := segment
+			part.Text = runeslice.NRunes(segment.Text, maxMessageLength-length)
+			segment.Text = segment.Text[len(part.Text):]
+			if part.Text != "" {
+				current = append(current, part)
+				length += utf8.RuneCountInString(part.PlainText())
+			}
+			if length >= maxMessageLength {
+				flush()
+			}
+		}
+	}
+	flush()
+	return messages
+}
+
+// sendImage sends image as a photo. Image that can't be sent is replaced with its alternative text,
+// so buttons attached to it are never lost.
+func (b *Bot) sendImage(to *tgbotapi.User, textUUID string, image markup.Segment, buttons ...tgbotapi.InlineKeyboardButton) {
+	fallback := strings.TrimSpace("🖼 " + image.Text)
+	var file tgbotapi.RequestFileData = tgbotapi.FileURL(image.URL)
+	if name, ok := markup.AttachmentName(image.URL); ok {
+		content, err := b.service.GetAttachment(to.ID, textUUID, name)
+		if err != nil {
+			log.Printf("failed to get attachment %s of text %s: %v", name, textUUID, err)
+			b.replyWithPlainText(to, fallback, buttons...)
+			return
+		}
+		file = tgbotapi.FileBytes{Name: name, Bytes: content}
+	}
+	photo := tgbotapi.NewPhoto(to.ID, file)
+	const maxTelegramCaptionSize = 1024
+	photo.Caption = runeslice.NRunes(image.Text, maxTelegramCaptionSize)
+	if len(buttons) > 0 {
+		photo.ReplyMarkup = buildReplyMarkup(buttons...)
+	}
+	if _, err := b.bot.Send(photo); err != nil {
+		// telegram fails to fetch some urls or rejects the image
+		log.Printf("failed to send image %s of text %s: %v", image.URL, textUUID, err)
+		b.replyWithPlainText(to, fallback, buttons...)
 	}
 }
 
@@ -427,52 +654,451 @@ func (b *Bot) start(msg *tgbotapi.Message) {
 	}()
 }
 
+// progressTexts is how many texts /progress shows with time to finish
+const progressTexts = 5
+
+// progress shows reading statistics: what is read by periods, when the user reads and time to finish texts
 func (b *Bot) progress(msg *tgbotapi.Message) {
-	curText, err := b.service.GetCurrentText(msg.From.ID)
+	stats, err := b.service.ReadingStats(msg.From.ID)
 	if err != nil {
-		b.replyError(msg, "failed to get current text", err)
+		b.replyErrorWithI18n(msg, errorOnGettingStatsMsgId, err)
 		return
 	}
+	periods := make([]string, 0, 4)
+	for _, period := range []struct {
+		nameID string
+		stats  service.PeriodStats
+	}{
+		{progressTodayMsgId, stats.Today},
+		{progressWeekMsgId, stats.Week},
+		{progressMonthMsgId, stats.Month},
+		{progressTotalMsgId, stats.Total},
+	} {
+		periods = append(periods, b.getTextWithArgs(msg.From, progressPeriodMsgId, map[string]string{
+			"period":   b.getText(msg.From, period.nameID),
+			"chunks":   strconv.FormatInt(period.stats.Chunks, 10),
+			"words":    strconv.FormatInt(period.stats.Words, 10),
+			"duration": b.duration(msg.From, period.stats.Seconds),
+		}))
+	}
+	peakHour := b.getText(msg.From, progressNoReadingMsgId)
+	if hour := stats.PeakHour(); hour >= 0 {
+		peakHour = b.getTextWithArgs(msg.From, progressPeakHourMsgId, map[string]string{
+			"hour": strconv.Itoa(hour),
+		})
+	}
+	texts := make([]string, 0, progressTexts)
+	for _, text := range stats.Texts[:min(len(stats.Texts), progressTexts)] {
+		marker := "▪️"
+		if text.Current {
+			marker = "📖"
+		}
+		texts = append(texts, b.getTextWithArgs(msg.From, progressTextMsgId, map[string]string{
+			"marker":    marker,
+			"text_name": html.EscapeString(text.Name),
+			"percent":   strconv.Itoa(text.Percent),
+			"duration":  b.duration(msg.From, text.RemainingSeconds),
+		}))
+	}
+	if len(texts) == 0 {
+		texts = append(texts, b.getText(msg.From, progressNoTextsMsgId))
+	}
+	b.replyToMsgWithI18nWithArgs(msg, onProgressMsgId, map[string]string{
+		"periods":        strings.Join(periods, "\n"),
+		"finished_texts": strconv.Itoa(stats.FinishedTexts),
+		"timezone":       stats.Timezone,
+		"hours":          hoursChart(stats.Hours),
+		"peak_hour":      peakHour,
+		"texts":          strings.Join(texts, "\n"),
+	})
+}
+
+// duration formats seconds as minutes or hours and minutes
+func (b *Bot) duration(from *tgbotapi.User, seconds int64) string {
+	minutes := (seconds + 30) / 60
+	if minutes < 60 {
+		return b.getTextWithArgs(from, durationMinutesMsgId, map[string]string{
+			"minutes": strconv.FormatInt(minutes, 10),
+		})
+	}
+	return b.getTextWithArgs(from, durationHoursMsgId, map[string]string{
+		"hours":   strconv.FormatInt(minutes/60, 10),
+		"minutes": strconv.FormatInt(minutes%60, 10),
+	})
+}
 
-	progress := float32(curText.CurrentChunk) / float32(curText.TotalChunks) * 100.0
-	progressText := fmt.Sprintf(
-		"Current text is <code>%s</code>.\nCurrent chunk is %d/%d. You completed %.0f%% of the text.",
-		curText.Name, curText.CurrentChunk, curText.TotalChunks, progress,
-	)
-	replyMsg := tgbotapi.NewMessage(msg.From.ID, progressText)
-	b.sendMsg(replyMsg)
+// hoursChart draws reading time by hours of the day as a bar for every hour
+func hoursChart(hours [24]int64) string {
+	bars := []rune("▁▂▃▄▅▆▇█")
+	most := slices.Max(hours[:])
+	var sb strings.Builder
+	for _, seconds := range hours {
+		level := 0
+		if most > 0 {
+			level = int(math.Ceil(float64(seconds) / float64(most) * float64(len(bars)-1)))
+		}
+		sb.WriteRune(bars[level])
+	}
+	return sb.String()
 }
 
+// listCmd lists texts, arguments change sort and filter of the list
 func (b *Bot) listCmd(msg *tgbotapi.Message) {
+	if args := msg.CommandArguments(); strings.TrimSpace(args) != "" {
+		b.updateList(msg.From, args)
+		return
+	}
 	b.list(msg.From, 1, defaultPageSize)
 }
 
+// list shows the page of texts sorted and filtered as the user chose
 func (b *Bot) list(from *tgbotapi.User, page, pageSize int) {
-	texts, more, err := b.service.ListTexts(from.ID, page, pageSize)
+	options, err := b.service.ListOptions(from.ID)
+	if err != nil {
+		b.replyErrorToUserWithI18n(from, errorOnListMsgId, err)
+		return
+	}
+	texts, more, err := b.service.ListTexts(from.ID, options, page, pageSize)
 	if err != nil {
 		b.replyErrorToUserWithI18n(from, errorOnListMsgId, err)
 		return
 	}
+	optionsBtn := tgbotapi.NewInlineKeyboardButtonData(b.getText(from, listOptionsButtonMsgId), listOptions)
 	if len(texts) == 0 {
+		if !options.Filter.IsEmpty() {
+			b.replyToUserWithI18nWithArgs(from, warningNoTextsMatchFilterMsgId, map[string]string{
+				"filter": html.EscapeString(options.Filter.String()),
+			}, optionsBtn)
+			return
+		}
 		b.replyToUserWithI18n(from, warningNoTextsMsgId)
 		return
 	}
 	// reply with button for each text and save text index in callback data
 	var buttons []tgbotapi.InlineKeyboardButton
 	for _, t := range texts {
-		// TODO: split text based on runes, not bytes
 		btnText := completionPercentString(t.CompletionPercent) + " " + t.Name
-		if len(btnText) > 100 {
-			btnText = btnText[:97] + "..."
+		if short := runeslice.NRunes(btnText, maxListButtonTextLength); len(short) < len(btnText) {
+			btnText = short + "..."
 		}
 		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(btnText, textSelect+t.UUID))
 	}
 	if more {
 		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(b.getText(from, nextButtonMsgId), nextPage+strconv.Itoa(page+1)))
 	}
+	buttons = append(buttons, optionsBtn)
 	b.replyToUserWithI18n(from, onListMsgId, buttons...)
 }
 
+// updateList changes sort and filter of the list with words like "recent #articles" and shows the list
+func (b *Bot) updateList(from *tgbotapi.User, words string) {
+	if _, err := b.service.UpdateListOptions(from.ID, words); err != nil {
+		b.replyErrorToUserWithI18n(from, errorOnParsingTextFilterMsgId, err)
+		return
+	}
+	b.list(from, 1, defaultPageSize)
+}
+
+// listFilters are filters that can be switched with buttons
+var listFilters = []string{
+	string(service.ProgressUnfinished),
+	string(service.ProgressStarted),
+	string(service.ProgressUnopened),
+	string(storage.SourceFile),
+	string(storage.SourceURL),
+	string(storage.SourceText),
+}
+
+// showListOptions shows buttons to choose sort and to switch filters of the list
+func (b *Bot) showListOptions(from *tgbotapi.User) {
+	options, err := b.service.ListOptions(from.ID)
+	if err != nil {
+		b.replyErrorToUserWithI18n(from, errorOnListMsgId, err)
+		return
+	}
+	filterWords := strings.Fields(options.Filter.String())
+	mark := func(on bool, text string) string {
+		if on {
+			return "✅ " + text
+		}
+		return text
+	}
+	var buttons []tgbotapi.InlineKeyboardButton
+	for _, sort := range service.TextSorts {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(
+			mark(sort == options.Sort, b.getText(from, "sort_"+string(sort))), listSort+string(sort),
+		))
+	}
+	for _, filter := range listFilters {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(
+			mark(slices.Contains(filterWords, filter), b.getText(from, "filter_"+filter)), listFilter+filter,
+		))
+	}
+	buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(
+		mark(options.Filter.IsEmpty(), b.getText(from, "filter_all")), listFilter+"all",
+	))
+	filter := options.Filter.String()
+	if filter == "" {
+		filter = b.getText(from, "filter_all")
+	}
+	b.replyToUserWithI18nWithArgs(from, onListOptionsMsgId, map[string]string{
+		"sort":   b.getText(from, "sort_"+string(options.Sort)),
+		"filter": html.EscapeString(filter),
+	}, buttons...)
+}
+
+// toggleListFilter adds filter to the list filter or removes it, "all" removes all filters
+func (b *Bot) toggleListFilter(from *tgbotapi.User, filter string) {
+	options, err := b.service.ListOptions(from.ID)
+	if err != nil {
+		b.replyErrorToUserWithI18n(from, errorOnListMsgId, err)
+		return
+	}
+	words := strings.Fields(options.Filter.String())
+	if i := slices.Index(words, filter); i >= 0 {
+		words = slices.Delete(words, i, i+1)
+	} else if filter != "all" {
+		words = append(words, filter)
+	}
+	if len(words) == 0 || filter == "all" {
+		words = []string{"all"}
+	}
+	b.updateList(from, strings.Join(words, " "))
+}
+
+func (b *Bot) bookmarkChunk(from *tgbotapi.User) {
+	_, err := b.service.BookmarkCurrentChunk(from.ID, "")
+	if err != nil {
+		b.replyErrorToUserWithI18n(from, errorOnAddingBookmarkMsgId, err)
+		return
+	}
+	b.replyToUserWithI18n(from, bookmarkSavedMsgId)
+}
+
+func (b *Bot) bookmarks(msg *tgbotapi.Message) {
+	bookmarks, err := b.service.TextBookmarks(msg.From.ID)
+	if err != nil {
+		b.replyErrorWithI18n(msg, errorOnListingBookmarksMsgId, err)
+		return
+	}
+	if len(bookmarks) == 0 {
+		b.replyToMsgWithI18n(msg, warningNoBookmarksMsgId)
+		return
+	}
+	var buttons []tgbotapi.InlineKeyboardButton
+	for _, bookmark := range bookmarks {
+		name := bookmark.TextName
+		if bookmark.Label != "" {
+			name += ": " + bookmark.Label
+		}
+		name = runeslice.NRunes(name, maxButtonTextLength)
+		buttons = append(buttons,
+			tgbotapi.NewInlineKeyboardButtonData("🔖 "+name, bookmarkOpen+bookmark.UUID),
+			tgbotapi.NewInlineKeyboardButtonData(b.getTextWithArgs(msg.From, deleteBookmarkButtonMsgId, map[string]string{
+				"bookmark_name": name,
+			}), bookmarkDelete+bookmark.UUID),
+		)
+	}
+	b.replyToMsgWithI18n(msg, onBookmarksMsgId, buttons...)
+}
+
+func (b *Bot) openBookmark(from *tgbotapi.User, bookmarkUUID string) {
+	text, err := b.service.OpenBookmark(from.ID, bookmarkUUID)
+	if err != nil {
+		b.replyErrorToUserWithI18n(from, errorOnOpeningBookmarkMsgId, err)
+		return
+	}
+	b.replyToUserWithI18nWithArgs(from, onTextSelectMsgId, map[string]string{
+		"text_name": text.Name,
+	})
+	b.currentChunk(from)
+}
+
+func (b *Bot) deleteBookmark(from *tgbotapi.User, bookmarkUUID string) {
+	err := b.service.DeleteBookmark(from.ID, bookmarkUUID)
+	if err != nil {
+		b.replyErrorToUserWithI18n(from, errorOnDeletingBookmarkMsgId, err)
+		return
+	}
+	b.replyToUserWithI18n(from, bookmarkDeletedMsgId)
+}
+
+// search lists chunks of the user's texts that contain all words of the query
+func (b *Bot) search(msg *tgbotapi.Message) {
+	results, more, err := b.service.Search(msg.From.ID, msg.CommandArguments(), 1, searchPageSize)
+	if err != nil {
+		if errors.Is(err, service.ErrEmptyQuery) {
+			b.replyToMsgWithI18n(msg, errorOnSearchEmptyQueryMsgId)
+			return
+		}
+		b.replyErrorWithI18n(msg, errorOnSearchMsgId, err)
+		return
+	}
+	if len(results) == 0 {
+		b.replyToMsgWithI18n(msg, warningNoSearchResultsMsgId)
+		return
+	}
+	lines := []string{b.getText(msg.From, onSearchResultsMsgId)}
+	var buttons []tgbotapi.InlineKeyboardButton
+	for i, result := range results {
+		lines = append(lines, fmt.Sprintf("%d. <b>%s</b>, %d: %s",
+			i+1, html.EscapeString(result.TextName), result.Chunk, html.EscapeString(result.Snippet)))
+		name := runeslice.NRunes(fmt.Sprintf("%d. %s, %d", i+1, result.TextName, result.Chunk), maxButtonTextLength)
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(
+			name, searchOpen+result.TextUUID+":"+strconv.FormatInt(result.Chunk, 10),
+		))
+	}
+	if more {
+		lines = append(lines, b.getText(msg.From, searchHasMoreResultsMsgId))
+	}
+	b.replyWithText(msg, strings.Join(lines, "\n\n"), buttons...)
+}
+
+// openSearchResult selects the text of the found chunk and shows the chunk
+func (b *Bot) openSearchResult(from *tgbotapi.User, data string) {
+	textUUID, strChunk, _ := strings.Cut(data, ":")
+	chunk, err := strconv.ParseInt(strChunk, 10, 64)
+	if err != nil {
+		b.replyErrorToUserWithI18n(from, errorOnParsingPageMsgId, err)
+		return
+	}
+	text, err := b.service.SelectText(from.ID, textUUID)
+	if err != nil {
+		b.replyErrorToUserWithI18n(from, errorOnTextSelectMsgId, err)
+		return
+	}
+	b.replyToUserWithI18nWithArgs(from, onTextSelectMsgId, map[string]string{
+		"text_name": text.Name,
+	})
+	b.setPage(from, chunk)
+}
+
+// tag adds tags from arguments to the current text
+func (b *Bot) tag(msg *tgbotapi.Message) {
+	text, err := b.service.AddTextTags(msg.From.ID, "", strings.Fields(msg.CommandArguments()))
+	b.replyTextTags(msg, text, err)
+}
+
+// untag removes tags from arguments from the current text
+func (b *Bot) untag(msg *tgbotapi.Message) {
+	text, err := b.service.RemoveTextTags(msg.From.ID, "", strings.Fields(msg.CommandArguments()))
+	b.replyTextTags(msg, text, err)
+}
+
+func (b *Bot) replyTextTags(msg *tgbotapi.Message, text storage.Text, err error) {
+	if err != nil {
+		if errors.Is(err, service.ErrTextNotSelected) {
+			b.replyToMsgWithI18n(msg, errorOnUpdatingTagsNoTextSelectedMsgId)
+			return
+		}
+		b.replyErrorWithI18n(msg, errorOnUpdatingTagsMsgId, err)
+		return
+	}
+	tags := make([]string, 0, len(text.Tags))
+	for _, tag := range text.Tags {
+		tags = append(tags, "#"+tag)
+	}
+	b.replyToMsgWithI18nWithArgs(msg, textTagsMsgId, map[string]string{
+		"text_name": html.EscapeString(text.Name),
+		"tags":      html.EscapeString(strings.Join(tags, " ")),
+	})
+}
+
+// tags lists tags of the user's texts, tag button lists texts with the tag
+func (b *Bot) tags(msg *tgbotapi.Message) {
+	tags, err := b.service.Tags(msg.From.ID)
+	if err != nil {
+		b.replyErrorWithI18n(msg, errorOnListingTagsMsgId, err)
+		return
+	}
+	if len(tags) == 0 {
+		b.replyToMsgWithI18n(msg, warningNoTagsMsgId)
+		return
+	}
+	var buttons []tgbotapi.InlineKeyboardButton
+	for _, tag := range tags {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf("#%s (%d)", tag.Tag, tag.Texts), listTag+tag.Tag,
+		))
+	}
+	b.replyToMsgWithI18n(msg, onTagsMsgId, buttons...)
+}
+
+// state moves the current text to the state from arguments, or shows buttons to choose it
+func (b *Bot) state(msg *tgbotapi.Message) {
+	if args := strings.TrimSpace(msg.CommandArguments()); args != "" {
+		state, err := service.ParseTextState(args)
+		if err != nil {
+			b.replyErrorWithI18n(msg, errorOnSettingTextStateMsgId, err)
+			return
+		}
+		b.setTextState(msg.From, ":"+string(state))
+		return
+	}
+	text, err := b.service.GetCurrentText(msg.From.ID)
+	if err != nil {
+		b.replyToMsgWithI18n(msg, errorOnSettingTextStateNoTextSelectedMsgId)
+		return
+	}
+	var buttons []tgbotapi.InlineKeyboardButton
+	for _, state := range storage.TextStates {
+		if state == text.State {
+			continue
+		}
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(
+			b.getText(msg.From, stateMsgId(state)), textState+text.UUID+":"+string(state),
+		))
+	}
+	b.replyToMsgWithI18nWithArgs(msg, onTextStateMsgId, map[string]string{
+		"text_name": html.EscapeString(text.Name),
+		"state":     b.getText(msg.From, stateMsgId(text.State)),
+	}, buttons...)
+}
+
+// setTextState moves the text to the state, data is text uuid and state separated by colon.
+// Empty uuid means the current text.
+func (b *Bot) setTextState(from *tgbotapi.User, data string) {
+	textUUID, state, _ := strings.Cut(data, ":")
+	text, err := b.service.SetTextState(from.ID, textUUID, storage.TextState(state))
+	if err != nil {
+		if errors.Is(err, service.ErrTextNotSelected) {
+			b.replyToUserWithI18n(from, errorOnSettingTextStateNoTextSelectedMsgId)
+			return
+		}
+		b.replyErrorToUserWithI18n(from, errorOnSettingTextStateMsgId, err)
+		return
+	}
+	b.replyToUserWithI18nWithArgs(from, textStateSetMsgId, map[string]string{
+		"text_name": html.EscapeString(text.Name),
+		"state":     b.getText(from, stateMsgId(text.State)),
+	})
+}
+
+func stateMsgId(state storage.TextState) string {
+	return "state_" + string(state)
+}
+
+// quote saves current chunk as quote, or the fragment of the chunk user replied to.
+// Arguments of the command are saved as note.
+func (b *Bot) quote(msg *tgbotapi.Message) {
+	fragment := ""
+	if msg.ReplyToMessage != nil {
+		fragment = msg.ReplyToMessage.Text
+	}
+	highlight, err := b.service.QuoteCurrentChunk(msg.From.ID, fragment, msg.CommandArguments())
+	if err != nil {
+		b.replyErrorWithI18n(msg, errorOnSavingQuoteMsgId, err)
+		return
+	}
+	quote := runeslice.NRunes(highlight.Text, maxQuotePreviewLength)
+	if len(quote) < len(highlight.Text) {
+		quote += "..."
+	}
+	b.replyToMsgWithI18nWithArgs(msg, quoteSavedMsgId, map[string]string{
+		"quote": html.EscapeString(quote),
+	})
+}
+
 func completionPercentString(percent int) string {
 	switch percent {
 	case 0:
@@ -509,8 +1135,20 @@ func (b *Bot) setPage(from *tgbotapi.User, page int64) {
 }
 
 func (b *Bot) chunk(msg *tgbotapi.Message) {
-	strChunk := msg.CommandArguments()
-	chunk, err := strconv.ParseInt(strings.TrimSpace(strChunk), 10, 64)
+	strChunk := strings.TrimSpace(msg.CommandArguments())
+	if seconds, ok := parseDuration(strChunk); ok {
+		err := b.service.SetChunkSeconds(msg.From.ID, seconds)
+		if err != nil {
+			b.replyErrorWithI18n(msg, errorOnSettingChunkSizeMsgId, err)
+			return
+		}
+		b.replyToMsgWithI18nWithArgs(msg, chunkTimeSetMsgId, map[string]string{
+			"seconds": strconv.FormatInt(seconds, 10),
+		})
+		b.rechunkTexts(msg)
+		return
+	}
+	chunk, err := strconv.ParseInt(strChunk, 10, 64)
 	if err != nil {
 		b.replyErrorWithI18n(msg, errorOnParsingChunkSizeMsgId, err)
 		return
@@ -521,6 +1159,83 @@ func (b *Bot) chunk(msg *tgbotapi.Message) {
 		return
 	}
 	b.replyToMsgWithI18n(msg, chunkSizeSetMsgId)
+	b.rechunkTexts(msg)
+}
+
+// rechunkTexts splits texts of the user again after chunk size change
+func (b *Bot) rechunkTexts(msg *tgbotapi.Message) {
+	rechunked, err := b.service.RechunkTexts(msg.From.ID)
+	if err != nil {
+		b.replyErrorWithI18n(msg, errorOnRechunkingTextsMsgId, err)
+		return
+	}
+	if rechunked > 0 {
+		b.replyToMsgWithI18nWithArgs(msg, textsRechunkedMsgId, map[string]string{
+			"count": strconv.Itoa(rechunked),
+		})
+	}
+}
+
+var durationUnits = map[string]int64{
+	"s": 1, "sec": 1, "с": 1, "сек": 1,
+	"m": 60, "min": 60, "м": 60, "мин": 60,
+}
+
+// parseDuration parses reading time like "90s" or "2m" into seconds
+func parseDuration(s string) (int64, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if i <= 0 {
+		return 0, false
+	}
+	unit, ok := durationUnits[strings.TrimSpace(s[i:])]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s[:i], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n * unit, true
+}
+
+// speed sets reading speed in characters per minute: "/speed 1200", "/speed 1200 en",
+// or resets it to measured speed: "/speed auto"
+func (b *Bot) speed(msg *tgbotapi.Message) {
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) == 0 {
+		speed, err := b.service.ReadingSpeed(msg.From.ID, getLanguageCode(msg.From))
+		if err != nil {
+			b.replyErrorWithI18n(msg, errorOnGettingReadingSpeedMsgId, err)
+			return
+		}
+		b.replyToMsgWithI18nWithArgs(msg, readingSpeedMsgId, map[string]string{
+			"speed": strconv.FormatInt(speed, 10),
+		})
+		return
+	}
+	if args[0] == "auto" {
+		if err := b.service.ResetReadingSpeed(msg.From.ID); err != nil {
+			b.replyErrorWithI18n(msg, errorOnSettingReadingSpeedMsgId, err)
+			return
+		}
+		b.replyToMsgWithI18n(msg, readingSpeedResetMsgId)
+		return
+	}
+	speed, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		b.replyErrorWithI18n(msg, errorOnParsingReadingSpeedMsgId, err)
+		return
+	}
+	language := ""
+	if len(args) > 1 {
+		language = strings.ToLower(args[1])
+	}
+	if err := b.service.SetReadingSpeed(msg.From.ID, language, speed); err != nil {
+		b.replyErrorWithI18n(msg, errorOnSettingReadingSpeedMsgId, err)
+		return
+	}
+	b.replyToMsgWithI18n(msg, readingSpeedSetMsgId)
 }
 
 func (b *Bot) delete(msg *tgbotapi.Message) {
@@ -546,88 +1261,277 @@ func (b *Bot) rename(msg *tgbotapi.Message) {
 	})
 }
 
+// epub sends current text as epub book for e-readers
+func (b *Bot) epub(msg *tgbotapi.Message) {
+	name, data, err := b.service.TextEPUB(msg.From.ID, "")
+	if err != nil {
+		if errors.Is(err, service.ErrTextNotSelected) {
+			b.replyToMsgWithI18n(msg, errorOnMakingEpubNoTextSelectedMsgId)
+			return
+		}
+		b.replyErrorWithI18n(msg, errorOnMakingEpubMsgId, err)
+		return
+	}
+	b.send(tgbotapi.NewDocument(msg.Chat.ID, tgbotapi.FileBytes{Name: name, Bytes: data}))
+}
+
+// download sends backup of everything that is stored for the user, it can be restored with /restore
 func (b *Bot) download(msg *tgbotapi.Message) {
-	page := 0
-	pageSize := 300
-	type OutputText struct {
-		TextName     string   `json:"textName"`
-		CurrentChunk int64    `json:"currentChunk"`
-		Chunks       []string `json:"chunks"`
+	backup, err := b.service.Backup(msg.From.ID)
+	if err != nil {
+		b.replyErrorWithI18n(msg, errorOnBackupMsgId, err)
+		return
 	}
+	doc := tgbotapi.FileBytes{
+		Name:  fmt.Sprintf("adhd_reader_backup_%s.json.gz", time.Now().Format(time.DateOnly)),
+		Bytes: backup,
+	}
+	b.send(tgbotapi.NewDocument(msg.Chat.ID, doc))
+}
 
-	type Output struct {
-		Texts []OutputText `json:"texts"`
+// restore restores backup sent with /restore caption or the backup the command replies to.
+// Texts are added to the existing ones unless replace is passed.
+func (b *Bot) restore(msg *tgbotapi.Message, args string) {
+	mode := storage.RestoreMerge
+	switch strings.TrimSpace(args) {
+	case "", string(storage.RestoreMerge):
+	case string(storage.RestoreReplace):
+		mode = storage.RestoreReplace
+	default:
+		b.replyToMsgWithI18n(msg, errorOnParsingRestoreModeMsgId)
+		return
+	}
+	document := msg.Document
+	if document == nil && msg.ReplyToMessage != nil {
+		document = msg.ReplyToMessage.Document
+	}
+	if document == nil {
+		b.replyToMsgWithI18n(msg, warningNoBackupFileMsgId)
+		return
+	}
+	data, ok := b.loadDocument(msg, document)
+	if !ok {
+		return
+	}
+	restored, err := b.service.Restore(msg.From.ID, data, mode)
+	if err != nil {
+		b.replyErrorWithI18n(msg, errorOnRestoreMsgId, err)
+		return
 	}
+	b.replyToMsgWithI18nWithArgs(msg, backupRestoredMsgId, map[string]string{
+		"texts_count": strconv.Itoa(restored),
+	})
+}
 
-	outTexts := make([]OutputText, 0, pageSize)
-	curSize := 0
-	part := 1
-	sendPart := func() {
-		if len(outTexts) == 0 {
-			return
-		}
+func (b *Bot) cdownload(msg *tgbotapi.Message) {
+	curText, err := b.service.GetCurrentFullText(msg.From.ID)
+	if err != nil {
+		b.replyErrorWithI18n(msg, errorOnListMsgId, err)
+		return
+	}
+
+	doc := tgbotapi.FileBytes{Name: curText.Name + ".txt", Bytes: []byte(curText.Text)}
+	b.send(tgbotapi.NewDocument(msg.Chat.ID, doc))
+}
 
-		out := Output{
-			Texts: outTexts,
+func (b *Bot) export(msg *tgbotapi.Message) {
+	format, err := notesexport.ParseFormat(msg.CommandArguments())
+	if err != nil {
+		b.replyErrorWithI18n(msg, errorOnParsingExportFormatMsgId, err)
+		return
+	}
+	file, err := b.service.ExportHighlights(msg.From.ID, "", format)
+	if err != nil {
+		if errors.Is(err, service.ErrNoHighlights) {
+			b.replyToMsgWithI18n(msg, warningNoHighlightsMsgId)
+			return
 		}
+		b.replyErrorWithI18n(msg, errorOnExportingHighlightsMsgId, err)
+		return
+	}
+	doc := tgbotapi.FileBytes{Name: file.Name, Bytes: file.Data}
+	b.send(tgbotapi.NewDocument(msg.Chat.ID, doc))
+}
 
-		outBytes, err := json.Marshal(out)
+func (b *Bot) encrypt(msg *tgbotapi.Message) {
+	switch strings.TrimSpace(msg.CommandArguments()) {
+	case "":
+		encrypted, err := b.service.TextsEncrypted(msg.From.ID)
 		if err != nil {
-			b.replyErrorWithI18n(msg, errorOnFullTextEncodeMsgId, err)
+			b.replyErrorWithI18n(msg, errorOnEncryptingTextsMsgId, err)
 			return
 		}
-
-		doc := tgbotapi.FileBytes{
-			Name:  fmt.Sprintf("all_texts_part%d.json", part),
-			Bytes: outBytes,
+		if err := b.service.EncryptTexts(msg.From.ID); err != nil {
+			b.replyErrorWithI18n(msg, errorOnEncryptingTextsMsgId, err)
+			return
+		}
+		if encrypted {
+			b.replyToMsgWithI18n(msg, textsKeyRotatedMsgId)
+			return
 		}
-		b.send(tgbotapi.NewDocument(msg.Chat.ID, doc))
-		part++
-		curSize = 0
-		outTexts = outTexts[:0]
+		b.replyToMsgWithI18n(msg, textsEncryptedMsgId)
+	case "off":
+		if err := b.service.DecryptTexts(msg.From.ID); err != nil {
+			b.replyErrorWithI18n(msg, errorOnEncryptingTextsMsgId, err)
+			return
+		}
+		b.replyToMsgWithI18n(msg, textsDecryptedMsgId)
+	default:
+		b.replyToMsgWithI18n(msg, errorOnParsingEncryptModeMsgId)
 	}
-	for {
-		texts, err := b.service.FullTexts(msg.From.ID, nil, page, pageSize)
+}
+
+func (b *Bot) remind(msg *tgbotapi.Message) {
+	switch args := strings.TrimSpace(msg.CommandArguments()); args {
+	case "":
+		b.reminders(msg)
+	case "off":
+		if _, err := b.service.DeleteReminders(msg.From.ID); err != nil {
+			b.replyErrorWithI18n(msg, errorOnDeletingReminderMsgId, err)
+			return
+		}
+		b.replyToMsgWithI18n(msg, remindersDeletedMsgId)
+	default:
+		reminder, err := b.service.AddReminder(msg.From.ID, args, getLanguageCode(msg.From))
 		if err != nil {
-			b.replyErrorWithI18n(msg, errorOnListMsgId, err)
+			if errors.Is(err, service.ErrInvalidReminder) {
+				b.replyErrorWithI18n(msg, errorOnParsingReminderMsgId, err)
+				return
+			}
+			b.replyErrorWithI18n(msg, errorOnSavingReminderMsgId, err)
 			return
 		}
-		if len(texts) == 0 {
-			break
+		b.replyToMsgWithI18nWithArgs(msg, reminderSavedMsgId, map[string]string{
+			"schedule": service.FormatReminder(reminder),
+			"next_at":  reminder.NextAt.Format("02.01 15:04"), // in the timezone of the reminder
+		})
+	}
+}
+
+func (b *Bot) reminders(msg *tgbotapi.Message) {
+	reminders, err := b.service.GetReminders(msg.From.ID)
+	if err != nil {
+		b.replyErrorWithI18n(msg, errorOnGettingRemindersMsgId, err)
+		return
+	}
+	if len(reminders) == 0 {
+		b.replyToMsgWithI18n(msg, noRemindersMsgId)
+		return
+	}
+	buttons := make([]tgbotapi.InlineKeyboardButton, 0, len(reminders))
+	for _, reminder := range reminders {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData("🗑 "+service.FormatReminder(reminder), reminderDelete+reminder.UUID))
+	}
+	b.replyToMsgWithI18n(msg, onRemindersMsgId, buttons...)
+}
+
+func (b *Bot) deleteReminder(from *tgbotapi.User, reminderUUID string) {
+	err := b.service.DeleteReminder(from.ID, reminderUUID)
+	if err != nil && err != storage.ErrNotFound { // reminder is deleted by the button pressed twice
+		b.replyErrorToUserWithI18n(from, errorOnDeletingReminderMsgId, err)
+		return
+	}
+	b.replyToUserWithI18n(from, reminderDeletedMsgId)
+}
+
+// SendReminder sends the next chunk of the text chosen by the reminder, it's called when the reminder's time comes
+func (b *Bot) SendReminder(userID int64, reminder storage.Reminder) {
+	to := &tgbotapi.User{ID: userID, LanguageCode: reminder.Language}
+	defer b.handlePanic(to)
+
+	text, err := b.service.SelectReminderText(userID, reminder.Mode)
+	if err != nil {
+		if errors.Is(err, service.ErrNoUnreadTexts) {
+			b.replyToUserWithI18n(to, reminderNoTextsMsgId)
+			return
 		}
+		b.replyErrorToUserWithI18n(to, errorOnSendingReminderMsgId, err)
+		return
+	}
+	b.replyToUserWithI18nWithArgs(to, reminderTimeToReadMsgId, map[string]string{
+		"text_name": text.Name,
+	})
+	b.chunkReply(to, b.service.UnreadChunk)
+}
 
-		for _, t := range texts {
-			localCurSize := 0
-			localCurSize += len(t.Name)
-			localCurSize += 8 // for current chunk
-			for _, chunk := range t.Chunks {
-				localCurSize += len(chunk)
-			}
-			if curSize+localCurSize > 20*1<<20 {
-				sendPart()
-			}
-			outTexts = append(outTexts, OutputText{
-				TextName:     t.Name,
-				CurrentChunk: t.CurrentChunk,
-				Chunks:       t.Chunks,
-			})
-			curSize += localCurSize
+// goal shows the daily reading goal or sets it, e.g. 10 chunks or 15m with optional timezone
+func (b *Bot) goal(msg *tgbotapi.Message) {
+	args := strings.TrimSpace(msg.CommandArguments())
+	if args == "" {
+		history, err := b.service.History(msg.From.ID, 1)
+		if err != nil {
+			b.replyErrorWithI18n(msg, errorOnGettingHistoryMsgId, err)
+			return
 		}
+		b.replyToMsgWithI18nWithArgs(msg, onGoalMsgId, map[string]string{
+			"goal":     b.goalText(msg.From, history.Goal),
+			"timezone": history.Timezone,
+		})
+		return
+	}
+	goal, timezone, err := service.ParseGoal(args)
+	if err != nil {
+		b.replyErrorWithI18n(msg, errorOnParsingGoalMsgId, err)
+		return
+	}
+	if err = b.service.SetGoal(msg.From.ID, goal, timezone); err != nil {
+		b.replyErrorWithI18n(msg, errorOnSavingGoalMsgId, err)
+		return
+	}
+	b.replyToMsgWithI18nWithArgs(msg, goalSetMsgId, map[string]string{
+		"goal": b.goalText(msg.From, goal),
+	})
+}
 
-		page++
+func (b *Bot) goalText(from *tgbotapi.User, goal service.Goal) string {
+	switch {
+	case goal.Chunks > 0:
+		return b.getTextWithArgs(from, goalChunksMsgId, map[string]string{"count": strconv.FormatInt(goal.Chunks, 10)})
+	case goal.Minutes > 0:
+		return b.getTextWithArgs(from, goalMinutesMsgId, map[string]string{"count": strconv.FormatInt(goal.Minutes, 10)})
 	}
-	sendPart()
+	return b.getText(from, goalAnyChunkMsgId)
 }
 
-func (b *Bot) cdownload(msg *tgbotapi.Message) {
-	curText, err := b.service.GetCurrentFullText(msg.From.ID)
+// historyDays is how many days the heatmap of /history shows, 4 weeks
+const historyDays = 28
+
+func (b *Bot) history(msg *tgbotapi.Message) {
+	history, err := b.service.History(msg.From.ID, historyDays)
 	if err != nil {
-		b.replyErrorWithI18n(msg, errorOnListMsgId, err)
+		b.replyErrorWithI18n(msg, errorOnGettingHistoryMsgId, err)
 		return
 	}
+	today := history.Today()
+	b.replyToMsgWithI18nWithArgs(msg, onHistoryMsgId, map[string]string{
+		"heatmap":        heatmap(history),
+		"from":           history.Days[0].Date.Format("02.01"),
+		"goal":           b.goalText(msg.From, history.Goal),
+		"today_chunks":   strconv.FormatInt(today.Chunks, 10),
+		"today_minutes":  strconv.FormatInt((today.Seconds+30)/60, 10),
+		"current_streak": strconv.Itoa(history.CurrentStreak),
+		"longest_streak": strconv.Itoa(history.LongestStreak),
+	})
+}
 
-	doc := tgbotapi.FileBytes{Name: curText.Name + ".txt", Bytes: []byte(curText.Text)}
-	b.send(tgbotapi.NewDocument(msg.Chat.ID, doc))
+// heatmap draws days in rows of a week, the last cell is today:
+// 🟩 the goal is reached, 🟨 something is read, ⬜ nothing is read
+func heatmap(history service.History) string {
+	var sb strings.Builder
+	for i, day := range history.Days {
+		switch {
+		case day.Reached(history.Goal):
+			sb.WriteString("🟩")
+		case day.Chunks > 0:
+			sb.WriteString("🟨")
+		default:
+			sb.WriteString("⬜")
+		}
+		if (i+1)%7 == 0 && i+1 < len(history.Days) {
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String()
 }
 
 func (b *Bot) help(msg *tgbotapi.Message) {
@@ -647,7 +1551,12 @@ func (b *Bot) random(msg *tgbotapi.Message, atMostChunksStr string) {
 	if atMostChunks < 1 {
 		atMostChunks = -1
 	}
-	text, err := b.service.RandomText(msg.From.ID, atMostChunks)
+	filter, err := service.ParseTextFilter(msg.CommandArguments())
+	if err != nil {
+		b.replyErrorWithI18n(msg, errorOnParsingTextFilterMsgId, err)
+		return
+	}
+	text, err := b.service.RandomText(msg.From.ID, atMostChunks, filter)
 	if err != nil {
 		b.replyErrorWithI18n(msg, errorOnRandomTextMsgId, err)
 		return
@@ -656,7 +1565,12 @@ func (b *Bot) random(msg *tgbotapi.Message, atMostChunksStr string) {
 }
 
 func (b *Bot) quickwin(msg *tgbotapi.Message) {
-	text, err := b.service.QuickWin(msg.From.ID)
+	filter, err := service.ParseTextFilter(msg.CommandArguments())
+	if err != nil {
+		b.replyErrorWithI18n(msg, errorOnParsingTextFilterMsgId, err)
+		return
+	}
+	text, err := b.service.QuickWin(msg.From.ID, filter)
 	if err != nil {
 		b.replyErrorWithI18n(msg, errorOnRandomTextMsgId, err)
 		return
@@ -673,63 +1587,165 @@ func (b *Bot) loot(msg *tgbotapi.Message) {
 }
 
 func (b *Bot) stats(msg *tgbotapi.Message) {
+	b.showStats(msg.From)
+}
 
-	stats, level, err := b.service.GetStatsAndLevel(msg.From.ID)
+// showStats shows level and stats with buttons to spend free points
+func (b *Bot) showStats(from *tgbotapi.User) {
+	stats, level, err := b.service.GetStatsAndLevel(from.ID)
 	if err != nil {
-		b.replyErrorWithI18n(msg, errorOnGettingLootMsgId, err)
+		b.replyErrorToUserWithI18n(from, errorOnGettingLevelMsgId, err)
+		return
 	}
-	b.replyWithPlainText(msg.From, "LEVEL "+strconv.FormatInt(level.Level, 10)+"\n"+
-		"EXP: "+strconv.FormatInt(level.Experience, 10)+"\n"+"✨✨✨✨✨"+"\n"+
-		"AC: "+strconv.FormatInt(stats.Accuracy, 10)+"\n"+
-		"AT: "+strconv.FormatInt(stats.Attention, 10)+"\n"+
-		"TM: "+strconv.FormatInt(stats.TimeManagement, 10)+"\n"+
-		"CR: "+strconv.FormatInt(stats.Charizma, 10)+"\n"+
-		"LK: "+strconv.FormatInt(stats.Luck, 10)+"\n"+
-		"Free: "+strconv.FormatInt(stats.Free, 10))
-
+	free := b.getText(from, noFreeStatPointsMsgId)
+	var buttons []tgbotapi.InlineKeyboardButton
+	if stats.Free > 0 {
+		free = b.getTextWithArgs(from, freeStatPointsMsgId, map[string]string{
+			"count": strconv.FormatInt(stats.Free, 10),
+		})
+		for _, name := range service.SpendableStats {
+			buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData("➕ "+b.getText(from, "stat_"+name), statSpend+name))
+		}
+	}
+	b.replyToUserWithI18nWithArgs(from, onStatsMsgId, map[string]string{
+		"level":                 strconv.FormatInt(level.Level, 10),
+		"experience":            strconv.FormatInt(level.Experience, 10),
+		"next_level_experience": strconv.FormatInt(level.NextLevelExperience, 10),
+		"accuracy":              strconv.FormatInt(stats.Accuracy, 10),
+		"attention":             strconv.FormatInt(stats.Attention, 10),
+		"luck":                  strconv.FormatInt(stats.Luck, 10),
+		"time_management":       strconv.FormatInt(stats.TimeManagement, 10),
+		"charizma":              strconv.FormatInt(stats.Charizma, 10),
+		"free":                  free,
+	}, buttons...)
 }
 
-var plainTextParsers = map[string]func([]byte) (string, error){
-	contenttype.OctetStream: plaintext.PlainText,
-	contenttype.PlainText:   plaintext.PlainText,
-	contenttype.PDF:         pdf.PlaintText,
-	contenttype.EPUB:        epub.PlainText,
-	contenttype.FB2_XML:     fb2.PlainText,
+func (b *Bot) spendFreePoint(from *tgbotapi.User, statName string) {
+	_, err := b.service.SpendFreePoint(from.ID, statName)
+	switch {
+	case errors.Is(err, service.ErrNoFreePoints): // the button is pressed after points are spent
+		b.replyToUserWithI18n(from, noFreeStatPointsMsgId)
+		return
+	case err != nil:
+		b.replyErrorToUserWithI18n(from, errorOnSpendingStatPointMsgId, err)
+		return
+	}
+	b.showStats(from)
 }
 
-func (b *Bot) saveTextFromDocument(msg *tgbotapi.Message) {
-	if msg.Document.FileSize != 0 && msg.Document.FileSize > b.maxFileSize {
-		b.replyToMsgWithI18nWithArgs(msg, errorOnFileUploadTooBigMsgId, map[string]string{
-			"max_file_size": sizeconverter.HumanReadableSizeInMB(b.maxFileSize),
-		})
+func (b *Bot) craft(msg *tgbotapi.Message) {
+	recipeName, args, _ := strings.Cut(strings.TrimSpace(msg.CommandArguments()), " ")
+	if recipeName == "" {
+		b.recipes(msg)
 		return
 	}
-	parser, ok := plainTextParsers[msg.Document.MimeType]
-	if !ok {
-		b.replyToMsgWithI18nWithArgs(msg, errorOnFileUploadInvalidFormatMsgId, map[string]string{
-			"supported_formats": "txt, pdf, epub, fb2",
-		})
+	amounts, err := service.ParseMix(args)
+	if err != nil {
+		b.replyErrorWithI18n(msg, errorOnParsingMixMsgId, err)
 		return
 	}
-	fileURL, err := b.bot.GetFileDirectURL(msg.Document.FileID)
+	crafted, err := b.service.Craft(msg.From.ID, recipeName, amounts)
+	switch {
+	case errors.Is(err, service.ErrUnknownRecipe), errors.Is(err, service.ErrInvalidMix):
+		b.replyErrorWithI18n(msg, errorOnParsingMixMsgId, err)
+		return
+	case errors.Is(err, service.ErrNotEnoughLoot):
+		b.replyErrorWithI18n(msg, notEnoughLootMsgId, err)
+		return
+	case err != nil:
+		b.replyErrorWithI18n(msg, errorOnCraftingMsgId, err)
+		return
+	}
+	b.replyToMsgWithI18nWithArgs(msg, craftedMsgId, map[string]string{
+		"name": crafted.Buff.Recipe,
+		"mix":  mixToString(crafted.Mix),
+		"buff": b.buffText(msg.From, crafted.Buff),
+	})
+}
+
+func (b *Bot) recipes(msg *tgbotapi.Message) {
+	recipes, err := b.service.Recipes(msg.From.ID)
 	if err != nil {
-		b.replyErrorWithI18n(msg, errorOnFileUploadBuildingFileURLMsgId, err)
+		b.replyErrorWithI18n(msg, errorOnGettingRecipesMsgId, err)
 		return
 	}
-	data, err := b.fileLoader.DownloadFile(fileURL)
-	switch err {
-	case nil:
-	case fileloader.ErrFileIsTooBig:
-		b.replyToMsgWithI18nWithArgs(msg, errorOnFileUploadTooBigMsgId, map[string]string{
-			"max_file_size": sizeconverter.HumanReadableSizeInMB(b.maxFileSize),
+	buffs, err := b.service.ActiveBuffs(msg.From.ID)
+	if err != nil {
+		b.replyErrorWithI18n(msg, errorOnGettingRecipesMsgId, err)
+		return
+	}
+	lines := make([]string, 0, len(recipes))
+	for _, recipe := range recipes {
+		line := b.getTextWithArgs(msg.From, recipeMsgId, map[string]string{
+			"name":         recipe.Name,
+			"buff":         b.getText(msg.From, buffKindMsgIds[recipe.Buff]),
+			"multiplier":   strconv.FormatFloat(recipe.Multiplier, 'f', -1, 64),
+			"duration":     b.duration(msg.From, int64(recipe.Duration.Seconds())),
+			"max_duration": b.duration(msg.From, int64(2*recipe.Duration.Seconds())),
+			"min":          mixToString(recipe.Min),
+			"max":          mixToString(recipe.Max),
+		})
+		if recipe.Ideal != nil {
+			line += "\n" + b.getTextWithArgs(msg.From, recipeIdealMixMsgId, map[string]string{
+				"mix": mixToString(*recipe.Ideal),
+			})
+		}
+		lines = append(lines, line)
+	}
+	active := make([]string, 0, len(buffs))
+	for _, buff := range buffs {
+		active = append(active, b.buffText(msg.From, buff))
+	}
+	if len(active) == 0 {
+		active = append(active, b.getText(msg.From, noActiveBuffsMsgId))
+	}
+	b.replyToMsgWithI18nWithArgs(msg, onRecipesMsgId, map[string]string{
+		"recipes":     strings.Join(lines, "\n\n"),
+		"buffs":       strings.Join(active, "\n"),
+		"ingredients": strings.Join(service.IngredientNames, ", "),
+	})
+}
+
+var buffKindMsgIds = map[storage.BuffKind]string{
+	storage.BuffLoot: buffLootMsgId,
+	storage.BuffExp:  buffExpMsgId,
+}
+
+// buffText describes the buff with time left until it ends
+func (b *Bot) buffText(from *tgbotapi.User, buff storage.Buff) string {
+	return b.getTextWithArgs(from, activeBuffMsgId, map[string]string{
+		"buff":       b.getText(from, buffKindMsgIds[buff.Kind]),
+		"multiplier": strconv.FormatFloat(buff.Multiplier, 'f', -1, 64),
+		"duration":   b.duration(from, int64(time.Until(buff.Until).Seconds())),
+	})
+}
+
+func mixToString(mix service.Mix) string {
+	return strings.TrimSpace(DustToString(&mix.Dust, " ") + HerbToString(&mix.Herb, " "))
+}
+
+var documentParsers = map[string]func([]byte) (markup.Document, error){
+	contenttype.OctetStream: plaintext.Parse,
+	contenttype.PlainText:   plaintext.Parse,
+	contenttype.PDF:         pdf.Parse,
+	contenttype.EPUB:        epub.Parse,
+	contenttype.FB2_XML:     fb2.Parse,
+}
+
+func (b *Bot) saveTextFromDocument(msg *tgbotapi.Message) {
+	parser, ok := documentParsers[msg.Document.MimeType]
+	if !ok {
+		b.replyToMsgWithI18nWithArgs(msg, errorOnFileUploadInvalidFormatMsgId, map[string]string{
+			"supported_formats": "txt, pdf, epub, fb2",
 		})
 		return
-	default:
-		b.replyErrorWithI18n(msg, errorOnFileUploadMsgId, err)
+	}
+	data, ok := b.loadDocument(msg, msg.Document)
+	if !ok {
 		return
 	}
 
-	text, err := parser(data)
+	doc, err := parser(data)
 	if err != nil {
 		b.replyErrorWithI18n(msg, errorOnFileUploadExtractingTextMsgId, err)
 		return
@@ -738,7 +1754,7 @@ func (b *Bot) saveTextFromDocument(msg *tgbotapi.Message) {
 	textID, err := b.service.AddTextFromFile(
 		msg.From.ID,
 		filechecksum.Calculate(data),
-		msg.Document.FileName, text,
+		msg.Document.FileName, doc,
 	)
 	if err != nil {
 		if err == service.ErrTextNotUTF8 {
@@ -762,6 +1778,33 @@ func (b *Bot) saveTextFromDocument(msg *tgbotapi.Message) {
 	}, readBtn, deleteBtn)
 }
 
+// loadDocument downloads the document, replies with error if it failed
+func (b *Bot) loadDocument(msg *tgbotapi.Message, document *tgbotapi.Document) ([]byte, bool) {
+	if document.FileSize != 0 && document.FileSize > b.maxFileSize {
+		b.replyToMsgWithI18nWithArgs(msg, errorOnFileUploadTooBigMsgId, map[string]string{
+			"max_file_size": sizeconverter.HumanReadableSizeInMB(b.maxFileSize),
+		})
+		return nil, false
+	}
+	fileURL, err := b.bot.GetFileDirectURL(document.FileID)
+	if err != nil {
+		b.replyErrorWithI18n(msg, errorOnFileUploadBuildingFileURLMsgId, err)
+		return nil, false
+	}
+	data, err := b.fileLoader.DownloadFile(fileURL)
+	switch err {
+	case nil:
+		return data, true
+	case fileloader.ErrFileIsTooBig:
+		b.replyToMsgWithI18nWithArgs(msg, errorOnFileUploadTooBigMsgId, map[string]string{
+			"max_file_size": sizeconverter.HumanReadableSizeInMB(b.maxFileSize),
+		})
+	default:
+		b.replyErrorWithI18n(msg, errorOnFileUploadMsgId, err)
+	}
+	return nil, false
+}
+
 func (b *Bot) saveTextFromMessage(msg *tgbotapi.Message) {
 	text := msg.Text
 	if text == "" {
@@ -13,46 +13,144 @@ const startFileNameRu = `Обучение в эпоху "золотых_рыбо
 
 // error messages
 const (
-	panicMsgId                            = "panic"
-	errorOnTextSelectMsgId                = "error_on_text_select"
-	errorOnTextDeleteMsgId                = "error_on_text_delete"
-	errorOnTextDeleteExampleTextMsgId     = "error_on_text_delete_example_text"
-	errorOnTextDeleteNoTextsAddedMsgId    = "error_on_text_delete_no_texts_added"
-	erroroOnGettingNextChunk              = "error_on_getting_next_chunk"
-	errorOnListMsgId                      = "error_on_list"
-	errorOnParsingPageMsgId               = "error_on_parsing_page"
-	errorOnSettingPageNoTextSelectedMsgId = "error_on_setting_page_no_text_selected"
-	errorOnSettingPageMsgId               = "error_on_setting_page"
-	errorOnParsingChunkSizeMsgId          = "error_on_parsing_chunk_size"
-	errorOnSettingChunkSizeMsgId          = "error_on_setting_chunk_size"
-	errorOnFileUploadTooBigMsgId          = "error_on_file_upload_too_big"
-	errorOnFileUploadInvalidFormatMsgId   = "error_on_file_upload_invalid_format"
-	errorOnFileUploadBuildingFileURLMsgId = "error_on_file_upload_building_file_URL"
-	errorOnFileUploadExtractingTextMsgId  = "error_on_file_upload_extracting_text"
-	errorOnFileUploadMsgId                = "error_on_file_upload"
-	errorOnTextSaveNotUTF8MsgId           = "error_on_text_save_not_utf8"
-	errorOnTextSaveMsgId                  = "error_on_text_save"
-	errorOnTextSaveFromLink               = "error_on_text_save_from_link"
-	errorOnTextSaveAlreadyExistsMsgId     = "error_on_text_save_already_exists"
-	errorUnknownCommandMsgId              = "error_unknown_command"
-	errorOnTextRenameMsgId                = "error_on_text_rename"
-	errorOnFullTextEncodeMsgId            = "error_on_full_text_encode"
-	errorOnParsingListPageMsgId           = "error_on_parsing_list_page"
-	errorEmptyChunkMsgId                  = "error_empty_chunk"
-	errorOnRandomTextMsgId                = "error_on_random_text"
-	errorOnGettingLootMsgId               = "error_on_getting_loot"
+	panicMsgId                                 = "panic"
+	errorOnTextSelectMsgId                     = "error_on_text_select"
+	errorOnTextDeleteMsgId                     = "error_on_text_delete"
+	errorOnTextDeleteExampleTextMsgId          = "error_on_text_delete_example_text"
+	errorOnTextDeleteNoTextsAddedMsgId         = "error_on_text_delete_no_texts_added"
+	erroroOnGettingNextChunk                   = "error_on_getting_next_chunk"
+	errorOnListMsgId                           = "error_on_list"
+	errorOnParsingPageMsgId                    = "error_on_parsing_page"
+	errorOnSettingPageNoTextSelectedMsgId      = "error_on_setting_page_no_text_selected"
+	errorOnSettingPageMsgId                    = "error_on_setting_page"
+	errorOnParsingChunkSizeMsgId               = "error_on_parsing_chunk_size"
+	errorOnSettingChunkSizeMsgId               = "error_on_setting_chunk_size"
+	errorOnFileUploadTooBigMsgId               = "error_on_file_upload_too_big"
+	errorOnFileUploadInvalidFormatMsgId        = "error_on_file_upload_invalid_format"
+	errorOnFileUploadBuildingFileURLMsgId      = "error_on_file_upload_building_file_URL"
+	errorOnFileUploadExtractingTextMsgId       = "error_on_file_upload_extracting_text"
+	errorOnFileUploadMsgId                     = "error_on_file_upload"
+	errorOnTextSaveNotUTF8MsgId                = "error_on_text_save_not_utf8"
+	errorOnTextSaveMsgId                       = "error_on_text_save"
+	errorOnTextSaveFromLink                    = "error_on_text_save_from_link"
+	errorOnTextSaveAlreadyExistsMsgId          = "error_on_text_save_already_exists"
+	errorUnknownCommandMsgId                   = "error_unknown_command"
+	errorOnTextRenameMsgId                     = "error_on_text_rename"
+	errorOnFullTextEncodeMsgId                 = "error_on_full_text_encode"
+	errorOnParsingListPageMsgId                = "error_on_parsing_list_page"
+	errorEmptyChunkMsgId                       = "error_empty_chunk"
+	errorOnRandomTextMsgId                     = "error_on_random_text"
+	errorOnGettingLootMsgId                    = "error_on_getting_loot"
+	errorOnParsingReadingSpeedMsgId            = "error_on_parsing_reading_speed"
+	errorOnSettingReadingSpeedMsgId            = "error_on_setting_reading_speed"
+	errorOnGettingReadingSpeedMsgId            = "error_on_getting_reading_speed"
+	errorOnRechunkingTextsMsgId                = "error_on_rechunking_texts"
+	errorOnAddingBookmarkMsgId                 = "error_on_adding_bookmark"
+	errorOnListingBookmarksMsgId               = "error_on_listing_bookmarks"
+	errorOnOpeningBookmarkMsgId                = "error_on_opening_bookmark"
+	errorOnDeletingBookmarkMsgId               = "error_on_deleting_bookmark"
+	errorOnSavingQuoteMsgId                    = "error_on_saving_quote"
+	errorOnExportingHighlightsMsgId            = "error_on_exporting_highlights"
+	errorOnParsingExportFormatMsgId            = "error_on_parsing_export_format"
+	errorOnBackupMsgId                         = "error_on_backup"
+	errorOnRestoreMsgId                        = "error_on_restore"
+	errorOnParsingRestoreModeMsgId             = "error_on_parsing_restore_mode"
+	errorOnMakingEpubMsgId                     = "error_on_making_epub"
+	errorOnMakingEpubNoTextSelectedMsgId       = "error_on_making_epub_no_text_selected"
+	errorOnSearchMsgId                         = "error_on_search"
+	errorOnSearchEmptyQueryMsgId               = "error_on_search_empty_query"
+	errorOnParsingTextFilterMsgId              = "error_on_parsing_text_filter"
+	errorOnSettingTextStateMsgId               = "error_on_setting_text_state"
+	errorOnSettingTextStateNoTextSelectedMsgId = "error_on_setting_text_state_no_text_selected"
+	errorOnUpdatingTagsMsgId                   = "error_on_updating_tags"
+	errorOnUpdatingTagsNoTextSelectedMsgId     = "error_on_updating_tags_no_text_selected"
+	errorOnListingTagsMsgId                    = "error_on_listing_tags"
+	errorOnEncryptingTextsMsgId                = "error_on_encrypting_texts"
+	errorOnParsingEncryptModeMsgId             = "error_on_parsing_encrypt_mode"
+	errorOnParsingReminderMsgId                = "error_on_parsing_reminder"
+	errorOnSavingReminderMsgId                 = "error_on_saving_reminder"
+	errorOnGettingRemindersMsgId               = "error_on_getting_reminders"
+	errorOnDeletingReminderMsgId               = "error_on_deleting_reminder"
+	errorOnSendingReminderMsgId                = "error_on_sending_reminder"
+	errorOnParsingGoalMsgId                    = "error_on_parsing_goal"
+	errorOnSavingGoalMsgId                     = "error_on_saving_goal"
+	errorOnGettingHistoryMsgId                 = "error_on_getting_history"
+	errorOnGettingStatsMsgId                   = "error_on_getting_stats"
+	errorOnGettingRecipesMsgId                 = "error_on_getting_recipes"
+	errorOnParsingMixMsgId                     = "error_on_parsing_mix"
+	errorOnCraftingMsgId                       = "error_on_crafting"
+	notEnoughLootMsgId                         = "not_enough_loot"
+	errorOnGettingLevelMsgId                   = "error_on_getting_level"
+	errorOnSpendingStatPointMsgId              = "error_on_spending_stat_point"
 )
 
 const (
-	onTextSelectMsgId  = "on_text_select"
-	onTextDeletedMsgId = "on_text_deleted"
-	textFinishedMsgId  = "text_finished"
-	lastChunkMsgId     = "last_chunk"
-	onListMsgId        = "on_list"
-	pageSetMsgId       = "page_set"
-	chunkSizeSetMsgId  = "chunk_size_set"
-	textSavedMsgId     = "text_saved"
-	onTextRenamedMsgId = "on_text_renamed"
+	onTextSelectMsgId         = "on_text_select"
+	onTextDeletedMsgId        = "on_text_deleted"
+	textFinishedMsgId         = "text_finished"
+	lastChunkMsgId            = "last_chunk"
+	onListMsgId               = "on_list"
+	pageSetMsgId              = "page_set"
+	chunkSizeSetMsgId         = "chunk_size_set"
+	chunkTimeSetMsgId         = "chunk_time_set"
+	textsRechunkedMsgId       = "texts_rechunked"
+	readingSpeedMsgId         = "reading_speed"
+	readingSpeedSetMsgId      = "reading_speed_set"
+	readingSpeedResetMsgId    = "reading_speed_reset"
+	textSavedMsgId            = "text_saved"
+	bookmarkSavedMsgId        = "bookmark_saved"
+	bookmarkDeletedMsgId      = "bookmark_deleted"
+	onBookmarksMsgId          = "on_bookmarks"
+	quoteSavedMsgId           = "quote_saved"
+	backupRestoredMsgId       = "backup_restored"
+	onSearchResultsMsgId      = "on_search_results"
+	searchHasMoreResultsMsgId = "search_has_more_results"
+	textTagsMsgId             = "text_tags"
+	onTagsMsgId               = "on_tags"
+	onListOptionsMsgId        = "on_list_options"
+	onTextStateMsgId          = "on_text_state"
+	textStateSetMsgId         = "text_state_set"
+	onTextRenamedMsgId        = "on_text_renamed"
+	textsEncryptedMsgId       = "texts_encrypted"
+	textsKeyRotatedMsgId      = "texts_key_rotated"
+	textsDecryptedMsgId       = "texts_decrypted"
+	reminderSavedMsgId        = "reminder_saved"
+	onRemindersMsgId          = "on_reminders"
+	noRemindersMsgId          = "no_reminders"
+	reminderDeletedMsgId      = "reminder_deleted"
+	remindersDeletedMsgId     = "reminders_deleted"
+	reminderTimeToReadMsgId   = "reminder_time_to_read"
+	reminderNoTextsMsgId      = "reminder_no_texts"
+	onGoalMsgId               = "on_goal"
+	goalSetMsgId              = "goal_set"
+	goalChunksMsgId           = "goal_chunks"
+	goalMinutesMsgId          = "goal_minutes"
+	goalAnyChunkMsgId         = "goal_any_chunk"
+	onHistoryMsgId            = "on_history"
+	streakMilestoneMsgId      = "streak_milestone"
+	onProgressMsgId           = "on_progress"
+	progressPeriodMsgId       = "progress_period"
+	progressTodayMsgId        = "progress_today"
+	progressWeekMsgId         = "progress_week"
+	progressMonthMsgId        = "progress_month"
+	progressTotalMsgId        = "progress_total"
+	progressPeakHourMsgId     = "progress_peak_hour"
+	progressNoReadingMsgId    = "progress_no_reading"
+	progressTextMsgId         = "progress_text"
+	progressNoTextsMsgId      = "progress_no_texts"
+	durationMinutesMsgId      = "duration_minutes"
+	durationHoursMsgId        = "duration_hours"
+	onRecipesMsgId            = "on_recipes"
+	recipeMsgId               = "recipe"
+	recipeIdealMixMsgId       = "recipe_ideal_mix"
+	noActiveBuffsMsgId        = "no_active_buffs"
+	activeBuffMsgId           = "active_buff"
+	buffLootMsgId             = "buff_loot"
+	buffExpMsgId              = "buff_exp"
+	craftedMsgId              = "crafted"
+	onStatsMsgId              = "on_stats"
+	freeStatPointsMsgId       = "free_stat_points"
+	noFreeStatPointsMsgId     = "no_free_stat_points"
 )
 
 const (
@@ -63,11 +161,20 @@ const (
 	readButtonMsgId               = "read_button"
 	rereadButtonMsgId             = "reread_button"
 	nextPageButtonMsgId           = "next_page_button"
+	bookmarkButtonMsgId           = "bookmark_button"
+	deleteBookmarkButtonMsgId     = "delete_bookmark_button"
+	listOptionsButtonMsgId        = "list_options_button"
 )
 
 const (
 	warningFirstChunkCantGoBackMsgId = "warning_first_chunk_cant_go_back"
 	warningNoTextsMsgId              = "warning_no_texts"
+	warningNoBookmarksMsgId          = "warning_no_bookmarks"
+	warningNoHighlightsMsgId         = "warning_no_highlights"
+	warningNoBackupFileMsgId         = "warning_no_backup_file"
+	warningNoSearchResultsMsgId      = "warning_no_search_results"
+	warningNoTextsMatchFilterMsgId   = "warning_no_texts_match_filter"
+	warningNoTagsMsgId               = "warning_no_tags"
 )
 
 // onboarding messages
@@ -3,9 +3,12 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
+	_ "time/tzdata" // timezones of reminders don't depend on the host
 
 	"github.com/go-chi/chi/v5"
 	"github.com/pechorka/adhd-reader/internal/handler"
@@ -18,6 +21,7 @@ import (
 	"github.com/pechorka/adhd-reader/pkg/fileloader"
 	"github.com/pechorka/adhd-reader/pkg/i18n"
 	"github.com/pechorka/adhd-reader/pkg/queue"
+	"github.com/pechorka/adhd-reader/pkg/snapshot"
 	"github.com/pechorka/adhd-reader/pkg/watcher"
 	"github.com/pechorka/adhd-reader/pkg/webscraper"
 )
@@ -36,6 +40,26 @@ type config struct {
 	DbPath  string  `json:"db_path"`
 	Admins  []int64 `json:"admins"`
 	Secret  string  `json:"secret"`
+	// KeyringPath is file with encryption keys added by rotation, keys can't be rotated if it's empty
+	KeyringPath string `json:"keyring_path"`
+	// DbDriver is either "bolt" (default) or "sqlite"
+	DbDriver string `json:"db_driver"`
+	// GCIntervalHours is how often unused texts are removed from the database, 0 disables it
+	GCIntervalHours int `json:"gc_interval_hours"`
+	// SnapshotsDir is where snapshots of the database are saved, snapshots are disabled if it's empty
+	SnapshotsDir string `json:"snapshots_dir"`
+	// SnapshotIntervalHours is how often snapshots are taken, 0 takes them only by admin command
+	SnapshotIntervalHours int `json:"snapshot_interval_hours"`
+	// SnapshotsKeep is number of kept snapshots, 0 keeps all of them
+	SnapshotsKeep     int  `json:"snapshots_keep"`
+	SnapshotsCompress bool `json:"snapshots_compress"`
+	// RestoreSnapshot is name of the snapshot from SnapshotsDir that replaces the database on start.
+	// It's restored once, so it's safe to leave it in config.
+	RestoreSnapshot string `json:"restore_snapshot"`
+	// MigrationsDryRun reports migrations pending for the database and exits without changing it
+	MigrationsDryRun bool `json:"migrations_dry_run"`
+	// LevelingPath is json file with the leveling curve, see internal/service/leveling.json for the default one
+	LevelingPath string `json:"leveling_path"`
 }
 
 func readCfg(path string) (*config, error) {
@@ -51,6 +75,9 @@ func readCfg(path string) (*config, error) {
 	if c.DbPath == "" {
 		c.DbPath = "./db.db"
 	}
+	if c.DbDriver == "" {
+		c.DbDriver = "bolt"
+	}
 	if c.Port == 0 {
 		c.Port = 8080
 	}
@@ -58,6 +85,77 @@ func readCfg(path string) (*config, error) {
 	return &c, nil
 }
 
+func openStore(cfg *config) (storage.Store, error) {
+	switch {
+	case cfg.Debug:
+		return storage.NewTempStorage()
+	case cfg.DbDriver == "bolt":
+		return storage.NewStorage(cfg.DbPath)
+	case cfg.DbDriver == "sqlite":
+		return storage.NewSQLiteStorage(cfg.DbPath)
+	default:
+		return nil, fmt.Errorf("unknown db driver %q", cfg.DbDriver)
+	}
+}
+
+func openEncryptor(cfg *config) (*encryptor.Encryptor, error) {
+	if cfg.KeyringPath == "" {
+		return encryptor.NewEncryptor(cfg.Secret), nil
+	}
+	return encryptor.LoadEncryptor(cfg.Secret, cfg.KeyringPath)
+}
+
+func collectGarbagePeriodically(service *service.Service, interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				report, err := service.CollectGarbage()
+				if err != nil {
+					log.Println("failed to collect garbage:", err)
+					continue
+				}
+				log.Printf("garbage collected: %d texts, %d files, %d bytes reclaimed", report.Texts, report.Files, report.Reclaimed())
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// sendRemindersPeriodically sends due reminders, reminders are saved with their next time,
+// so reminders due while the bot was down are sent after restart
+func sendRemindersPeriodically(service *service.Service, send func(int64, storage.Reminder), interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				report, err := service.SendDueReminders(send)
+				if err != nil {
+					log.Println("failed to send reminders:", err)
+				}
+				if report.Skipped > 0 || report.Failed > 0 {
+					log.Printf("reminders: %d sent, %d skipped as missed, %d failed", report.Sent, report.Skipped, report.Failed)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
 func main() {
 	if err := run(); err != nil {
 		fmt.Println(err)
@@ -79,12 +177,26 @@ func run() error {
 		return err
 	}
 
-	var store *storage.Storage
-	if cfg.Debug {
-		store, err = storage.NewTempStorage()
-	} else {
-		store, err = storage.NewStorage(cfg.DbPath)
+	if cfg.MigrationsDryRun {
+		report, err := storage.DryRunMigrations(cfg.DbPath)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("schema version %d -> %d, pending migrations: %q\n", report.From, report.To, report.Applied)
+		return nil
 	}
+
+	if cfg.RestoreSnapshot != "" {
+		restored, err := snapshot.Restore(cfg.SnapshotsDir, cfg.RestoreSnapshot, cfg.DbPath)
+		if err != nil {
+			return err
+		}
+		if restored {
+			log.Printf("database is restored from snapshot %s", cfg.RestoreSnapshot)
+		}
+	}
+
+	store, err := openStore(cfg)
 	if err != nil {
 		return err
 	}
@@ -98,12 +210,38 @@ func run() error {
 	defer watcher.Close()
 
 	scrapper := webscraper.New()
-	encryptor := encryptor.NewEncryptor(cfg.Secret)
+	encryptor, err := openEncryptor(cfg)
+	if err != nil {
+		return err
+	}
+	store.SetKeyWrapper(encryptor)
+	leveling := service.DefaultLeveling()
+	if cfg.LevelingPath != "" {
+		if leveling, err = service.LoadLeveling(cfg.LevelingPath); err != nil {
+			return err
+		}
+	}
 	service := service.NewService(store, 500, scrapper, encryptor)
+	service.SetLeveling(leveling)
+	if err = service.SeedRecipes(); err != nil {
+		return err
+	}
 	msgQueue := queue.NewMessageQueue(queue.Config{})
 	fileLoader := fileloader.NewLoader(fileloader.Config{
 		MaxFileSize: defaultMaxFileSize,
 	})
+	var snapshots *snapshot.Manager
+	if cfg.SnapshotsDir != "" {
+		snapshots = snapshot.New(store, snapshot.Config{
+			Dir:      cfg.SnapshotsDir,
+			Keep:     cfg.SnapshotsKeep,
+			Compress: cfg.SnapshotsCompress,
+		})
+		if cfg.SnapshotIntervalHours > 0 {
+			stopSnapshots := snapshots.RunEvery(time.Duration(cfg.SnapshotIntervalHours) * time.Hour)
+			defer stopSnapshots()
+		}
+	}
 	b, err := bot.NewBot(bot.Config{
 		Token:       cfg.TgToken,
 		Service:     service,
@@ -112,11 +250,19 @@ func run() error {
 		I18n:        i18nService,
 		MaxFileSize: defaultMaxFileSize,
 		AdminUsers:  cfg.Admins,
+		Snapshots:   snapshots,
 	})
 	if err != nil {
 		return err
 	}
 	go b.Run()
+	stopReminders := sendRemindersPeriodically(service, b.SendReminder, time.Minute)
+	defer stopReminders()
+
+	if cfg.GCIntervalHours > 0 {
+		stopGC := collectGarbagePeriodically(service, time.Duration(cfg.GCIntervalHours)*time.Hour)
+		defer stopGC()
+	}
 
 	handlers := handler.NewHandlers(service)
 	mx := chi.NewRouter()
@@ -2,7 +2,10 @@ package handler
 
 import (
 	"errors"
+	"io"
+	"mime"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -11,6 +14,8 @@ import (
 	"github.com/pechorka/adhd-reader/internal/handler/mw/auth"
 	"github.com/pechorka/adhd-reader/internal/service"
 	"github.com/pechorka/adhd-reader/internal/storage"
+	"github.com/pechorka/adhd-reader/pkg/markup"
+	"github.com/pechorka/adhd-reader/pkg/notesexport"
 )
 
 type Service interface {
@@ -18,6 +23,24 @@ type Service interface {
 	SyncTexts(userID int64, texts []service.SyncText) ([]service.SyncText, error)
 	NextChunk(userID int64) (storage.Text, string, service.ChunkType, error)
 	PrevChunk(userID int64) (storage.Text, string, service.ChunkType, error)
+	GetAttachment(userID int64, textUUID, name string) ([]byte, error)
+	AddBookmark(userID int64, textUUID string, position int64, label string) (storage.Bookmark, error)
+	Bookmarks(userID int64, textUUID string) ([]storage.Bookmark, error)
+	DeleteBookmark(userID int64, bookmarkUUID string) error
+	AddHighlight(userID int64, highlight storage.Highlight) (storage.Highlight, error)
+	Highlights(userID int64, textUUID string) ([]storage.Highlight, error)
+	DeleteHighlight(userID int64, highlightUUID string) error
+	ExportHighlights(userID int64, textUUID string, format notesexport.Format) (notesexport.File, error)
+	Backup(userID int64) ([]byte, error)
+	TextEPUB(userID int64, textUUID string) (string, []byte, error)
+	Restore(userID int64, data []byte, mode storage.RestoreMode) (int, error)
+	Search(userID int64, query string, page, pageSize int) ([]service.SearchResult, bool, error)
+	SetTextState(userID int64, textUUID string, state storage.TextState) (storage.Text, error)
+	SetTextTags(userID int64, textUUID string, tags []string) (storage.Text, error)
+	Tags(userID int64) ([]service.TagCount, error)
+	History(userID int64, days int) (service.History, error)
+	SetGoal(userID int64, goal service.Goal, timezone string) error
+	ReadingStats(userID int64) (service.ReadingStats, error)
 }
 
 type Handlers struct {
@@ -33,6 +56,50 @@ func (h *Handlers) Register(mx chi.Router) {
 	mx.Post("/text/sync", h.SyncTexts)
 	mx.Post("/text/chunk/next", h.NextChunk)
 	mx.Post("/text/chunk/prev", h.PrevChunk)
+	mx.Get("/text/{id}/attachment/{name}", h.GetAttachment)
+	mx.Get("/text/{id}/epub", h.GetEPUB)
+	mx.Put("/text/{id}/state", h.SetTextState)
+	mx.Put("/text/{id}/tags", h.SetTextTags)
+	mx.Get("/tag", h.GetTags)
+	mx.Get("/bookmark", h.GetBookmarks)
+	mx.Post("/bookmark", h.AddBookmark)
+	mx.Delete("/bookmark/{id}", h.DeleteBookmark)
+	mx.Get("/highlight", h.GetHighlights)
+	mx.Post("/highlight", h.AddHighlight)
+	mx.Delete("/highlight/{id}", h.DeleteHighlight)
+	mx.Get("/export", h.ExportHighlights)
+	mx.Get("/backup", h.Backup)
+	mx.Post("/restore", h.Restore)
+	mx.Get("/search", h.Search)
+	mx.Get("/history", h.GetHistory)
+	mx.Put("/goal", h.SetGoal)
+	mx.Get("/stats", h.GetStats)
+}
+
+type ChunkSegment struct {
+	Type   string   `json:"type"`
+	Text   string   `json:"text,omitempty"`
+	URL    string   `json:"url,omitempty"`
+	Styles []string `json:"styles,omitempty"`
+}
+
+// chunkSegments splits chunk into segments, attachments are referenced by api url
+func chunkSegments(textUUID, chunk string) []ChunkSegment {
+	segments := markup.Segments(chunk)
+	result := make([]ChunkSegment, 0, len(segments))
+	for _, segment := range segments {
+		url := segment.URL
+		if name, ok := markup.AttachmentName(url); ok {
+			url = "/api/v1/text/" + textUUID + "/attachment/" + name
+		}
+		result = append(result, ChunkSegment{
+			Type:   string(segment.Type),
+			Text:   segment.Text,
+			URL:    url,
+			Styles: segment.Style.Names(),
+		})
+	}
+	return result
 }
 
 type GetTextsResponse struct {
@@ -40,10 +107,16 @@ type GetTextsResponse struct {
 }
 
 type GetTextsResponseItem struct {
-	TextUUID     string   `json:"id"`
-	Name         string   `json:"name"`
-	CurrentChunk int64    `json:"currentChunk"`
-	Chunks       []string `json:"chunks"`
+	TextUUID      string           `json:"id"`
+	Name          string           `json:"name"`
+	State         string           `json:"state"`
+	Tags          []string         `json:"tags"`
+	CurrentChunk  int64            `json:"currentChunk"`
+	Position      int64            `json:"position"`
+	Chunks        []string         `json:"chunks"`
+	ChunkOffsets  []int64          `json:"chunkOffsets"`
+	ChunksMarkup  []string         `json:"chunksMarkup"`
+	ChunkSegments [][]ChunkSegment `json:"chunkSegments"`
 }
 
 func (h *Handlers) GetTexts(w http.ResponseWriter, r *http.Request) {
@@ -64,12 +137,23 @@ func (h *Handlers) GetTexts(w http.ResponseWriter, r *http.Request) {
 	}
 	resp := GetTextsResponse{Texts: make([]GetTextsResponseItem, 0, len(texts))}
 	for _, text := range texts {
-		resp.Texts = append(resp.Texts, GetTextsResponseItem{
-			TextUUID:     text.UUID,
-			Name:         text.Name,
-			CurrentChunk: text.CurrentChunk,
-			Chunks:       text.Chunks,
-		})
+		item := GetTextsResponseItem{
+			TextUUID:      text.UUID,
+			Name:          text.Name,
+			State:         string(text.State),
+			Tags:          text.Tags,
+			CurrentChunk:  text.CurrentChunk,
+			Position:      text.Position,
+			Chunks:        make([]string, 0, len(text.Chunks)),
+			ChunkOffsets:  text.Offsets,
+			ChunksMarkup:  text.Chunks,
+			ChunkSegments: make([][]ChunkSegment, 0, len(text.Chunks)),
+		}
+		for _, chunk := range text.Chunks {
+			item.Chunks = append(item.Chunks, markup.PlainText(chunk))
+			item.ChunkSegments = append(item.ChunkSegments, chunkSegments(text.UUID, chunk))
+		}
+		resp.Texts = append(resp.Texts, item)
 	}
 	respond.JSON(w, resp)
 }
@@ -82,7 +166,10 @@ type SyncItem struct {
 	TextUUID     string `json:"id"`
 	ModifiedAt   string `json:"modifiedAt"`
 	CurrentChunk int64  `json:"currentChunk"`
-	Deleted      bool   `json:"deleted"`
+	// Position is the number of visible characters before the reading position, white space, markup,
+	// list bullets and link urls are not counted. It takes precedence over currentChunk and is kept inside the text.
+	Position *int64 `json:"position,omitempty"`
+	Deleted  bool   `json:"deleted"`
 }
 
 type SyncTextsResponse struct {
@@ -108,6 +195,7 @@ func (h *Handlers) SyncTexts(w http.ResponseWriter, r *http.Request) {
 			TextUUID:     item.TextUUID,
 			ModifiedAt:   modifiedAt,
 			CurrentChunk: item.CurrentChunk,
+			Position:     item.Position,
 			Deleted:      item.Deleted,
 		})
 	}
@@ -122,6 +210,7 @@ func (h *Handlers) SyncTexts(w http.ResponseWriter, r *http.Request) {
 			TextUUID:     item.TextUUID,
 			ModifiedAt:   item.ModifiedAt.Format(time.RFC3339),
 			CurrentChunk: item.CurrentChunk,
+			Position:     item.Position,
 			Deleted:      item.Deleted,
 		})
 	}
@@ -133,9 +222,12 @@ type NextChunkRequest struct {
 }
 
 type NextChunkResponse struct {
-	TextUUID string `json:"id"`
-	Chunk    string `json:"chunk"`
-	Type     string `json:"type"`
+	TextUUID string         `json:"id"`
+	Position int64          `json:"position"`
+	Chunk    string         `json:"chunk"`
+	Markup   string         `json:"markup"`
+	Segments []ChunkSegment `json:"segments"`
+	Type     string         `json:"type"`
 }
 
 func (h *Handlers) NextChunk(w http.ResponseWriter, r *http.Request) {
@@ -158,7 +250,10 @@ func (h *Handlers) NextChunk(w http.ResponseWriter, r *http.Request) {
 	}
 	resp := NextChunkResponse{
 		TextUUID: text.UUID,
-		Chunk:    chunk,
+		Position: text.Position,
+		Chunk:    markup.PlainText(chunk),
+		Markup:   chunk,
+		Segments: chunkSegments(text.UUID, chunk),
 		Type:     chunkType.String(),
 	}
 	respond.JSON(w, resp)
@@ -169,9 +264,12 @@ type PrevChunkRequest struct {
 }
 
 type PrevChunkResponse struct {
-	TextUUID string `json:"id"`
-	Chunk    string `json:"chunk"`
-	Type     string `json:"type"`
+	TextUUID string         `json:"id"`
+	Position int64          `json:"position"`
+	Chunk    string         `json:"chunk"`
+	Markup   string         `json:"markup"`
+	Segments []ChunkSegment `json:"segments"`
+	Type     string         `json:"type"`
 }
 
 func (h *Handlers) PrevChunk(w http.ResponseWriter, r *http.Request) {
@@ -194,8 +292,559 @@ func (h *Handlers) PrevChunk(w http.ResponseWriter, r *http.Request) {
 	}
 	resp := PrevChunkResponse{
 		TextUUID: text.UUID,
-		Chunk:    chunk,
+		Position: text.Position,
+		Chunk:    markup.PlainText(chunk),
+		Markup:   chunk,
+		Segments: chunkSegments(text.UUID, chunk),
 		Type:     chunkType.String(),
 	}
 	respond.JSON(w, resp)
 }
+
+func (h *Handlers) GetAttachment(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	content, err := h.svc.GetAttachment(userID, chi.URLParam(r, "id"), chi.URLParam(r, "name"))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			respond.ErrorWithCode(w, http.StatusNotFound, respond.CODE_NOT_FOUND)
+			return
+		}
+		respond.ErrorWithCode(w, http.StatusInternalServerError, respond.CODE_INTERNAL_ERROR)
+		return
+	}
+	respond.File(w, content)
+}
+
+// GetEPUB returns the text as epub book
+func (h *Handlers) GetEPUB(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	name, data, err := h.svc.TextEPUB(userID, chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			respond.ErrorWithCode(w, http.StatusNotFound, respond.CODE_NOT_FOUND)
+			return
+		}
+		respond.ErrorWithCode(w, http.StatusInternalServerError, respond.CODE_INTERNAL_ERROR)
+		return
+	}
+	respond.Attachment(w, name, "application/epub+zip", data)
+}
+
+type Bookmark struct {
+	BookmarkUUID string `json:"id"`
+	TextUUID     string `json:"textId"`
+	Position     int64  `json:"position"`
+	Label        string `json:"label,omitempty"`
+	CreatedAt    string `json:"createdAt"`
+}
+
+type GetBookmarksResponse struct {
+	Bookmarks []Bookmark `json:"bookmarks"`
+}
+
+// GetBookmarks returns bookmarks of all texts or of the text passed in textId query parameter
+func (h *Handlers) GetBookmarks(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	bookmarks, err := h.svc.Bookmarks(userID, r.URL.Query().Get("textId"))
+	if err != nil {
+		respond.ErrorWithCode(w, http.StatusInternalServerError, respond.CODE_INTERNAL_ERROR)
+		return
+	}
+	resp := GetBookmarksResponse{Bookmarks: make([]Bookmark, 0, len(bookmarks))}
+	for _, bookmark := range bookmarks {
+		resp.Bookmarks = append(resp.Bookmarks, bookmarkResponse(bookmark))
+	}
+	respond.JSON(w, resp)
+}
+
+type AddBookmarkRequest struct {
+	TextUUID string `json:"textId"`
+	Position int64  `json:"position"`
+	Label    string `json:"label"`
+}
+
+func (h *Handlers) AddBookmark(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	var req AddBookmarkRequest
+	err := request.DecodeJSON(r.Body, &req)
+	if err != nil {
+		respond.ErrorWithCode(w, http.StatusBadRequest, respond.CODE_INVALID_JSON)
+		return
+	}
+	bookmark, err := h.svc.AddBookmark(userID, req.TextUUID, req.Position, req.Label)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			respond.ErrorWithCode(w, http.StatusNotFound, respond.CODE_NOT_FOUND)
+		default:
+			respond.RespondErrorWithText(w, http.StatusBadRequest, respond.CODE_INVALID_REQUEST, err.Error())
+		}
+		return
+	}
+	respond.JSON(w, bookmarkResponse(bookmark))
+}
+
+func (h *Handlers) DeleteBookmark(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	err := h.svc.DeleteBookmark(userID, chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			respond.ErrorWithCode(w, http.StatusNotFound, respond.CODE_NOT_FOUND)
+			return
+		}
+		respond.ErrorWithCode(w, http.StatusInternalServerError, respond.CODE_INTERNAL_ERROR)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func bookmarkResponse(bookmark storage.Bookmark) Bookmark {
+	return Bookmark{
+		BookmarkUUID: bookmark.UUID,
+		TextUUID:     bookmark.TextUUID,
+		Position:     bookmark.Position,
+		Label:        bookmark.Label,
+		CreatedAt:    bookmark.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+type Highlight struct {
+	HighlightUUID string `json:"id"`
+	TextUUID      string `json:"textId"`
+	Start         int64  `json:"start"`
+	End           int64  `json:"end"`
+	Text          string `json:"text"`
+	Note          string `json:"note,omitempty"`
+	CreatedAt     string `json:"createdAt"`
+}
+
+type GetHighlightsResponse struct {
+	Highlights []Highlight `json:"highlights"`
+}
+
+// GetHighlights returns highlights of all texts or of the text passed in textId query parameter
+func (h *Handlers) GetHighlights(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	highlights, err := h.svc.Highlights(userID, r.URL.Query().Get("textId"))
+	if err != nil {
+		respond.ErrorWithCode(w, http.StatusInternalServerError, respond.CODE_INTERNAL_ERROR)
+		return
+	}
+	resp := GetHighlightsResponse{Highlights: make([]Highlight, 0, len(highlights))}
+	for _, highlight := range highlights {
+		resp.Highlights = append(resp.Highlights, highlightResponse(highlight))
+	}
+	respond.JSON(w, resp)
+}
+
+type AddHighlightRequest struct {
+	TextUUID string `json:"textId"`
+	Start    int64  `json:"start"`
+	End      int64  `json:"end"`
+	Text     string `json:"text"`
+	Note     string `json:"note"`
+}
+
+func (h *Handlers) AddHighlight(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	var req AddHighlightRequest
+	err := request.DecodeJSON(r.Body, &req)
+	if err != nil {
+		respond.ErrorWithCode(w, http.StatusBadRequest, respond.CODE_INVALID_JSON)
+		return
+	}
+	highlight, err := h.svc.AddHighlight(userID, storage.Highlight{
+		TextUUID: req.TextUUID,
+		Start:    req.Start,
+		End:      req.End,
+		Text:     req.Text,
+		Note:     req.Note,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			respond.ErrorWithCode(w, http.StatusNotFound, respond.CODE_NOT_FOUND)
+		default:
+			respond.RespondErrorWithText(w, http.StatusBadRequest, respond.CODE_INVALID_REQUEST, err.Error())
+		}
+		return
+	}
+	respond.JSON(w, highlightResponse(highlight))
+}
+
+func (h *Handlers) DeleteHighlight(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	err := h.svc.DeleteHighlight(userID, chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			respond.ErrorWithCode(w, http.StatusNotFound, respond.CODE_NOT_FOUND)
+			return
+		}
+		respond.ErrorWithCode(w, http.StatusInternalServerError, respond.CODE_INTERNAL_ERROR)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func highlightResponse(highlight storage.Highlight) Highlight {
+	return Highlight{
+		HighlightUUID: highlight.UUID,
+		TextUUID:      highlight.TextUUID,
+		Start:         highlight.Start,
+		End:           highlight.End,
+		Text:          highlight.Text,
+		Note:          highlight.Note,
+		CreatedAt:     highlight.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// ExportHighlights returns highlights and notes grouped by text in format passed in format query parameter:
+// markdown (default), csv (Readwise import) or json. Only the text passed in textId is exported if it's set.
+func (h *Handlers) ExportHighlights(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	format, err := notesexport.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		respond.RespondErrorWithText(w, http.StatusBadRequest, respond.CODE_INVALID_REQUEST, err.Error())
+		return
+	}
+	file, err := h.svc.ExportHighlights(userID, r.URL.Query().Get("textId"), format)
+	if err != nil {
+		if errors.Is(err, service.ErrNoHighlights) {
+			respond.ErrorWithCode(w, http.StatusNotFound, respond.CODE_NOT_FOUND)
+			return
+		}
+		respond.ErrorWithCode(w, http.StatusInternalServerError, respond.CODE_INTERNAL_ERROR)
+		return
+	}
+	respond.Attachment(w, file.Name, file.ContentType, file.Data)
+}
+
+// Backup returns gzipped json with everything that is stored for the user
+func (h *Handlers) Backup(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	backup, err := h.svc.Backup(userID)
+	if err != nil {
+		respond.ErrorWithCode(w, http.StatusInternalServerError, respond.CODE_INTERNAL_ERROR)
+		return
+	}
+	name := "adhd_reader_backup_" + time.Now().Format(time.DateOnly) + ".json.gz"
+	respond.Attachment(w, name, "application/gzip", backup)
+}
+
+const maxBackupSize = 100 << 20
+
+type RestoreResponse struct {
+	RestoredTexts int `json:"restoredTexts"`
+}
+
+// Restore restores backup uploaded as the request body or as file field of multipart form.
+// Texts are added to the existing ones unless mode query parameter is replace.
+func (h *Handlers) Restore(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	mode := storage.RestoreMode(r.URL.Query().Get("mode"))
+	if mode == "" {
+		mode = storage.RestoreMerge
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBackupSize)
+	var body io.Reader = r.Body
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			respond.RespondErrorWithText(w, http.StatusBadRequest, respond.CODE_INVALID_REQUEST, err.Error())
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		respond.RespondErrorWithText(w, http.StatusBadRequest, respond.CODE_INVALID_REQUEST, err.Error())
+		return
+	}
+	restored, err := h.svc.Restore(userID, data, mode)
+	if err != nil {
+		respond.RespondErrorWithText(w, http.StatusBadRequest, respond.CODE_INVALID_REQUEST, err.Error())
+		return
+	}
+	respond.JSON(w, RestoreResponse{RestoredTexts: restored})
+}
+
+type SearchResponse struct {
+	Results []SearchResult `json:"results"`
+	More    bool           `json:"more"`
+}
+
+type SearchResult struct {
+	TextUUID string `json:"textId"`
+	TextName string `json:"textName"`
+	Chunk    int64  `json:"chunk"`
+	Snippet  string `json:"snippet"`
+}
+
+// Search returns page of chunks that contain all words of the q parameter, pages start from 1
+func (h *Handlers) Search(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	query := r.URL.Query()
+	var page, pageSize int
+	for param, value := range map[string]*int{"page": &page, "pageSize": &pageSize} {
+		if v := query.Get(param); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				respond.RespondErrorWithText(w, http.StatusBadRequest, respond.CODE_INVALID_REQUEST, "invalid "+param)
+				return
+			}
+			*value = n
+		}
+	}
+	results, more, err := h.svc.Search(userID, query.Get("q"), page, pageSize)
+	if err != nil {
+		if errors.Is(err, service.ErrEmptyQuery) {
+			respond.RespondErrorWithText(w, http.StatusBadRequest, respond.CODE_INVALID_REQUEST, err.Error())
+			return
+		}
+		respond.ErrorWithCode(w, http.StatusInternalServerError, respond.CODE_INTERNAL_ERROR)
+		return
+	}
+	resp := SearchResponse{Results: make([]SearchResult, 0, len(results)), More: more}
+	for _, result := range results {
+		resp.Results = append(resp.Results, SearchResult{
+			TextUUID: result.TextUUID,
+			TextName: result.TextName,
+			Chunk:    result.Chunk,
+			Snippet:  result.Snippet,
+		})
+	}
+	respond.JSON(w, resp)
+}
+
+type SetTextStateRequest struct {
+	State string `json:"state"`
+}
+
+type SetTextTagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+type TextLibraryResponse struct {
+	TextUUID string   `json:"id"`
+	State    string   `json:"state"`
+	Tags     []string `json:"tags"`
+}
+
+// SetTextState moves the text to one of the reading list states
+func (h *Handlers) SetTextState(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	var req SetTextStateRequest
+	if err := request.DecodeJSON(r.Body, &req); err != nil {
+		respond.ErrorWithCode(w, http.StatusBadRequest, respond.CODE_INVALID_JSON)
+		return
+	}
+	text, err := h.svc.SetTextState(userID, chi.URLParam(r, "id"), storage.TextState(req.State))
+	h.respondTextLibrary(w, text, err)
+}
+
+// SetTextTags replaces tags of the text
+func (h *Handlers) SetTextTags(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	var req SetTextTagsRequest
+	if err := request.DecodeJSON(r.Body, &req); err != nil {
+		respond.ErrorWithCode(w, http.StatusBadRequest, respond.CODE_INVALID_JSON)
+		return
+	}
+	text, err := h.svc.SetTextTags(userID, chi.URLParam(r, "id"), req.Tags)
+	h.respondTextLibrary(w, text, err)
+}
+
+func (h *Handlers) respondTextLibrary(w http.ResponseWriter, text storage.Text, err error) {
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			respond.ErrorWithCode(w, http.StatusNotFound, respond.CODE_NOT_FOUND)
+		case errors.Is(err, service.ErrUnknownTextState), errors.Is(err, service.ErrInvalidTag):
+			respond.RespondErrorWithText(w, http.StatusBadRequest, respond.CODE_INVALID_REQUEST, err.Error())
+		default:
+			respond.ErrorWithCode(w, http.StatusInternalServerError, respond.CODE_INTERNAL_ERROR)
+		}
+		return
+	}
+	tags := text.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	respond.JSON(w, TextLibraryResponse{
+		TextUUID: text.UUID,
+		State:    string(text.State),
+		Tags:     tags,
+	})
+}
+
+type GetTagsResponse struct {
+	Tags []Tag `json:"tags"`
+}
+
+type Tag struct {
+	Name  string `json:"name"`
+	Texts int    `json:"texts"`
+}
+
+// GetTags returns tags of the user's texts with number of texts for every tag
+func (h *Handlers) GetTags(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	tags, err := h.svc.Tags(userID)
+	if err != nil {
+		respond.ErrorWithCode(w, http.StatusInternalServerError, respond.CODE_INTERNAL_ERROR)
+		return
+	}
+	resp := GetTagsResponse{Tags: make([]Tag, 0, len(tags))}
+	for _, tag := range tags {
+		resp.Tags = append(resp.Tags, Tag{Name: tag.Tag, Texts: tag.Texts})
+	}
+	respond.JSON(w, resp)
+}
+
+type GoalResponse struct {
+	Chunks   int64  `json:"chunks"`
+	Minutes  int64  `json:"minutes"`
+	Timezone string `json:"timezone"`
+}
+
+type HistoryDay struct {
+	Date    string `json:"date"` // YYYY-MM-DD in the user's timezone
+	Chunks  int64  `json:"chunks"`
+	Chars   int64  `json:"chars"`
+	Words   int64  `json:"words"`
+	Seconds int64  `json:"seconds"`
+	Reached bool   `json:"reached"` // the goal is reached
+}
+
+type GetHistoryResponse struct {
+	Goal          GoalResponse `json:"goal"`
+	Days          []HistoryDay `json:"days"`
+	CurrentStreak int          `json:"currentStreak"`
+	LongestStreak int          `json:"longestStreak"`
+}
+
+// GetHistory returns what the user read by days for the last days (30 by default) and reading streaks
+func (h *Handlers) GetHistory(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	days := 30
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > service.MaxHistoryDays {
+			respond.RespondErrorWithText(w, http.StatusBadRequest, respond.CODE_INVALID_REQUEST, "invalid days")
+			return
+		}
+		days = n
+	}
+	history, err := h.svc.History(userID, days)
+	if err != nil {
+		respond.ErrorWithCode(w, http.StatusInternalServerError, respond.CODE_INTERNAL_ERROR)
+		return
+	}
+	resp := GetHistoryResponse{
+		Goal: GoalResponse{
+			Chunks:   history.Goal.Chunks,
+			Minutes:  history.Goal.Minutes,
+			Timezone: history.Timezone,
+		},
+		Days:          make([]HistoryDay, 0, len(history.Days)),
+		CurrentStreak: history.CurrentStreak,
+		LongestStreak: history.LongestStreak,
+	}
+	for _, day := range history.Days {
+		resp.Days = append(resp.Days, HistoryDay{
+			Date:    day.Date.Format(time.DateOnly),
+			Chunks:  day.Chunks,
+			Chars:   day.Chars,
+			Words:   day.Words,
+			Seconds: day.Seconds,
+			Reached: day.Reached(history.Goal),
+		})
+	}
+	respond.JSON(w, resp)
+}
+
+type SetGoalRequest struct {
+	Chunks   int64  `json:"chunks"`
+	Minutes  int64  `json:"minutes"`
+	Timezone string `json:"timezone,omitempty"` // the timezone isn't changed if empty
+}
+
+// SetGoal sets the daily goal in chunks or minutes, zero goal is reached by any chunk
+func (h *Handlers) SetGoal(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	var req SetGoalRequest
+	if err := request.DecodeJSON(r.Body, &req); err != nil {
+		respond.ErrorWithCode(w, http.StatusBadRequest, respond.CODE_INVALID_JSON)
+		return
+	}
+	err := h.svc.SetGoal(userID, service.Goal{Chunks: req.Chunks, Minutes: req.Minutes}, req.Timezone)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidGoal) {
+			respond.RespondErrorWithText(w, http.StatusBadRequest, respond.CODE_INVALID_REQUEST, err.Error())
+			return
+		}
+		respond.ErrorWithCode(w, http.StatusInternalServerError, respond.CODE_INTERNAL_ERROR)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type PeriodStats struct {
+	Days    int   `json:"days"` // 0 for all time
+	Chunks  int64 `json:"chunks"`
+	Words   int64 `json:"words"`
+	Chars   int64 `json:"chars"`
+	Seconds int64 `json:"seconds"`
+}
+
+type TextEstimate struct {
+	TextUUID         string `json:"id"`
+	Name             string `json:"name"`
+	Current          bool   `json:"current"`
+	Percent          int    `json:"percent"`
+	RemainingChars   int64  `json:"remainingChars"`
+	RemainingSeconds int64  `json:"remainingSeconds"`
+}
+
+type GetStatsResponse struct {
+	Timezone      string         `json:"timezone"`
+	Today         PeriodStats    `json:"today"`
+	Week          PeriodStats    `json:"week"`
+	Month         PeriodStats    `json:"month"`
+	Total         PeriodStats    `json:"total"`
+	Hours         []int64        `json:"hours"` // seconds of reading by hour of the day in the timezone
+	FinishedTexts int            `json:"finishedTexts"`
+	Texts         []TextEstimate `json:"texts"`
+}
+
+// GetStats returns reading statistics of the user
+func (h *Handlers) GetStats(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	stats, err := h.svc.ReadingStats(userID)
+	if err != nil {
+		respond.ErrorWithCode(w, http.StatusInternalServerError, respond.CODE_INTERNAL_ERROR)
+		return
+	}
+	resp := GetStatsResponse{
+		Timezone:      stats.Timezone,
+		Today:         PeriodStats(stats.Today),
+		Week:          PeriodStats(stats.Week),
+		Month:         PeriodStats(stats.Month),
+		Total:         PeriodStats(stats.Total),
+		Hours:         stats.Hours[:],
+		FinishedTexts: stats.FinishedTexts,
+		Texts:         make([]TextEstimate, 0, len(stats.Texts)),
+	}
+	for _, text := range stats.Texts {
+		resp.Texts = append(resp.Texts, TextEstimate{
+			TextUUID:         text.UUID,
+			Name:             text.Name,
+			Current:          text.Current,
+			Percent:          text.Percent,
+			RemainingChars:   text.RemainingChars,
+			RemainingSeconds: text.RemainingSeconds,
+		})
+	}
+	respond.JSON(w, resp)
+}
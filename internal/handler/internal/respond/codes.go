@@ -8,4 +8,6 @@ const (
 	CODE_INVALID_JSON           = 5
 	CODE_ALREADY_AT_FIRST_CHUNK = 6
 	CODE_ALREADY_AT_LAST_CHUNK  = 7
+	CODE_NOT_FOUND              = 8
+	CODE_INVALID_REQUEST        = 9
 )
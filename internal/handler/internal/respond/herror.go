@@ -3,6 +3,7 @@ package respond
 import (
 	"encoding/json"
 	"log"
+	"mime"
 	"net/http"
 )
 
@@ -28,3 +29,21 @@ func JSON(w http.ResponseWriter, v interface{}) {
 		log.Printf("failed to encode response: %v", err)
 	}
 }
+
+func File(w http.ResponseWriter, content []byte) {
+	w.Header().Set("Content-Type", http.DetectContentType(content))
+	_, err := w.Write(content)
+	if err != nil {
+		log.Printf("failed to write file: %v", err)
+	}
+}
+
+// Attachment responds with file that should be saved by the client under the name
+func Attachment(w http.ResponseWriter, name, contentType string, content []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	_, err := w.Write(content)
+	if err != nil {
+		log.Printf("failed to write file: %v", err)
+	}
+}
@@ -0,0 +1,292 @@
+package service
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pechorka/adhd-reader/internal/storage"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrUnknownRecipe = errors.New("unknown recipe")
+	ErrInvalidMix    = errors.New("invalid mix")
+	ErrNotEnoughLoot = errors.New("not enough loot")
+)
+
+// DefaultRecipes are saved by SeedRecipes. Herbs are rare, so recipes need only a few of them.
+var DefaultRecipes = []storage.Recipe{
+	{
+		Name:          "focus",
+		RecipeDustMin: storage.Dust{RedCount: 2, YellowCount: 1},
+		RecipeDustMax: storage.Dust{RedCount: 6, YellowCount: 4},
+		RecipeHerbMax: storage.Herb{MelissaCount: 1},
+		Buff:          storage.BuffExp,
+		Multiplier:    1.5,
+		Duration:      30 * time.Minute,
+	},
+	{
+		Name:          "fortune",
+		RecipeDustMin: storage.Dust{YellowCount: 2, GreenCount: 1},
+		RecipeDustMax: storage.Dust{YellowCount: 6, GreenCount: 4},
+		RecipeHerbMax: storage.Herb{LavandaCount: 1},
+		Buff:          storage.BuffLoot,
+		Multiplier:    1.5,
+		Duration:      30 * time.Minute,
+	},
+	{
+		Name:          "insight",
+		RecipeDustMin: storage.Dust{BlueCount: 2, IndigoCount: 2, PurpleCount: 1},
+		RecipeDustMax: storage.Dust{BlueCount: 5, IndigoCount: 5, PurpleCount: 2},
+		RecipeHerbMin: storage.Herb{MelissaCount: 1},
+		RecipeHerbMax: storage.Herb{MelissaCount: 2},
+		Buff:          storage.BuffExp,
+		Multiplier:    2,
+		Duration:      time.Hour,
+	},
+	{
+		Name:          "treasure",
+		RecipeDustMin: storage.Dust{OrangeCount: 1, WhiteCount: 1, BlackCount: 1},
+		RecipeDustMax: storage.Dust{OrangeCount: 3, WhiteCount: 2, BlackCount: 2},
+		RecipeHerbMin: storage.Herb{LavandaCount: 1},
+		RecipeHerbMax: storage.Herb{LavandaCount: 2},
+		Buff:          storage.BuffLoot,
+		Multiplier:    2,
+		Duration:      30 * time.Minute,
+	},
+}
+
+// SeedRecipes saves DefaultRecipes, saved recipes with the same names are replaced,
+// so changed definitions are applied on start
+func (s *Service) SeedRecipes() error {
+	for _, recipe := range DefaultRecipes {
+		if err := s.s.SaveRecipe(recipe); err != nil {
+			return errors.Wrapf(err, "failed to save recipe %q", recipe.Name)
+		}
+	}
+	return nil
+}
+
+// IngredientNames are names of dust and herbs accepted by ParseMix
+var IngredientNames = []string{"red", "orange", "yellow", "green", "blue", "indigo", "purple", "white", "black", "lavanda", "melissa"}
+
+// Mix is dust and herbs spent on crafting
+type Mix struct {
+	Dust Dust
+	Herb Herb
+}
+
+// amounts returns pointers to amounts of ingredients in order of IngredientNames
+func (m *Mix) amounts() []*int64 {
+	return []*int64{
+		&m.Dust.RedCount, &m.Dust.OrangeCount, &m.Dust.YellowCount, &m.Dust.GreenCount, &m.Dust.BlueCount,
+		&m.Dust.IndigoCount, &m.Dust.PurpleCount, &m.Dust.WhiteCount, &m.Dust.BlackCount,
+		&m.Herb.LavandaCount, &m.Herb.MelissaCount,
+	}
+}
+
+func (m Mix) total() int64 {
+	return m.Dust.TotalDust() + m.Herb.TotalHerb()
+}
+
+func mixOf(dust storage.Dust, herb storage.Herb) Mix {
+	return Mix{Dust: *mapDbDustToDust(&dust), Herb: *mapDbHerbToHerb(&herb)}
+}
+
+// ParseMix parses amounts of ingredients like "red=3 melissa=1"
+func ParseMix(s string) (map[string]int64, error) {
+	amounts := make(map[string]int64)
+	for _, field := range strings.Fields(strings.ToLower(s)) {
+		name, value, ok := strings.Cut(field, "=")
+		if !ok || !slices.Contains(IngredientNames, name) {
+			return nil, errors.Wrapf(ErrInvalidMix, "expected ingredient=amount, got %q", field)
+		}
+		amount, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || amount < 0 {
+			return nil, errors.Wrapf(ErrInvalidMix, "invalid amount of %s %q", name, value)
+		}
+		amounts[name] = amount
+	}
+	return amounts, nil
+}
+
+// CraftRecipe is a recipe with the mix the user crafted it with last time
+type CraftRecipe struct {
+	Name       string
+	Min        Mix
+	Max        Mix
+	Ideal      *Mix // nil if the user didn't craft the recipe
+	Buff       storage.BuffKind
+	Multiplier float64
+	// Duration is how long the buff of the minimal mix lasts, the maximal mix doubles it
+	Duration time.Duration
+}
+
+// Recipes returns recipes sorted by name with ideal mixes of the user
+func (s *Service) Recipes(userID int64) ([]CraftRecipe, error) {
+	recipes, err := s.s.GetRecipes()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get recipes")
+	}
+	result := make([]CraftRecipe, 0, len(recipes))
+	for _, recipe := range recipes {
+		craftRecipe, err := s.craftRecipe(userID, recipe)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, craftRecipe)
+	}
+	return result, nil
+}
+
+func (s *Service) craftRecipe(userID int64, recipe storage.Recipe) (CraftRecipe, error) {
+	userRecipe, err := s.s.GetUserRecipeByUserIDandRecipeName(userID, recipe.Name)
+	if err != nil {
+		return CraftRecipe{}, errors.Wrap(err, "failed to get user recipe")
+	}
+	craftRecipe := CraftRecipe{
+		Name:       recipe.Name,
+		Min:        mixOf(recipe.RecipeDustMin, recipe.RecipeHerbMin),
+		Max:        mixOf(recipe.RecipeDustMax, recipe.RecipeHerbMax),
+		Buff:       recipe.Buff,
+		Multiplier: recipe.Multiplier,
+		Duration:   recipe.Duration,
+	}
+	if userRecipe.RecipeName != "" {
+		ideal := mixOf(userRecipe.IdealDusts, userRecipe.IdealHerbs)
+		craftRecipe.Ideal = &ideal
+	}
+	return craftRecipe, nil
+}
+
+// Crafted is the result of crafting
+type Crafted struct {
+	Mix  Mix
+	Buff storage.Buff // with the time the buff ends after crafting
+}
+
+// Craft spends the mix of dust and herbs on the recipe and gives the buff of the recipe.
+// Amounts override the ideal mix of the user or the recipe minimum if the recipe isn't crafted yet.
+// The spent mix becomes the new ideal mix of the recipe.
+func (s *Service) Craft(userID int64, recipeName string, amounts map[string]int64) (Crafted, error) {
+	recipe, err := s.s.GetRecipeByName(strings.ToLower(recipeName))
+	if err != nil {
+		return Crafted{}, errors.Wrap(err, "failed to get recipe")
+	}
+	if recipe.Name == "" {
+		return Crafted{}, errors.Wrapf(ErrUnknownRecipe, "recipe %q", recipeName)
+	}
+	craftRecipe, err := s.craftRecipe(userID, recipe)
+	if err != nil {
+		return Crafted{}, err
+	}
+	mix := craftRecipe.Min
+	if craftRecipe.Ideal != nil {
+		mix = *craftRecipe.Ideal
+	}
+	mixAmounts, minAmounts, maxAmounts := mix.amounts(), craftRecipe.Min.amounts(), craftRecipe.Max.amounts()
+	for i, name := range IngredientNames {
+		if amount, ok := amounts[name]; ok {
+			*mixAmounts[i] = amount
+		}
+		if *mixAmounts[i] < *minAmounts[i] || *mixAmounts[i] > *maxAmounts[i] {
+			return Crafted{}, errors.Wrapf(ErrInvalidMix, "%s should be between %d and %d", name, *minAmounts[i], *maxAmounts[i])
+		}
+	}
+
+	// the balance is checked in the same transaction, so concurrent crafts can't spend the same loot
+	err = s.s.UpdateLoot(userID, func(d *storage.Dust, h *storage.Herb) error {
+		balance := Mix{Dust: Dust(*d), Herb: Herb(*h)}
+		for i, amount := range balance.amounts() {
+			if *amount < *mixAmounts[i] {
+				return errors.Wrapf(ErrNotEnoughLoot, "%s: have %d, need %d", IngredientNames[i], *amount, *mixAmounts[i])
+			}
+		}
+		d.RedCount -= mix.Dust.RedCount
+		d.OrangeCount -= mix.Dust.OrangeCount
+		d.YellowCount -= mix.Dust.YellowCount
+		d.GreenCount -= mix.Dust.GreenCount
+		d.BlueCount -= mix.Dust.BlueCount
+		d.IndigoCount -= mix.Dust.IndigoCount
+		d.PurpleCount -= mix.Dust.PurpleCount
+		d.WhiteCount -= mix.Dust.WhiteCount
+		d.BlackCount -= mix.Dust.BlackCount
+		h.LavandaCount -= mix.Herb.LavandaCount
+		h.MelissaCount -= mix.Herb.MelissaCount
+		return nil
+	})
+	if err != nil {
+		return Crafted{}, errors.Wrap(err, "failed to spend loot")
+	}
+	_, err = s.s.UpdateUserRecipe(userID, recipe.Name, func(r *storage.UserRecipe) {
+		r.IdealDusts = storage.Dust(mix.Dust)
+		r.IdealHerbs = storage.Herb(mix.Herb)
+	})
+	if err != nil {
+		return Crafted{}, errors.Wrap(err, "failed to save ideal mix")
+	}
+
+	now := s.now()
+	duration := buffDuration(craftRecipe, mix)
+	var buff storage.Buff
+	_, err = s.s.UpdateBuffs(userID, func(buffs []storage.Buff) []storage.Buff {
+		buffs = slices.DeleteFunc(buffs, func(b storage.Buff) bool { return !b.Until.After(now) })
+		// crafting the same recipe again prolongs the buff
+		i := slices.IndexFunc(buffs, func(b storage.Buff) bool { return b.Recipe == recipe.Name })
+		if i < 0 {
+			buffs = append(buffs, storage.Buff{Recipe: recipe.Name, Until: now})
+			i = len(buffs) - 1
+		}
+		buffs[i].Kind, buffs[i].Multiplier = recipe.Buff, recipe.Multiplier
+		buffs[i].Until = buffs[i].Until.Add(duration)
+		buff = buffs[i]
+		return buffs
+	})
+	if err != nil {
+		return Crafted{}, errors.Wrap(err, "failed to add buff")
+	}
+	return Crafted{Mix: mix, Buff: buff}, nil
+}
+
+// buffDuration grows from the recipe duration for the minimal mix to twice of it for the maximal mix
+func buffDuration(recipe CraftRecipe, mix Mix) time.Duration {
+	share := 0.0
+	if spread := recipe.Max.total() - recipe.Min.total(); spread > 0 {
+		share = float64(mix.total()-recipe.Min.total()) / float64(spread)
+	}
+	return time.Duration(float64(recipe.Duration) * (1 + share)).Round(time.Minute)
+}
+
+// ActiveBuffs returns buffs of the user which haven't ended yet
+func (s *Service) ActiveBuffs(userID int64) ([]storage.Buff, error) {
+	buffs, err := s.s.GetBuffs(userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get buffs")
+	}
+	now := s.now()
+	return slices.DeleteFunc(buffs, func(b storage.Buff) bool { return !b.Until.After(now) }), nil
+}
+
+// buffMultiplier returns the greatest multiplier of active buffs of the kind, buffs don't stack
+func (s *Service) buffMultiplier(userID int64, kind storage.BuffKind) (float64, error) {
+	buffs, err := s.ActiveBuffs(userID)
+	if err != nil {
+		return 0, err
+	}
+	multiplier := 1.0
+	for _, buff := range buffs {
+		if buff.Kind == kind {
+			multiplier = max(multiplier, buff.Multiplier)
+		}
+	}
+	return multiplier, nil
+}
+
+func (m *Mix) multiply(multiplier float64) {
+	for _, amount := range m.amounts() {
+		*amount = int64(math.Round(float64(*amount) * multiplier))
+	}
+}
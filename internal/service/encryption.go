@@ -0,0 +1,48 @@
+package service
+
+import (
+	"github.com/pkg/errors"
+)
+
+// EncryptTexts encrypts texts and notes of the user, encrypts them with a new key if they are encrypted already
+func (s *Service) EncryptTexts(userID int64) error {
+	return s.s.EncryptTexts(userID)
+}
+
+// DecryptTexts turns off encryption of the user's texts and notes
+func (s *Service) DecryptTexts(userID int64) error {
+	return s.s.DecryptTexts(userID)
+}
+
+func (s *Service) TextsEncrypted(userID int64) (bool, error) {
+	return s.s.TextsEncrypted(userID)
+}
+
+// RotateEncryptionKey makes the encryptor encrypt with a new key and re-encrypts data keys of users with it.
+// Tokens issued before are still valid. Returns version of the new key and number of re-encrypted data keys.
+func (s *Service) RotateEncryptionKey() (int, int, error) {
+	version, err := s.encryptor.Rotate()
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to rotate encryption key")
+	}
+	count, err := s.s.RewrapDataKeys()
+	if err != nil {
+		return version, 0, errors.Wrap(err, "failed to re-encrypt data keys")
+	}
+	return version, count, nil
+}
+
+// RetireEncryptionKeys re-encrypts data keys of users with the newest key and forgets older keys,
+// so a leaked old secret can't decrypt anything. Tokens issued with older keys become invalid and
+// have to be reissued. Returns the newest retired version and number of re-encrypted data keys.
+func (s *Service) RetireEncryptionKeys() (int, int, error) {
+	count, err := s.s.RewrapDataKeys()
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to re-encrypt data keys")
+	}
+	version, err := s.encryptor.Retire()
+	if err != nil {
+		return 0, count, errors.Wrap(err, "failed to retire encryption keys")
+	}
+	return version, count, nil
+}
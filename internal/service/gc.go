@@ -0,0 +1,16 @@
+package service
+
+import (
+	"time"
+
+	"github.com/pechorka/adhd-reader/internal/storage"
+)
+
+// gcGracePeriod protects files that were just processed from garbage collection,
+// text referencing such file can be not saved yet
+const gcGracePeriod = time.Hour
+
+// CollectGarbage removes texts and files that are not used by any user and compacts the storage
+func (s *Service) CollectGarbage() (storage.GCReport, error) {
+	return s.s.CollectGarbage(s.now().Add(-gcGracePeriod))
+}
@@ -0,0 +1,281 @@
+package service
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pechorka/adhd-reader/internal/storage"
+	"github.com/pechorka/adhd-reader/pkg/markup"
+	"github.com/pkg/errors"
+)
+
+var ErrInvalidGoal = errors.New("invalid goal")
+
+const (
+	maxGoalChunks  = 1000
+	maxGoalMinutes = 24 * 60
+	// MaxHistoryDays is how many days History returns at most
+	MaxHistoryDays = 366
+	// streakLookbackDays is how many days before the requested ones are read to count streaks,
+	// it covers the longest celebrated milestone
+	streakLookbackDays = 365
+)
+
+// streakMilestones are streak lengths in days which are celebrated
+var streakMilestones = []int{3, 7, 14, 30, 50, 100, 200, 365}
+
+// Goal is how much the user wants to read every day, zero goal is reached by any chunk
+type Goal struct {
+	Chunks  int64
+	Minutes int64
+}
+
+// ParseGoal parses goal like "10" chunks or "15m" minutes with optional timezone like "15m Europe/Moscow",
+// "off" is zero goal. Timezone is IANA name or UTC offset, empty if not set.
+func ParseGoal(s string) (Goal, string, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return Goal{}, "", errors.Wrap(ErrInvalidGoal, "expected goal and optional timezone")
+	}
+	var goal Goal
+	switch amount := strings.ToLower(fields[0]); {
+	case amount == "off":
+	case strings.HasSuffix(amount, "m"):
+		minutes, err := strconv.ParseInt(strings.TrimSuffix(amount, "m"), 10, 64)
+		if err != nil || minutes < 1 {
+			return Goal{}, "", errors.Wrapf(ErrInvalidGoal, "invalid minutes %q", amount)
+		}
+		goal.Minutes = minutes
+	default:
+		chunks, err := strconv.ParseInt(amount, 10, 64)
+		if err != nil || chunks < 1 {
+			return Goal{}, "", errors.Wrapf(ErrInvalidGoal, "invalid chunks %q", amount)
+		}
+		goal.Chunks = chunks
+	}
+	var timezone string
+	if len(fields) == 2 {
+		timezone = fields[1]
+	}
+	return goal, timezone, checkGoal(goal, timezone)
+}
+
+// checkGoal checks that the goal is either in chunks or minutes and timezone is known, empty timezone is allowed
+func checkGoal(goal Goal, timezone string) error {
+	switch {
+	case goal.Chunks < 0 || goal.Chunks > maxGoalChunks:
+		return errors.Wrapf(ErrInvalidGoal, "chunks should be between 1 and %d", maxGoalChunks)
+	case goal.Minutes < 0 || goal.Minutes > maxGoalMinutes:
+		return errors.Wrapf(ErrInvalidGoal, "minutes should be between 1 and %d", maxGoalMinutes)
+	case goal.Chunks > 0 && goal.Minutes > 0:
+		return errors.Wrap(ErrInvalidGoal, "goal is set both in chunks and minutes")
+	}
+	if timezone == "" {
+		return nil
+	}
+	if _, err := loadTimezone(timezone); err != nil {
+		return errors.Wrapf(ErrInvalidGoal, "unknown timezone %q", timezone)
+	}
+	return nil
+}
+
+// FormatGoal returns goal in the form accepted by ParseGoal
+func FormatGoal(goal Goal) string {
+	switch {
+	case goal.Chunks > 0:
+		return strconv.FormatInt(goal.Chunks, 10)
+	case goal.Minutes > 0:
+		return strconv.FormatInt(goal.Minutes, 10) + "m"
+	}
+	return "off"
+}
+
+// SetGoal saves the daily goal, empty timezone keeps the timezone set before
+func (s *Service) SetGoal(userID int64, goal Goal, timezone string) error {
+	if err := checkGoal(goal, timezone); err != nil {
+		return err
+	}
+	_, err := s.s.UpdateReading(userID, func(r *storage.Reading) {
+		r.GoalChunks, r.GoalMinutes = goal.Chunks, goal.Minutes
+		if timezone != "" {
+			r.Timezone = timezone
+		}
+	})
+	return errors.Wrap(err, "failed to save goal")
+}
+
+// DayStats is what the user read during the day
+type DayStats struct {
+	Date    time.Time // midnight in the user's timezone
+	Chunks  int64
+	Chars   int64
+	Words   int64
+	Seconds int64 // time to read the chunks at the user's reading speed
+}
+
+// Reached returns true if the goal is reached during the day
+func (d DayStats) Reached(goal Goal) bool {
+	switch {
+	case goal.Chunks > 0:
+		return d.Chunks >= goal.Chunks
+	case goal.Minutes > 0:
+		return d.Seconds >= goal.Minutes*60
+	}
+	return d.Chunks > 0
+}
+
+// History is the reading history of the user by days
+type History struct {
+	Goal          Goal
+	Timezone      string
+	Days          []DayStats // the oldest first, the last day is today
+	CurrentStreak int        // days in a row the goal is reached, today is counted if the goal is reached already
+	LongestStreak int        // the longest streak in the last year before the returned days
+}
+
+// Today returns stats of the current day
+func (h History) Today() DayStats {
+	return h.Days[len(h.Days)-1]
+}
+
+// History returns reading history of the user for the last days including today.
+// Streaks are counted with the current goal for all days.
+func (s *Service) History(userID int64, days int) (History, error) {
+	history, _, err := s.history(userID, days)
+	return history, err
+}
+
+// history returns History and reading events of the user it's built from
+func (s *Service) history(userID int64, days int) (History, []storage.ReadingEvent, error) {
+	if days < 1 || days > MaxHistoryDays {
+		return History{}, nil, errors.Errorf("days should be between 1 and %d", MaxHistoryDays)
+	}
+	reading, err := s.s.GetReadingByUserID(userID)
+	if err != nil {
+		return History{}, nil, errors.Wrap(err, "failed to get reading")
+	}
+	// the extra day covers any timezone of the user
+	since := s.now().AddDate(0, 0, -(streakLookbackDays + days + 1))
+	events, err := s.s.GetReadingEvents(userID, since)
+	if err != nil {
+		return History{}, nil, errors.Wrap(err, "failed to get reading history")
+	}
+	return buildHistory(reading, events, s.now(), days), events, nil
+}
+
+func buildHistory(reading storage.Reading, events []storage.ReadingEvent, now time.Time, days int) History {
+	history := History{
+		Goal:     Goal{Chunks: reading.GoalChunks, Minutes: reading.GoalMinutes},
+		Timezone: reading.Timezone,
+	}
+	if history.Timezone == "" {
+		history.Timezone = "UTC"
+	}
+	loc, err := loadTimezone(history.Timezone)
+	if err != nil {
+		loc = time.UTC // timezone is checked when it's set, so it can fail only if tzdata changed
+	}
+
+	byDay := make(map[string]DayStats) // by date in the user's timezone
+	for _, event := range events {
+		date := startOfDay(event.At, loc)
+		day := byDay[dateKey(date)]
+		day.Date = date
+		day.Chunks++
+		day.Chars += event.Chars
+		day.Words += event.Words
+		day.Seconds += event.Seconds
+		byDay[dateKey(date)] = day
+	}
+
+	today := startOfDay(now, loc)
+	for i := days - 1; i >= 0; i-- {
+		date := addDays(today, -i)
+		day := byDay[dateKey(date)]
+		day.Date = date
+		history.Days = append(history.Days, day)
+	}
+
+	reached := func(date time.Time) bool { return byDay[dateKey(date)].Reached(history.Goal) }
+	date := today
+	if !reached(date) {
+		date = addDays(date, -1) // the streak isn't broken until the day is over
+	}
+	for ; reached(date); date = addDays(date, -1) {
+		history.CurrentStreak++
+	}
+
+	var reachedDates []time.Time
+	for _, day := range byDay {
+		if day.Reached(history.Goal) {
+			reachedDates = append(reachedDates, day.Date)
+		}
+	}
+	slices.SortFunc(reachedDates, time.Time.Compare)
+	streak := 0
+	for i, date := range reachedDates {
+		if i > 0 && dateKey(addDays(reachedDates[i-1], 1)) == dateKey(date) {
+			streak++
+		} else {
+			streak = 1
+		}
+		history.LongestStreak = max(history.LongestStreak, streak)
+	}
+	return history
+}
+
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// addDays adds days keeping midnight, days can be shorter or longer than 24 hours
+func addDays(date time.Time, days int) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day()+days, 0, 0, 0, 0, date.Location())
+}
+
+func dateKey(date time.Time) string {
+	return date.Format(time.DateOnly)
+}
+
+// StreakMilestone returns length of the current streak if the last shown chunk reached today's goal
+// and the streak is one of the celebrated milestones, otherwise it returns 0.
+// It's called right after the next chunk is shown.
+func (s *Service) StreakMilestone(userID int64) (int, error) {
+	history, events, err := s.history(userID, 1)
+	if err != nil {
+		return 0, err
+	}
+	today := history.Today()
+	if len(events) == 0 || !today.Reached(history.Goal) || !slices.Contains(streakMilestones, history.CurrentStreak) {
+		return 0, nil
+	}
+	// today has events as the goal is reached, so the last event is today's
+	last := events[len(events)-1]
+	before := today
+	before.Chunks--
+	before.Chars -= last.Chars
+	before.Words -= last.Words
+	before.Seconds -= last.Seconds
+	if before.Reached(history.Goal) {
+		return 0, nil
+	}
+	return history.CurrentStreak, nil
+}
+
+// logReading adds the chunk shown by trackReading to the reading history
+func (s *Service) logReading(userID int64, text storage.Text, chunk string, reading storage.Reading) error {
+	seconds := float64(reading.LastChunkLen) / readingSpeed(reading, text.Language) * 60
+	err := s.s.AddReadingEvent(userID, storage.ReadingEvent{
+		At:       reading.LastChunkAt,
+		TextUUID: text.UUID,
+		Chunk:    text.CurrentChunk,
+		Chars:    reading.LastChunkLen,
+		Words:    int64(len(strings.Fields(markup.PlainText(chunk)))),
+		Seconds:  int64(math.Round(seconds)),
+	})
+	return errors.Wrap(err, "failed to log reading")
+}
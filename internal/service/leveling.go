@@ -0,0 +1,182 @@
+package service
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/pechorka/adhd-reader/internal/storage"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidLeveling = errors.New("invalid leveling")
+	ErrUnknownStat     = errors.New("unknown stat")
+	ErrNoFreePoints    = errors.New("no free stat points")
+)
+
+//go:embed leveling.json
+var defaultLevelingJSON []byte
+
+// Leveling is the leveling curve, the default one is in leveling.json
+type Leveling struct {
+	// FirstLevelExp is experience needed for the first level,
+	// every next level needs ExpGrowth times more up to MaxLevelExp.
+	// The default cap is what level 100 needs, so levels reached before the curve was configurable stay the same.
+	FirstLevelExp int64   `json:"first_level_exp"`
+	ExpGrowth     float64 `json:"exp_growth"`
+	MaxLevelExp   int64   `json:"max_level_exp"`
+	// LevelStats are stats given for reaching the level, index is the level.
+	// Every level after them gives FreePointsPerLevel free points to spend with SpendFreePoint.
+	LevelStats         []Stat `json:"level_stats"`
+	FreePointsPerLevel int64  `json:"free_points_per_level"`
+}
+
+// DefaultLeveling returns the leveling curve from leveling.json
+func DefaultLeveling() Leveling {
+	leveling, err := ParseLeveling(defaultLevelingJSON)
+	if err != nil {
+		panic(err) // the file is embedded and checked by tests
+	}
+	return leveling
+}
+
+// LoadLeveling reads the leveling curve from the json file
+func LoadLeveling(path string) (Leveling, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Leveling{}, errors.Wrap(err, "failed to read leveling")
+	}
+	return ParseLeveling(data)
+}
+
+func ParseLeveling(data []byte) (Leveling, error) {
+	var leveling Leveling
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields() // typo in the name of a stat would silently drop it
+	if err := dec.Decode(&leveling); err != nil {
+		return Leveling{}, errors.Wrap(ErrInvalidLeveling, err.Error())
+	}
+	switch {
+	case leveling.FirstLevelExp < 1:
+		return Leveling{}, errors.Wrap(ErrInvalidLeveling, "first_level_exp should be positive")
+	case leveling.ExpGrowth < 1:
+		return Leveling{}, errors.Wrap(ErrInvalidLeveling, "exp_growth should be at least 1")
+	case leveling.MaxLevelExp < leveling.FirstLevelExp:
+		// without the cap experience for high levels overflows
+		return Leveling{}, errors.Wrap(ErrInvalidLeveling, "max_level_exp should be at least first_level_exp")
+	case leveling.FreePointsPerLevel < 0:
+		return Leveling{}, errors.Wrap(ErrInvalidLeveling, "free_points_per_level should not be negative")
+	}
+	return leveling, nil
+}
+
+// levelExp returns experience needed to reach the level from the previous one
+func (l Leveling) levelExp(level int64, previous int64) int64 {
+	if level <= 1 {
+		return l.FirstLevelExp
+	}
+	return min(int64(float64(previous)*l.ExpGrowth), l.MaxLevelExp)
+}
+
+// LevelByExperience returns the level reached with the experience
+func (l Leveling) LevelByExperience(experience int64) int64 {
+	level, threshold, exp := int64(0), int64(0), int64(0)
+	for {
+		exp = l.levelExp(level+1, exp)
+		if exp == l.MaxLevelExp {
+			// the rest of levels need the same experience
+			return level + max(0, (experience-threshold)/exp)
+		}
+		if experience < threshold+exp {
+			return level
+		}
+		level++
+		threshold += exp
+	}
+}
+
+// ExperienceForLevel returns experience needed to reach the level
+func (l Leveling) ExperienceForLevel(level int64) int64 {
+	threshold, exp := int64(0), int64(0)
+	for i := int64(1); i <= level; i++ {
+		exp = l.levelExp(i, exp)
+		if exp == l.MaxLevelExp {
+			return threshold + (level-i+1)*exp
+		}
+		threshold += exp
+	}
+	return threshold
+}
+
+// StatsForLevel returns stats given for reaching the level from the start, including free points
+func (l Leveling) StatsForLevel(level int64) Stat {
+	return l.levelUpStats(-1, level)
+}
+
+// levelUpStats returns stats given for levels after from up to the level to
+func (l Leveling) levelUpStats(from, to int64) Stat {
+	var stats Stat
+	for level := from + 1; level <= to; level++ {
+		if level >= int64(len(l.LevelStats)) {
+			stats.Free += (to - level + 1) * l.FreePointsPerLevel
+			break
+		}
+		stats = stats.add(l.LevelStats[level])
+	}
+	return stats
+}
+
+func (s Stat) add(other Stat) Stat {
+	return Stat{
+		Free:           s.Free + other.Free,
+		Luck:           s.Luck + other.Luck,
+		Accuracy:       s.Accuracy + other.Accuracy,
+		Attention:      s.Attention + other.Attention,
+		TimeManagement: s.TimeManagement + other.TimeManagement,
+		Charizma:       s.Charizma + other.Charizma,
+	}
+}
+
+// SetLeveling replaces the default leveling curve, it's called before the service is used
+func (s *Service) SetLeveling(leveling Leveling) {
+	s.leveling = leveling
+}
+
+// SpendableStats are names of stats free points are spent on, Charizma doesn't affect anything yet
+var SpendableStats = []string{"accuracy", "attention", "luck", "time_management"}
+
+// SpendFreePoint moves a free stat point to the stat
+func (s *Service) SpendFreePoint(userID int64, statName string) (*Stat, error) {
+	var spent bool
+	dbStat, err := s.s.UpdateStat(userID, func(stat *storage.Stat) {
+		if stat.Free < 1 {
+			return
+		}
+		switch strings.ToLower(statName) {
+		case "accuracy":
+			stat.Accuracy++
+		case "attention":
+			stat.Attention++
+		case "luck":
+			stat.Luck++
+		case "time_management":
+			stat.TimeManagement++
+		default:
+			return
+		}
+		stat.Free--
+		spent = true
+	})
+	switch {
+	case err != nil:
+		return nil, errors.Wrap(err, "failed to update stats")
+	case spent:
+		return mapDbStatToServiceStat(dbStat), nil
+	case dbStat.Free < 1:
+		return nil, ErrNoFreePoints
+	}
+	return nil, errors.Wrapf(ErrUnknownStat, "stat %q", statName)
+}
@@ -0,0 +1,357 @@
+package service
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pechorka/adhd-reader/internal/storage"
+	"github.com/pkg/errors"
+)
+
+var ErrInvalidReminder = errors.New("invalid reminder")
+var ErrTooManyReminders = errors.New("too many reminders")
+
+const (
+	maxReminders = 10
+	// reminderMaxDelay is how late a reminder is still sent, reminders missed for longer
+	// (e.g. while the bot was down) are skipped to the next time
+	reminderMaxDelay = time.Hour
+)
+
+var (
+	workDays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	weekends = []time.Weekday{time.Saturday, time.Sunday}
+)
+
+// ParseReminder parses schedule like "weekdays 08:30 Europe/Moscow random", only time is required.
+// Days are daily (default), weekdays, weekends or days of the week like mon,wed,fri.
+// Timezone is IANA name or UTC offset like +03:00, UTC by default.
+// Mode is current (default) or random, see storage.ReminderMode.
+func ParseReminder(s string) (storage.Reminder, error) {
+	reminder := storage.Reminder{Hour: -1, Timezone: "UTC", Mode: storage.ReminderCurrent}
+	var hasDays, hasTimezone, hasMode bool
+	for _, word := range strings.Fields(s) {
+		field := strings.ToLower(word)
+		switch {
+		case field == string(storage.ReminderCurrent) || field == string(storage.ReminderRandom):
+			if hasMode {
+				return storage.Reminder{}, errors.Wrap(ErrInvalidReminder, "mode is set twice")
+			}
+			reminder.Mode, hasMode = storage.ReminderMode(field), true
+		case isClock(field):
+			if reminder.Hour >= 0 {
+				return storage.Reminder{}, errors.Wrap(ErrInvalidReminder, "time is set twice")
+			}
+			clock, err := time.Parse("15:04", field)
+			if err != nil {
+				return storage.Reminder{}, errors.Wrapf(ErrInvalidReminder, "invalid time %q", field)
+			}
+			reminder.Hour, reminder.Minute = clock.Hour(), clock.Minute()
+		default:
+			if weekdays, ok := parseWeekdays(field); ok {
+				if hasDays {
+					return storage.Reminder{}, errors.Wrap(ErrInvalidReminder, "days are set twice")
+				}
+				reminder.Weekdays, hasDays = weekdays, true
+				continue
+			}
+			if hasTimezone {
+				return storage.Reminder{}, errors.Wrapf(ErrInvalidReminder, "unknown word %q", field)
+			}
+			// IANA names are case sensitive
+			if _, err := loadTimezone(word); err != nil {
+				return storage.Reminder{}, err
+			}
+			reminder.Timezone, hasTimezone = word, true
+		}
+	}
+	if reminder.Hour < 0 {
+		return storage.Reminder{}, errors.Wrap(ErrInvalidReminder, "time is not set")
+	}
+	return reminder, nil
+}
+
+// checkReminder applies checks of ParseReminder to the reminder which wasn't parsed, e.g. restored from a backup
+func checkReminder(reminder storage.Reminder) error {
+	switch {
+	case reminder.Hour < 0 || reminder.Hour > 23 || reminder.Minute < 0 || reminder.Minute > 59:
+		return errors.Wrapf(ErrInvalidReminder, "invalid time %d:%d", reminder.Hour, reminder.Minute)
+	case reminder.Mode != storage.ReminderCurrent && reminder.Mode != storage.ReminderRandom:
+		return errors.Wrapf(ErrInvalidReminder, "unknown mode %q", reminder.Mode)
+	}
+	for _, day := range reminder.Weekdays {
+		if day < time.Sunday || day > time.Saturday {
+			return errors.Wrapf(ErrInvalidReminder, "invalid day %d", day)
+		}
+	}
+	_, err := loadTimezone(reminder.Timezone)
+	return err
+}
+
+// restoredReminders returns valid reminders of the backup which the user doesn't have yet, scheduled from now.
+// Reminders over maxReminders together with existing ones are dropped.
+func restoredReminders(restored, existing []storage.Reminder, now time.Time) []storage.Reminder {
+	var reminders []storage.Reminder
+	for _, reminder := range restored {
+		if len(existing)+len(reminders) >= maxReminders {
+			break
+		}
+		exists := slices.ContainsFunc(existing, func(r storage.Reminder) bool {
+			return r.UUID == reminder.UUID
+		})
+		if exists || checkReminder(reminder) != nil {
+			continue
+		}
+		var err error
+		if reminder.NextAt, err = NextReminderAt(reminder, now); err != nil {
+			continue
+		}
+		reminders = append(reminders, reminder)
+	}
+	return reminders
+}
+
+// isClock returns true for words like 08:30, UTC offsets like +03:00 are not clocks
+func isClock(field string) bool {
+	return strings.Contains(field, ":") && field[0] >= '0' && field[0] <= '9'
+}
+
+// parseWeekdays parses daily, weekdays, weekends or days of the week like mon,wed,fri
+func parseWeekdays(field string) ([]time.Weekday, bool) {
+	switch field {
+	case "daily":
+		return nil, true
+	case "weekdays":
+		return slices.Clone(workDays), true
+	case "weekends":
+		return slices.Clone(weekends), true
+	}
+	var weekdays []time.Weekday
+	for _, name := range strings.Split(field, ",") {
+		i := slices.IndexFunc(allWeekdays(), func(day time.Weekday) bool {
+			return len(name) >= 3 && strings.HasPrefix(strings.ToLower(day.String()), name)
+		})
+		if i < 0 {
+			return nil, false
+		}
+		weekdays = append(weekdays, time.Weekday(i))
+	}
+	slices.Sort(weekdays)
+	if len(weekdays) == 7 {
+		return nil, true
+	}
+	return slices.Compact(weekdays), true
+}
+
+func allWeekdays() []time.Weekday {
+	return []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}
+}
+
+// loadTimezone returns location by IANA name or UTC offset like +03:00, UTC+3 or -5
+func loadTimezone(name string) (*time.Location, error) {
+	offset := strings.TrimPrefix(strings.TrimPrefix(strings.ToUpper(name), "UTC"), "GMT")
+	if offset == "" {
+		return time.UTC, nil
+	}
+	if offset[0] == '+' || offset[0] == '-' {
+		hoursStr, minutesStr, _ := strings.Cut(offset[1:], ":")
+		hours, err := strconv.Atoi(hoursStr)
+		if err != nil || hours > 14 {
+			return nil, errors.Wrapf(ErrInvalidReminder, "invalid UTC offset %q", name)
+		}
+		var minutes int
+		if minutesStr != "" {
+			if minutes, err = strconv.Atoi(minutesStr); err != nil || minutes >= 60 {
+				return nil, errors.Wrapf(ErrInvalidReminder, "invalid UTC offset %q", name)
+			}
+		}
+		seconds := (hours*60 + minutes) * 60
+		if offset[0] == '-' {
+			seconds = -seconds
+		}
+		return time.FixedZone(name, seconds), nil
+	}
+	if name == "Local" {
+		return nil, errors.Wrapf(ErrInvalidReminder, "unknown timezone %q", name)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidReminder, "unknown timezone %q", name)
+	}
+	return loc, nil
+}
+
+// FormatReminder returns schedule of the reminder in the form accepted by ParseReminder
+func FormatReminder(reminder storage.Reminder) string {
+	var days string
+	switch {
+	case len(reminder.Weekdays) == 0:
+		days = "daily"
+	case slices.Equal(reminder.Weekdays, workDays):
+		days = "weekdays"
+	case slices.Equal(reminder.Weekdays, weekends):
+		days = "weekends"
+	default:
+		names := make([]string, 0, len(reminder.Weekdays))
+		for _, day := range reminder.Weekdays {
+			names = append(names, strings.ToLower(day.String()[:3]))
+		}
+		days = strings.Join(names, ",")
+	}
+	schedule := days + " " + clock(reminder.Hour, reminder.Minute) + " " + reminder.Timezone
+	if reminder.Mode == storage.ReminderRandom {
+		schedule += " " + string(storage.ReminderRandom)
+	}
+	return schedule
+}
+
+func clock(hour, minute int) string {
+	return time.Date(0, 1, 1, hour, minute, 0, 0, time.UTC).Format("15:04")
+}
+
+// NextReminderAt returns the first time after t when the reminder is sent
+func NextReminderAt(reminder storage.Reminder, t time.Time) (time.Time, error) {
+	loc, err := loadTimezone(reminder.Timezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	local := t.In(loc)
+	// the time of the day can be skipped by daylight saving time change, so the next week is checked too
+	for day := 0; day <= 7; day++ {
+		at := time.Date(local.Year(), local.Month(), local.Day()+day, reminder.Hour, reminder.Minute, 0, 0, loc)
+		if at.After(t) && (len(reminder.Weekdays) == 0 || slices.Contains(reminder.Weekdays, at.Weekday())) {
+			return at, nil
+		}
+	}
+	return time.Time{}, errors.Wrap(ErrInvalidReminder, "reminder has no days")
+}
+
+// AddReminder saves reminder with the schedule parsed by ParseReminder,
+// language is the language code of the reminder messages
+func (s *Service) AddReminder(userID int64, schedule, language string) (storage.Reminder, error) {
+	reminder, err := ParseReminder(schedule)
+	if err != nil {
+		return storage.Reminder{}, err
+	}
+	reminders, err := s.s.GetReminders(userID)
+	if err != nil {
+		return storage.Reminder{}, errors.Wrap(err, "failed to get reminders")
+	}
+	if len(reminders) >= maxReminders {
+		return storage.Reminder{}, errors.Wrapf(ErrTooManyReminders, "at most %d reminders are allowed", maxReminders)
+	}
+	reminder.Language = language
+	if reminder.NextAt, err = NextReminderAt(reminder, s.now()); err != nil {
+		return storage.Reminder{}, err
+	}
+	reminder, err = s.s.AddReminder(userID, reminder)
+	return reminder, errors.Wrap(err, "failed to save reminder")
+}
+
+func (s *Service) GetReminders(userID int64) ([]storage.Reminder, error) {
+	return s.s.GetReminders(userID)
+}
+
+func (s *Service) DeleteReminder(userID int64, reminderUUID string) error {
+	return s.s.DeleteReminder(userID, reminderUUID)
+}
+
+// DeleteReminders deletes all reminders of the user, returns number of deleted reminders
+func (s *Service) DeleteReminders(userID int64) (int, error) {
+	reminders, err := s.s.GetReminders(userID)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get reminders")
+	}
+	for _, reminder := range reminders {
+		err = s.s.DeleteReminder(userID, reminder.UUID)
+		if err != nil && err != storage.ErrNotFound {
+			return 0, errors.Wrap(err, "failed to delete reminder")
+		}
+	}
+	return len(reminders), nil
+}
+
+// ReminderReport describes reminders handled by SendDueReminders
+type ReminderReport struct {
+	Sent    int
+	Skipped int // reminders missed for longer than reminderMaxDelay
+	Failed  int
+}
+
+// SendDueReminders calls send for reminders of all users which time has come and schedules them to the next time.
+// Reminders are scheduled before they are sent, so a reminder is never sent twice.
+func (s *Service) SendDueReminders(send func(userID int64, reminder storage.Reminder)) (ReminderReport, error) {
+	now := s.now()
+	due, err := s.s.DueReminders(now)
+	if err != nil {
+		return ReminderReport{}, errors.Wrap(err, "failed to get due reminders")
+	}
+	var (
+		report   ReminderReport
+		firstErr error
+	)
+	for _, d := range due {
+		err := s.rescheduleReminder(d, now)
+		if err == storage.ErrNotFound {
+			continue // deleted by the user
+		}
+		if err != nil {
+			report.Failed++
+			if firstErr == nil {
+				firstErr = errors.Wrapf(err, "failed to reschedule reminder %s of user %d", d.Reminder.UUID, d.UserID)
+			}
+			continue
+		}
+		if now.Sub(d.Reminder.NextAt) > reminderMaxDelay {
+			report.Skipped++
+			continue
+		}
+		send(d.UserID, d.Reminder)
+		report.Sent++
+	}
+	return report, firstErr
+}
+
+func (s *Service) rescheduleReminder(d storage.UserReminder, now time.Time) error {
+	nextAt, err := NextReminderAt(d.Reminder, now)
+	if err != nil {
+		return err
+	}
+	return s.s.RescheduleReminder(d.UserID, d.Reminder.UUID, nextAt)
+}
+
+// SelectReminderText selects text which chunk is sent by the reminder with the mode.
+// Returns ErrNoUnreadTexts if the user has nothing to read.
+func (s *Service) SelectReminderText(userID int64, mode storage.ReminderMode) (storage.TextWithChunkInfo, error) {
+	if mode == storage.ReminderCurrent {
+		// storage doesn't have distinct error for not selected text
+		current, err := s.s.GetCurrentText(userID)
+		if err == nil && current.UUID != "" && len(unreadTexts([]storage.TextWithChunkInfo{current}, TextFilter{})) > 0 {
+			return current, nil
+		}
+	}
+	text, err := s.RandomText(userID, -1, TextFilter{})
+	if err != nil {
+		return storage.TextWithChunkInfo{}, err
+	}
+	if _, err = s.SelectText(userID, text.UUID); err != nil {
+		return storage.TextWithChunkInfo{}, err
+	}
+	return text, nil
+}
+
+// UnreadChunk selects chunk after the last shown chunk of the current text without measuring reading speed,
+// as the user didn't read the previous chunk just now
+func (s *Service) UnreadChunk(userID int64) (storage.Text, string, ChunkType, error) {
+	return s.selectChunk(userID, false, func(_ storage.Text, curChunk, totalChunks int64) (nextChunk int64, err error) {
+		if curChunk == storage.NotSelected {
+			return 0, nil
+		}
+		if isTextFinished(curChunk, totalChunks) {
+			return 0, ErrTextFinished
+		}
+		return curChunk + 1, nil
+	})
+}
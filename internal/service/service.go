@@ -1,15 +1,27 @@
 package service
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"io"
 	"math"
 	"math/rand"
+	"path"
+	"slices"
+	"strings"
 	"time"
+	"unicode"
 	"unicode/utf8"
 
+	"github.com/google/uuid"
 	"github.com/pechorka/adhd-reader/internal/storage"
 
 	"github.com/pechorka/adhd-reader/pkg/chance"
+	"github.com/pechorka/adhd-reader/pkg/filewriter/epub"
+	"github.com/pechorka/adhd-reader/pkg/markup"
+	"github.com/pechorka/adhd-reader/pkg/notesexport"
 	"github.com/pechorka/adhd-reader/pkg/randstring"
 	"github.com/pechorka/adhd-reader/pkg/textspliter"
 	"github.com/pechorka/adhd-reader/pkg/webscraper"
@@ -21,6 +33,14 @@ var ErrFirstChunk = errors.New("first chunk")
 var ErrTextNotSelected = errors.New("text is not selected")
 var ErrTextNotUTF8 = errors.New("text is not valid utf8")
 var ErrInvalidToken = errors.New("invalid token")
+var ErrNoHighlights = errors.New("no highlights")
+var ErrEmptyQuery = errors.New("empty search query")
+var ErrUnknownTextState = errors.New("unknown text state")
+var ErrInvalidTag = errors.New("invalid tag")
+var ErrUnknownFilter = errors.New("unknown filter")
+var ErrUnknownTextSort = errors.New("unknown sort")
+var ErrNoUnreadTexts = errors.New("no unread texts")
+var ErrBackupTooBig = errors.New("backup is too big")
 
 const telegramMessageLengthLimit = 4096
 
@@ -32,18 +52,22 @@ type Chancer interface {
 type Encryptor interface {
 	EncryptString(plaintext string) (string, error)
 	DecryptString(ciphertext string) (string, error)
+	Rotate() (int, error)
+	Retire() (int, error)
 }
 
 type Service struct {
-	s         *storage.Storage
+	s         storage.Store
 	scrapper  *webscraper.WebScrapper
 	chancer   Chancer
 	encryptor Encryptor
 	chunkSize int64
+	leveling  Leveling
+	now       func() time.Time
 }
 
 func NewService(
-	s *storage.Storage,
+	s storage.Store,
 	chunkSize int64,
 	scrapper *webscraper.WebScrapper,
 	encryptor Encryptor,
@@ -54,6 +78,8 @@ func NewService(
 		chancer:   chance.Default,
 		encryptor: encryptor,
 		scrapper:  scrapper,
+		leveling:  DefaultLeveling(),
+		now:       time.Now,
 	}
 }
 
@@ -61,8 +87,18 @@ func (s *Service) GetCurrentText(userID int64) (storage.TextWithChunkInfo, error
 	return s.s.GetCurrentText(userID)
 }
 
+// GetCurrentFullText returns current text without markup
 func (s *Service) GetCurrentFullText(userID int64) (storage.FullText, error) {
-	return s.s.GetCurrentFullText(userID)
+	fullText, err := s.s.GetCurrentFullText(userID)
+	if err != nil {
+		return fullText, err
+	}
+	fullText.Text = markup.PlainText(fullText.Text)
+	return fullText, nil
+}
+
+func (s *Service) GetAttachment(userID int64, textUUID, name string) ([]byte, error) {
+	return s.s.GetAttachment(userID, textUUID, name)
 }
 
 func (s *Service) SetChunkSize(userID int64, chunkSize int64) error {
@@ -72,54 +108,78 @@ func (s *Service) SetChunkSize(userID int64, chunkSize int64) error {
 	if chunkSize > telegramMessageLengthLimit {
 		return errors.Errorf("chunk size is too big, telegram message length limit is %d", telegramMessageLengthLimit)
 	}
+	// chunk size in characters replaces chunk size in time
+	_, err := s.s.UpdateReading(userID, func(r *storage.Reading) {
+		r.ChunkSeconds = 0
+	})
+	if err != nil {
+		return err
+	}
 	return s.s.SetChunkSize(userID, chunkSize)
 }
 
+// AddText adds plain text
 func (s *Service) AddText(userID int64, textName, text string) (string, error) {
-	chunkSize, err := s.getChunkSize(userID)
-	if err != nil {
-		return "", err
-	}
-	textChunks, err := s.processText(userID, textName, text, chunkSize)
+	text = markup.Escape(text)
+	processed, err := s.processText(userID, textName, text, "")
 	if err != nil {
 		return "", err
 	}
 	data := storage.NewText{
 		Name:      textName,
-		Chunks:    textChunks,
+		Chunks:    processed.chunks,
 		Text:      text,
-		ChunkSize: chunkSize,
+		ChunkSize: processed.chunkSize,
+		Language:  processed.language,
 	}
 	return s.s.AddText(userID, data)
 }
 
-func (s *Service) AddTextFromFile(userID int64, checksum []byte, name, text string) (string, error) {
-	chunkSize, err := s.getChunkSize(userID)
+func (s *Service) AddTextFromFile(userID int64, checksum []byte, name string, doc markup.Document) (string, error) {
+	encrypted, err := s.s.TextsEncrypted(userID)
 	if err != nil {
 		return "", err
 	}
+	if encrypted {
+		// processed files are shared between users, so they are never encrypted
+		return s.addEncryptedFile(userID, checksum, name, doc)
+	}
 	pf, err := s.s.GetProcessedFileByChecksum(checksum)
 	switch err {
 	case nil:
-		// can reuse processed file if chunk size is the same
-		if pf.ChunkSize == chunkSize {
-			return s.s.AddTextFromProcessedFile(userID, name, pf)
+		chunkSize, err := s.getChunkSize(userID, pf.Language)
+		if err != nil {
+			return "", err
+		}
+		// can reuse processed file if it was split with the same chunk size
+		pf, err = s.s.GetProcessedFile(checksum, chunkSize)
+		if err == nil {
+			var textID string
+			textID, err = s.s.AddTextFromProcessedFile(userID, name, pf)
+			if err != storage.ErrNotFound { // file is processed again if it was just collected as garbage
+				return textID, err
+			}
+		}
+		if err != storage.ErrNotFound {
+			return "", err
 		}
 	case storage.ErrNotFound:
 	default:
 		return "", err
 	}
 
-	textChunks, err := s.processText(userID, name, text, chunkSize)
+	processed, err := s.processText(userID, name, doc.Text, doc.Language)
 	if err != nil {
 		return "", err
 	}
 
 	pf, err = s.s.AddProcessedFile(storage.NewProcessedFile{
-		Text:      text,
-		Chunks:    textChunks,
-		ChunkSize: chunkSize,
-		CheckSum:  checksum,
+		Text:        doc.Text,
+		Chunks:      processed.chunks,
+		ChunkSize:   processed.chunkSize,
+		Language:    processed.language,
+		CheckSum:    checksum,
+		Attachments: doc.Attachments,
 	})
 	if err != nil {
 		return "", err
@@ -127,71 +187,684 @@ func (s *Service) AddTextFromFile(userID int64, checksum []byte, name, text stri
 	return s.s.AddTextFromProcessedFile(userID, name, pf)
 }
 
-func (s *Service) AddTextFromURL(userID int64, url string) (id string, name string, err error) {
-	chunkSize, err := s.getChunkSize(userID)
+// addEncryptedFile adds text from file without saving it as processed file
+func (s *Service) addEncryptedFile(userID int64, checksum []byte, name string, doc markup.Document) (string, error) {
+	processed, err := s.processText(userID, name, doc.Text, doc.Language)
 	if err != nil {
-		return "", "", err
+		return "", err
 	}
+	return s.s.AddText(userID, storage.NewText{
+		Name:        name,
+		Source:      storage.SourceFile,
+		Text:        doc.Text,
+		Chunks:      processed.chunks,
+		ChunkSize:   processed.chunkSize,
+		Language:    processed.language,
+		CheckSum:    checksum,
+		Attachments: doc.Attachments,
+	})
+}
+
+func (s *Service) AddTextFromURL(userID int64, url string) (id string, name string, err error) {
 	name, text, err := s.scrapper.Scrape(context.Background(), url)
 	if err != nil {
 		return "", "", err
 	}
-	textChunks, err := s.processText(userID, name, text, chunkSize)
+	processed, err := s.processText(userID, name, text, "")
 	if err != nil {
 		return "", "", err
 	}
 	data := storage.NewText{
 		Name:      name,
-		Chunks:    textChunks,
+		Source:    storage.SourceURL,
+		Chunks:    processed.chunks,
 		Text:      text,
-		ChunkSize: chunkSize,
+		ChunkSize: processed.chunkSize,
+		Language:  processed.language,
 	}
 	id, err = s.s.AddText(userID, data)
 	return id, name, err
 }
 
-func (s *Service) processText(userID int64, textName, text string, chunkSize int64) ([]string, error) {
+type processedText struct {
+	chunks    []string
+	chunkSize int64
+	language  string
+}
+
+// processText splits text into chunks using sentence rules of the declared language
+// or the language detected from the text. Chunk size depends on the language if user reads by time.
+func (s *Service) processText(userID int64, textName, text string, language string) (processedText, error) {
 	if textName == "" {
-		return nil, errors.New("text name is empty")
+		return processedText{}, errors.New("text name is empty")
 	}
 	if len(textName) > 255 {
-		return nil, errors.Errorf("text name %s is too long, max length is 255 (less if you use emojis/non-ascii symbols)", textName)
+		return processedText{}, errors.Errorf("text name %s is too long, max length is 255 (less if you use emojis/non-ascii symbols)", textName)
 	}
 	if !utf8.ValidString(text) {
-		return nil, ErrTextNotUTF8
+		return processedText{}, ErrTextNotUTF8
+	}
+	rules, ok := textspliter.LookupLanguage(language)
+	if !ok {
+		rules = textspliter.DetectLanguage(text)
 	}
-	return textspliter.SplitText(text, int(chunkSize)), nil
+	chunkSize, err := s.getChunkSize(userID, rules.Language())
+	if err != nil {
+		return processedText{}, err
+	}
+	return processedText{
+		chunks:    textspliter.Split(text, textspliter.LimitsFor(int(chunkSize)), rules),
+		chunkSize: chunkSize,
+		language:  rules.Language(),
+	}, nil
 }
 
-func (s *Service) getChunkSize(userID int64) (int64, error) {
-	chunkSize, err := s.s.GetChunkSize(userID)
+// getChunkSize returns chunk size in runes for text in the language
+func (s *Service) getChunkSize(userID int64, language string) (int64, error) {
+	chunkSizeFor, err := s.chunkSizer(userID)
 	if err != nil {
 		return 0, err
 	}
+	return chunkSizeFor(language), nil
+}
+
+// chunkSizer returns function that computes chunk size in runes for text in the language
+func (s *Service) chunkSizer(userID int64) (func(language string) int64, error) {
+	reading, err := s.s.GetReadingByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if reading.ChunkSeconds > 0 {
+		return func(language string) int64 {
+			return chunkSizeForTime(readingSpeed(reading, language), reading.ChunkSeconds)
+		}, nil
+	}
+	chunkSize, err := s.s.GetChunkSize(userID)
+	if err != nil {
+		return nil, err
+	}
 	if chunkSize == 0 {
 		chunkSize = s.chunkSize
 	}
-	return chunkSize, nil
+	return func(string) int64 { return chunkSize }, nil
+}
+
+// RechunkText splits the text again with the current chunk size of the user
+func (s *Service) RechunkText(userID int64, textUUID string) error {
+	_, err := s.rechunk(userID, func(text storage.Text) bool {
+		return text.UUID == textUUID
+	})
+	return err
+}
+
+// RechunkTexts splits all texts of the user again with the current chunk size.
+// Returns number of texts that were split again.
+func (s *Service) RechunkTexts(userID int64) (int, error) {
+	return s.rechunk(userID, func(storage.Text) bool {
+		return true
+	})
+}
+
+func (s *Service) rechunk(userID int64, predicate func(storage.Text) bool) (int, error) {
+	chunkSizeFor, err := s.chunkSizer(userID)
+	if err != nil {
+		return 0, err
+	}
+	return s.s.RechunkTexts(userID, predicate, func(text storage.Text, fullText string) (storage.Rechunked, error) {
+		chunkSize := chunkSizeFor(text.Language)
+		if text.ChunkSize == chunkSize {
+			return storage.Rechunked{}, nil
+		}
+		rules, ok := textspliter.LookupLanguage(text.Language)
+		if !ok {
+			rules = textspliter.DetectLanguage(fullText)
+		}
+		return storage.Rechunked{
+			Chunks:    textspliter.Split(fullText, textspliter.LimitsFor(int(chunkSize)), rules),
+			ChunkSize: chunkSize,
+		}, nil
+	})
+}
+
+const (
+	minChunkSeconds = 10
+	maxChunkSeconds = 30 * 60
+	// chunk size computed from reading time is rounded to this step,
+	// so small changes of reading speed don't change chunk size
+	chunkSizeStep = 50
+	// reading speed samples outside of these bounds are ignored:
+	// user skipped the chunk or was distracted
+	minReadingSpeed = 100
+	maxReadingSpeed = 5000
+	// measured reading speed is used after this number of samples
+	minSpeedSamples = 3
+	// weight of the new sample in measured reading speed
+	speedSampleWeight = 0.2
+)
+
+// defaultReadingSpeed is average silent reading speed in runes per minute
+const defaultReadingSpeed = 1000
+
+var defaultReadingSpeeds = map[string]float64{
+	"en": 1000, // ~200 words per minute
+	"ru": 900,  // ~150 words per minute
+}
+
+// SetChunkSeconds sets chunk size as time of reading, chunk size in runes is computed from reading speed
+func (s *Service) SetChunkSeconds(userID int64, seconds int64) error {
+	if seconds < minChunkSeconds || seconds > maxChunkSeconds {
+		return errors.Errorf("chunk time should be between %d and %d seconds", minChunkSeconds, maxChunkSeconds)
+	}
+	_, err := s.s.UpdateReading(userID, func(r *storage.Reading) {
+		r.ChunkSeconds = seconds
+	})
+	return err
+}
+
+// SetReadingSpeed sets reading speed in runes per minute for the language or for all languages if language is empty
+func (s *Service) SetReadingSpeed(userID int64, language string, runesPerMinute int64) error {
+	if runesPerMinute < minReadingSpeed || runesPerMinute > maxReadingSpeed {
+		return errors.Errorf("reading speed should be between %d and %d characters per minute", minReadingSpeed, maxReadingSpeed)
+	}
+	_, err := s.s.UpdateReading(userID, func(r *storage.Reading) {
+		if r.Speeds == nil {
+			r.Speeds = make(map[string]storage.Speed)
+		}
+		speed := r.Speeds[language]
+		speed.Explicit = float64(runesPerMinute)
+		r.Speeds[language] = speed
+	})
+	return err
+}
+
+// ResetReadingSpeed drops reading speeds set by user, so measured speeds are used again
+func (s *Service) ResetReadingSpeed(userID int64) error {
+	_, err := s.s.UpdateReading(userID, func(r *storage.Reading) {
+		for language, speed := range r.Speeds {
+			speed.Explicit = 0
+			r.Speeds[language] = speed
+		}
+	})
+	return err
+}
+
+// ReadingSpeed returns reading speed in runes per minute used for the language
+func (s *Service) ReadingSpeed(userID int64, language string) (int64, error) {
+	reading, err := s.s.GetReadingByUserID(userID)
+	if err != nil {
+		return 0, err
+	}
+	return int64(readingSpeed(reading, language)), nil
+}
+
+func readingSpeed(reading storage.Reading, language string) float64 {
+	speed := reading.Speeds[language]
+	if speed.Explicit > 0 {
+		return speed.Explicit
+	}
+	if all := reading.Speeds[""]; all.Explicit > 0 {
+		return all.Explicit
+	}
+	if speed.Samples >= minSpeedSamples {
+		return speed.Measured
+	}
+	if speed, ok := defaultReadingSpeeds[language]; ok {
+		return speed
+	}
+	return defaultReadingSpeed
+}
+
+func chunkSizeForTime(runesPerMinute float64, seconds int64) int64 {
+	size := int64(math.Round(runesPerMinute*float64(seconds)/60/chunkSizeStep)) * chunkSizeStep
+	return min(max(size, chunkSizeStep), textspliter.MaxChunkLen)
+}
+
+// trackReading remembers the shown chunk. When user asks for the next chunk,
+// time spent on the previous one is used to measure reading speed.
+func (s *Service) trackReading(userID int64, language, chunk string, measure bool) (*storage.Reading, error) {
+	now := s.now()
+	reading, err := s.s.UpdateReading(userID, func(r *storage.Reading) {
+		if measure && r.LastChunkLen > 0 && !r.LastChunkAt.IsZero() {
+			if minutes := now.Sub(r.LastChunkAt).Minutes(); minutes > 0 {
+				addSpeedSample(r, r.LastLanguage, float64(r.LastChunkLen)/minutes)
+			}
+		}
+		r.LastChunkAt = now
+		r.LastChunkLen = int64(utf8.RuneCountInString(markup.PlainText(chunk)))
+		r.LastLanguage = language
+	})
+	return reading, errors.Wrap(err, "failed to track reading")
+}
+
+func addSpeedSample(r *storage.Reading, language string, runesPerMinute float64) {
+	if runesPerMinute < minReadingSpeed || runesPerMinute > maxReadingSpeed {
+		return
+	}
+	speed := r.Speeds[language]
+	if speed.Samples == 0 {
+		speed.Measured = runesPerMinute
+	} else {
+		speed.Measured += (runesPerMinute - speed.Measured) * speedSampleWeight
+	}
+	speed.Samples++
+	if r.Speeds == nil {
+		r.Speeds = make(map[string]storage.Speed)
+	}
+	r.Speeds[language] = speed
+}
+
+const maxTagSize = 48 // in bytes, tags are passed in telegram callback data
+
+// TextProgress selects texts by reading progress
+type TextProgress string
+
+const (
+	ProgressUnfinished TextProgress = "unfinished"
+	ProgressStarted    TextProgress = "started" // opened, but not finished
+	ProgressUnopened   TextProgress = "unopened"
+)
+
+var textProgresses = []TextProgress{ProgressUnfinished, ProgressStarted, ProgressUnopened}
+
+var textSources = []storage.TextSource{storage.SourceFile, storage.SourceURL, storage.SourceText}
+
+// allTexts is the filter word that selects all texts
+const allTexts = "all"
+
+// TextFilter selects texts by tags, states, progress and source, empty filter selects all texts
+type TextFilter struct {
+	Tags     []string             // text has all the tags
+	States   []storage.TextState  // text is in one of the states
+	Progress []TextProgress       // text has one of the progresses
+	Sources  []storage.TextSource // text is from one of the sources
+}
+
+// ParseTextFilter parses filter like "#articles reading paused unfinished file", "all" is an empty filter
+func ParseTextFilter(s string) (TextFilter, error) {
+	var filter TextFilter
+	for _, field := range strings.Fields(s) {
+		if strings.HasPrefix(field, "#") {
+			tag, err := normalizeTag(field)
+			if err != nil {
+				return TextFilter{}, err
+			}
+			filter.Tags = append(filter.Tags, tag)
+			continue
+		}
+		word := strings.ToLower(field)
+		switch {
+		case word == allTexts:
+		case slices.Contains(storage.TextStates, storage.TextState(word)):
+			filter.States = append(filter.States, storage.TextState(word))
+		case slices.Contains(textProgresses, TextProgress(word)):
+			filter.Progress = append(filter.Progress, TextProgress(word))
+		case slices.Contains(textSources, storage.TextSource(word)):
+			filter.Sources = append(filter.Sources, storage.TextSource(word))
+		default:
+			return TextFilter{}, errors.Wrapf(ErrUnknownFilter, "%q", field)
+		}
+	}
+	return filter, nil
+}
+
+// String returns filter in the format of ParseTextFilter
+func (f TextFilter) String() string {
+	fields := make([]string, 0, len(f.Tags)+len(f.States)+len(f.Progress)+len(f.Sources))
+	for _, tag := range f.Tags {
+		fields = append(fields, "#"+tag)
+	}
+	for _, state := range f.States {
+		fields = append(fields, string(state))
+	}
+	for _, progress := range f.Progress {
+		fields = append(fields, string(progress))
+	}
+	for _, source := range f.Sources {
+		fields = append(fields, string(source))
+	}
+	return strings.Join(fields, " ")
+}
+
+// IsEmpty reports if filter selects all texts
+func (f TextFilter) IsEmpty() bool {
+	return len(f.Tags) == 0 && len(f.States) == 0 && len(f.Progress) == 0 && len(f.Sources) == 0
+}
+
+func (f TextFilter) match(text storage.TextWithChunkInfo) bool {
+	if len(f.States) > 0 && !slices.Contains(f.States, text.State) {
+		return false
+	}
+	if len(f.Sources) > 0 && !slices.Contains(f.Sources, text.Source) {
+		return false
+	}
+	if len(f.Progress) > 0 && !slices.ContainsFunc(f.Progress, func(progress TextProgress) bool {
+		return progress.match(text)
+	}) {
+		return false
+	}
+	for _, tag := range f.Tags {
+		if !slices.Contains(text.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p TextProgress) match(text storage.TextWithChunkInfo) bool {
+	opened := text.CurrentChunk != storage.NotSelected
+	finished := opened && isTextFinished(text.CurrentChunk, text.TotalChunks)
+	switch p {
+	case ProgressUnfinished:
+		return !finished
+	case ProgressStarted:
+		return opened && !finished
+	case ProgressUnopened:
+		return !opened
+	}
+	return false
+}
+
+// TextSort is the order of the list of texts
+type TextSort string
+
+const (
+	SortAdded   TextSort = "added"   // in order texts were added
+	SortRecent  TextSort = "recent"  // recently read first
+	SortNewest  TextSort = "newest"  // recently added first
+	SortClosest TextSort = "closest" // closest to the end first, finished texts are the last
+	SortLongest TextSort = "longest"
+	SortName    TextSort = "name"
+)
+
+var TextSorts = []TextSort{SortAdded, SortRecent, SortNewest, SortClosest, SortLongest, SortName}
+
+// ParseTextSort parses sort key, empty key is SortAdded
+func ParseTextSort(s string) (TextSort, error) {
+	sort := TextSort(strings.ToLower(strings.TrimSpace(s)))
+	if sort == "" {
+		return SortAdded, nil
+	}
+	if !slices.Contains(TextSorts, sort) {
+		return "", errors.Wrapf(ErrUnknownTextSort, "%q", s)
+	}
+	return sort, nil
+}
+
+func sortTexts(texts []storage.TextWithChunkInfo, sort TextSort) {
+	var cmp func(a, b storage.TextWithChunkInfo) int
+	switch sort {
+	case SortRecent:
+		cmp = func(a, b storage.TextWithChunkInfo) int { return b.ModifiedAt.Compare(a.ModifiedAt) }
+	case SortNewest:
+		cmp = func(a, b storage.TextWithChunkInfo) int { return b.CreatedAt.Compare(a.CreatedAt) }
+	case SortClosest:
+		cmp = func(a, b storage.TextWithChunkInfo) int { return compareFloats(remainingPart(a), remainingPart(b)) }
+	case SortLongest:
+		cmp = func(a, b storage.TextWithChunkInfo) int { return compareFloats(float64(b.Length), float64(a.Length)) }
+	case SortName:
+		cmp = func(a, b storage.TextWithChunkInfo) int {
+			return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		}
+	default:
+		return
+	}
+	slices.SortStableFunc(texts, cmp)
+}
+
+// remainingPart returns part of the text that is left to read, finished texts are after all others
+func remainingPart(text storage.TextWithChunkInfo) float64 {
+	if text.CurrentChunk == storage.NotSelected || text.TotalChunks == 0 {
+		return 1
+	}
+	if isTextFinished(text.CurrentChunk, text.TotalChunks) {
+		return 2
+	}
+	return float64(text.TotalChunks-text.CurrentChunk) / float64(text.TotalChunks)
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// ListOptions are sort and filter of the list of texts
+type ListOptions struct {
+	Sort   TextSort
+	Filter TextFilter
+}
+
+// ListOptions returns sort and filter the user chose for the list of texts
+func (s *Service) ListOptions(userID int64) (ListOptions, error) {
+	prefs, err := s.s.GetListPreferencesByUserID(userID)
+	if err != nil {
+		return ListOptions{}, err
+	}
+	return listOptions(prefs), nil
+}
+
+// UpdateListOptions changes sort and filter of the list of texts with words like "recent #articles unfinished".
+// Sort is changed if words contain sort key, filter is replaced if words contain filter.
+func (s *Service) UpdateListOptions(userID int64, words string) (ListOptions, error) {
+	var sort TextSort
+	var filterWords []string
+	for _, word := range strings.Fields(words) {
+		if parsed, err := ParseTextSort(word); err == nil {
+			sort = parsed
+			continue
+		}
+		filterWords = append(filterWords, word)
+	}
+	filter, err := ParseTextFilter(strings.Join(filterWords, " "))
+	if err != nil {
+		return ListOptions{}, err
+	}
+	prefs, err := s.s.UpdateListPreferences(userID, func(prefs *storage.ListPreferences) {
+		if sort != "" {
+			prefs.Sort = string(sort)
+		}
+		if len(filterWords) > 0 {
+			prefs.Filter = filter.String()
+		}
+	})
+	if err != nil {
+		return ListOptions{}, err
+	}
+	return listOptions(*prefs), nil
+}
+
+// listOptions parses saved preferences, options that can't be parsed are reset
+func listOptions(prefs storage.ListPreferences) ListOptions {
+	sort, err := ParseTextSort(prefs.Sort)
+	if err != nil {
+		sort = SortAdded
+	}
+	filter, _ := ParseTextFilter(prefs.Filter)
+	return ListOptions{Sort: sort, Filter: filter}
+}
+
+func filterTexts(texts []storage.TextWithChunkInfo, filter TextFilter) []storage.TextWithChunkInfo {
+	return slices.DeleteFunc(texts, func(text storage.TextWithChunkInfo) bool {
+		return !filter.match(text)
+	})
+}
+
+// unreadTexts returns texts to continue reading: not finished and not abandoned,
+// unless filter asks for texts in these states
+func unreadTexts(texts []storage.TextWithChunkInfo, filter TextFilter) []storage.TextWithChunkInfo {
+	texts = filterTexts(texts, filter)
+	if len(filter.States) > 0 {
+		return texts
+	}
+	return slices.DeleteFunc(texts, func(text storage.TextWithChunkInfo) bool {
+		return text.State == storage.StateFinished || text.State == storage.StateAbandoned ||
+			isTextFinished(text.CurrentChunk, text.TotalChunks)
+	})
+}
+
+// ParseTextState parses state name, see storage.TextStates
+func ParseTextState(s string) (storage.TextState, error) {
+	state := storage.TextState(strings.ToLower(strings.TrimSpace(s)))
+	if !slices.Contains(storage.TextStates, state) {
+		return "", errors.Wrapf(ErrUnknownTextState, "%q", s)
+	}
+	return state, nil
+}
+
+// normalizeTag returns lower case tag without #
+func normalizeTag(tag string) (string, error) {
+	normalized := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+	switch {
+	case normalized == "":
+		return "", errors.Wrap(ErrInvalidTag, "tag is empty")
+	case strings.IndexFunc(normalized, func(r rune) bool { return unicode.IsSpace(r) || r == '#' || r == ':' }) >= 0:
+		return "", errors.Wrapf(ErrInvalidTag, "%q contains space, # or :", tag)
+	case len(normalized) > maxTagSize:
+		return "", errors.Wrapf(ErrInvalidTag, "%q is too long", tag)
+	}
+	return normalized, nil
+}
+
+func normalizeTags(tags []string) ([]string, error) {
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		normalized, err := normalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(result, normalized) {
+			result = append(result, normalized)
+		}
+	}
+	return result, nil
+}
+
+// SetTextState moves the text to the state, empty uuid means the current text
+func (s *Service) SetTextState(userID int64, textUUID string, state storage.TextState) (storage.Text, error) {
+	if !slices.Contains(storage.TextStates, state) {
+		return storage.Text{}, errors.Wrapf(ErrUnknownTextState, "%q", state)
+	}
+	return s.updateText(userID, textUUID, func(text *storage.Text) {
+		text.State = state
+	})
+}
+
+// SetTextTags replaces tags of the text, empty uuid means the current text
+func (s *Service) SetTextTags(userID int64, textUUID string, tags []string) (storage.Text, error) {
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return storage.Text{}, err
+	}
+	return s.updateText(userID, textUUID, func(text *storage.Text) {
+		text.Tags = tags
+	})
+}
+
+// AddTextTags adds tags to the text, empty uuid means the current text
+func (s *Service) AddTextTags(userID int64, textUUID string, tags []string) (storage.Text, error) {
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return storage.Text{}, err
+	}
+	return s.updateText(userID, textUUID, func(text *storage.Text) {
+		for _, tag := range tags {
+			if !slices.Contains(text.Tags, tag) {
+				text.Tags = append(text.Tags, tag)
+			}
+		}
+	})
+}
+
+// RemoveTextTags removes tags from the text, empty uuid means the current text
+func (s *Service) RemoveTextTags(userID int64, textUUID string, tags []string) (storage.Text, error) {
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return storage.Text{}, err
+	}
+	return s.updateText(userID, textUUID, func(text *storage.Text) {
+		text.Tags = slices.DeleteFunc(text.Tags, func(tag string) bool {
+			return slices.Contains(tags, tag)
+		})
+	})
+}
+
+// updateText changes the text and marks it as modified, empty uuid means the current text
+func (s *Service) updateText(userID int64, textUUID string, update func(text *storage.Text)) (storage.Text, error) {
+	var updated storage.Text
+	err := s.s.UpdateTexts(userID, func(texts *storage.UserTexts) error {
+		i := texts.Current
+		if textUUID != "" {
+			i = slices.IndexFunc(texts.Texts, func(text storage.Text) bool { return text.UUID == textUUID })
+			if i < 0 {
+				return storage.ErrNotFound
+			}
+		}
+		if i == storage.NotSelected {
+			return ErrTextNotSelected
+		}
+		update(&texts.Texts[i])
+		texts.Texts[i].ModifiedAt = time.Now()
+		updated = texts.Texts[i]
+		return nil
+	})
+	return updated, err
+}
+
+type TagCount struct {
+	Tag   string
+	Texts int
+}
+
+// Tags returns tags of the user's texts in alphabetical order
+func (s *Service) Tags(userID int64) ([]TagCount, error) {
+	texts, err := s.s.GetTexts(userID)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, text := range texts {
+		for _, tag := range text.Tags {
+			counts[tag]++
+		}
+	}
+	result := make([]TagCount, 0, len(counts))
+	for tag, count := range counts {
+		result = append(result, TagCount{Tag: tag, Texts: count})
+	}
+	slices.SortFunc(result, func(a, b TagCount) int {
+		return strings.Compare(a.Tag, b.Tag)
+	})
+	return result, nil
 }
 
 type TextWithCompletion struct {
 	UUID              string
 	Name              string
+	State             storage.TextState
+	Tags              []string
 	CompletionPercent int
 }
 
-func (s *Service) ListTexts(userID int64, page, pageSize int) (_ []TextWithCompletion, more bool, err error) {
+func (s *Service) ListTexts(userID int64, options ListOptions, page, pageSize int) (_ []TextWithCompletion, more bool, err error) {
 	texts, err := s.s.GetTexts(userID)
 	if err != nil {
 		return nil, false, err
 	}
 
+	texts = filterTexts(texts, options.Filter)
+	sortTexts(texts, options.Sort)
 	texts, more = paginateTexts(texts, page, pageSize)
 	result := make([]TextWithCompletion, 0, len(texts))
 	for _, t := range texts {
 		result = append(result, TextWithCompletion{
 			UUID:              t.UUID,
 			Name:              t.Name,
+			State:             t.State,
+			Tags:              t.Tags,
 			CompletionPercent: calculateCompletionPercent(t),
 		})
 	}
@@ -220,6 +893,183 @@ func (s *Service) FullTexts(userID int64, after *time.Time, page, pageSize int)
 	return s.s.GetFullTexts(userID, after, page, pageSize)
 }
 
+// SearchResult is a chunk of the user's text that contains all words of the search query
+type SearchResult struct {
+	TextUUID string
+	TextName string
+	Chunk    int64  // index of the chunk, see SetPage
+	Snippet  string // part of the chunk around the found word, without markup
+}
+
+// Search finds chunks of the user's texts that contain all words of the query
+func (s *Service) Search(userID int64, query string, page, pageSize int) (_ []SearchResult, more bool, err error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, false, ErrEmptyQuery
+	}
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 10
+	}
+	matches, more, err := s.s.Search(userID, query, page, pageSize)
+	if err != nil {
+		return nil, false, err
+	}
+	results := make([]SearchResult, 0, len(matches))
+	for _, match := range matches {
+		results = append(results, SearchResult{
+			TextUUID: match.Text.UUID,
+			TextName: match.Text.Name,
+			Chunk:    match.Chunk,
+			Snippet:  snippet(markup.PlainText(match.ChunkText), textspliter.Terms(query, match.Text.Language)),
+		})
+	}
+	return results, more, nil
+}
+
+// snippet returns whole words of the text around the first found term
+func snippet(text string, terms []string) string {
+	const radius = 60 // runes before and after the term
+	runes := []rune(text)
+	// unicode.ToLower keeps the number of runes, so indexes of lower match indexes of runes
+	lower := strings.Map(unicode.ToLower, text)
+	at := -1
+	for _, term := range terms {
+		if i := strings.Index(lower, term); i >= 0 {
+			if i = utf8.RuneCountInString(lower[:i]); at < 0 || i < at {
+				at = i
+			}
+		}
+	}
+	at = max(at, 0)
+	start, end := max(at-radius, 0), min(at+radius, len(runes))
+	for start > 0 && !unicode.IsSpace(runes[start-1]) {
+		start--
+	}
+	for end < len(runes) && !unicode.IsSpace(runes[end]) {
+		end++
+	}
+	result := strings.Join(strings.Fields(string(runes[start:end])), " ")
+	if start > 0 {
+		result = "..." + result
+	}
+	if end < len(runes) {
+		result += "..."
+	}
+	return result
+}
+
+// Backup returns gzipped json with everything that is stored for the user
+func (s *Service) Backup(userID int64) ([]byte, error) {
+	backup, err := s.s.ExportUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err = json.NewEncoder(zw).Encode(backup); err != nil {
+		return nil, errors.Wrap(err, "failed to encode backup")
+	}
+	if err = zw.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to compress backup")
+	}
+	return buf.Bytes(), nil
+}
+
+// legacyBackup is the format texts were downloaded in before backups were introduced
+type legacyBackup struct {
+	Texts []struct {
+		TextName     string   `json:"textName"`
+		CurrentChunk int64    `json:"currentChunk"`
+		Position     int64    `json:"position"`
+		Chunks       []string `json:"chunks"`
+	} `json:"texts"`
+}
+
+// Restore restores backup made by Backup, gzipped or not, or texts downloaded before backups were introduced.
+// Returns number of restored texts.
+func (s *Service) Restore(userID int64, data []byte, mode storage.RestoreMode) (int, error) {
+	if mode != storage.RestoreMerge && mode != storage.RestoreReplace {
+		return 0, errors.Errorf("unknown restore mode %q", mode)
+	}
+	if bytes.HasPrefix(data, gzipMagic) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to decompress backup")
+		}
+		// a small gzip can expand to gigabytes, so only one byte over the limit is read
+		if data, err = io.ReadAll(io.LimitReader(zr, maxRestoreSize+1)); err != nil {
+			return 0, errors.Wrap(err, "failed to decompress backup")
+		}
+	}
+	if len(data) > maxRestoreSize {
+		return 0, errors.Wrapf(ErrBackupTooBig, "backup is larger than %d MB", maxRestoreSize>>20)
+	}
+	var backup storage.Backup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return 0, errors.Wrap(err, "failed to decode backup")
+	}
+	if backup.Version == 0 {
+		var legacy legacyBackup
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return 0, errors.Wrap(err, "failed to decode backup")
+		}
+		backup = fromLegacyBackup(legacy)
+	}
+	// backups can be edited, so settings are checked like the ones set by commands
+	var existing []storage.Reminder
+	if mode == storage.RestoreMerge {
+		var err error
+		if existing, err = s.s.GetReminders(userID); err != nil {
+			return 0, errors.Wrap(err, "failed to get reminders")
+		}
+	}
+	backup.Reminders = restoredReminders(backup.Reminders, existing, s.now())
+	goal := Goal{Chunks: backup.Reading.GoalChunks, Minutes: backup.Reading.GoalMinutes}
+	if checkGoal(goal, backup.Reading.Timezone) != nil {
+		backup.Reading.GoalChunks, backup.Reading.GoalMinutes, backup.Reading.Timezone = 0, 0, ""
+	}
+	return s.s.ImportUser(userID, backup, mode)
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// maxRestoreSize is the largest backup Restore accepts after decompression
+const maxRestoreSize = 256 << 20
+
+func fromLegacyBackup(legacy legacyBackup) storage.Backup {
+	backup := storage.Backup{Version: 1, CreatedAt: time.Now()}
+	for _, text := range legacy.Texts {
+		if len(text.Chunks) == 0 {
+			continue
+		}
+		// texts were downloaded before they were kept in markup
+		chunks := make([]string, 0, len(text.Chunks))
+		for _, chunk := range text.Chunks {
+			chunks = append(chunks, markup.Escape(chunk))
+		}
+		position := text.Position
+		if position == 0 && text.CurrentChunk > 0 {
+			// texts downloaded before positions were introduced have only current chunk
+			position = textspliter.Offsets(chunks)[min(text.CurrentChunk, int64(len(chunks)-1))]
+		}
+		backup.Texts = append(backup.Texts, storage.BackupText{
+			UUID:         uuid.New().String(),
+			Name:         text.TextName,
+			Source:       storage.SourceText,
+			CurrentChunk: text.CurrentChunk,
+			Position:     position,
+			CreatedAt:    backup.CreatedAt,
+			ModifiedAt:   backup.CreatedAt,
+			FullText:     markup.Escape(strings.Join(text.Chunks, "\n")),
+			Chunks:       chunks,
+		})
+	}
+	return backup
+}
+
 func calculateCompletionPercent(text storage.TextWithChunkInfo) int {
 	if text.TotalChunks-1 <= 0 || text.CurrentChunk == storage.NotSelected {
 		return 0
@@ -227,11 +1077,13 @@ func calculateCompletionPercent(text storage.TextWithChunkInfo) int {
 	return int(float64(text.CurrentChunk) / float64(text.TotalChunks-1) * 100)
 }
 
-func (s *Service) QuickWin(userID int64) (storage.TextWithChunkInfo, error) {
+// QuickWin returns unread text that is closest to the end
+func (s *Service) QuickWin(userID int64, filter TextFilter) (storage.TextWithChunkInfo, error) {
 	texts, err := s.s.GetTexts(userID)
 	if err != nil {
 		return storage.TextWithChunkInfo{}, err
 	}
+	texts = unreadTexts(texts, filter)
 
 	minDelta := int64(math.MaxInt64)
 	textI := -1
@@ -253,7 +1105,8 @@ func (s *Service) QuickWin(userID int64) (storage.TextWithChunkInfo, error) {
 	return texts[textI], nil
 }
 
-func (s *Service) RandomText(userID int64, atMostChunks int64) (storage.TextWithChunkInfo, error) {
+// RandomText returns random unread text with at most atMostChunks chunks
+func (s *Service) RandomText(userID int64, atMostChunks int64, filter TextFilter) (storage.TextWithChunkInfo, error) {
 	texts, err := s.s.GetTexts(userID)
 	if err != nil {
 		return storage.TextWithChunkInfo{}, err
@@ -262,18 +1115,12 @@ func (s *Service) RandomText(userID int64, atMostChunks int64) (storage.TextWith
 		texts = filterTextsByChunkCount(texts, atMostChunks)
 	}
 
-	unreadTexts := make([]storage.TextWithChunkInfo, 0, len(texts))
-	for _, t := range texts {
-		if !isTextFinished(t.CurrentChunk, t.TotalChunks) {
-			unreadTexts = append(unreadTexts, t)
-		}
-	}
-
-	if len(unreadTexts) == 0 {
-		return storage.TextWithChunkInfo{}, errors.New("no unread texts")
+	texts = unreadTexts(texts, filter)
+	if len(texts) == 0 {
+		return storage.TextWithChunkInfo{}, ErrNoUnreadTexts
 	}
 
-	return unreadTexts[rand.Intn(len(unreadTexts))], nil
+	return texts[rand.Intn(len(texts))], nil
 }
 
 func isTextFinished(curChunk, totalChunks int64) bool {
@@ -330,11 +1177,274 @@ func (s *Service) RenameText(userID int64, newName string) (string, error) {
 	return oldName, err
 }
 
+const maxLabelLength = 255
+
+// BookmarkCurrentChunk bookmarks the beginning of the current chunk of the current text
+func (s *Service) BookmarkCurrentChunk(userID int64, label string) (storage.Bookmark, error) {
+	current, err := s.s.GetCurrentChunk(userID)
+	if err != nil {
+		return storage.Bookmark{}, err
+	}
+	return s.AddBookmark(userID, current.Text.UUID, current.Position, label)
+}
+
+// AddBookmark bookmarks position in the text, label is optional
+func (s *Service) AddBookmark(userID int64, textUUID string, position int64, label string) (storage.Bookmark, error) {
+	if position < 0 {
+		return storage.Bookmark{}, errors.New("position should not be negative")
+	}
+	label = strings.TrimSpace(label)
+	if utf8.RuneCountInString(label) > maxLabelLength {
+		return storage.Bookmark{}, errors.Errorf("label is too long, max length is %d", maxLabelLength)
+	}
+	return s.s.AddBookmark(userID, storage.Bookmark{
+		TextUUID: textUUID,
+		Position: position,
+		Label:    label,
+	})
+}
+
+// Bookmarks returns bookmarks of the text or of all texts if textUUID is empty
+func (s *Service) Bookmarks(userID int64, textUUID string) ([]storage.Bookmark, error) {
+	return s.s.GetBookmarks(userID, textUUID)
+}
+
+type TextBookmark struct {
+	storage.Bookmark
+	TextName string
+}
+
+// TextBookmarks returns bookmarks of all texts with names of the texts
+func (s *Service) TextBookmarks(userID int64) ([]TextBookmark, error) {
+	bookmarks, err := s.s.GetBookmarks(userID, "")
+	if err != nil {
+		return nil, err
+	}
+	texts, err := s.s.GetTexts(userID)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(texts))
+	for _, t := range texts {
+		names[t.UUID] = t.Name
+	}
+	result := make([]TextBookmark, 0, len(bookmarks))
+	for _, bookmark := range bookmarks {
+		result = append(result, TextBookmark{Bookmark: bookmark, TextName: names[bookmark.TextUUID]})
+	}
+	return result, nil
+}
+
+func (s *Service) DeleteBookmark(userID int64, bookmarkUUID string) error {
+	return s.s.DeleteBookmark(userID, bookmarkUUID)
+}
+
+// OpenBookmark selects text of the bookmark and moves reading position to the bookmark
+func (s *Service) OpenBookmark(userID int64, bookmarkUUID string) (storage.Text, error) {
+	bookmarks, err := s.s.GetBookmarks(userID, "")
+	if err != nil {
+		return storage.Text{}, err
+	}
+	i := slices.IndexFunc(bookmarks, func(bookmark storage.Bookmark) bool {
+		return bookmark.UUID == bookmarkUUID
+	})
+	if i < 0 {
+		return storage.Text{}, storage.ErrNotFound
+	}
+	bookmark := bookmarks[i]
+	var text storage.Text
+	err = s.s.UpdateTexts(userID, func(texts *storage.UserTexts) error {
+		for i := range texts.Texts {
+			if texts.Texts[i].UUID == bookmark.TextUUID {
+				texts.Current = i
+				texts.Texts[i].Position = bookmark.Position
+				texts.Texts[i].ModifiedAt = time.Now()
+				text = texts.Texts[i]
+				return nil
+			}
+		}
+		return storage.ErrNotFound
+	})
+	return text, err
+}
+
+// QuoteCurrentChunk highlights fragment of the current chunk or the whole chunk if fragment is empty.
+// Fragment is plain text, e.g. part of the message with the chunk.
+func (s *Service) QuoteCurrentChunk(userID int64, fragment, note string) (storage.Highlight, error) {
+	current, err := s.s.GetCurrentChunk(userID)
+	if err != nil {
+		return storage.Highlight{}, err
+	}
+	if current.Text.CurrentChunk == storage.NotSelected {
+		return storage.Highlight{}, ErrTextNotSelected
+	}
+	// positions are counted in text characters, shown bullets and link urls are not counted
+	text := markup.Text(current.Chunk)
+	fragment = strings.TrimSpace(fragment)
+	start, end := current.Position, current.Position+visibleLen(text)
+	switch i := strings.Index(text, fragment); {
+	case fragment == "":
+		fragment = strings.TrimSpace(markup.PlainText(current.Chunk))
+	case i >= 0:
+		start = current.Position + visibleLen(text[:i])
+		end = start + visibleLen(fragment)
+	}
+	// fragment that isn't found exactly (e.g. message shows links differently) is placed at the chunk
+	return s.AddHighlight(userID, storage.Highlight{
+		TextUUID: current.Text.UUID,
+		Start:    start,
+		End:      end,
+		Text:     fragment,
+		Note:     note,
+	})
+}
+
+// AddHighlight saves fragment of the text between start and end positions
+func (s *Service) AddHighlight(userID int64, highlight storage.Highlight) (storage.Highlight, error) {
+	if highlight.Start < 0 || highlight.End < highlight.Start {
+		return storage.Highlight{}, errors.New("invalid highlight range")
+	}
+	highlight.Note = strings.TrimSpace(highlight.Note)
+	if len(highlight.Text) > telegramMessageLengthLimit || len(highlight.Note) > telegramMessageLengthLimit {
+		return storage.Highlight{}, errors.Errorf("highlight is too long, max length is %d", telegramMessageLengthLimit)
+	}
+	return s.s.AddHighlight(userID, highlight)
+}
+
+// Highlights returns highlights of the text or of all texts if textUUID is empty
+func (s *Service) Highlights(userID int64, textUUID string) ([]storage.Highlight, error) {
+	return s.s.GetHighlights(userID, textUUID)
+}
+
+func (s *Service) DeleteHighlight(userID int64, highlightUUID string) error {
+	return s.s.DeleteHighlight(userID, highlightUUID)
+}
+
+// ExportHighlights renders highlights and notes grouped by text,
+// highlights of all texts are exported if textUUID is empty
+func (s *Service) ExportHighlights(userID int64, textUUID string, format notesexport.Format) (notesexport.File, error) {
+	highlights, err := s.s.GetHighlights(userID, textUUID)
+	if err != nil {
+		return notesexport.File{}, err
+	}
+	if len(highlights) == 0 {
+		return notesexport.File{}, ErrNoHighlights
+	}
+	texts, err := s.s.GetTexts(userID)
+	if err != nil {
+		return notesexport.File{}, err
+	}
+	byText := make(map[string][]notesexport.Highlight, len(texts))
+	for _, h := range highlights {
+		byText[h.TextUUID] = append(byText[h.TextUUID], notesexport.Highlight{
+			Text:      h.Text,
+			Note:      h.Note,
+			Location:  h.Start,
+			CreatedAt: h.CreatedAt,
+		})
+	}
+	books := make([]notesexport.Book, 0, len(byText))
+	for _, t := range texts {
+		bookHighlights := byText[t.UUID]
+		if len(bookHighlights) == 0 {
+			continue
+		}
+		// highlights are stored in order they were made, book notes follow the text
+		slices.SortStableFunc(bookHighlights, func(a, b notesexport.Highlight) int {
+			return int(a.Location - b.Location)
+		})
+		books = append(books, notesexport.Book{
+			Title:      t.Name,
+			Language:   t.Language,
+			Highlights: bookHighlights,
+		})
+	}
+	return notesexport.Export(format, books)
+}
+
+// TextEPUB returns the text as epub book and its file name. Current text is used if textUUID is empty.
+// Every chapter of the text becomes a section of the book, or every chunk if the text has no headings.
+func (s *Service) TextEPUB(userID int64, textUUID string) (string, []byte, error) {
+	if textUUID == "" {
+		// storage doesn't have distinct error for not selected text
+		current, err := s.s.GetCurrentText(userID)
+		if err != nil || current.UUID == "" {
+			return "", nil, ErrTextNotSelected
+		}
+		textUUID = current.UUID
+	}
+	content, err := s.s.GetTextContent(userID, textUUID)
+	if err != nil {
+		return "", nil, err
+	}
+	sections := chapters(content.FullText)
+	if len(sections) < 2 {
+		sections = make([]epub.Section, 0, len(content.Chunks))
+		for _, chunk := range content.Chunks {
+			sections = append(sections, epub.Section{Text: chunk})
+		}
+	}
+	data, err := epub.Write(epub.Book{
+		Identifier:  "urn:uuid:" + content.Text.UUID,
+		Title:       content.Text.Name,
+		Language:    content.Text.Language,
+		Modified:    content.Text.ModifiedAt,
+		Sections:    sections,
+		Attachments: content.Attachments,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return trimFileExtension(content.Text.Name) + ".epub", data, nil
+}
+
+// chapters splits text in markup at headings, text before the first heading is a chapter without title
+func chapters(text string) []epub.Section {
+	var sections []epub.Section
+	var b strings.Builder
+	var title string
+	flush := func() {
+		if strings.TrimSpace(b.String()) != "" {
+			sections = append(sections, epub.Section{Title: title, Text: b.String()})
+		}
+		b.Reset()
+	}
+	for _, line := range strings.SplitAfter(text, "\n") {
+		// # at the beginning of a line that isn't a heading is escaped, code blocks included
+		if strings.HasPrefix(line, "#") && markup.LinePrefixLen([]byte(line)) > 0 {
+			flush()
+			title = strings.TrimSpace(markup.PlainText(line))
+		}
+		b.WriteString(line)
+	}
+	flush()
+	return sections
+}
+
+// trimFileExtension removes extension of the file the text was uploaded from
+func trimFileExtension(name string) string {
+	ext := path.Ext(name)
+	if len(ext) < 2 || len(ext) > 5 || strings.ContainsFunc(ext[1:], func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		return name
+	}
+	return strings.TrimSuffix(name, ext)
+}
+
+// visibleLen returns number of visible characters in plain text, see storage.Text.Position
+func visibleLen(plain string) int64 {
+	return int64(utf8.RuneCountInString(strings.Join(strings.Fields(plain), "")))
+}
+
 type SyncText struct {
 	TextUUID     string
 	CurrentChunk int64
-	ModifiedAt   time.Time
-	Deleted      bool
+	// Position is the reading position in visible characters, see storage.Text.
+	// It's nil if client knows only current chunk.
+	Position   *int64
+	ModifiedAt time.Time
+	Deleted    bool
 }
 
 func (s *Service) SyncTexts(userID int64, texts []SyncText) ([]SyncText, error) {
@@ -349,8 +1459,16 @@ func (s *Service) SyncTexts(userID int64, texts []SyncText) ([]SyncText, error)
 		}
 		syncTextMap[t.TextUUID] = t
 	}
+	infos, err := s.s.GetTexts(userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get texts")
+	}
+	lengths := make(map[string]int64, len(infos))
+	for _, info := range infos {
+		lengths[info.UUID] = info.Length
+	}
 	var result []SyncText
-	err := s.s.UpdateTexts(userID, func(texts *storage.UserTexts) error {
+	err = s.s.UpdateTexts(userID, func(texts *storage.UserTexts) error {
 		for i := range texts.Texts {
 			t := texts.Texts[i]
 			syncText, ok := syncTextMap[t.UUID]
@@ -359,7 +1477,12 @@ func (s *Service) SyncTexts(userID int64, texts []SyncText) ([]SyncText, error)
 			}
 			delete(syncTextMap, t.UUID)
 			if syncText.ModifiedAt.After(t.ModifiedAt) {
-				t.CurrentChunk = syncText.CurrentChunk
+				// current chunk is derived from the position, client may split text differently
+				if syncText.Position != nil {
+					t.Position = syncPosition(*syncText.Position, lengths[t.UUID])
+				} else {
+					t.CurrentChunk = syncText.CurrentChunk
+				}
 				t.ModifiedAt = syncText.ModifiedAt
 				texts.Texts[i] = t
 				continue
@@ -368,6 +1491,7 @@ func (s *Service) SyncTexts(userID int64, texts []SyncText) ([]SyncText, error)
 			result = append(result, SyncText{
 				TextUUID:     t.UUID,
 				CurrentChunk: t.CurrentChunk,
+				Position:     &t.Position,
 				ModifiedAt:   t.ModifiedAt,
 			})
 		}
@@ -386,8 +1510,16 @@ func (s *Service) SyncTexts(userID int64, texts []SyncText) ([]SyncText, error)
 	return result, err
 }
 
+// syncPosition keeps position from client inside the text of the length, negative position means not started text
+func syncPosition(position, length int64) int64 {
+	if position < 0 {
+		return storage.NotSelected
+	}
+	return min(position, length)
+}
+
 func (s *Service) SetPage(userID, page int64) error {
-	_, err := s.s.SelectChunk(userID, func(_ storage.Text, _, totalChunks int64) (nextChunk int64, err error) {
+	_, _, err := s.s.SelectChunk(userID, func(_ storage.Text, _, totalChunks int64) (nextChunk int64, err error) {
 		if page >= totalChunks || page < 0 {
 			return 0, errors.Errorf("invalid page index, should be between 0 and %d", totalChunks-1)
 		}
@@ -397,7 +1529,7 @@ func (s *Service) SetPage(userID, page int64) error {
 }
 
 func (s *Service) NextChunk(userID int64) (storage.Text, string, ChunkType, error) {
-	return s.selectChunk(userID, func(_ storage.Text, curChunk, totalChunks int64) (nextChunk int64, err error) {
+	return s.selectChunk(userID, true, func(_ storage.Text, curChunk, totalChunks int64) (nextChunk int64, err error) {
 		if isTextFinished(curChunk, totalChunks) {
 			return 0, ErrTextFinished
 		}
@@ -406,7 +1538,7 @@ func (s *Service) NextChunk(userID int64) (storage.Text, string, ChunkType, erro
 }
 
 func (s *Service) PrevChunk(userID int64) (storage.Text, string, ChunkType, error) {
-	return s.selectChunk(userID, func(_ storage.Text, curChunk, totalChunks int64) (nextChunk int64, err error) {
+	return s.selectChunk(userID, false, func(_ storage.Text, curChunk, totalChunks int64) (nextChunk int64, err error) {
 		if curChunk <= 0 {
 			return 0, ErrFirstChunk
 		}
@@ -415,7 +1547,7 @@ func (s *Service) PrevChunk(userID int64) (storage.Text, string, ChunkType, erro
 }
 
 func (s *Service) CurrentOrFirstChunk(userID int64) (storage.Text, string, ChunkType, error) {
-	return s.selectChunk(userID, func(_ storage.Text, curChunk, totalChunks int64) (nextChunk int64, err error) {
+	return s.selectChunk(userID, false, func(_ storage.Text, curChunk, totalChunks int64) (nextChunk int64, err error) {
 		if curChunk == storage.NotSelected {
 			return 0, nil // return first chunk
 		}
@@ -435,11 +1567,11 @@ const (
 	ChunkTypeOther ChunkType = "other"
 )
 
-func (s *Service) selectChunk(userID int64, selectChunk storage.SelectChunkFunc) (storage.Text, string, ChunkType, error) {
+// selectChunk selects chunk of the current text, measureSpeed is true when user finished reading previous chunk,
+// then the shown chunk is also added to the reading history
+func (s *Service) selectChunk(userID int64, measureSpeed bool, selectChunk storage.SelectChunkFunc) (storage.Text, string, ChunkType, error) {
 	var chunkType ChunkType = ChunkTypeOther
-	var curText storage.Text
-	text, err := s.s.SelectChunk(userID, func(text storage.Text, curChunk, totalChunks int64) (nextChunk int64, err error) {
-		curText = text
+	curText, text, err := s.s.SelectChunk(userID, func(text storage.Text, curChunk, totalChunks int64) (nextChunk int64, err error) {
 		nextChunk, err = selectChunk(text, curChunk, totalChunks)
 		if err != nil {
 			return 0, err
@@ -452,7 +1584,14 @@ func (s *Service) selectChunk(userID int64, selectChunk storage.SelectChunkFunc)
 		}
 		return nextChunk, nil
 	})
-	return curText, text, chunkType, err
+	if err != nil {
+		return curText, text, chunkType, err
+	}
+	reading, err := s.trackReading(userID, curText.Language, text, measureSpeed)
+	if err != nil || !measureSpeed {
+		return curText, text, chunkType, err
+	}
+	return curText, text, chunkType, s.logReading(userID, curText, text, *reading)
 }
 
 func (s *Service) DeleteTextByUUID(userID int64, textUUID string) error {
@@ -570,14 +1709,17 @@ type Herb struct {
 type Level struct {
 	Level      int64
 	Experience int64
+	// NextLevelExperience is experience needed for the next level
+	NextLevelExperience int64
 }
+
 type Stat struct {
-	Free           int64
-	Luck           int64
-	Accuracy       int64
-	Attention      int64
-	TimeManagement int64
-	Charizma       int64
+	Free           int64 `json:"free,omitempty"`
+	Luck           int64 `json:"luck,omitempty"`
+	Accuracy       int64 `json:"accuracy,omitempty"`
+	Attention      int64 `json:"attention,omitempty"`
+	TimeManagement int64 `json:"time_management,omitempty"`
+	Charizma       int64 `json:"charizma,omitempty"`
 }
 
 type LootResult struct {
@@ -609,7 +1751,16 @@ func (s *Service) LootOnNextChunk(userID int64) (*LootResult, error) {
 	// *pointer = shark
 
 	playerStats := mapDbStatToServiceStat(pointerdbPlayerStats)
-	deltaDust := s.findDustAtomicAction(playerStats.Attention, playerStats.Accuracy)
+	lootMultiplier, err := s.buffMultiplier(userID, storage.BuffLoot)
+	if err != nil {
+		return nil, err
+	}
+	loot := Mix{
+		Dust: s.findDustAtomicAction(playerStats),
+		Herb: s.findHerbAtomicAction(playerStats),
+	}
+	loot.multiply(lootMultiplier)
+	deltaDust, deltaHerb := loot.Dust, loot.Herb
 	dbDust, err := s.s.UpdateDust(userID, func(d *storage.Dust) {
 		d.RedCount += deltaDust.RedCount
 		d.OrangeCount += deltaDust.OrangeCount
@@ -624,7 +1775,6 @@ func (s *Service) LootOnNextChunk(userID int64) (*LootResult, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to update dust")
 	}
-	deltaHerb := s.findHerbAtomicAction(playerStats.Attention, playerStats.Accuracy)
 	dbHerb, err := s.s.UpdateHerb(userID, func(d *storage.Herb) {
 		d.MelissaCount += deltaHerb.MelissaCount
 		d.LavandaCount += deltaHerb.LavandaCount
@@ -644,46 +1794,38 @@ func (s *Service) LootOnNextChunk(userID int64) (*LootResult, error) {
 func (s *Service) ExpOnNextChunk(userID int64) (*Level, int64, bool, error) {
 	deltaExp := int64(2)
 	//TODO determine by chunk size of TEXT not user
-	userChunkSize, err := s.getChunkSize(userID)
+	userChunkSize, err := s.getChunkSize(userID, "")
 	if err != nil {
 		return nil, 0, false, err
 	}
 	deltaExp = calculateExperienceGainByChunkSize(userChunkSize)
-	levelUp := false
-	oldExperience, err := s.s.GetLevelByUserID(userID)
+	expMultiplier, err := s.buffMultiplier(userID, storage.BuffExp)
 	if err != nil {
 		return nil, 0, false, err
 	}
-	oldLevelNumber := DetectLevelByExperience(oldExperience.Experience)
-
+	deltaExp = int64(math.Round(float64(deltaExp) * expMultiplier))
+	// levels are detected inside the update, so concurrent chunks can't level up the same level twice
+	var oldLevelNumber, newLevelNumber int64
 	dbLevel, err := s.s.UpdateLevel(userID, func(d *storage.Level) {
+		oldLevelNumber = s.leveling.LevelByExperience(d.Experience)
 		d.Experience += deltaExp
+		newLevelNumber = s.leveling.LevelByExperience(d.Experience)
 	})
 	if err != nil {
 		return nil, 0, false, err
 	}
 
-	newLevel := mapDbLevelToServiceLevel(dbLevel)
-	if newLevel.Level > oldLevelNumber {
-		levelUp = true
-		if newLevel.Level >= 21 {
-			_, err = s.s.UpdateStat(userID, func(d *storage.Stat) {
-				d.Free++
-			})
-			if err != nil {
-				return nil, 0, false, err
-			}
-		}
-	}
-
-	if newLevel.Level < 21 {
-		currentStats := LevelUpStatDistribution(newLevel.Level)
+	newLevel := s.mapDbLevelToServiceLevel(dbLevel)
+	levelUp := newLevelNumber > oldLevelNumber
+	if levelUp {
+		gain := s.leveling.levelUpStats(oldLevelNumber, newLevelNumber)
 		_, err = s.s.UpdateStat(userID, func(d *storage.Stat) {
-			d.Accuracy = currentStats.Accuracy
-			d.Attention = currentStats.Attention
-			d.TimeManagement = currentStats.TimeManagement
-			d.Charizma = currentStats.Charizma
-			d.Luck = currentStats.Luck
+			d.Free += gain.Free
+			d.Accuracy += gain.Accuracy
+			d.Attention += gain.Attention
+			d.TimeManagement += gain.TimeManagement
+			d.Charizma += gain.Charizma
+			d.Luck += gain.Luck
 		})
 		if err != nil {
 			return nil, 0, false, err
@@ -705,97 +1847,27 @@ func calculateExperienceGainByChunkSize(chunkSize int64) int64 {
 	return exp
 }
 
-var levelThresholds = calculateLevelTresholds()
-
-func DetectLevelByExperience(experience int64) int64 {
-
-	newLevelNumber := int64(0)
-	for _, threshold := range levelThresholds {
-		if experience >= threshold {
-			newLevelNumber += 1
-		} else {
-			break
-		}
-	}
-	return newLevelNumber
-}
-
-func LevelUpStatDistribution(level int64) Stat {
-
-	var accuracyByLevelIncrease = []int64{
-		//01, 2, 3, 4, 5, 6, 7, 8, 9
-		0, 1, 0, 1, 0, 1, 0, 1, 0, 0, //0-9
-		0, 0, 0, 0, 0, 1, 0, 0, 0, 0, //10-19
-		0, 0, 0, 0, 0, 0, 0, 0, 0, 0} //20-29
-	var attentionByLevelIncrease = []int64{
-		0, 0, 1, 0, 1, 0, 1, 0, 0, 0, //0-9
-		0, 1, 0, 0, 0, 0, 0, 0, 1, 0, //10-19
-		0, 0, 0, 0, 0, 0, 0, 0, 0, 0} //20-29
-	var timeManagementByLevelIncrease = []int64{
-		0, 0, 0, 0, 0, 0, 0, 0, 1, 0, //0-9
-		1, 0, 0, 1, 0, 0, 1, 0, 0, 1, //10-19
-		0, 0, 0, 0, 0, 0, 0, 0, 0, 0} //20-29
-	var charizmaByLevelIncrease = []int64{
-		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, //0-9
-		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, //10-19
-		0, 0, 0, 0, 0, 0, 0, 0, 0, 0} //20-29
-	var luckByLevelIncrease = []int64{
-		0, 0, 0, 0, 0, 0, 0, 0, 0, 1, //0-9
-		0, 0, 1, 0, 1, 0, 0, 1, 0, 0, //10-19
-		1, 0, 0, 0, 0, 0, 0, 0, 0, 0} //20-29
-
-	var statsIncrease = [][]int64{
-		accuracyByLevelIncrease,
-		attentionByLevelIncrease,
-		timeManagementByLevelIncrease,
-		charizmaByLevelIncrease,
-		luckByLevelIncrease,
-	}
-	var stats = Stat{}
-
-	for i := 0; i < int(level)+1; i++ {
-		stats.Accuracy += statsIncrease[0][i]
-		stats.Attention += statsIncrease[1][i]
-		stats.TimeManagement += statsIncrease[2][i]
-		stats.Charizma += statsIncrease[3][i]
-		stats.Luck += statsIncrease[4][i]
-	}
-
-	return stats
-}
-
-// TODO: Подумать над thresholds после 20 уровня. Они уже там не оч адекватно большие
-func calculateLevelTresholds() []int64 {
-	var levelThresholds = []int64{100}
-	var levelRequirements = []int64{100}
-	//filling levelRequirements with values. Each next level requires 1.1 times more than previous. Starting from 100 for Level 1
-	for i := 1; i < 100; i++ {
-		levelRequirements = append(levelRequirements, int64(float64(levelRequirements[i-1])*1.1))
-	}
-	for i := 1; i < len(levelRequirements); i++ {
-		levelThresholds = append(levelThresholds, levelThresholds[i-1]+levelRequirements[i])
-	}
-	return levelThresholds
-}
-
-func (s *Service) findHerbAtomicAction(attention int64, accuracy int64) Herb {
+func (s *Service) findHerbAtomicAction(stats *Stat) Herb {
 	var deltaHerb Herb
+	accuracy := stats.Accuracy
 	// BASE 1.9% chance to get Herb
-	// 0.1% for each point in Attention
-	var chanceToGetHerb float64 = 0.019 + 0.001*float64(attention)
+	// 0.1% for each point in Attention, 0.2% for each point in TimeManagement
+	var chanceToGetHerb float64 = 0.019 + 0.001*float64(stats.Attention) + 0.002*float64(stats.TimeManagement)
 	if !s.chancer.Win(chanceToGetHerb) {
 		return deltaHerb
 	}
+	// Lavanda is rare, each point of Luck makes it 10% more likely
+	percents := luckyPercents([]float64{0.7, 0.3}, []bool{false, true}, stats.Luck)
 	//Amount of herb depends on Accuracy. Each point of accuracy adds 33% chance to get one more herb
 	s.chancer.PickWin(
 		chance.WinInput{
-			Percent: 0.7,
+			Percent: percents[0],
 			Action: func() {
 				deltaHerb.MelissaCount += 1 + s.getLootAmountByAccuracyAndIncreaseRate(accuracy, 0.33)
 			},
 		},
 		chance.WinInput{
-			Percent: 0.3,
+			Percent: percents[1],
 			Action: func() {
 				deltaHerb.LavandaCount += 1 + s.getLootAmountByAccuracyAndIncreaseRate(accuracy, 0.33)
 			},
@@ -804,67 +1876,74 @@ func (s *Service) findHerbAtomicAction(attention int64, accuracy int64) Herb {
 	return deltaHerb
 }
 
-func (s *Service) findDustAtomicAction(attention int64, accuracy int64) Dust {
+func (s *Service) findDustAtomicAction(stats *Stat) Dust {
 
 	var deltaDust Dust
+	accuracy := stats.Accuracy
 	// BASE 33% chance to get Dust
 	// 0.1% for each point in Attention
-	var chanceToGetDust float64 = 0.33 + 0.001*float64(attention)
+	var chanceToGetDust float64 = 0.33 + 0.001*float64(stats.Attention)
 	if !s.chancer.Win(chanceToGetDust) {
 		return deltaDust
 	}
+	// orange, purple, white and black dust are rare, each point of Luck makes them 10% more likely
+	percents := luckyPercents(
+		[]float64{0.25, 0.03, 0.25, 0.142, 0.13, 0.13, 0.03, 0.019, 0.019},
+		[]bool{false, true, false, false, false, false, true, true, true},
+		stats.Luck,
+	)
 	//Amount of dust depends on Accuracy. Each point of accuracy adds 50% chance to get one more dust
 	s.chancer.PickWin(
 		chance.WinInput{
-			Percent: 0.25,
+			Percent: percents[0],
 			Action: func() {
 				deltaDust.RedCount += 1 + s.getLootAmountByAccuracyAndIncreaseRate(accuracy, 0.5)
 			},
 		},
 		chance.WinInput{
-			Percent: 0.03,
+			Percent: percents[1],
 			Action: func() {
 				deltaDust.OrangeCount += 1 + s.getLootAmountByAccuracyAndIncreaseRate(accuracy, 0.5)
 			},
 		},
 		chance.WinInput{
-			Percent: 0.25,
+			Percent: percents[2],
 			Action: func() {
 				deltaDust.YellowCount += 1 + s.getLootAmountByAccuracyAndIncreaseRate(accuracy, 0.5)
 			},
 		},
 		chance.WinInput{
-			Percent: 0.142,
+			Percent: percents[3],
 			Action: func() {
 				deltaDust.GreenCount += 1 + s.getLootAmountByAccuracyAndIncreaseRate(accuracy, 0.5)
 			},
 		},
 		chance.WinInput{
-			Percent: 0.13,
+			Percent: percents[4],
 			Action: func() {
 				deltaDust.BlueCount += 1 + s.getLootAmountByAccuracyAndIncreaseRate(accuracy, 0.5)
 			},
 		},
 		chance.WinInput{
-			Percent: 0.13,
+			Percent: percents[5],
 			Action: func() {
 				deltaDust.IndigoCount += 1 + s.getLootAmountByAccuracyAndIncreaseRate(accuracy, 0.5)
 			},
 		},
 		chance.WinInput{
-			Percent: 0.03,
+			Percent: percents[6],
 			Action: func() {
 				deltaDust.PurpleCount += 1 + s.getLootAmountByAccuracyAndIncreaseRate(accuracy, 0.5)
 			},
 		},
 		chance.WinInput{
-			Percent: 0.019,
+			Percent: percents[7],
 			Action: func() {
 				deltaDust.WhiteCount += 1 + s.getLootAmountByAccuracyAndIncreaseRate(accuracy, 0.5)
 			},
 		},
 		chance.WinInput{
-			Percent: 0.019,
+			Percent: percents[8],
 			Action: func() {
 				deltaDust.BlackCount += 1 + s.getLootAmountByAccuracyAndIncreaseRate(accuracy, 0.5)
 			},
@@ -873,6 +1952,24 @@ func (s *Service) findDustAtomicAction(attention int64, accuracy int64) Dust {
 	return deltaDust
 }
 
+// luckyPercents makes rare loot 10% more likely for each point of Luck, sum of percents doesn't change
+func luckyPercents(percents []float64, rare []bool, luck int64) []float64 {
+	lucky := make([]float64, len(percents))
+	var total, luckyTotal float64
+	for i, percent := range percents {
+		lucky[i] = percent
+		if rare[i] {
+			lucky[i] *= 1 + 0.1*float64(luck)
+		}
+		total += percent
+		luckyTotal += lucky[i]
+	}
+	for i := range lucky {
+		lucky[i] *= total / luckyTotal
+	}
+	return lucky
+}
+
 func (s *Service) getLootAmountByAccuracyAndIncreaseRate(accuracy int64, increaseRate float64) int64 {
 	count := int64(0)
 	for i := 0; i < int(accuracy); i++ {
@@ -903,10 +2000,12 @@ func mapDbHerbToHerb(dbHerb *storage.Herb) *Herb {
 	}
 }
 
-func mapDbLevelToServiceLevel(dbLevel *storage.Level) *Level {
+func (s *Service) mapDbLevelToServiceLevel(dbLevel *storage.Level) *Level {
+	level := s.leveling.LevelByExperience(dbLevel.Experience)
 	return &Level{
-		Experience: dbLevel.Experience,
-		Level:      DetectLevelByExperience(dbLevel.Experience),
+		Experience:          dbLevel.Experience,
+		Level:               level,
+		NextLevelExperience: s.leveling.ExperienceForLevel(level + 1),
 	}
 }
 
@@ -950,7 +2049,7 @@ func (s *Service) GetStatsAndLevel(userID int64) (*Stat, *Level, error) {
 	if err != nil {
 		return nil, nil, err
 	}
-	return mapDbStatToServiceStat(&dbStat), mapDbLevelToServiceLevel(&dbLevel), nil
+	return mapDbStatToServiceStat(&dbStat), s.mapDbLevelToServiceLevel(&dbLevel), nil
 }
 
 func (s *Service) GetAuthToken(userID int64) (string, error) {
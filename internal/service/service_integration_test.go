@@ -1,15 +1,28 @@
 package service
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/pechorka/adhd-reader/internal/storage"
 	"github.com/pechorka/adhd-reader/pkg/chance"
+	"github.com/pechorka/adhd-reader/pkg/encryptor"
+	epubparser "github.com/pechorka/adhd-reader/pkg/fileparser/epub"
+	"github.com/pechorka/adhd-reader/pkg/markup"
+	"github.com/pechorka/adhd-reader/pkg/notesexport"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -26,7 +39,7 @@ func TestService_ListTexts(t *testing.T) {
 	text3ID, err := srv.AddText(userID, "text3Name", "text3")
 	require.NoError(t, err)
 
-	texts, more, err := srv.ListTexts(userID, 1, 50)
+	texts, more, err := srv.ListTexts(userID, ListOptions{}, 1, 50)
 	require.NoError(t, err)
 	require.False(t, more)
 	require.Equal(t, text1ID, texts[0].UUID)
@@ -45,19 +58,19 @@ func TestService_ListTextsPagination(t *testing.T) {
 	text3ID, err := srv.AddText(userID, "text3Name", "text3")
 	require.NoError(t, err)
 
-	texts, more, err := srv.ListTexts(userID, 1, 1)
+	texts, more, err := srv.ListTexts(userID, ListOptions{}, 1, 1)
 	require.NoError(t, err)
 	require.True(t, more)
 	require.Len(t, texts, 1)
 	require.Equal(t, text1ID, texts[0].UUID)
 
-	texts, more, err = srv.ListTexts(userID, 2, 1)
+	texts, more, err = srv.ListTexts(userID, ListOptions{}, 2, 1)
 	require.NoError(t, err)
 	require.True(t, more)
 	require.Len(t, texts, 1)
 	require.Equal(t, text2ID, texts[0].UUID)
 
-	texts, more, err = srv.ListTexts(userID, 3, 1)
+	texts, more, err = srv.ListTexts(userID, ListOptions{}, 3, 1)
 	require.NoError(t, err)
 	require.False(t, more)
 	require.Len(t, texts, 1)
@@ -102,7 +115,7 @@ func TestService_DeleteTextByUUID(t *testing.T) {
 	text3ID, err := srv.AddText(userID, "text3Name", "text3")
 	require.NoError(t, err)
 
-	texts, more, err := srv.ListTexts(userID, 1, 50)
+	texts, more, err := srv.ListTexts(userID, ListOptions{}, 1, 50)
 	require.NoError(t, err)
 	require.False(t, more)
 	require.Len(t, texts, 3)
@@ -116,7 +129,7 @@ func TestService_DeleteTextByUUID(t *testing.T) {
 	err = srv.DeleteTextByUUID(userID, text2ID)
 	require.NoError(t, err)
 
-	texts, more, err = srv.ListTexts(userID, 1, 50)
+	texts, more, err = srv.ListTexts(userID, ListOptions{}, 1, 50)
 	require.NoError(t, err)
 	require.False(t, more)
 	require.Len(t, texts, 2)
@@ -135,7 +148,7 @@ func TestService_DeleteTextByName(t *testing.T) {
 	_, err = srv.AddText(userID, "text3Name", "text3")
 	require.NoError(t, err)
 
-	texts, more, err := srv.ListTexts(userID, 1, 50)
+	texts, more, err := srv.ListTexts(userID, ListOptions{}, 1, 50)
 	require.NoError(t, err)
 	require.False(t, more)
 	require.Len(t, texts, 3)
@@ -149,7 +162,7 @@ func TestService_DeleteTextByName(t *testing.T) {
 	err = srv.DeleteTextByName(userID, "text2Name")
 	require.NoError(t, err)
 
-	texts, more, err = srv.ListTexts(userID, 1, 50)
+	texts, more, err = srv.ListTexts(userID, ListOptions{}, 1, 50)
 	require.NoError(t, err)
 	require.False(t, more)
 	require.Len(t, texts, 2)
@@ -236,6 +249,92 @@ func TestService_SetChunkSize(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestService_ChunkSeconds(t *testing.T) {
+	store := testStorage(t)
+	srv := NewService(store, 5, nil, nil)
+	userID := rand.Int63()
+
+	err := srv.SetChunkSeconds(userID, minChunkSeconds-1)
+	require.Error(t, err)
+	err = srv.SetChunkSeconds(userID, maxChunkSeconds+1)
+	require.Error(t, err)
+
+	err = srv.SetChunkSeconds(userID, 60)
+	require.NoError(t, err)
+	chunkSize, err := srv.getChunkSize(userID, "en")
+	require.NoError(t, err)
+	require.EqualValues(t, 1000, chunkSize)
+	chunkSize, err = srv.getChunkSize(userID, "ru")
+	require.NoError(t, err)
+	require.EqualValues(t, 900, chunkSize)
+
+	err = srv.SetReadingSpeed(userID, "", 600)
+	require.NoError(t, err)
+	chunkSize, err = srv.getChunkSize(userID, "ru")
+	require.NoError(t, err)
+	require.EqualValues(t, 600, chunkSize)
+
+	err = srv.SetReadingSpeed(userID, "ru", 300)
+	require.NoError(t, err)
+	chunkSize, err = srv.getChunkSize(userID, "ru")
+	require.NoError(t, err)
+	require.EqualValues(t, 300, chunkSize)
+
+	// chunk size in characters disables chunk size in time
+	err = srv.SetChunkSize(userID, 5)
+	require.NoError(t, err)
+	chunkSize, err = srv.getChunkSize(userID, "ru")
+	require.NoError(t, err)
+	require.EqualValues(t, 5, chunkSize)
+}
+
+func TestService_MeasureReadingSpeed(t *testing.T) {
+	store := testStorage(t)
+	srv := NewService(store, 100, nil, nil)
+	now := time.Now()
+	srv.now = func() time.Time { return now }
+	userID := rand.Int63()
+
+	sentence := "This sentence is exactly fifty characters long ok."
+	text := strings.Repeat(sentence+" ", 20)
+	textID, err := srv.AddText(userID, "text", text)
+	require.NoError(t, err)
+	_, err = srv.SelectText(userID, textID)
+	require.NoError(t, err)
+
+	_, chunk, _, err := srv.CurrentOrFirstChunk(userID)
+	require.NoError(t, err)
+	chunkLen := utf8.RuneCountInString(chunk)
+	for i := 0; i < minSpeedSamples; i++ {
+		// read every chunk at 600 characters per minute
+		now = now.Add(time.Duration(float64(chunkLen) / 600 * float64(time.Minute)))
+		_, chunk, _, err = srv.NextChunk(userID)
+		require.NoError(t, err)
+		chunkLen = utf8.RuneCountInString(chunk)
+	}
+	speed, err := srv.ReadingSpeed(userID, "en")
+	require.NoError(t, err)
+	require.EqualValues(t, 600, speed)
+
+	// user was distracted, sample is ignored
+	now = now.Add(time.Hour)
+	_, _, _, err = srv.NextChunk(userID)
+	require.NoError(t, err)
+	speed, err = srv.ReadingSpeed(userID, "en")
+	require.NoError(t, err)
+	require.EqualValues(t, 600, speed)
+
+	// explicit speed wins over measured
+	require.NoError(t, srv.SetReadingSpeed(userID, "en", 1500))
+	speed, err = srv.ReadingSpeed(userID, "en")
+	require.NoError(t, err)
+	require.EqualValues(t, 1500, speed)
+	require.NoError(t, srv.ResetReadingSpeed(userID))
+	speed, err = srv.ReadingSpeed(userID, "en")
+	require.NoError(t, err)
+	require.EqualValues(t, 600, speed)
+}
+
 func TestService_SyncTexts(t *testing.T) {
 	srv := NewService(testStorage(t), 100, nil, nil)
 	userID := rand.Int63()
@@ -268,12 +367,851 @@ func TestService_SyncTexts(t *testing.T) {
 	require.Equal(t, nonExistentTextID, syncOnMobile[1].TextUUID)
 	require.True(t, syncOnMobile[1].Deleted)
 
-	texts, more, err := srv.ListTexts(userID, 1, 50)
+	texts, more, err := srv.ListTexts(userID, ListOptions{}, 1, 50)
 	require.NoError(t, err)
 	require.False(t, more)
 	require.Len(t, texts, 2)
 }
 
+func TestService_SyncTextsPosition(t *testing.T) {
+	srv := NewService(testStorage(t), 50, nil, nil)
+	userID := rand.Int63()
+
+	var text strings.Builder
+	for i := 0; i < 30; i++ {
+		fmt.Fprintf(&text, "Sentence number %d is here. ", i)
+	}
+	textID, err := srv.AddText(userID, "text", text.String())
+	require.NoError(t, err)
+
+	// client splits text differently and sends position in the middle of server chunk
+	fullTexts, err := srv.FullTexts(userID, nil, -1, 0)
+	require.NoError(t, err)
+	offsets := fullTexts[0].Offsets
+	position := offsets[5] + 3
+	_, err = srv.SyncTexts(userID, []SyncText{
+		{TextUUID: textID, ModifiedAt: time.Now().Add(time.Hour), Position: &position},
+	})
+	require.NoError(t, err)
+
+	fullTexts, err = srv.FullTexts(userID, nil, -1, 0)
+	require.NoError(t, err)
+	require.EqualValues(t, 5, fullTexts[0].CurrentChunk)
+	require.Equal(t, position, fullTexts[0].Position)
+
+	// position survives re-chunking
+	require.NoError(t, srv.SetChunkSize(userID, 200))
+	require.NoError(t, srv.RechunkText(userID, textID))
+	fullTexts, err = srv.FullTexts(userID, nil, -1, 0)
+	require.NoError(t, err)
+	require.Equal(t, position, fullTexts[0].Position)
+	current := fullTexts[0].CurrentChunk
+	require.LessOrEqual(t, fullTexts[0].Offsets[current], position)
+	require.Greater(t, fullTexts[0].Offsets[current+1], position)
+
+	// server is newer, client gets position
+	syncOnMobile, err := srv.SyncTexts(userID, []SyncText{
+		{TextUUID: textID, ModifiedAt: time.Now().Add(-time.Hour), CurrentChunk: 1},
+	})
+	require.NoError(t, err)
+	require.Len(t, syncOnMobile, 1)
+	require.Equal(t, position, *syncOnMobile[0].Position)
+
+	// navigation moves position to the beginning of the chunk
+	_, err = srv.SelectText(userID, textID)
+	require.NoError(t, err)
+	text2, _, _, err := srv.NextChunk(userID)
+	require.NoError(t, err)
+	require.Equal(t, fullTexts[0].Offsets[current+1], text2.Position)
+
+	// positions outside the text are kept inside it
+	texts, err := srv.s.GetTexts(userID)
+	require.NoError(t, err)
+	for _, sent := range []struct{ position, expected int64 }{
+		{texts[0].Length + 100, texts[0].Length},
+		{-5, storage.NotSelected},
+	} {
+		_, err = srv.SyncTexts(userID, []SyncText{
+			{TextUUID: textID, ModifiedAt: time.Now().Add(time.Hour), Position: &sent.position},
+		})
+		require.NoError(t, err)
+		fullTexts, err = srv.FullTexts(userID, nil, -1, 0)
+		require.NoError(t, err)
+		require.Equal(t, sent.expected, fullTexts[0].Position)
+	}
+}
+
+func TestService_Bookmarks(t *testing.T) {
+	srv := NewService(testStorage(t), 50, nil, nil)
+	userID := rand.Int63()
+
+	var text strings.Builder
+	for i := 0; i < 30; i++ {
+		fmt.Fprintf(&text, "Sentence number %d is here. ", i)
+	}
+	textID, err := srv.AddText(userID, "text", text.String())
+	require.NoError(t, err)
+	_, err = srv.SelectText(userID, textID)
+	require.NoError(t, err)
+	require.NoError(t, srv.SetPage(userID, 3))
+	_, bookmarkedChunk, _, err := srv.CurrentOrFirstChunk(userID)
+	require.NoError(t, err)
+
+	bookmark, err := srv.BookmarkCurrentChunk(userID, "")
+	require.NoError(t, err)
+	_, err = srv.AddBookmark(userID, "unknown", 0, "")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = srv.AddBookmark(userID, textID, 0, "start")
+	require.NoError(t, err)
+
+	bookmarks, err := srv.TextBookmarks(userID)
+	require.NoError(t, err)
+	require.Len(t, bookmarks, 2)
+	require.Equal(t, "text", bookmarks[0].TextName)
+	require.Equal(t, "start", bookmarks[1].Label)
+
+	// bookmark returns to the same chunk
+	require.NoError(t, srv.SetPage(userID, 6))
+	_, err = srv.OpenBookmark(userID, bookmark.UUID)
+	require.NoError(t, err)
+	_, chunk, _, err := srv.CurrentOrFirstChunk(userID)
+	require.NoError(t, err)
+	require.Equal(t, bookmarkedChunk, chunk)
+
+	require.NoError(t, srv.DeleteBookmark(userID, bookmark.UUID))
+	require.ErrorIs(t, srv.DeleteBookmark(userID, bookmark.UUID), storage.ErrNotFound)
+	bookmarks, err = srv.TextBookmarks(userID)
+	require.NoError(t, err)
+	require.Len(t, bookmarks, 1)
+
+	// bookmarks of deleted text are deleted
+	require.NoError(t, srv.DeleteTextByUUID(userID, textID))
+	bookmarks, err = srv.TextBookmarks(userID)
+	require.NoError(t, err)
+	require.Empty(t, bookmarks)
+}
+
+func TestService_QuoteCurrentChunk(t *testing.T) {
+	srv := NewService(testStorage(t), 100, nil, nil)
+	userID := rand.Int63()
+
+	textID, err := srv.AddText(userID, "text", "First sentence here. Second bold sentence.")
+	require.NoError(t, err)
+	_, err = srv.SelectText(userID, textID)
+	require.NoError(t, err)
+	_, _, _, err = srv.CurrentOrFirstChunk(userID)
+	require.NoError(t, err)
+
+	whole, err := srv.QuoteCurrentChunk(userID, "", "")
+	require.NoError(t, err)
+	require.Equal(t, "First sentence here. Second bold sentence.", whole.Text)
+	require.EqualValues(t, 0, whole.Start)
+	require.EqualValues(t, 37, whole.End)
+
+	fragment, err := srv.QuoteCurrentChunk(userID, "Second bold", " my note ")
+	require.NoError(t, err)
+	require.EqualValues(t, 18, fragment.Start)
+	require.EqualValues(t, 28, fragment.End)
+	require.Equal(t, "my note", fragment.Note)
+
+	highlights, err := srv.Highlights(userID, textID)
+	require.NoError(t, err)
+	require.Len(t, highlights, 2)
+	require.NoError(t, srv.DeleteHighlight(userID, whole.UUID))
+	highlights, err = srv.Highlights(userID, "")
+	require.NoError(t, err)
+	require.Len(t, highlights, 1)
+	require.Equal(t, fragment.UUID, highlights[0].UUID)
+}
+
+func TestService_ExportHighlights(t *testing.T) {
+	srv := NewService(testStorage(t), 100, nil, nil)
+	userID := rand.Int63()
+
+	_, err := srv.ExportHighlights(userID, "", notesexport.JSON)
+	require.ErrorIs(t, err, ErrNoHighlights)
+
+	firstID, err := srv.AddText(userID, "first", "First sentence here. Second sentence.")
+	require.NoError(t, err)
+	secondID, err := srv.AddText(userID, "second", "Another text.")
+	require.NoError(t, err)
+	for _, h := range []storage.Highlight{
+		{TextUUID: firstID, Start: 18, End: 32, Text: "Second sentence."},
+		{TextUUID: secondID, Start: 0, End: 12, Text: "Another text.", Note: "note"},
+		{TextUUID: firstID, Start: 0, End: 18, Text: "First sentence here."},
+	} {
+		_, err := srv.AddHighlight(userID, h)
+		require.NoError(t, err)
+	}
+
+	file, err := srv.ExportHighlights(userID, "", notesexport.JSON)
+	require.NoError(t, err)
+	var export struct {
+		Books []struct {
+			Title      string
+			Highlights []struct{ Text, Note string }
+		}
+	}
+	require.NoError(t, json.Unmarshal(file.Data, &export))
+	require.Len(t, export.Books, 2)
+	require.Equal(t, "first", export.Books[0].Title)
+	require.Equal(t, "First sentence here.", export.Books[0].Highlights[0].Text)
+	require.Equal(t, "Second sentence.", export.Books[0].Highlights[1].Text)
+	require.Equal(t, "second", export.Books[1].Title)
+	require.Equal(t, "note", export.Books[1].Highlights[0].Note)
+
+	file, err = srv.ExportHighlights(userID, secondID, notesexport.Markdown)
+	require.NoError(t, err)
+	require.Equal(t, "second.md", file.Name)
+}
+
+func TestService_BackupRestore(t *testing.T) {
+	srv := NewService(testStorage(t), 100, nil, nil)
+	userID := rand.Int63()
+
+	textID, err := srv.AddText(userID, "text", "First sentence here. Second sentence.")
+	require.NoError(t, err)
+	_, err = srv.AddHighlight(userID, storage.Highlight{TextUUID: textID, Start: 0, End: 18, Text: "First sentence here."})
+	require.NoError(t, err)
+
+	backup, err := srv.Backup(userID)
+	require.NoError(t, err)
+
+	otherUserID := rand.Int63()
+	restored, err := srv.Restore(otherUserID, backup, storage.RestoreMerge)
+	require.NoError(t, err)
+	require.Equal(t, 1, restored)
+	highlights, err := srv.Highlights(otherUserID, textID)
+	require.NoError(t, err)
+	require.Len(t, highlights, 1)
+
+	// files from /download before backups were introduced
+	legacy := `{"texts":[{"textName":"old","currentChunk":1,"chunks":["- First chunk.","Second chunk."]}]}`
+	restored, err = srv.Restore(otherUserID, []byte(legacy), storage.RestoreMerge)
+	require.NoError(t, err)
+	require.Equal(t, 1, restored)
+	texts, _, err := srv.ListTexts(otherUserID, ListOptions{}, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, texts, 2)
+	require.Equal(t, "old", texts[1].Name)
+	require.Equal(t, 100, texts[1].CompletionPercent)
+	fullTexts, err := srv.FullTexts(otherUserID, nil, -1, 0)
+	require.NoError(t, err)
+	require.Equal(t, "- First chunk.", markup.PlainText(fullTexts[1].Chunks[0]), "downloaded texts are plain")
+
+	_, err = srv.Restore(otherUserID, []byte("not a backup"), storage.RestoreMerge)
+	require.Error(t, err)
+	_, err = srv.Restore(otherUserID, backup, storage.RestoreCopy)
+	require.Error(t, err, "uploaded backups aren't trusted")
+
+	var bomb bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&bomb, gzip.BestSpeed)
+	require.NoError(t, err)
+	_, err = zw.Write(make([]byte, maxRestoreSize+1))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	_, err = srv.Restore(otherUserID, bomb.Bytes(), storage.RestoreMerge)
+	require.ErrorIs(t, err, ErrBackupTooBig)
+}
+
+func TestService_RestoreChecksReminders(t *testing.T) {
+	srv := NewService(testStorage(t), 100, nil, nil)
+	now := time.Date(2024, 3, 27, 9, 0, 0, 0, time.UTC)
+	srv.now = func() time.Time { return now }
+	userID := rand.Int63()
+
+	valid := storage.Reminder{UUID: "valid", Hour: 8, Timezone: "Europe/Moscow", Mode: storage.ReminderCurrent, NextAt: now.Add(-time.Hour)}
+	backup := storage.Backup{
+		Version: storage.BackupVersion,
+		Reminders: []storage.Reminder{
+			valid,
+			{UUID: "hour", Hour: 25, Timezone: "UTC", Mode: storage.ReminderCurrent},
+			{UUID: "timezone", Hour: 8, Timezone: "Local", Mode: storage.ReminderCurrent},
+			{UUID: "mode", Hour: 8, Timezone: "UTC", Mode: "spam"},
+			{UUID: "day", Hour: 8, Timezone: "UTC", Mode: storage.ReminderCurrent, Weekdays: []time.Weekday{9}},
+		},
+		Reading: storage.Reading{GoalChunks: 10, Timezone: "Mars/Olympus"},
+	}
+	for i := 0; i < maxReminders; i++ {
+		extra := valid
+		extra.UUID = "extra" + strconv.Itoa(i)
+		backup.Reminders = append(backup.Reminders, extra)
+	}
+	data, err := json.Marshal(backup)
+	require.NoError(t, err)
+	_, err = srv.Restore(userID, data, storage.RestoreReplace)
+	require.NoError(t, err)
+
+	reminders, err := srv.GetReminders(userID)
+	require.NoError(t, err)
+	require.Len(t, reminders, maxReminders)
+	require.Equal(t, "valid", reminders[0].UUID)
+	require.True(t, reminders[0].NextAt.Equal(time.Date(2024, 3, 28, 5, 0, 0, 0, time.UTC)), reminders[0].NextAt)
+
+	// merge doesn't add reminders over the limit
+	backup.Reminders[len(backup.Reminders)-1].UUID = "over limit"
+	data, err = json.Marshal(backup)
+	require.NoError(t, err)
+	_, err = srv.Restore(userID, data, storage.RestoreMerge)
+	require.NoError(t, err)
+	reminders, err = srv.GetReminders(userID)
+	require.NoError(t, err)
+	require.Len(t, reminders, maxReminders)
+
+	history, err := srv.History(userID, 1)
+	require.NoError(t, err)
+	require.Zero(t, history.Goal)
+	require.Equal(t, "UTC", history.Timezone)
+}
+
+func TestService_TextEPUB(t *testing.T) {
+	srv := NewService(testStorage(t), 30, nil, nil)
+	userID := rand.Int63()
+
+	_, _, err := srv.TextEPUB(userID, "")
+	require.ErrorIs(t, err, ErrTextNotSelected)
+
+	bookID, err := srv.AddTextFromFile(userID, []byte("epub-checksum"), "book.fb2", markup.Document{
+		Text: "Preface text.\n# First chapter\nFirst chapter text.\n# Second chapter\nSecond chapter text.",
+	})
+	require.NoError(t, err)
+	name, data, err := srv.TextEPUB(userID, bookID)
+	require.NoError(t, err)
+	require.Equal(t, "book.epub", name)
+	doc, err := epubparser.Parse(data)
+	require.NoError(t, err)
+	require.Equal(t, "Preface text.\n# First chapter\nFirst chapter text.\n# Second chapter\nSecond chapter text.\n", doc.Text)
+
+	// text without chapters is split by chunks
+	textID, err := srv.AddText(userID, "Mr. Smith", "First paragraph is here.\n\nSecond paragraph is here.")
+	require.NoError(t, err)
+	_, err = srv.SelectText(userID, textID)
+	require.NoError(t, err)
+	name, data, err = srv.TextEPUB(userID, "")
+	require.NoError(t, err)
+	require.Equal(t, "Mr. Smith.epub", name)
+	plain, err := epubparser.PlainText(data)
+	require.NoError(t, err)
+	require.Equal(t, "First paragraph is here.\nSecond paragraph is here.\n", plain)
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	var sections int
+	for _, f := range zr.File {
+		if strings.HasPrefix(path.Base(f.Name), "section-") {
+			sections++
+		}
+	}
+	require.Equal(t, 2, sections)
+
+	_, _, err = srv.TextEPUB(userID, "unknown")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestService_Search(t *testing.T) {
+	srv := NewService(testStorage(t), 50, nil, nil)
+	userID := rand.Int63()
+
+	var text strings.Builder
+	for i := 0; i < 30; i++ {
+		fmt.Fprintf(&text, "Sentence number %d is here. ", i)
+	}
+	textID, err := srv.AddText(userID, "text", text.String())
+	require.NoError(t, err)
+	otherID, err := srv.AddText(userID, "other", "The fox's NUMBER 7 jumps.")
+	require.NoError(t, err)
+
+	results, more, err := srv.Search(userID, "number, 7", 1, 10)
+	require.NoError(t, err)
+	require.False(t, more)
+	require.Len(t, results, 2)
+	require.Equal(t, textID, results[0].TextUUID)
+	require.Contains(t, results[0].Snippet, "number 7 is here")
+	require.Equal(t, otherID, results[1].TextUUID)
+	require.Equal(t, "The fox's NUMBER 7 jumps.", results[1].Snippet)
+
+	// found chunk is opened by its index
+	_, err = srv.SelectText(userID, results[0].TextUUID)
+	require.NoError(t, err)
+	require.NoError(t, srv.SetPage(userID, results[0].Chunk))
+	_, chunk, _, err := srv.CurrentOrFirstChunk(userID)
+	require.NoError(t, err)
+	require.Contains(t, chunk, "number 7 is here")
+
+	// pages
+	results, more, err = srv.Search(userID, "sentence", 1, 10)
+	require.NoError(t, err)
+	require.True(t, more)
+	require.Len(t, results, 10)
+	lastPage, more, err := srv.Search(userID, "sentence", 2, 10)
+	require.NoError(t, err)
+	require.False(t, more)
+	require.NotEmpty(t, lastPage)
+	require.Greater(t, lastPage[0].Chunk, results[9].Chunk)
+
+	results, _, err = srv.Search(userID, "fox", 1, 10)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+
+	// index follows rechunking and deletion
+	require.NoError(t, srv.SetChunkSize(userID, 200))
+	_, err = srv.RechunkTexts(userID)
+	require.NoError(t, err)
+	require.NoError(t, srv.DeleteTextByUUID(userID, otherID))
+	results, _, err = srv.Search(userID, "number 7", 1, 10)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.NoError(t, srv.SetPage(userID, results[0].Chunk))
+	_, chunk, _, err = srv.CurrentOrFirstChunk(userID)
+	require.NoError(t, err)
+	require.Contains(t, chunk, "number 7 is here")
+
+	results, _, err = srv.Search(userID, "fox", 1, 10)
+	require.NoError(t, err)
+	require.Empty(t, results)
+
+	_, _, err = srv.Search(userID, " ", 1, 10)
+	require.ErrorIs(t, err, ErrEmptyQuery)
+}
+
+func TestService_TextLibrary(t *testing.T) {
+	srv := NewService(testStorage(t), 50, nil, nil)
+	userID := rand.Int63()
+
+	var text strings.Builder
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&text, "Sentence number %d is here. ", i)
+	}
+	var ids []string
+	for _, name := range []string{"article", "book", "note"} {
+		id, err := srv.AddText(userID, name, text.String())
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	_, err := srv.AddTextTags(userID, "", []string{"#later"})
+	require.ErrorIs(t, err, ErrTextNotSelected)
+	_, err = srv.AddTextTags(userID, ids[0], []string{"#two words"})
+	require.ErrorIs(t, err, ErrInvalidTag)
+	tagged, err := srv.AddTextTags(userID, ids[0], []string{"#Articles", "later", "#articles"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"articles", "later"}, tagged.Tags)
+	_, err = srv.SetTextTags(userID, ids[1], []string{"later"})
+	require.NoError(t, err)
+	_, err = srv.SelectText(userID, ids[0])
+	require.NoError(t, err)
+	tagged, err = srv.RemoveTextTags(userID, "", []string{"#later"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"articles"}, tagged.Tags)
+
+	tags, err := srv.Tags(userID)
+	require.NoError(t, err)
+	require.Equal(t, []TagCount{{Tag: "articles", Texts: 1}, {Tag: "later", Texts: 1}}, tags)
+
+	// reading moves text to reading state, user can pause it
+	require.NoError(t, srv.SetPage(userID, 1))
+	_, err = srv.SetTextState(userID, ids[2], "unknown")
+	require.ErrorIs(t, err, ErrUnknownTextState)
+	_, err = srv.SetTextState(userID, ids[2], storage.StatePaused)
+	require.NoError(t, err)
+	recent, _, err := srv.ListTexts(userID, ListOptions{Sort: SortRecent}, 1, 50)
+	require.NoError(t, err)
+	require.Equal(t, "note", recent[0].Name, "changing the state moves the text up in recent")
+
+	list := func(filter string) []string {
+		t.Helper()
+		textFilter, err := ParseTextFilter(filter)
+		require.NoError(t, err)
+		texts, _, err := srv.ListTexts(userID, ListOptions{Filter: textFilter}, 1, 50)
+		require.NoError(t, err)
+		var names []string
+		for _, text := range texts {
+			names = append(names, text.Name)
+		}
+		return names
+	}
+	require.Equal(t, []string{"article", "book", "note"}, list(""))
+	require.Equal(t, []string{"article"}, list("#articles"))
+	require.Equal(t, []string{"article"}, list("reading"))
+	require.Equal(t, []string{"book", "note"}, list("queued paused"))
+	require.Empty(t, list("#articles paused"))
+	_, err = ParseTextFilter("#articles someday")
+	require.ErrorIs(t, err, ErrUnknownFilter)
+
+	random, err := srv.RandomText(userID, 0, TextFilter{Tags: []string{"later"}})
+	require.NoError(t, err)
+	require.Equal(t, ids[1], random.UUID)
+	quickWin, err := srv.QuickWin(userID, TextFilter{States: []storage.TextState{storage.StatePaused}})
+	require.NoError(t, err)
+	require.Equal(t, ids[2], quickWin.UUID)
+
+	// abandoned and finished texts aren't picked unless asked for
+	_, err = srv.SetTextState(userID, ids[1], storage.StateAbandoned)
+	require.NoError(t, err)
+	_, err = srv.RandomText(userID, 0, TextFilter{Tags: []string{"later"}})
+	require.Error(t, err)
+	current, err := srv.GetCurrentText(userID)
+	require.NoError(t, err)
+	require.NoError(t, srv.SetPage(userID, current.TotalChunks-1))
+	require.Equal(t, []string{"article"}, list("finished"))
+	_, err = srv.QuickWin(userID, TextFilter{Tags: []string{"articles"}})
+	require.Error(t, err)
+	random, err = srv.RandomText(userID, 0, TextFilter{})
+	require.NoError(t, err)
+	require.Equal(t, ids[2], random.UUID)
+}
+
+func TestService_ListOptions(t *testing.T) {
+	srv := NewService(testStorage(t), 50, nil, nil)
+	userID := rand.Int63()
+
+	var long strings.Builder
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&long, "Sentence number %d is here. ", i)
+	}
+	zebraID, err := srv.AddText(userID, "Zebra", long.String())
+	require.NoError(t, err)
+	_, err = srv.AddTextFromFile(userID, []byte("checksum"), "apple", markup.Document{Text: "Short one."})
+	require.NoError(t, err)
+	_, err = srv.AddText(userID, "Mango", "Medium text with a few more words in it.")
+	require.NoError(t, err)
+
+	_, err = srv.SelectText(userID, zebraID)
+	require.NoError(t, err)
+	require.NoError(t, srv.SetPage(userID, 1))
+
+	list := func(options ListOptions) []string {
+		t.Helper()
+		texts, _, err := srv.ListTexts(userID, options, 1, 50)
+		require.NoError(t, err)
+		var names []string
+		for _, text := range texts {
+			names = append(names, text.Name)
+		}
+		return names
+	}
+	filter := func(words string) TextFilter {
+		t.Helper()
+		textFilter, err := ParseTextFilter(words)
+		require.NoError(t, err)
+		return textFilter
+	}
+	require.Equal(t, []string{"Zebra", "apple", "Mango"}, list(ListOptions{}))
+	require.Equal(t, []string{"apple", "Mango", "Zebra"}, list(ListOptions{Sort: SortName}))
+	require.Equal(t, []string{"Zebra", "Mango", "apple"}, list(ListOptions{Sort: SortLongest}))
+	require.Equal(t, []string{"Mango", "apple", "Zebra"}, list(ListOptions{Sort: SortNewest}))
+	require.Equal(t, []string{"Zebra", "Mango", "apple"}, list(ListOptions{Sort: SortRecent}))
+	require.Equal(t, []string{"Zebra", "apple", "Mango"}, list(ListOptions{Sort: SortClosest}))
+	require.Equal(t, []string{"apple", "Mango"}, list(ListOptions{Filter: filter("unopened")}))
+	require.Equal(t, []string{"Zebra"}, list(ListOptions{Filter: filter("started")}))
+	require.Equal(t, []string{"Zebra", "apple", "Mango"}, list(ListOptions{Filter: filter("unfinished")}))
+	require.Equal(t, []string{"apple"}, list(ListOptions{Filter: filter("file")}))
+	require.Equal(t, []string{"Zebra", "Mango"}, list(ListOptions{Filter: filter("text")}))
+	require.Empty(t, list(ListOptions{Filter: filter("url")}))
+	_, err = ParseTextSort("oldest")
+	require.ErrorIs(t, err, ErrUnknownTextSort)
+
+	// options are saved, sort and filter can be changed separately
+	options, err := srv.ListOptions(userID)
+	require.NoError(t, err)
+	require.Equal(t, ListOptions{Sort: SortAdded}, options)
+	_, err = srv.UpdateListOptions(userID, "longest someday")
+	require.ErrorIs(t, err, ErrUnknownFilter)
+	_, err = srv.UpdateListOptions(userID, "longest unopened")
+	require.NoError(t, err)
+	options, err = srv.UpdateListOptions(userID, "name")
+	require.NoError(t, err)
+	require.Equal(t, ListOptions{Sort: SortName, Filter: filter("unopened")}, options)
+	options, err = srv.ListOptions(userID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"apple", "Mango"}, list(options))
+	options, err = srv.UpdateListOptions(userID, "all")
+	require.NoError(t, err)
+	require.Equal(t, ListOptions{Sort: SortName}, options)
+}
+
+func TestService_AddTextFromFileWithAttachments(t *testing.T) {
+	srv := NewService(testStorage(t), 100, nil, nil)
+	userID := rand.Int63()
+
+	textID, err := srv.AddTextFromFile(userID, []byte("checksum"), "book.epub", markup.Document{
+		Text:        "Cover: ![cover](attachment:cover.png)",
+		Attachments: map[string][]byte{"cover.png": []byte("image")},
+	})
+	require.NoError(t, err)
+
+	content, err := srv.GetAttachment(userID, textID, "cover.png")
+	require.NoError(t, err)
+	require.Equal(t, []byte("image"), content)
+
+	_, err = srv.GetAttachment(userID, textID, "missing.png")
+	require.Equal(t, storage.ErrNotFound, err)
+	_, err = srv.GetAttachment(rand.Int63(), textID, "cover.png")
+	require.Equal(t, storage.ErrNotFound, err)
+}
+
+func TestService_RechunkTexts(t *testing.T) {
+	srv := NewService(testStorage(t), 200, nil, nil)
+	userID := rand.Int63()
+
+	var text strings.Builder
+	for i := 0; i < 30; i++ {
+		fmt.Fprintf(&text, "Sentence number %d is here. ", i)
+	}
+	textID, err := srv.AddText(userID, "text", text.String())
+	require.NoError(t, err)
+	_, err = srv.SelectText(userID, textID)
+	require.NoError(t, err)
+	require.NoError(t, srv.SetPage(userID, 3))
+	_, oldChunk, _, err := srv.CurrentOrFirstChunk(userID)
+	require.NoError(t, err)
+	oldText, err := srv.GetCurrentText(userID)
+	require.NoError(t, err)
+
+	require.NoError(t, srv.SetChunkSize(userID, 50))
+	rechunked, err := srv.RechunkTexts(userID)
+	require.NoError(t, err)
+	require.Equal(t, 1, rechunked)
+
+	newText, err := srv.GetCurrentText(userID)
+	require.NoError(t, err)
+	require.Greater(t, newText.TotalChunks, oldText.TotalChunks)
+	_, newChunk, _, err := srv.CurrentOrFirstChunk(userID)
+	require.NoError(t, err)
+	// reading continues from the same sentence
+	firstSentence, _, _ := strings.Cut(oldChunk, ".")
+	require.True(t, strings.HasPrefix(newChunk, firstSentence), "old chunk %q, new chunk %q", oldChunk, newChunk)
+
+	// chunk size didn't change
+	rechunked, err = srv.RechunkTexts(userID)
+	require.NoError(t, err)
+	require.Zero(t, rechunked)
+}
+
+func TestService_RechunkTextFromFile(t *testing.T) {
+	store := testStorage(t)
+	srv := NewService(store, 200, nil, nil)
+	user1, user2, user3 := rand.Int63(), rand.Int63(), rand.Int63()
+	checksum := []byte("checksum")
+
+	var text strings.Builder
+	for i := 0; i < 30; i++ {
+		fmt.Fprintf(&text, "Sentence number %d is here. ", i)
+	}
+	text.WriteString("![cover](attachment:cover.png)")
+	doc := markup.Document{
+		Text:        text.String(),
+		Attachments: map[string][]byte{"cover.png": []byte("image")},
+	}
+	text1ID, err := srv.AddTextFromFile(user1, checksum, "book", doc)
+	require.NoError(t, err)
+	text2ID, err := srv.AddTextFromFile(user2, checksum, "book", doc)
+	require.NoError(t, err)
+	_, err = srv.SelectText(user2, text2ID)
+	require.NoError(t, err)
+	before, err := srv.GetCurrentText(user2)
+	require.NoError(t, err)
+
+	require.NoError(t, srv.SetChunkSize(user1, 50))
+	require.NoError(t, srv.RechunkText(user1, text1ID))
+	_, err = srv.SelectText(user1, text1ID)
+	require.NoError(t, err)
+	rechunked, err := srv.GetCurrentText(user1)
+	require.NoError(t, err)
+	require.Greater(t, rechunked.TotalChunks, before.TotalChunks)
+	content, err := srv.GetAttachment(user1, text1ID, "cover.png")
+	require.NoError(t, err)
+	require.Equal(t, []byte("image"), content)
+
+	// other user still reads the shared file split with the old chunk size
+	after, err := srv.GetCurrentText(user2)
+	require.NoError(t, err)
+	require.Equal(t, before.TotalChunks, after.TotalChunks)
+
+	// file split with the same chunk size is reused
+	pf, err := store.GetProcessedFile(checksum, 50)
+	require.NoError(t, err)
+	require.NoError(t, srv.SetChunkSize(user3, 50))
+	text3ID, err := srv.AddTextFromFile(user3, checksum, "book", doc)
+	require.NoError(t, err)
+	require.Equal(t, pf.UUID, text3ID)
+}
+
+func TestService_EncryptedTextFromFile(t *testing.T) {
+	store := testStorage(t)
+	store.SetKeyWrapper(encryptor.NewEncryptor("secret"))
+	srv := NewService(store, 100, nil, nil)
+	userID := rand.Int63()
+	checksum := []byte("checksum")
+	require.NoError(t, srv.EncryptTexts(userID))
+
+	textID, err := srv.AddTextFromFile(userID, checksum, "book.epub", markup.Document{
+		Text:        "Cover: ![cover](attachment:cover.png)",
+		Attachments: map[string][]byte{"cover.png": []byte("image")},
+	})
+	require.NoError(t, err)
+	content, err := srv.GetAttachment(userID, textID, "cover.png")
+	require.NoError(t, err)
+	require.Equal(t, []byte("image"), content)
+
+	// file is not shared with other users
+	_, err = store.GetProcessedFileByChecksum(checksum)
+	require.Equal(t, storage.ErrNotFound, err)
+
+	require.NoError(t, srv.DecryptTexts(userID))
+	encrypted, err := srv.TextsEncrypted(userID)
+	require.NoError(t, err)
+	require.False(t, encrypted)
+	content, err = srv.GetAttachment(userID, textID, "cover.png")
+	require.NoError(t, err)
+	require.Equal(t, []byte("image"), content)
+}
+
+func TestService_SendDueReminders(t *testing.T) {
+	srv := NewService(testStorage(t), 5, nil, nil)
+	userID := int64(1)
+	now := time.Date(2024, 3, 29, 8, 0, 0, 0, time.UTC)
+	srv.now = func() time.Time { return now }
+	daily, err := srv.AddReminder(userID, "08:30", "en")
+	require.NoError(t, err)
+	require.True(t, daily.NextAt.Equal(now.Add(30*time.Minute)))
+	_, err = srv.AddReminder(userID, "weekdays 09:00", "ru")
+	require.NoError(t, err)
+	_, err = srv.AddReminder(userID, "08:30 Mars/Olympus", "en")
+	require.ErrorIs(t, err, ErrInvalidReminder)
+
+	var sent []string
+	send := func(id int64, reminder storage.Reminder) {
+		require.Equal(t, userID, id)
+		sent = append(sent, FormatReminder(reminder))
+	}
+	report, err := srv.SendDueReminders(send)
+	require.NoError(t, err)
+	require.Equal(t, ReminderReport{}, report)
+
+	now = now.Add(45 * time.Minute)
+	report, err = srv.SendDueReminders(send)
+	require.NoError(t, err)
+	require.Equal(t, ReminderReport{Sent: 1}, report)
+	require.Equal(t, []string{"daily 08:30 UTC"}, sent)
+	// already sent reminder is scheduled to the next day
+	report, err = srv.SendDueReminders(send)
+	require.NoError(t, err)
+	require.Equal(t, ReminderReport{}, report)
+
+	// reminders missed while the bot was down are skipped
+	now = now.Add(3 * 24 * time.Hour)
+	report, err = srv.SendDueReminders(send)
+	require.NoError(t, err)
+	require.Equal(t, ReminderReport{Skipped: 2}, report)
+	reminders, err := srv.GetReminders(userID)
+	require.NoError(t, err)
+	require.True(t, reminders[0].NextAt.Equal(time.Date(2024, 4, 2, 8, 30, 0, 0, time.UTC)))
+	require.True(t, reminders[1].NextAt.Equal(time.Date(2024, 4, 1, 9, 0, 0, 0, time.UTC)))
+
+	deleted, err := srv.DeleteReminders(userID)
+	require.NoError(t, err)
+	require.Equal(t, 2, deleted)
+	now = now.Add(24 * time.Hour)
+	report, err = srv.SendDueReminders(send)
+	require.NoError(t, err)
+	require.Equal(t, ReminderReport{}, report)
+}
+
+func TestService_ReminderChunk(t *testing.T) {
+	srv := NewService(testStorage(t), 5, nil, nil)
+	userID := int64(1)
+	_, err := srv.SelectReminderText(userID, storage.ReminderCurrent)
+	require.ErrorIs(t, err, ErrNoUnreadTexts)
+
+	textID, err := srv.AddText(userID, "text", "First. Second. Third.")
+	require.NoError(t, err)
+	text, err := srv.SelectReminderText(userID, storage.ReminderCurrent)
+	require.NoError(t, err)
+	require.Equal(t, textID, text.UUID)
+	_, chunk, _, err := srv.UnreadChunk(userID)
+	require.NoError(t, err)
+	require.Equal(t, "First.", chunk)
+	_, chunk, _, err = srv.UnreadChunk(userID)
+	require.NoError(t, err)
+	require.Equal(t, "Second.", chunk)
+
+	_, _, _, err = srv.NextChunk(userID)
+	require.NoError(t, err)
+	_, _, _, err = srv.UnreadChunk(userID)
+	require.ErrorIs(t, err, ErrTextFinished)
+	// finished text is replaced with an unread one
+	otherID, err := srv.AddText(userID, "other", "Other.")
+	require.NoError(t, err)
+	text, err = srv.SelectReminderText(userID, storage.ReminderCurrent)
+	require.NoError(t, err)
+	require.Equal(t, otherID, text.UUID)
+	current, err := srv.GetCurrentText(userID)
+	require.NoError(t, err)
+	require.Equal(t, otherID, current.UUID)
+}
+
+func TestService_ReadingHistory(t *testing.T) {
+	srv := NewService(testStorage(t), 5, nil, nil)
+	userID := int64(1)
+	now := time.Date(2024, 3, 29, 9, 0, 0, 0, time.UTC)
+	srv.now = func() time.Time { return now }
+	textID, err := srv.AddText(userID, "text", "First. Second. Third. Fourth. Fifth. Sixth.")
+	require.NoError(t, err)
+	_, err = srv.SelectText(userID, textID)
+	require.NoError(t, err)
+	require.ErrorIs(t, srv.SetGoal(userID, Goal{Chunks: 2, Minutes: 5}, ""), ErrInvalidGoal)
+	require.NoError(t, srv.SetGoal(userID, Goal{Chunks: 2}, ""))
+
+	// reading for 3 days in a row
+	for day, milestone := range []int{0, 0, 3} {
+		now = time.Date(2024, 3, 27+day, 9, 0, 0, 0, time.UTC)
+		_, _, _, err = srv.NextChunk(userID)
+		require.NoError(t, err)
+		got, err := srv.StreakMilestone(userID)
+		require.NoError(t, err)
+		require.Zero(t, got, "goal isn't reached by the first chunk")
+
+		now = now.Add(time.Minute)
+		_, _, _, err = srv.NextChunk(userID)
+		require.NoError(t, err)
+		got, err = srv.StreakMilestone(userID)
+		require.NoError(t, err)
+		require.Equal(t, milestone, got)
+	}
+	// going back doesn't count as reading
+	_, _, _, err = srv.PrevChunk(userID)
+	require.NoError(t, err)
+
+	history, err := srv.History(userID, 3)
+	require.NoError(t, err)
+	require.Equal(t, Goal{Chunks: 2}, history.Goal)
+	require.Equal(t, 3, history.CurrentStreak)
+	require.Equal(t, 3, history.LongestStreak)
+	for _, day := range history.Days {
+		require.EqualValues(t, 2, day.Chunks)
+		require.Positive(t, day.Chars)
+	}
+	events, err := srv.s.GetReadingEvents(userID, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, events, 6)
+	require.Equal(t, textID, events[5].TextUUID)
+	require.EqualValues(t, 5, events[5].Chunk)
+	require.EqualValues(t, len("Sixth."), events[5].Chars)
+	_, err = srv.History(userID, MaxHistoryDays+1)
+	require.Error(t, err)
+
+	// streaks are counted only in the last year
+	for day := 0; day < 5; day++ {
+		for i := 0; i < 2; i++ {
+			at := time.Date(2022, 3, 1+day, 9, i, 0, 0, time.UTC)
+			require.NoError(t, srv.s.AddReadingEvent(userID, storage.ReadingEvent{At: at, TextUUID: textID}))
+		}
+	}
+	history, err = srv.History(userID, 3)
+	require.NoError(t, err)
+	require.Equal(t, 3, history.LongestStreak)
+}
+
 func TestDustOnNextChunk(t *testing.T) {
 	t.Run("dust is added", func(t *testing.T) {
 		store := testStorage(t)
@@ -306,6 +1244,160 @@ func TestDustOnNextChunk(t *testing.T) {
 	})
 }
 
+func TestService_Craft(t *testing.T) {
+	srv := NewService(testStorage(t), 5, nil, nil)
+	srv.chancer = &mockChancer{winResult: true} // red dust and melissa are found in every chunk
+	userID := int64(1)
+	now := time.Date(2024, 3, 29, 9, 0, 0, 0, time.UTC)
+	srv.now = func() time.Time { return now }
+
+	_, err := srv.Craft(userID, "focus", nil)
+	require.ErrorIs(t, err, ErrUnknownRecipe)
+	require.NoError(t, srv.SeedRecipes())
+	require.NoError(t, srv.SeedRecipes())
+	recipes, err := srv.Recipes(userID)
+	require.NoError(t, err)
+	require.Len(t, recipes, len(DefaultRecipes))
+	require.Equal(t, "focus", recipes[0].Name)
+	require.Nil(t, recipes[0].Ideal)
+
+	_, err = srv.Craft(userID, "focus", nil)
+	require.ErrorIs(t, err, ErrNotEnoughLoot)
+	for i := 0; i < 5; i++ {
+		_, err = srv.LootOnNextChunk(userID)
+		require.NoError(t, err)
+	}
+	_, err = srv.s.UpdateDust(userID, func(d *storage.Dust) { d.YellowCount = 2 })
+	require.NoError(t, err)
+
+	_, err = srv.Craft(userID, "focus", map[string]int64{"red": 7})
+	require.ErrorIs(t, err, ErrInvalidMix)
+	_, err = srv.Craft(userID, "focus", map[string]int64{"melissa": 2})
+	require.ErrorIs(t, err, ErrInvalidMix)
+	crafted, err := srv.Craft(userID, "Focus", map[string]int64{"red": 3})
+	require.NoError(t, err)
+	require.Equal(t, Mix{Dust: Dust{RedCount: 3, YellowCount: 1}}, crafted.Mix)
+	require.Equal(t, storage.BuffExp, crafted.Buff.Kind)
+	require.Equal(t, now.Add(34*time.Minute), crafted.Buff.Until) // 1 of 8 extra ingredients
+
+	// the ideal mix is used by default
+	recipes, err = srv.Recipes(userID)
+	require.NoError(t, err)
+	require.Equal(t, &crafted.Mix, recipes[0].Ideal)
+	_, err = srv.Craft(userID, "focus", nil)
+	require.ErrorIs(t, err, ErrNotEnoughLoot, "not enough red dust for the ideal mix")
+	crafted, err = srv.Craft(userID, "focus", map[string]int64{"red": 2, "melissa": 1})
+	require.NoError(t, err)
+	require.Equal(t, now.Add(68*time.Minute), crafted.Buff.Until, "crafting again prolongs the buff")
+	dust, herb, err := srv.GetLoot(userID)
+	require.NoError(t, err)
+	require.Zero(t, dust.TotalDust())
+	require.EqualValues(t, 4, herb.MelissaCount)
+
+	// the exp buff doesn't change loot
+	loot, err := srv.LootOnNextChunk(userID)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, loot.DeltaDust.RedCount)
+	_, deltaExp, _, err := srv.ExpOnNextChunk(userID)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, deltaExp, "exp for the chunk is multiplied by 1.5 and rounded")
+	buffs, err := srv.ActiveBuffs(userID)
+	require.NoError(t, err)
+	require.Len(t, buffs, 1)
+
+	now = now.Add(68 * time.Minute)
+	buffs, err = srv.ActiveBuffs(userID)
+	require.NoError(t, err)
+	require.Empty(t, buffs)
+	_, deltaExp, _, err = srv.ExpOnNextChunk(userID)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, deltaExp)
+
+	// concurrent crafts can't spend the same loot
+	_, err = srv.s.UpdateDust(userID, func(d *storage.Dust) { *d = storage.Dust{RedCount: 2, YellowCount: 1} })
+	require.NoError(t, err)
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = srv.Craft(userID, "focus", nil)
+		}(i)
+	}
+	wg.Wait()
+	crafts := 0
+	for _, err := range errs {
+		if err == nil {
+			crafts++
+			continue
+		}
+		require.ErrorIs(t, err, ErrNotEnoughLoot)
+	}
+	require.Equal(t, 1, crafts)
+	dust, herb, err = srv.GetLoot(userID)
+	require.NoError(t, err)
+	require.Zero(t, dust.TotalDust())
+	require.EqualValues(t, 4, herb.MelissaCount)
+}
+
+func TestService_SpendFreePoint(t *testing.T) {
+	srv := NewService(testStorage(t), 5, nil, nil)
+	userID := int64(1)
+	_, err := srv.SpendFreePoint(userID, "luck")
+	require.ErrorIs(t, err, ErrNoFreePoints)
+
+	// one chunk before level 21, which gives the first free point
+	base := srv.leveling.StatsForLevel(20)
+	_, err = srv.s.UpdateLevel(userID, func(l *storage.Level) { l.Experience = srv.leveling.ExperienceForLevel(21) - 1 })
+	require.NoError(t, err)
+	_, err = srv.s.UpdateStat(userID, func(stat *storage.Stat) { *stat = storage.Stat(base) })
+	require.NoError(t, err)
+	level, _, levelUp, err := srv.ExpOnNextChunk(userID)
+	require.NoError(t, err)
+	require.True(t, levelUp)
+	require.EqualValues(t, 21, level.Level)
+	require.Equal(t, srv.leveling.ExperienceForLevel(22), level.NextLevelExperience)
+
+	_, err = srv.SpendFreePoint(userID, "charizma")
+	require.ErrorIs(t, err, ErrUnknownStat)
+	stat, err := srv.SpendFreePoint(userID, "luck")
+	require.NoError(t, err)
+	base.Luck++
+	require.Equal(t, base, *stat)
+	_, err = srv.SpendFreePoint(userID, "luck")
+	require.ErrorIs(t, err, ErrNoFreePoints)
+
+	// spent points are kept on the next level
+	_, err = srv.s.UpdateLevel(userID, func(l *storage.Level) { l.Experience = srv.leveling.ExperienceForLevel(22) - 1 })
+	require.NoError(t, err)
+	_, _, levelUp, err = srv.ExpOnNextChunk(userID)
+	require.NoError(t, err)
+	require.True(t, levelUp)
+	stat, _, err = srv.GetStatsAndLevel(userID)
+	require.NoError(t, err)
+	base.Free++
+	require.Equal(t, base, *stat)
+
+	// concurrent chunks give the points of the level once
+	_, err = srv.s.UpdateLevel(userID, func(l *storage.Level) { l.Experience = srv.leveling.ExperienceForLevel(23) - 1 })
+	require.NoError(t, err)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, _, err := srv.ExpOnNextChunk(userID)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	stat, _, err = srv.GetStatsAndLevel(userID)
+	require.NoError(t, err)
+	base.Free++
+	require.Equal(t, base, *stat)
+}
+
 func testStorage(t *testing.T) *storage.Storage {
 	t.Helper()
 	dbPath := filepath.Join(os.TempDir(), fmt.Sprintf("adhd-reader-test-%d.db", rand.Int63()))
@@ -3,6 +3,7 @@ package service
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/pechorka/adhd-reader/internal/storage"
 	"github.com/stretchr/testify/require"
@@ -64,10 +65,17 @@ func TestGetLevelByExperience(t *testing.T) {
 		{name: "5629", args: args{experience: 5629}, want: 19},
 		{name: "5630", args: args{experience: 5630}, want: 20},
 		{name: "5631", args: args{experience: 5631}, want: 20},
+		{name: "9999", args: args{experience: 9999}, want: 25},
+		{name: "15000", args: args{experience: 15000}, want: 29},
+		{name: "35000", args: args{experience: 35000}, want: 37},
+		{name: "13337187", args: args{experience: 13337187}, want: 99},
+		{name: "13337188", args: args{experience: 13337188}, want: 100},
+		// levels after 100 need max_level_exp
+		{name: "14549712", args: args{experience: 14549712}, want: 101},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := DetectLevelByExperience(tt.args.experience); got != tt.want {
+			if got := DefaultLeveling().LevelByExperience(tt.args.experience); got != tt.want {
 				t.Errorf("GetLevelByExperience() = %v, want %v", got, tt.want)
 			}
 		})
@@ -102,7 +110,7 @@ func Test_calculateExperienceGainByChunkSize(t *testing.T) {
 	}
 }
 
-func TestLevelUpStatDistribution(t *testing.T) {
+func TestStatsForLevel(t *testing.T) {
 	type args struct {
 		level int64
 	}
@@ -132,12 +140,284 @@ func TestLevelUpStatDistribution(t *testing.T) {
 		{name: "18", args: args{level: 18}, want: Stat{Accuracy: 5, Attention: 5, TimeManagement: 4, Charizma: 0, Luck: 4}},
 		{name: "19", args: args{level: 19}, want: Stat{Accuracy: 5, Attention: 5, TimeManagement: 5, Charizma: 0, Luck: 4}},
 		{name: "20", args: args{level: 20}, want: Stat{Accuracy: 5, Attention: 5, TimeManagement: 5, Charizma: 0, Luck: 5}},
+		{name: "21", args: args{level: 21}, want: Stat{Free: 1, Accuracy: 5, Attention: 5, TimeManagement: 5, Charizma: 0, Luck: 5}},
+		{name: "30", args: args{level: 30}, want: Stat{Free: 10, Accuracy: 5, Attention: 5, TimeManagement: 5, Charizma: 0, Luck: 5}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := LevelUpStatDistribution(tt.args.level); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("LevelUpStatDistribution() = %v, want %v", got, tt.want)
+			if got := DefaultLeveling().StatsForLevel(tt.args.level); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("StatsForLevel() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestParseReminder(t *testing.T) {
+	tests := []struct {
+		schedule string
+		want     storage.Reminder
+		wantErr  bool
+	}{
+		{schedule: "8:30", want: storage.Reminder{Hour: 8, Minute: 30, Timezone: "UTC", Mode: storage.ReminderCurrent}},
+		{
+			schedule: "weekdays 08:30 Europe/Moscow random",
+			want: storage.Reminder{
+				Weekdays: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+				Hour:     8, Minute: 30, Timezone: "Europe/Moscow", Mode: storage.ReminderRandom,
+			},
+		},
+		{
+			schedule: "21:05 fri,Mon,wednesday +03:00",
+			want: storage.Reminder{
+				Weekdays: []time.Weekday{time.Monday, time.Wednesday, time.Friday},
+				Hour:     21, Minute: 5, Timezone: "+03:00", Mode: storage.ReminderCurrent,
+			},
+		},
+		{schedule: "sun,mon,tue,wed,thu,fri,sat 7:00 UTC-5", want: storage.Reminder{Hour: 7, Timezone: "UTC-5", Mode: storage.ReminderCurrent}},
+		{schedule: "weekdays", wantErr: true},
+		{schedule: "25:00", wantErr: true},
+		{schedule: "08:30 Mars/Olympus", wantErr: true},
+		{schedule: "08:30 +15", wantErr: true},
+		{schedule: "08:30 09:30", wantErr: true},
+		{schedule: "08:30 Europe/Moscow Europe/Berlin", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.schedule, func(t *testing.T) {
+			got, err := ParseReminder(tt.schedule)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrInvalidReminder)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+			parsed, err := ParseReminder(FormatReminder(got))
+			require.NoError(t, err)
+			require.Equal(t, got, parsed, "formatted reminder must be parsed back")
+		})
+	}
+}
+
+func TestNextReminderAt(t *testing.T) {
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	// Friday
+	now := time.Date(2024, 3, 29, 9, 0, 0, 0, moscow)
+	tests := []struct {
+		name     string
+		schedule string
+		now      time.Time
+		want     time.Time
+	}{
+		{name: "later today", schedule: "daily 10:00 Europe/Moscow", now: now, want: time.Date(2024, 3, 29, 10, 0, 0, 0, moscow)},
+		{name: "tomorrow", schedule: "daily 08:30 Europe/Moscow", now: now, want: time.Date(2024, 3, 30, 8, 30, 0, 0, moscow)},
+		{name: "exactly now", schedule: "daily 09:00 Europe/Moscow", now: now, want: time.Date(2024, 3, 30, 9, 0, 0, 0, moscow)},
+		{name: "after weekend", schedule: "weekdays 08:30 Europe/Moscow", now: now, want: time.Date(2024, 4, 1, 8, 30, 0, 0, moscow)},
+		{name: "other timezone", schedule: "daily 08:00 +00:00", now: now, want: time.Date(2024, 3, 29, 8, 0, 0, 0, time.UTC)},
+		// clocks are moved from 02:00 to 03:00 in Berlin on 31 March
+		{name: "daylight saving", schedule: "sun 02:30 Europe/Berlin", now: now, want: time.Date(2024, 3, 31, 3, 30, 0, 0, berlin)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reminder, err := ParseReminder(tt.schedule)
+			require.NoError(t, err)
+			got, err := NextReminderAt(reminder, tt.now)
+			require.NoError(t, err)
+			require.True(t, tt.want.Equal(got), "want %s, got %s", tt.want, got)
+		})
+	}
+}
+
+func TestParseGoal(t *testing.T) {
+	tests := []struct {
+		input    string
+		goal     Goal
+		timezone string
+		wantErr  bool
+	}{
+		{input: "10", goal: Goal{Chunks: 10}},
+		{input: "15m", goal: Goal{Minutes: 15}},
+		{input: "15M Europe/Moscow", goal: Goal{Minutes: 15}, timezone: "Europe/Moscow"},
+		{input: "off +03:00", timezone: "+03:00"},
+		{input: "", wantErr: true},
+		{input: "0", wantErr: true},
+		{input: "m", wantErr: true},
+		{input: "5h", wantErr: true},
+		{input: "1001", wantErr: true},
+		{input: "10 Mars/Olympus", wantErr: true},
+		{input: "10 UTC extra", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			goal, timezone, err := ParseGoal(tt.input)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrInvalidGoal)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.goal, goal)
+			require.Equal(t, tt.timezone, timezone)
+			if timezone == "" {
+				parsed, _, err := ParseGoal(FormatGoal(goal))
+				require.NoError(t, err)
+				require.Equal(t, goal, parsed)
+			}
+		})
+	}
+}
+
+func TestBuildHistory(t *testing.T) {
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+	now := time.Date(2024, 3, 29, 9, 0, 0, 0, moscow)
+	read := func(daysAgo, hour int, seconds int64) storage.ReadingEvent {
+		return storage.ReadingEvent{At: time.Date(2024, 3, 29-daysAgo, hour, 0, 0, 0, moscow), Chars: 100, Seconds: seconds}
+	}
+	events := []storage.ReadingEvent{
+		read(9, 12, 60), read(8, 12, 60), read(7, 12, 60), // longest streak
+		read(5, 12, 30),
+		// 01:00 in Moscow is the previous day in UTC
+		read(2, 1, 60), read(1, 12, 30), read(1, 13, 30),
+	}
+
+	history := buildHistory(storage.Reading{Timezone: "Europe/Moscow"}, events, now, 7)
+	require.Equal(t, "Europe/Moscow", history.Timezone)
+	require.Len(t, history.Days, 7)
+	require.True(t, time.Date(2024, 3, 23, 0, 0, 0, 0, moscow).Equal(history.Days[0].Date))
+	require.True(t, time.Date(2024, 3, 29, 0, 0, 0, 0, moscow).Equal(history.Today().Date))
+	require.Equal(t, DayStats{Date: history.Days[5].Date, Chunks: 2, Chars: 200, Seconds: 60}, history.Days[5])
+	require.Zero(t, history.Today().Chunks)
+	// today's goal isn't reached yet, but the streak isn't broken
+	require.Equal(t, 2, history.CurrentStreak)
+	require.Equal(t, 3, history.LongestStreak)
+
+	history = buildHistory(storage.Reading{Timezone: "Europe/Moscow", GoalMinutes: 1}, events, now, 7)
+	require.Equal(t, 2, history.CurrentStreak)
+	require.Equal(t, 3, history.LongestStreak)
+	history = buildHistory(storage.Reading{Timezone: "Europe/Moscow", GoalChunks: 2}, events, now, 7)
+	require.Equal(t, 1, history.CurrentStreak)
+	require.Equal(t, 1, history.LongestStreak)
+
+	history = buildHistory(storage.Reading{}, events, now, 1)
+	require.Equal(t, "UTC", history.Timezone)
+	require.Equal(t, 1, history.CurrentStreak, "days are counted in UTC")
+}
+
+func TestBuildReadingStats(t *testing.T) {
+	now := time.Date(2024, 3, 29, 9, 0, 0, 0, time.UTC)
+	read := func(daysAgo, hour int) storage.ReadingEvent {
+		return storage.ReadingEvent{At: time.Date(2024, 3, 29-daysAgo, hour, 0, 0, 0, time.UTC), Chars: 100, Words: 20, Seconds: 60}
+	}
+	events := []storage.ReadingEvent{read(40, 21), read(10, 21), read(6, 8), read(0, 8), read(0, 21), read(0, 21)}
+	texts := []storage.TextWithChunkInfo{
+		{UUID: "unopened", CurrentChunk: storage.NotSelected, TotalChunks: 10, Length: 1000},
+		{UUID: "finished", CurrentChunk: 9, TotalChunks: 10, Length: 1000},
+		{UUID: "marked", CurrentChunk: 1, TotalChunks: 10, Length: 1000, State: storage.StateFinished},
+		{UUID: "abandoned", CurrentChunk: 1, TotalChunks: 10, Length: 1000, State: storage.StateAbandoned},
+		{UUID: "long", CurrentChunk: 0, TotalChunks: 10, Length: 2000},
+		{UUID: "short", CurrentChunk: 5, TotalChunks: 10, Length: 2000, Language: "en"},
+		{UUID: "current", CurrentChunk: 0, TotalChunks: 10, Length: 5000},
+	}
+	reading := storage.Reading{Speeds: map[string]storage.Speed{"": {Explicit: 1000}}}
+
+	stats := buildReadingStats(reading, events, texts, "current", now)
+	require.Equal(t, "UTC", stats.Timezone)
+	require.Equal(t, PeriodStats{Days: 1, Chunks: 3, Words: 60, Chars: 300, Seconds: 180}, stats.Today)
+	require.EqualValues(t, 4, stats.Week.Chunks)
+	require.EqualValues(t, 5, stats.Month.Chunks)
+	require.EqualValues(t, 6, stats.Total.Chunks)
+	require.EqualValues(t, 240, stats.Hours[21])
+	require.EqualValues(t, 120, stats.Hours[8])
+	require.Equal(t, 21, stats.PeakHour())
+	require.Equal(t, 2, stats.FinishedTexts)
+
+	var uuids []string
+	for _, text := range stats.Texts {
+		uuids = append(uuids, text.UUID)
+	}
+	require.Equal(t, []string{"current", "short", "long"}, uuids)
+	require.Equal(t, TextEstimate{UUID: "short", Percent: 55, RemainingChars: 1000, RemainingSeconds: 60}, stats.Texts[1])
+	require.True(t, stats.Texts[0].Current)
+	require.Equal(t, -1, ReadingStats{}.PeakHour())
+}
+
+func TestParseMix(t *testing.T) {
+	tests := []struct {
+		input   string
+		amounts map[string]int64
+		wantErr bool
+	}{
+		{input: "", amounts: map[string]int64{}},
+		{input: "red=3 Melissa=0", amounts: map[string]int64{"red": 3, "melissa": 0}},
+		{input: "red=3 red=4", amounts: map[string]int64{"red": 4}},
+		{input: "red", wantErr: true},
+		{input: "pink=1", wantErr: true},
+		{input: "red=-1", wantErr: true},
+		{input: "red=many", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			amounts, err := ParseMix(tt.input)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrInvalidMix)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.amounts, amounts)
+		})
+	}
+}
+
+func TestBuffDuration(t *testing.T) {
+	recipe := CraftRecipe{
+		Min:      Mix{Dust: Dust{RedCount: 2}},
+		Max:      Mix{Dust: Dust{RedCount: 6}, Herb: Herb{MelissaCount: 2}},
+		Duration: 30 * time.Minute,
+	}
+	require.Equal(t, 30*time.Minute, buffDuration(recipe, recipe.Min))
+	require.Equal(t, 60*time.Minute, buffDuration(recipe, recipe.Max))
+	require.Equal(t, 45*time.Minute, buffDuration(recipe, Mix{Dust: Dust{RedCount: 4}, Herb: Herb{MelissaCount: 1}}))
+	recipe.Max = recipe.Min
+	require.Equal(t, 30*time.Minute, buffDuration(recipe, recipe.Min))
+}
+
+func TestExperienceForLevel(t *testing.T) {
+	leveling := DefaultLeveling()
+	require.Zero(t, leveling.ExperienceForLevel(0))
+	require.EqualValues(t, 100, leveling.ExperienceForLevel(1))
+	require.EqualValues(t, 5630, leveling.ExperienceForLevel(20))
+	for level := int64(0); level < 60; level++ {
+		exp := leveling.ExperienceForLevel(level)
+		require.Equal(t, level, leveling.LevelByExperience(exp))
+		require.Equal(t, level, leveling.LevelByExperience(leveling.ExperienceForLevel(level+1)-1))
+	}
+}
+
+func TestParseLeveling(t *testing.T) {
+	leveling, err := ParseLeveling([]byte(`{"first_level_exp": 10, "exp_growth": 2, "max_level_exp": 30, "level_stats": [{}, {"luck": 2}]}`))
+	require.NoError(t, err)
+	require.EqualValues(t, 3, leveling.LevelByExperience(60)) // 10 + 20 + 30
+	require.Equal(t, Stat{Luck: 2}, leveling.StatsForLevel(3), "no free points are given")
+
+	for _, data := range []string{
+		`{"first_level_exp": 0, "exp_growth": 2, "max_level_exp": 30}`,
+		`{"first_level_exp": 10, "exp_growth": 0.5, "max_level_exp": 30}`,
+		`{"first_level_exp": 10, "exp_growth": 2}`,
+		`{"first_level_exp": 10, "exp_growth": 2, "max_level_exp": 30, "free_points_per_level": -1}`,
+		`{"first_level_exp": 10, "exp_growth": 2, "max_level_exp": 30, "level_stats": [{"lck": 1}]}`,
+		`not json`,
+	} {
+		_, err = ParseLeveling([]byte(data))
+		require.ErrorIs(t, err, ErrInvalidLeveling, data)
+	}
+}
+
+func TestLuckyPercents(t *testing.T) {
+	percents := []float64{0.7, 0.3}
+	rare := []bool{false, true}
+	require.Equal(t, percents, luckyPercents(percents, rare, 0))
+	lucky := luckyPercents(percents, rare, 5)
+	require.InDelta(t, 1, lucky[0]+lucky[1], 1e-9)
+	require.InDelta(t, 0.45/1.15, lucky[1], 1e-9)
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/pechorka/adhd-reader/internal/storage"
+	"github.com/pkg/errors"
+)
+
+// PeriodStats is what the user read during the period
+type PeriodStats struct {
+	Days    int // length of the period including today, 0 for all time
+	Chunks  int64
+	Words   int64
+	Chars   int64
+	Seconds int64 // time to read the chunks at the user's reading speed
+}
+
+// TextEstimate is how much is left to read in the text
+type TextEstimate struct {
+	UUID             string
+	Name             string
+	Current          bool // the text is selected
+	Percent          int  // completion percent
+	RemainingChars   int64
+	RemainingSeconds int64 // at the user's reading speed for the language of the text
+}
+
+// ReadingStats is the reading statistics of the user
+type ReadingStats struct {
+	Timezone string
+	Today    PeriodStats
+	Week     PeriodStats // the last 7 days
+	Month    PeriodStats // the last 30 days
+	Total    PeriodStats
+	// Hours is time of reading by hour of the day in the user's timezone
+	Hours         [24]int64
+	FinishedTexts int
+	// Texts are opened unfinished texts, the current text is the first, others are sorted by remaining time
+	Texts []TextEstimate
+}
+
+// PeakHour returns the hour of the day when the user reads the most, -1 if nothing is read
+func (s ReadingStats) PeakHour() int {
+	peak := -1
+	for hour, seconds := range s.Hours {
+		if seconds > 0 && (peak < 0 || seconds > s.Hours[peak]) {
+			peak = hour
+		}
+	}
+	return peak
+}
+
+// ReadingStats returns statistics built from the reading history and texts of the user
+func (s *Service) ReadingStats(userID int64) (ReadingStats, error) {
+	reading, err := s.s.GetReadingByUserID(userID)
+	if err != nil {
+		return ReadingStats{}, errors.Wrap(err, "failed to get reading")
+	}
+	events, err := s.s.GetReadingEvents(userID, time.Time{})
+	if err != nil {
+		return ReadingStats{}, errors.Wrap(err, "failed to get reading history")
+	}
+	texts, err := s.s.GetTexts(userID)
+	if err != nil {
+		return ReadingStats{}, errors.Wrap(err, "failed to get texts")
+	}
+	// storage doesn't have distinct error for not selected text, stats are shown without it
+	current, _ := s.s.GetCurrentText(userID)
+	return buildReadingStats(reading, events, texts, current.UUID, s.now()), nil
+}
+
+func buildReadingStats(reading storage.Reading, events []storage.ReadingEvent, texts []storage.TextWithChunkInfo, currentUUID string, now time.Time) ReadingStats {
+	stats := ReadingStats{
+		Timezone: reading.Timezone,
+		Today:    PeriodStats{Days: 1},
+		Week:     PeriodStats{Days: 7},
+		Month:    PeriodStats{Days: 30},
+	}
+	if stats.Timezone == "" {
+		stats.Timezone = "UTC"
+	}
+	loc, err := loadTimezone(stats.Timezone)
+	if err != nil {
+		loc = time.UTC // see buildHistory
+	}
+
+	today := startOfDay(now, loc)
+	for _, event := range events {
+		for _, period := range []*PeriodStats{&stats.Today, &stats.Week, &stats.Month, &stats.Total} {
+			if period.Days == 0 || !event.At.Before(addDays(today, 1-period.Days)) {
+				period.add(event)
+			}
+		}
+		stats.Hours[event.At.In(loc).Hour()] += event.Seconds
+	}
+
+	for _, text := range texts {
+		opened := text.CurrentChunk != storage.NotSelected
+		if text.State == storage.StateFinished || (opened && isTextFinished(text.CurrentChunk, text.TotalChunks)) {
+			stats.FinishedTexts++
+			continue
+		}
+		if !opened || text.State == storage.StateAbandoned {
+			continue
+		}
+		remaining := int64(math.Round(float64(text.Length) * remainingPart(text)))
+		stats.Texts = append(stats.Texts, TextEstimate{
+			UUID:             text.UUID,
+			Name:             text.Name,
+			Current:          text.UUID == currentUUID,
+			Percent:          calculateCompletionPercent(text),
+			RemainingChars:   remaining,
+			RemainingSeconds: int64(math.Round(float64(remaining) / readingSpeed(reading, text.Language) * 60)),
+		})
+	}
+	slices.SortStableFunc(stats.Texts, func(a, b TextEstimate) int {
+		if a.Current != b.Current {
+			if a.Current {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(a.RemainingSeconds, b.RemainingSeconds)
+	})
+	return stats
+}
+
+func (p *PeriodStats) add(event storage.ReadingEvent) {
+	p.Chunks++
+	p.Words += event.Words
+	p.Chars += event.Chars
+	p.Seconds += event.Seconds
+}
@@ -0,0 +1,593 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/pechorka/adhd-reader/pkg/textspliter"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// BackupVersion is the version of the backup format, it's increased on incompatible changes
+const BackupVersion = 1
+
+var ErrUnsupportedBackup = errors.New("unsupported backup version")
+
+// Backup is everything that is stored for the user
+type Backup struct {
+	Version     int             `json:"version"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	ChunkSize   int64           `json:"chunkSize"`
+	CurrentText string          `json:"currentText,omitempty"` // uuid of the selected text
+	Texts       []BackupText    `json:"texts"`
+	Reading     Reading         `json:"reading"`
+	List        ListPreferences `json:"list"`
+	Bookmarks   []Bookmark      `json:"bookmarks"`
+	Highlights  []Highlight     `json:"highlights"`
+	Dust        Dust            `json:"dust"`
+	Herb        Herb            `json:"herb"`
+	Level       Level           `json:"level"`
+	Stat        Stat            `json:"stat"`
+	Recipes     []UserRecipe    `json:"recipes"`
+	Reminders   []Reminder      `json:"reminders"`
+	History     []ReadingEvent  `json:"history"`
+}
+
+type BackupText struct {
+	UUID         string            `json:"id"`
+	Name         string            `json:"name"`
+	Source       TextSource        `json:"source"`
+	CurrentChunk int64             `json:"currentChunk"`
+	Position     int64             `json:"position"`
+	Language     string            `json:"language,omitempty"`
+	ChunkSize    int64             `json:"chunkSize,omitempty"`
+	CheckSum     []byte            `json:"checkSum,omitempty"`
+	State        TextState         `json:"state,omitempty"`
+	Tags         []string          `json:"tags,omitempty"`
+	CreatedAt    time.Time         `json:"createdAt"`
+	ModifiedAt   time.Time         `json:"modifiedAt"`
+	FullText     string            `json:"fullText"`
+	Chunks       []string          `json:"chunks"`
+	Attachments  map[string][]byte `json:"attachments,omitempty"`
+}
+
+type RestoreMode string
+
+const (
+	// RestoreMerge keeps data of the user and adds what is missing from the backup
+	RestoreMerge RestoreMode = "merge"
+	// RestoreReplace deletes texts of the user and replaces their data with the backup
+	RestoreReplace RestoreMode = "replace"
+	// RestoreCopy is RestoreReplace for backups exported by the server itself, e.g. to move users
+	// between storages. Texts from files stay shared with other users, and loot, level, stats,
+	// recipes and reading history are restored only in this mode. Backups uploaded by users
+	// can be edited, so they are never restored in this mode.
+	RestoreCopy RestoreMode = "copy"
+)
+
+// ExportUser returns backup of everything that is stored for the user
+func (s *Storage) ExportUser(userID int64) (Backup, error) {
+	backup := Backup{
+		Version:    BackupVersion,
+		CreatedAt:  time.Now(),
+		Texts:      []BackupText{},
+		Bookmarks:  []Bookmark{},
+		Highlights: []Highlight{},
+		Recipes:    []UserRecipe{},
+		Reminders:  []Reminder{},
+		History:    []ReadingEvent{},
+	}
+	id := int64ToBytes(userID)
+	err := s.view(func(tx *bolt.Tx) error {
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		if b := tx.Bucket(bktUserInfo); b != nil {
+			texts, err := getTexts(b, textsId(userID))
+			if err != nil {
+				return err
+			}
+			backup.ChunkSize = getChunkSize(b, chunkSizeId(userID))
+			if texts.Current != NotSelected && texts.Current < len(texts.Texts) {
+				backup.CurrentText = texts.Texts[texts.Current].UUID
+			}
+			for _, text := range texts.Texts {
+				backupText, err := exportText(tx, text, c)
+				if err != nil {
+					return errors.Wrapf(err, "failed to export text %q", text.Name)
+				}
+				backup.Texts = append(backup.Texts, backupText)
+			}
+		}
+		if b := tx.Bucket(bktReading); b != nil {
+			if backup.Reading, err = s.getReading(b, id); err != nil {
+				return err
+			}
+		}
+		if b := tx.Bucket(bktListPrefs); b != nil {
+			if backup.List, err = s.getListPreferences(b, id); err != nil {
+				return err
+			}
+		}
+		if b := tx.Bucket(bktBookmarks); b != nil {
+			bookmarks, err := getBookmarks(b, id, c)
+			if err != nil {
+				return err
+			}
+			backup.Bookmarks = append(backup.Bookmarks, bookmarks...)
+		}
+		if b := tx.Bucket(bktHighlights); b != nil {
+			highlights, err := getHighlights(b, id, c)
+			if err != nil {
+				return err
+			}
+			backup.Highlights = append(backup.Highlights, highlights...)
+		}
+		if b := tx.Bucket(bktDust); b != nil {
+			if backup.Dust, err = s.getDust(b, id); err != nil {
+				return err
+			}
+		}
+		if b := tx.Bucket(bktHerb); b != nil {
+			if backup.Herb, err = s.getHerb(b, id); err != nil {
+				return err
+			}
+		}
+		if b := tx.Bucket(bktLevel); b != nil {
+			if backup.Level, err = s.getLevel(b, id); err != nil {
+				return err
+			}
+		}
+		if b := tx.Bucket(bktStat); b != nil {
+			if backup.Stat, err = s.getStat(b, id); err != nil {
+				return err
+			}
+		}
+		if b := tx.Bucket(bktUserRecipe); b != nil {
+			prefix := userRecipePrefix(userID)
+			cur := b.Cursor()
+			for k, v := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+				recipe, err := unmarshalUserRecipe(v)
+				if err != nil {
+					return err
+				}
+				backup.Recipes = append(backup.Recipes, recipe)
+			}
+		}
+		if b := tx.Bucket(bktReminders); b != nil {
+			reminders, err := getReminders(b, id)
+			if err != nil {
+				return err
+			}
+			backup.Reminders = append(backup.Reminders, reminders...)
+		}
+		events, err := getReadingEvents(tx, id, time.Time{})
+		if err != nil {
+			return err
+		}
+		backup.History = append(backup.History, events...)
+		return nil
+	})
+	return backup, errors.Wrap(err, "failed to export user")
+}
+
+func exportText(tx *bolt.Tx, text Text, c *textCipher) (BackupText, error) {
+	content, err := textContent(tx, text, c)
+	if err != nil {
+		return BackupText{}, err
+	}
+	return BackupText{
+		UUID:         text.UUID,
+		Name:         text.Name,
+		Source:       text.Source,
+		CurrentChunk: text.CurrentChunk,
+		Position:     content.Text.Position,
+		Language:     text.Language,
+		ChunkSize:    text.ChunkSize,
+		CheckSum:     text.CheckSum,
+		State:        text.State,
+		Tags:         text.Tags,
+		CreatedAt:    text.CreatedAt,
+		ModifiedAt:   text.ModifiedAt,
+		FullText:     content.FullText,
+		Chunks:       content.Chunks,
+		Attachments:  content.Attachments,
+	}, nil
+}
+
+// ImportUser restores backup of the user, returns number of restored texts
+func (s *Storage) ImportUser(userID int64, backup Backup, mode RestoreMode) (int, error) {
+	if err := checkRestore(backup, mode); err != nil {
+		return 0, err
+	}
+	replace := mode != RestoreMerge
+	id := int64ToBytes(userID)
+	var restored int
+	err := s.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bktUserInfo)
+		if err != nil {
+			return err
+		}
+		texts, err := getTexts(b, textsId(userID))
+		if err != nil {
+			return err
+		}
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		if replace {
+			for _, text := range texts.Texts {
+				if err = unindexText(tx, userID, text.UUID); err != nil {
+					return err
+				}
+				if !ownsBucket(text) {
+					continue
+				}
+				if err = tx.DeleteBucket(text.BucketName); err != nil && err != bolt.ErrBucketNotFound {
+					return err
+				}
+			}
+			texts = defaultUserTexts()
+		}
+
+		// texts from file can get uuid of the same file already saved by other users
+		textUUIDs := make(map[string]string, len(backup.Texts))
+		for _, backupText := range backup.Texts {
+			if i := slices.IndexFunc(texts.Texts, func(text Text) bool { return text.UUID == backupText.UUID }); i >= 0 {
+				textUUIDs[backupText.UUID] = backupText.UUID
+				continue
+			}
+			text, err := importText(tx, backupText, c, mode == RestoreCopy)
+			if err != nil {
+				return errors.Wrapf(err, "failed to import text %q", backupText.Name)
+			}
+			if slices.ContainsFunc(texts.Texts, func(t Text) bool { return t.UUID == text.UUID }) {
+				textUUIDs[backupText.UUID] = text.UUID
+				continue
+			}
+			text.Name = uniqueTextName(texts, text.Name)
+			texts.Texts = append(texts.Texts, text)
+			if err = indexText(tx, userID, text, c); err != nil {
+				return err
+			}
+			textUUIDs[backupText.UUID] = text.UUID
+			restored++
+		}
+		if texts.Current == NotSelected && backup.CurrentText != "" {
+			texts.Current = slices.IndexFunc(texts.Texts, func(text Text) bool {
+				return text.UUID == textUUIDs[backup.CurrentText]
+			})
+		}
+		if err = putTexts(b, textsId(userID), texts); err != nil {
+			return err
+		}
+		if replace || getChunkSize(b, chunkSizeId(userID)) == 0 {
+			if err = putChunkSize(b, chunkSizeId(userID), backup.ChunkSize); err != nil {
+				return err
+			}
+		}
+
+		if err = importMarks(tx, id, backup, textUUIDs, replace, c); err != nil {
+			return err
+		}
+		if mode == RestoreCopy {
+			if err = importReadingEvents(tx, id, backup, textUUIDs, replace); err != nil {
+				return err
+			}
+			if err = s.importGame(tx, userID, backup, replace); err != nil {
+				return err
+			}
+		}
+		return s.importProgress(tx, userID, backup, replace)
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to import user")
+	}
+	return restored, nil
+}
+
+func checkRestore(backup Backup, mode RestoreMode) error {
+	if backup.Version < 1 || backup.Version > BackupVersion {
+		return errors.Wrapf(ErrUnsupportedBackup, "version %d", backup.Version)
+	}
+	if mode != RestoreMerge && mode != RestoreReplace && mode != RestoreCopy {
+		return errors.Errorf("unknown restore mode %q", mode)
+	}
+	return nil
+}
+
+// importText saves chunks of the text encrypted with c, the cipher of the user. Copied texts from file
+// of users without encryption reuse the processed file if it's already saved with the same chunk size.
+func importText(tx *bolt.Tx, backupText BackupText, c *textCipher, copied bool) (Text, error) {
+	text, err := importedText(backupText)
+	if err != nil {
+		return Text{}, err
+	}
+	if !copied {
+		text = ownedText(text)
+	}
+	text.Encrypted = c != nil
+	if ownsBucket(text) {
+		bucketName, err := fillTextBucket(tx, backupText.FullText, backupText.Chunks, backupText.Attachments, c)
+		text.BucketName = bucketName
+		return text, err
+	}
+
+	pfBucket, err := tx.CreateBucketIfNotExists(bktProcessedFiles)
+	if err != nil {
+		return Text{}, err
+	}
+	pf, err := getProcessedFileVariant(pfBucket, text.CheckSum, text.ChunkSize)
+	switch {
+	case err == nil && tx.Bucket(pf.BucketName) != nil:
+		text.UUID = pf.UUID
+		text.BucketName = pf.BucketName
+		return text, nil
+	case err == nil, err == ErrNotFound:
+	default:
+		return Text{}, err
+	}
+	bucketName, err := fillTextBucket(tx, backupText.FullText, backupText.Chunks, backupText.Attachments, nil)
+	if err != nil {
+		return Text{}, err
+	}
+	text.BucketName = bucketName
+	return text, putProcessedFile(pfBucket, ProcessedFile{
+		UUID:       text.UUID,
+		BucketName: bucketName,
+		ChunkSize:  text.ChunkSize,
+		Language:   text.Language,
+		CheckSum:   text.CheckSum,
+		CreatedAt:  time.Now(),
+	})
+}
+
+// importedText returns the text of the backup with invalid values fixed
+func importedText(backupText BackupText) (Text, error) {
+	if len(backupText.Chunks) == 0 {
+		return Text{}, errors.New("text has no chunks")
+	}
+	text := Text{
+		UUID:         backupText.UUID,
+		Name:         backupText.Name,
+		Source:       backupText.Source,
+		CurrentChunk: backupText.CurrentChunk,
+		Position:     backupText.Position,
+		Language:     backupText.Language,
+		ChunkSize:    backupText.ChunkSize,
+		CheckSum:     backupText.CheckSum,
+		State:        backupText.State,
+		Tags:         backupText.Tags,
+		CreatedAt:    backupText.CreatedAt,
+		ModifiedAt:   backupText.ModifiedAt,
+	}
+	if text.UUID == "" {
+		return Text{}, errors.New("text has no id")
+	}
+	if text.CurrentChunk < 0 {
+		text.CurrentChunk, text.Position = NotSelected, NotSelected
+	} else {
+		// backups can be edited, so position is kept inside the text and current chunk is derived from it
+		offsets := textspliter.Offsets(backupText.Chunks)
+		text.Position = min(max(text.Position, 0), textLength(backupText.Chunks, offsets))
+		text.CurrentChunk = chunkAt(offsets, text.Position)
+	}
+	if !slices.Contains(TextStates, text.State) {
+		text.State = progressState(text.CurrentChunk, int64(len(backupText.Chunks)))
+	}
+	// only texts from file with checksum can be shared with other users
+	if (text.Source != SourceFile || len(text.CheckSum) == 0) && text.Source != SourceURL {
+		text.Source = SourceText
+	}
+	return text, nil
+}
+
+// ownedText makes the restored text keep its content in its own bucket. Content of uploaded backups
+// can be edited, so it's never shared with other users as content of the file with the same checksum.
+func ownedText(text Text) Text {
+	if text.Source == SourceFile {
+		text.Source = SourceText
+	}
+	text.CheckSum = nil
+	return text
+}
+
+// uniqueTextName adds number to the name if the user already has text with this name
+func uniqueTextName(texts UserTexts, name string) string {
+	unique := name
+	for i := 2; validateUserTexts(texts, textNameUnique(unique)) != nil; i++ {
+		unique = fmt.Sprintf("%s (%d)", name, i)
+	}
+	return unique
+}
+
+// importMarks restores bookmarks and highlights of restored texts
+func importMarks(tx *bolt.Tx, id []byte, backup Backup, textUUIDs map[string]string, replace bool, c *textCipher) error {
+	b, err := tx.CreateBucketIfNotExists(bktBookmarks)
+	if err != nil {
+		return err
+	}
+	var bookmarks []Bookmark
+	if !replace {
+		if bookmarks, err = getBookmarks(b, id, c); err != nil {
+			return err
+		}
+	}
+	for _, bookmark := range backup.Bookmarks {
+		textUUID, ok := textUUIDs[bookmark.TextUUID]
+		if !ok || slices.ContainsFunc(bookmarks, func(m Bookmark) bool { return m.UUID == bookmark.UUID }) {
+			continue
+		}
+		bookmark.TextUUID = textUUID
+		bookmarks = append(bookmarks, bookmark)
+	}
+	if err = putBookmarks(b, id, bookmarks, c); err != nil {
+		return err
+	}
+
+	b, err = tx.CreateBucketIfNotExists(bktHighlights)
+	if err != nil {
+		return err
+	}
+	var highlights []Highlight
+	if !replace {
+		if highlights, err = getHighlights(b, id, c); err != nil {
+			return err
+		}
+	}
+	for _, highlight := range backup.Highlights {
+		textUUID, ok := textUUIDs[highlight.TextUUID]
+		if !ok || slices.ContainsFunc(highlights, func(h Highlight) bool { return h.UUID == highlight.UUID }) {
+			continue
+		}
+		highlight.TextUUID = textUUID
+		highlights = append(highlights, highlight)
+	}
+	return putHighlights(b, id, highlights, c)
+}
+
+// importProgress restores reading and list settings and reminders.
+// When merging, values are restored only if the user has none.
+func (s *Storage) importProgress(tx *bolt.Tx, userID int64, backup Backup, replace bool) error {
+	id := int64ToBytes(userID)
+
+	b, err := tx.CreateBucketIfNotExists(bktReading)
+	if err != nil {
+		return err
+	}
+	reading, err := s.getReading(b, id)
+	if err != nil {
+		return err
+	}
+	if replace || (reading.ChunkSeconds == 0 && len(reading.Speeds) == 0) {
+		if err = s.putReading(b, id, backup.Reading); err != nil {
+			return err
+		}
+	}
+
+	if b, err = tx.CreateBucketIfNotExists(bktListPrefs); err != nil {
+		return err
+	}
+	prefs, err := s.getListPreferences(b, id)
+	if err != nil {
+		return err
+	}
+	if replace || prefs == (ListPreferences{}) {
+		if err = s.putListPreferences(b, id, backup.List); err != nil {
+			return err
+		}
+	}
+
+	if b, err = tx.CreateBucketIfNotExists(bktReminders); err != nil {
+		return err
+	}
+	var reminders []Reminder
+	if !replace {
+		if reminders, err = getReminders(b, id); err != nil {
+			return err
+		}
+	}
+	return putReminders(b, id, mergeReminders(reminders, backup.Reminders))
+}
+
+// importGame restores loot, level, stats and recipes, see importProgress.
+// They are earned by reading, so only copies made by the server restore them.
+func (s *Storage) importGame(tx *bolt.Tx, userID int64, backup Backup, replace bool) error {
+	id := int64ToBytes(userID)
+
+	b, err := tx.CreateBucketIfNotExists(bktDust)
+	if err != nil {
+		return err
+	}
+	dust, err := s.getDust(b, id)
+	if err != nil {
+		return err
+	}
+	if replace || dust == (Dust{}) {
+		if err = s.putDust(b, id, backup.Dust); err != nil {
+			return err
+		}
+	}
+
+	if b, err = tx.CreateBucketIfNotExists(bktHerb); err != nil {
+		return err
+	}
+	herb, err := s.getHerb(b, id)
+	if err != nil {
+		return err
+	}
+	if replace || herb == (Herb{}) {
+		if err = s.putHerb(b, id, backup.Herb); err != nil {
+			return err
+		}
+	}
+
+	if b, err = tx.CreateBucketIfNotExists(bktLevel); err != nil {
+		return err
+	}
+	level, err := s.getLevel(b, id)
+	if err != nil {
+		return err
+	}
+	if replace || level == (Level{}) {
+		if err = s.putLevel(b, id, backup.Level); err != nil {
+			return err
+		}
+	}
+
+	if b, err = tx.CreateBucketIfNotExists(bktStat); err != nil {
+		return err
+	}
+	stat, err := s.getStat(b, id)
+	if err != nil {
+		return err
+	}
+	if replace || stat == (Stat{}) {
+		if err = s.putStat(b, id, backup.Stat); err != nil {
+			return err
+		}
+	}
+
+	if b, err = tx.CreateBucketIfNotExists(bktUserRecipe); err != nil {
+		return err
+	}
+	for _, recipe := range backup.Recipes {
+		key := userRecipeId(userID, recipe.RecipeName)
+		if !replace && b.Get(key) != nil {
+			continue
+		}
+		recipe.UserID = userID
+		if err = s.putUserRecipe(b, key, recipe); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeReminders adds restored reminders which the user doesn't have yet
+func mergeReminders(reminders, restored []Reminder) []Reminder {
+	for _, reminder := range restored {
+		exists := slices.ContainsFunc(reminders, func(r Reminder) bool {
+			return r.UUID == reminder.UUID
+		})
+		if !exists {
+			reminders = append(reminders, reminder)
+		}
+	}
+	return reminders
+}
+
+func userRecipePrefix(userID int64) []byte {
+	return append(strconv.AppendInt(nil, userID, 10), '|')
+}
+
+// userRecipeId is the key of the user recipe, see getUserRecipe
+func userRecipeId(userID int64, recipeName string) []byte {
+	return append(userRecipePrefix(userID), recipeName...)
+}
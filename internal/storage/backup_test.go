@@ -0,0 +1,178 @@
+package storage_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pechorka/adhd-reader/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func tempStorage(t *testing.T) *storage.Storage {
+	t.Helper()
+	s, err := storage.NewTempStorage()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, s.Close())
+	})
+	return s
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	src := tempStorage(t)
+	userID := int64(1)
+
+	textID, err := src.AddText(userID, storage.NewText{
+		Name:      "text",
+		Text:      "First. Second. Third.",
+		Chunks:    []string{"First.", "Second.", "Third."},
+		ChunkSize: 10,
+		Language:  "en",
+	})
+	require.NoError(t, err)
+	pf, err := src.AddProcessedFile(storage.NewProcessedFile{
+		Text:        "Book text ![image](attachment:a.png)",
+		Chunks:      []string{"Book text ![image](attachment:a.png)"},
+		ChunkSize:   100,
+		Language:    "en",
+		CheckSum:    []byte("checksum"),
+		Attachments: map[string][]byte{"a.png": {1, 2, 3}},
+	})
+	require.NoError(t, err)
+	fileID, err := src.AddTextFromProcessedFile(userID, "book.epub", pf)
+	require.NoError(t, err)
+	require.NoError(t, src.UpdateTexts(userID, func(texts *storage.UserTexts) error {
+		texts.Current = 0
+		texts.Texts[0].Position = 7
+		return nil
+	}))
+	require.NoError(t, src.SetChunkSize(userID, 10))
+	_, err = src.UpdateReading(userID, func(r *storage.Reading) {
+		r.ChunkSeconds = 60
+		r.Speeds = map[string]storage.Speed{"en": {Explicit: 900}}
+	})
+	require.NoError(t, err)
+	_, err = src.AddBookmark(userID, storage.Bookmark{TextUUID: textID, Position: 7, Label: "second"})
+	require.NoError(t, err)
+	_, err = src.AddHighlight(userID, storage.Highlight{TextUUID: fileID, Start: 0, End: 8, Text: "Book text", Note: "note"})
+	require.NoError(t, err)
+	_, err = src.UpdateDust(userID, func(d *storage.Dust) { d.RedCount = 3 })
+	require.NoError(t, err)
+	_, err = src.UpdateHerb(userID, func(h *storage.Herb) { h.MelissaCount = 2 })
+	require.NoError(t, err)
+	_, err = src.UpdateLevel(userID, func(l *storage.Level) { l.Experience = 42 })
+	require.NoError(t, err)
+	_, err = src.UpdateStat(userID, func(s *storage.Stat) { s.Luck = 5 })
+	require.NoError(t, err)
+
+	backup := exportUser(t, src, userID)
+	require.Len(t, backup.Texts, 2)
+	require.Equal(t, textID, backup.CurrentText)
+
+	// restore into another storage and another user
+	dst := tempStorage(t)
+	otherUserID := int64(2)
+	restored, err := dst.ImportUser(otherUserID, backup, storage.RestoreCopy)
+	require.NoError(t, err)
+	require.Equal(t, 2, restored)
+	require.Equal(t, withoutCreatedAt(backup), withoutCreatedAt(exportUser(t, dst, otherUserID)))
+
+	current, err := dst.GetCurrentChunk(otherUserID)
+	require.NoError(t, err)
+	require.Equal(t, "Second.", current.Chunk)
+	attachment, err := dst.GetAttachment(otherUserID, fileID, "a.png")
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 2, 3}, attachment)
+
+	// merging the same backup again doesn't duplicate anything
+	restored, err = dst.ImportUser(otherUserID, backup, storage.RestoreMerge)
+	require.NoError(t, err)
+	require.Zero(t, restored)
+	require.Equal(t, withoutCreatedAt(backup), withoutCreatedAt(exportUser(t, dst, otherUserID)))
+
+	// copied text from file reuses the file that is already saved
+	restored, err = src.ImportUser(otherUserID, backup, storage.RestoreCopy)
+	require.NoError(t, err)
+	require.Equal(t, 2, restored)
+	texts, err := src.GetTexts(otherUserID)
+	require.NoError(t, err)
+	require.Equal(t, fileID, texts[1].UUID)
+}
+
+func TestBackupMergeKeepsExistingData(t *testing.T) {
+	s := tempStorage(t)
+	userID := int64(1)
+
+	_, err := s.AddText(userID, storage.NewText{Name: "text", Text: "Old.", Chunks: []string{"Old."}})
+	require.NoError(t, err)
+	_, err = s.UpdateLevel(userID, func(l *storage.Level) { l.Experience = 10 })
+	require.NoError(t, err)
+
+	backup := storage.Backup{
+		Version: storage.BackupVersion,
+		Texts: []storage.BackupText{{
+			UUID:         "restored",
+			Name:         "text",
+			Source:       storage.SourceText,
+			CurrentChunk: storage.NotSelected,
+			Position:     storage.NotSelected,
+			FullText:     "New.",
+			Chunks:       []string{"New."},
+		}},
+		Level: storage.Level{Experience: 100},
+		Stat:  storage.Stat{Luck: 1},
+	}
+	restored, err := s.ImportUser(userID, backup, storage.RestoreMerge)
+	require.NoError(t, err)
+	require.Equal(t, 1, restored)
+
+	texts, err := s.GetTexts(userID)
+	require.NoError(t, err)
+	require.Len(t, texts, 2)
+	require.Equal(t, "text (2)", texts[1].Name)
+	level, err := s.GetLevelByUserID(userID)
+	require.NoError(t, err)
+	require.EqualValues(t, 10, level.Experience)
+	stat, err := s.GetStatByUserID(userID)
+	require.NoError(t, err)
+	require.Zero(t, stat, "uploaded backups don't restore stats")
+
+	restored, err = s.ImportUser(userID, backup, storage.RestoreReplace)
+	require.NoError(t, err)
+	require.Equal(t, 1, restored)
+	texts, err = s.GetTexts(userID)
+	require.NoError(t, err)
+	require.Len(t, texts, 1)
+	require.Equal(t, "text", texts[0].Name)
+	level, err = s.GetLevelByUserID(userID)
+	require.NoError(t, err)
+	require.EqualValues(t, 10, level.Experience)
+
+	_, err = s.ImportUser(userID, backup, storage.RestoreCopy)
+	require.NoError(t, err)
+	level, err = s.GetLevelByUserID(userID)
+	require.NoError(t, err)
+	require.EqualValues(t, 100, level.Experience)
+
+	backup.Version = storage.BackupVersion + 1
+	_, err = s.ImportUser(userID, backup, storage.RestoreMerge)
+	require.ErrorIs(t, err, storage.ErrUnsupportedBackup)
+}
+
+// exportUser returns backup as it's read back from json
+func exportUser(t *testing.T, s *storage.Storage, userID int64) storage.Backup {
+	t.Helper()
+	backup, err := s.ExportUser(userID)
+	require.NoError(t, err)
+	encoded, err := json.Marshal(backup)
+	require.NoError(t, err)
+	var decoded storage.Backup
+	require.NoError(t, json.Unmarshal(encoded, &decoded))
+	return decoded
+}
+
+func withoutCreatedAt(backup storage.Backup) storage.Backup {
+	backup.CreatedAt = time.Time{}
+	return backup
+}
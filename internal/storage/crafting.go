@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// bktBuffs has buffs of the user by user id, buffs are temporary and aren't backed up
+var bktBuffs = []byte("buffs")
+
+// SaveRecipe adds the recipe or replaces the recipe with the same name
+func (s *Storage) SaveRecipe(recipe Recipe) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bktRecipe)
+		if err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(recipe)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(recipe.Name), encoded)
+	})
+}
+
+// GetRecipes returns all recipes sorted by name
+func (s *Storage) GetRecipes() ([]Recipe, error) {
+	var recipes []Recipe
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktRecipe)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, _ []byte) error {
+			recipe, err := s.getRecipe(b, k)
+			if err != nil {
+				return err
+			}
+			recipes = append(recipes, recipe)
+			return nil
+		})
+	})
+	return recipes, err
+}
+
+// UpdateLoot updates dust and herbs of the user in one transaction, nothing is changed if updFunc returns an error
+func (s *Storage) UpdateLoot(userID int64, updFunc func(*Dust, *Herb) error) error {
+	return s.update(func(tx *bolt.Tx) error {
+		dustBkt, err := tx.CreateBucketIfNotExists(bktDust)
+		if err != nil {
+			return err
+		}
+		herbBkt, err := tx.CreateBucketIfNotExists(bktHerb)
+		if err != nil {
+			return err
+		}
+		id := int64ToBytes(userID)
+		dust, err := s.getDust(dustBkt, id)
+		if err != nil {
+			return err
+		}
+		herb, err := s.getHerb(herbBkt, id)
+		if err != nil {
+			return err
+		}
+		if err = updFunc(&dust, &herb); err != nil {
+			return err
+		}
+		if err = s.putDust(dustBkt, id, dust); err != nil {
+			return err
+		}
+		return s.putHerb(herbBkt, id, herb)
+	})
+}
+
+// UpdateBuffs replaces buffs of the user with result of updFunc
+func (s *Storage) UpdateBuffs(userID int64, updFunc func([]Buff) []Buff) ([]Buff, error) {
+	var buffs []Buff
+	err := s.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bktBuffs)
+		if err != nil {
+			return err
+		}
+		id := int64ToBytes(userID)
+		if buffs, err = getBuffs(b, id); err != nil {
+			return err
+		}
+		buffs = updFunc(buffs)
+		return putBuffs(b, id, buffs)
+	})
+	return buffs, err
+}
+
+// GetBuffs returns buffs of the user, expired buffs are returned until they are removed by UpdateBuffs
+func (s *Storage) GetBuffs(userID int64) ([]Buff, error) {
+	var buffs []Buff
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktBuffs)
+		if b == nil {
+			return nil
+		}
+		var err error
+		buffs, err = getBuffs(b, int64ToBytes(userID))
+		return err
+	})
+	return buffs, err
+}
+
+func getBuffs(b *bolt.Bucket, id []byte) (buffs []Buff, err error) {
+	v := b.Get(id)
+	if v == nil {
+		return buffs, nil
+	}
+	err = json.Unmarshal(v, &buffs)
+	if err != nil {
+		return buffs, errors.Wrap(err, "failed to unmarshal buffs")
+	}
+	return buffs, nil
+}
+
+func putBuffs(b *bolt.Bucket, id []byte, buffs []Buff) error {
+	if len(buffs) == 0 {
+		return b.Delete(id)
+	}
+	encoded, err := json.Marshal(buffs)
+	if err != nil {
+		return err
+	}
+	return b.Put(id, encoded)
+}
@@ -0,0 +1,298 @@
+package storage
+
+import (
+	"encoding/hex"
+
+	"github.com/gtank/cryptopasta"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Texts of the user can be encrypted with the user's data key. The data key is saved encrypted
+// by KeyWrapper, which holds the server secret. Content, chunks and attachments of encrypted texts,
+// bookmarks and highlights of the user are encrypted, words in the search index are replaced with
+// their HMAC. Encrypted texts never share buckets with other users, so files uploaded by such users
+// are not deduplicated. Names, tags, states and reading progress of texts are not encrypted,
+// as texts are found and sorted by them.
+
+var bktDataKeys = []byte("data_keys")
+
+var ErrEncryptionDisabled = errors.New("encryption is not configured")
+
+// KeyWrapper encrypts data keys of users
+type KeyWrapper interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// textCipher encrypts content of the user's texts. Nil cipher keeps content as is,
+// cipher without key fails to encrypt or decrypt anything.
+type textCipher struct {
+	key      *[32]byte
+	indexKey *[32]byte // key of HMAC of words in the search index
+}
+
+var searchIndexKeyTag = []byte("search index")
+
+func newTextCipher(dataKey []byte) (*textCipher, error) {
+	if len(dataKey) != 32 {
+		return nil, errors.New("invalid data key")
+	}
+	key := &[32]byte{}
+	copy(key[:], dataKey)
+	indexKey := &[32]byte{}
+	copy(indexKey[:], cryptopasta.GenerateHMAC(searchIndexKeyTag, key))
+	return &textCipher{key: key, indexKey: indexKey}, nil
+}
+
+// unwrapTextCipher returns cipher of the data key encrypted by keys, nil if there is no data key
+func unwrapTextCipher(keys KeyWrapper, wrapped []byte) (*textCipher, error) {
+	if wrapped == nil {
+		return nil, nil
+	}
+	if keys == nil {
+		// plain texts of the user are still readable
+		return &textCipher{}, nil
+	}
+	dataKey, err := keys.Decrypt(wrapped)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt data key")
+	}
+	return newTextCipher(dataKey)
+}
+
+// newWrappedDataKey returns cipher with a new data key and the key encrypted by keys
+func newWrappedDataKey(keys KeyWrapper) (*textCipher, []byte, error) {
+	if keys == nil {
+		return nil, nil, ErrEncryptionDisabled
+	}
+	dataKey := cryptopasta.NewEncryptionKey()
+	wrapped, err := keys.Encrypt(dataKey[:])
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to encrypt data key")
+	}
+	c, err := newTextCipher(dataKey[:])
+	return c, wrapped, err
+}
+
+// rewrapDataKey encrypts the data key encrypted by keys again, so it's encrypted with the newest key
+func rewrapDataKey(keys KeyWrapper, wrapped []byte) ([]byte, error) {
+	if keys == nil {
+		return nil, ErrEncryptionDisabled
+	}
+	dataKey, err := keys.Decrypt(wrapped)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt data key")
+	}
+	rewrapped, err := keys.Encrypt(dataKey)
+	return rewrapped, errors.Wrap(err, "failed to encrypt data key")
+}
+
+// of returns cipher of the text content, nil for texts that aren't encrypted
+func (c *textCipher) of(text Text) *textCipher {
+	switch {
+	case !text.Encrypted:
+		return nil
+	case c == nil:
+		return &textCipher{}
+	default:
+		return c
+	}
+}
+
+func (c *textCipher) seal(plaintext []byte) ([]byte, error) {
+	if c == nil {
+		return plaintext, nil
+	}
+	if c.key == nil {
+		return nil, ErrEncryptionDisabled
+	}
+	return cryptopasta.Encrypt(plaintext, c.key)
+}
+
+func (c *textCipher) open(ciphertext []byte) ([]byte, error) {
+	if c == nil {
+		return ciphertext, nil
+	}
+	if c.key == nil {
+		return nil, ErrEncryptionDisabled
+	}
+	plaintext, err := cryptopasta.Decrypt(ciphertext, c.key)
+	return plaintext, errors.Wrap(err, "failed to decrypt text")
+}
+
+func (c *textCipher) sealString(plaintext string) ([]byte, error) {
+	return c.seal([]byte(plaintext))
+}
+
+func (c *textCipher) openString(ciphertext []byte) (string, error) {
+	plaintext, err := c.open(ciphertext)
+	return string(plaintext), err
+}
+
+// term returns word as it's saved in the search index
+func (c *textCipher) term(term string) string {
+	if c == nil || c.indexKey == nil {
+		return term
+	}
+	return hex.EncodeToString(cryptopasta.GenerateHMAC([]byte(term), c.indexKey))
+}
+
+// SetKeyWrapper enables encryption of texts, it must be called before the storage is used
+func (s *Storage) SetKeyWrapper(keys KeyWrapper) {
+	s.keys = keys
+}
+
+// EncryptTexts encrypts texts, bookmarks and highlights of the user with a new data key,
+// texts added later are encrypted too. If the texts are encrypted already, the data key is rotated.
+// The database is compacted, so replaced content doesn't stay readable in free pages.
+func (s *Storage) EncryptTexts(userID int64) error {
+	err := s.update(func(tx *bolt.Tx) error {
+		from, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		to, wrapped, err := newWrappedDataKey(s.keys)
+		if err != nil {
+			return err
+		}
+		keys, err := tx.CreateBucketIfNotExists(bktDataKeys)
+		if err != nil {
+			return err
+		}
+		if err = keys.Put(int64ToBytes(userID), wrapped); err != nil {
+			return err
+		}
+		return reencryptTexts(tx, userID, from, to)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to encrypt texts")
+	}
+	return s.Compact()
+}
+
+// DecryptTexts stores texts, bookmarks and highlights of the user without encryption
+func (s *Storage) DecryptTexts(userID int64) error {
+	err := s.update(func(tx *bolt.Tx) error {
+		from, err := s.userCipher(tx, userID)
+		if err != nil || from == nil {
+			return err
+		}
+		if err = reencryptTexts(tx, userID, from, nil); err != nil {
+			return err
+		}
+		return tx.Bucket(bktDataKeys).Delete(int64ToBytes(userID))
+	})
+	return errors.Wrap(err, "failed to decrypt texts")
+}
+
+// TextsEncrypted returns true if texts of the user are encrypted
+func (s *Storage) TextsEncrypted(userID int64) (bool, error) {
+	var encrypted bool
+	err := s.view(func(tx *bolt.Tx) error {
+		keys := tx.Bucket(bktDataKeys)
+		encrypted = keys != nil && keys.Get(int64ToBytes(userID)) != nil
+		return nil
+	})
+	return encrypted, err
+}
+
+// RewrapDataKeys encrypts data keys of all users with the newest key of the key wrapper,
+// so older keys are no longer needed for texts. Returns number of re-encrypted data keys.
+func (s *Storage) RewrapDataKeys() (int, error) {
+	var count int
+	err := s.update(func(tx *bolt.Tx) error {
+		keys := tx.Bucket(bktDataKeys)
+		if keys == nil {
+			return nil
+		}
+		rewrapped := make(map[string][]byte)
+		err := keys.ForEach(func(userID, wrapped []byte) error {
+			dataKey, err := rewrapDataKey(s.keys, wrapped)
+			if err != nil {
+				return errors.Wrapf(err, "failed to rewrap data key of user %d", bytesToInt64(userID))
+			}
+			rewrapped[string(userID)] = dataKey
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for userID, wrapped := range rewrapped {
+			if err := keys.Put([]byte(userID), wrapped); err != nil {
+				return err
+			}
+		}
+		count = len(rewrapped)
+		return nil
+	})
+	return count, errors.Wrap(err, "failed to rewrap data keys")
+}
+
+// userCipher returns cipher of the user's texts, nil if the user's texts are not encrypted
+func (s *Storage) userCipher(tx *bolt.Tx, userID int64) (*textCipher, error) {
+	keys := tx.Bucket(bktDataKeys)
+	if keys == nil {
+		return nil, nil
+	}
+	return unwrapTextCipher(s.keys, keys.Get(int64ToBytes(userID)))
+}
+
+// reencryptTexts moves content of the user's texts to new buckets encrypted with to cipher
+func reencryptTexts(tx *bolt.Tx, userID int64, from, to *textCipher) error {
+	if b := tx.Bucket(bktUserInfo); b != nil {
+		id := textsId(userID)
+		texts, err := getTexts(b, id)
+		if err != nil {
+			return err
+		}
+		for i, text := range texts.Texts {
+			content, err := textContent(tx, text, from)
+			if err != nil {
+				return errors.Wrapf(err, "failed to read text %q", text.Name)
+			}
+			bucketName, err := fillTextBucket(tx, content.FullText, content.Chunks, content.Attachments, to)
+			if err != nil {
+				return err
+			}
+			if ownsBucket(text) {
+				if err = tx.DeleteBucket(text.BucketName); err != nil {
+					return err
+				}
+			}
+			text.BucketName = bucketName
+			text.Encrypted = to != nil
+			texts.Texts[i] = text
+			if err = indexText(tx, userID, text, to); err != nil {
+				return err
+			}
+		}
+		if err = putTexts(b, id, texts); err != nil {
+			return err
+		}
+	}
+
+	id := int64ToBytes(userID)
+	if b := tx.Bucket(bktBookmarks); b != nil && b.Get(id) != nil {
+		bookmarks, err := getBookmarks(b, id, from)
+		if err != nil {
+			return err
+		}
+		if err = putBookmarks(b, id, bookmarks, to); err != nil {
+			return err
+		}
+	}
+	if b := tx.Bucket(bktHighlights); b != nil && b.Get(id) != nil {
+		highlights, err := getHighlights(b, id, from)
+		if err != nil {
+			return err
+		}
+		return putHighlights(b, id, highlights, to)
+	}
+	return nil
+}
+
+// ownsBucket returns true if the text bucket is not shared with other users
+func ownsBucket(text Text) bool {
+	return text.Source != SourceFile || text.Encrypted
+}
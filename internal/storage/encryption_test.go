@@ -0,0 +1,62 @@
+package storage_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pechorka/adhd-reader/internal/storage"
+	"github.com/pechorka/adhd-reader/pkg/encryptor"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryption_NoPlaintextOnDisk(t *testing.T) {
+	backends := []struct {
+		name string
+		open func(path string) (storage.Store, error)
+	}{
+		{"bolt", func(path string) (storage.Store, error) { return storage.NewStorage(path) }},
+		{"sqlite", func(path string) (storage.Store, error) { return storage.NewSQLiteStorage(path) }},
+	}
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.db")
+			s, err := backend.open(path)
+			require.NoError(t, err)
+			s.SetKeyWrapper(encryptor.NewEncryptor("secret"))
+			userID := int64(1)
+			// content of texts added before encryption doesn't stay in free pages
+			plain := "Kilimanjaro"
+			_, err = s.AddText(userID, storage.NewText{Name: "plain", Text: plain + " text.", Chunks: []string{plain + " text."}})
+			require.NoError(t, err)
+			require.NoError(t, s.EncryptTexts(userID))
+
+			secret := "Zanzibar"
+			textID, err := s.AddText(userID, storage.NewText{
+				Name:        "text",
+				Text:        secret + " text.",
+				Chunks:      []string{secret + " text."},
+				Attachments: map[string][]byte{"cover.png": []byte(secret + " image")},
+			})
+			require.NoError(t, err)
+			_, err = s.AddBookmark(userID, storage.Bookmark{TextUUID: textID, Label: secret + " bookmark"})
+			require.NoError(t, err)
+			_, err = s.AddHighlight(userID, storage.Highlight{TextUUID: textID, Text: secret, Note: secret + " note"})
+			require.NoError(t, err)
+			matches, _, err := s.Search(userID, strings.ToLower(secret), 1, 10)
+			require.NoError(t, err)
+			require.Len(t, matches, 1)
+			require.NoError(t, s.Close())
+
+			files, err := filepath.Glob(path + "*")
+			require.NoError(t, err)
+			for _, file := range files {
+				content, err := os.ReadFile(file)
+				require.NoError(t, err)
+				require.NotContains(t, strings.ToLower(string(content)), strings.ToLower(secret), file)
+				require.NotContains(t, strings.ToLower(string(content)), strings.ToLower(plain), file)
+			}
+		})
+	}
+}
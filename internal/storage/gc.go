@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// compactTxMaxSize limits size of a transaction used to copy data while compacting
+const compactTxMaxSize = 64 * 1024 * 1024
+
+// GCReport describes result of the garbage collection
+type GCReport struct {
+	Texts      int   // removed text buckets
+	Files      int   // removed processed files
+	SizeBefore int64 // size of the database in bytes
+	SizeAfter  int64
+}
+
+// Reclaimed returns number of bytes freed by the garbage collection
+func (r GCReport) Reclaimed() int64 {
+	return r.SizeBefore - r.SizeAfter
+}
+
+// CollectGarbage removes text buckets that are not referenced by texts of any user, together with
+// processed files pointing to them, and compacts the database. Files processed after before are kept,
+// as text referencing them can be not saved yet.
+func (s *Storage) CollectGarbage(before time.Time) (GCReport, error) {
+	var report GCReport
+	var err error
+	if report.SizeBefore, err = s.size(); err != nil {
+		return report, err
+	}
+	err = s.update(func(tx *bolt.Tx) error {
+		referenced, err := referencedBuckets(tx)
+		if err != nil {
+			return err
+		}
+		if report.Files, err = sweepProcessedFiles(tx, referenced, before); err != nil {
+			return err
+		}
+		report.Texts, err = sweepTextBuckets(tx, referenced)
+		return err
+	})
+	if err != nil {
+		return report, errors.Wrap(err, "failed to collect garbage")
+	}
+	if err = s.Compact(); err != nil {
+		return report, err
+	}
+	report.SizeAfter, err = s.size()
+	return report, err
+}
+
+// Compact rewrites the database file without free pages
+func (s *Storage) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := s.db.Path()
+	compactPath := path + ".compact"
+	compacted, err := bolt.Open(compactPath, 0600, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create compacted database")
+	}
+	if err = bolt.Compact(compacted, s.db, compactTxMaxSize); err != nil {
+		compacted.Close()
+		os.Remove(compactPath)
+		return errors.Wrap(err, "failed to compact database")
+	}
+	if err = compacted.Close(); err != nil {
+		os.Remove(compactPath)
+		return errors.Wrap(err, "failed to close compacted database")
+	}
+	if err = s.db.Close(); err != nil {
+		os.Remove(compactPath)
+		return errors.Wrap(err, "failed to close database")
+	}
+	renameErr := os.Rename(compactPath, path)
+	// database is reopened even if rename failed, storage is unusable otherwise
+	if s.db, err = bolt.Open(path, 0600, nil); err != nil {
+		return errors.Wrap(err, "failed to reopen database")
+	}
+	return errors.Wrap(renameErr, "failed to replace database with compacted one")
+}
+
+func (s *Storage) size() (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	info, err := os.Stat(s.db.Path())
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// referencedBuckets returns names of buckets used by texts of all users
+func referencedBuckets(tx *bolt.Tx) (map[string]struct{}, error) {
+	referenced := make(map[string]struct{})
+	b := tx.Bucket(bktUserInfo)
+	if b == nil {
+		return referenced, nil
+	}
+	err := b.ForEach(func(k, v []byte) error {
+		if !bytes.HasPrefix(k, textsPrefix) {
+			return nil
+		}
+		texts, err := unmarshalTexts(v)
+		if err != nil {
+			return err
+		}
+		for _, text := range texts.Texts {
+			referenced[string(text.BucketName)] = struct{}{}
+		}
+		return nil
+	})
+	return referenced, err
+}
+
+// sweepProcessedFiles removes processed files with unreferenced buckets, kept files are added to referenced.
+// Returns number of removed files.
+func sweepProcessedFiles(tx *bolt.Tx, referenced map[string]struct{}, before time.Time) (int, error) {
+	b := tx.Bucket(bktProcessedFiles)
+	if b == nil {
+		return 0, nil
+	}
+	var garbage [][]byte
+	kept := make(map[string]struct{})
+	err := b.ForEach(func(k, v []byte) error {
+		var pf ProcessedFile
+		if err := json.Unmarshal(v, &pf); err != nil {
+			return errors.Wrap(err, "failed to unmarshal processed file")
+		}
+		if _, ok := referenced[string(pf.BucketName)]; ok || !pf.CreatedAt.Before(before) {
+			kept[string(pf.BucketName)] = struct{}{}
+			return nil
+		}
+		garbage = append(garbage, bytes.Clone(k))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for name := range kept {
+		referenced[name] = struct{}{}
+	}
+
+	var removedFirst [][]byte
+	for _, k := range garbage {
+		if err = b.Delete(k); err != nil {
+			return 0, err
+		}
+		if !bytes.Contains(k, processedFileVariantSeparator) {
+			removedFirst = append(removedFirst, k)
+		}
+	}
+	// other variant of the file becomes the first one, otherwise variants can't be found by checksum
+	for _, checksum := range removedFirst {
+		prefix := append(bytes.Clone(checksum), processedFileVariantSeparator...)
+		k, v := b.Cursor().Seek(prefix)
+		if k == nil || !bytes.HasPrefix(k, prefix) {
+			continue
+		}
+		v = bytes.Clone(v)
+		if err = b.Delete(k); err != nil {
+			return 0, err
+		}
+		if err = b.Put(checksum, v); err != nil {
+			return 0, err
+		}
+	}
+	return len(garbage), nil
+}
+
+// sweepTextBuckets removes text buckets that are not referenced, returns number of removed buckets
+func sweepTextBuckets(tx *bolt.Tx, referenced map[string]struct{}) (int, error) {
+	var garbage [][]byte
+	err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		if _, ok := referenced[string(name)]; ok || b.Get(fullTextKey) == nil {
+			return nil
+		}
+		garbage = append(garbage, bytes.Clone(name))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, name := range garbage {
+		if err = tx.DeleteBucket(name); err != nil {
+			return 0, err
+		}
+	}
+	return len(garbage), nil
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// bktReadingEvents has a bucket of events for every user,
+// events are keyed by time and sequence number, so they are iterated in order
+var bktReadingEvents = []byte("reading_events")
+
+// AddReadingEvent appends event to the reading history of the user
+func (s *Storage) AddReadingEvent(userID int64, event ReadingEvent) error {
+	return s.update(func(tx *bolt.Tx) error {
+		return addReadingEvents(tx, int64ToBytes(userID), []ReadingEvent{event})
+	})
+}
+
+// GetReadingEvents returns events of the user which happened at or after since, the oldest first
+func (s *Storage) GetReadingEvents(userID int64, since time.Time) ([]ReadingEvent, error) {
+	var events []ReadingEvent
+	err := s.view(func(tx *bolt.Tx) error {
+		var err error
+		events, err = getReadingEvents(tx, int64ToBytes(userID), since)
+		return err
+	})
+	return events, err
+}
+
+func getReadingEvents(tx *bolt.Tx, id []byte, since time.Time) ([]ReadingEvent, error) {
+	b := tx.Bucket(bktReadingEvents)
+	if b == nil {
+		return nil, nil
+	}
+	if b = b.Bucket(id); b == nil {
+		return nil, nil
+	}
+	var events []ReadingEvent
+	cur := b.Cursor()
+	for k, v := cur.Seek(int64ToBytes(unixNano(since))); k != nil; k, v = cur.Next() {
+		var event ReadingEvent
+		if err := json.Unmarshal(v, &event); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal reading event")
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func addReadingEvents(tx *bolt.Tx, id []byte, events []ReadingEvent) error {
+	b, err := tx.CreateBucketIfNotExists(bktReadingEvents)
+	if err != nil {
+		return err
+	}
+	if b, err = b.CreateBucketIfNotExists(id); err != nil {
+		return err
+	}
+	for _, event := range events {
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		key := binary.BigEndian.AppendUint64(int64ToBytes(unixNano(event.At)), seq)
+		if err = b.Put(key, encoded); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importReadingEvents adds restored events which the user doesn't have yet, texts are
+// matched by textUUIDs. When replacing, events of the user are deleted first.
+func importReadingEvents(tx *bolt.Tx, id []byte, backup Backup, textUUIDs map[string]string, replace bool) error {
+	var events []ReadingEvent
+	if replace {
+		if b := tx.Bucket(bktReadingEvents); b != nil {
+			if err := b.DeleteBucket(id); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+	} else {
+		var err error
+		if events, err = getReadingEvents(tx, id, time.Time{}); err != nil {
+			return err
+		}
+	}
+	return addReadingEvents(tx, id, restoredReadingEvents(events, backup.History, textUUIDs))
+}
+
+// restoredReadingEvents returns restored events which are missing in events
+func restoredReadingEvents(events, restored []ReadingEvent, textUUIDs map[string]string) []ReadingEvent {
+	type eventKey struct {
+		at       int64
+		textUUID string
+		chunk    int64
+	}
+	exists := make(map[eventKey]bool, len(events))
+	for _, event := range events {
+		exists[eventKey{unixNano(event.At), event.TextUUID, event.Chunk}] = true
+	}
+	var missing []ReadingEvent
+	for _, event := range restored {
+		// events of deleted texts keep the uuid they had
+		if textUUID, ok := textUUIDs[event.TextUUID]; ok {
+			event.TextUUID = textUUID
+		}
+		key := eventKey{unixNano(event.At), event.TextUUID, event.Chunk}
+		if !exists[key] {
+			exists[key] = true
+			missing = append(missing, event)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"time"
+
+	"github.com/pechorka/adhd-reader/pkg/markup"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Schema version of the database is the number of applied migrations, it's kept in the meta bucket.
+// Databases created before versioning have no version, all migrations are applied to them.
+// New migrations are appended to the end of the list, applied migrations must not be changed or removed.
+
+var (
+	bktMeta          = []byte("meta")
+	schemaVersionKey = []byte("schema_version")
+)
+
+var ErrSchemaTooNew = errors.New("database schema is newer than supported")
+
+type migration struct {
+	name    string
+	migrate func(tx *bolt.Tx) error
+}
+
+var migrations = []migration{
+	{name: "set states of texts", migrate: setTextStates},
+	{name: "escape markup of plain texts", migrate: escapePlainTexts},
+	{name: "set positions of texts", migrate: setTextPositions},
+}
+
+// MigrationReport describes migrations applied to the database
+type MigrationReport struct {
+	From    int64    // schema version before migrations
+	To      int64    // schema version after migrations
+	Applied []string // names of applied migrations
+}
+
+// SchemaVersion returns version of the database schema
+func (s *Storage) SchemaVersion() (int64, error) {
+	var version int64
+	err := s.view(func(tx *bolt.Tx) error {
+		if meta := tx.Bucket(bktMeta); meta != nil {
+			version = getSchemaVersion(meta)
+		}
+		return nil
+	})
+	return version, err
+}
+
+// DryRunMigrations applies pending migrations to the database at path and rolls them back.
+// It returns migrations that would be applied when the storage is opened.
+func DryRunMigrations(path string) (MigrationReport, error) {
+	// bolt creates missing database, dry run must not
+	if _, err := os.Stat(path); err != nil {
+		return MigrationReport{}, errors.Wrap(err, "failed to open database")
+	}
+	// bot holds the lock of the database while it's running
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return MigrationReport{}, errors.Wrap(err, "failed to open database")
+	}
+	defer db.Close()
+	var report MigrationReport
+	errDryRun := errors.New("dry run")
+	err = db.Update(func(tx *bolt.Tx) error {
+		if report, err = migrateTx(tx); err != nil {
+			return err
+		}
+		return errDryRun
+	})
+	if err != errDryRun {
+		return report, err
+	}
+	return report, nil
+}
+
+// migrate applies pending migrations in one transaction, so database is never left half migrated
+func migrate(db *bolt.DB) (report MigrationReport, err error) {
+	err = db.Update(func(tx *bolt.Tx) error {
+		report, err = migrateTx(tx)
+		return err
+	})
+	return report, err
+}
+
+func migrateTx(tx *bolt.Tx) (MigrationReport, error) {
+	meta, err := tx.CreateBucketIfNotExists(bktMeta)
+	if err != nil {
+		return MigrationReport{}, err
+	}
+	report := MigrationReport{
+		From: getSchemaVersion(meta),
+		To:   int64(len(migrations)),
+	}
+	if report.From > report.To {
+		return report, errors.Wrapf(ErrSchemaTooNew, "database version %d, supported version %d", report.From, report.To)
+	}
+	for i, m := range migrations[report.From:] {
+		if err = m.migrate(tx); err != nil {
+			return report, errors.Wrapf(err, "migration %d (%s) failed", report.From+int64(i)+1, m.name)
+		}
+		report.Applied = append(report.Applied, m.name)
+	}
+	return report, meta.Put(schemaVersionKey, int64ToBytes(report.To))
+}
+
+func getSchemaVersion(meta *bolt.Bucket) int64 {
+	v := meta.Get(schemaVersionKey)
+	if v == nil {
+		return 0
+	}
+	return bytesToInt64(v)
+}
+
+// setTextStates sets states of texts saved before states were introduced
+func setTextStates(tx *bolt.Tx) error {
+	b := tx.Bucket(bktUserInfo)
+	if b == nil {
+		return nil
+	}
+	updated := make(map[string]UserTexts)
+	c := b.Cursor()
+	for k, v := c.Seek(textsPrefix); k != nil && bytes.HasPrefix(k, textsPrefix); k, v = c.Next() {
+		texts, err := unmarshalTexts(v)
+		if err != nil {
+			return errors.Wrapf(err, "failed to unmarshal %s", k)
+		}
+		changed := false
+		for i, text := range texts.Texts {
+			if text.State != "" {
+				continue
+			}
+			var totalChunks int64
+			if textBucket := tx.Bucket(text.BucketName); textBucket != nil {
+				totalChunks = bytesToInt64(textBucket.Get(totalChunksKey))
+			}
+			texts.Texts[i].State = progressState(text.CurrentChunk, totalChunks)
+			changed = true
+		}
+		if changed {
+			updated[string(k)] = texts
+		}
+	}
+	// bucket can't be changed while it's iterated
+	for k, texts := range updated {
+		if err := putTexts(b, []byte(k), texts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// escapePlainTexts escapes special characters of texts saved before texts were kept in markup,
+// otherwise lines like "- item" become list items and backslashes disappear.
+// Such texts are the ones without length, they are never encrypted.
+func escapePlainTexts(tx *bolt.Tx) error {
+	var plain [][]byte
+	err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		if b.Get(fullTextKey) != nil && b.Get(lengthKey) == nil {
+			plain = append(plain, bytes.Clone(name))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range plain {
+		textBucket := tx.Bucket(name)
+		chunks := getPlainChunks(textBucket)
+		for i, chunk := range chunks {
+			chunks[i] = markup.Escape(chunk)
+		}
+		if err = textBucket.Put(fullTextKey, []byte(markup.Escape(string(textBucket.Get(fullTextKey))))); err != nil {
+			return err
+		}
+		// length and offsets are saved too, so texts are escaped only once
+		if err = putChunks(textBucket, chunks, nil); err != nil {
+			return errors.Wrapf(err, "failed to escape text %s", name)
+		}
+	}
+	return nil
+}
+
+// setTextPositions sets reading positions of texts saved before positions were introduced,
+// such texts have only current chunk
+func setTextPositions(tx *bolt.Tx) error {
+	b := tx.Bucket(bktUserInfo)
+	if b == nil {
+		return nil
+	}
+	updated := make(map[string]UserTexts)
+	c := b.Cursor()
+	for k, v := c.Seek(textsPrefix); k != nil && bytes.HasPrefix(k, textsPrefix); k, v = c.Next() {
+		texts, err := unmarshalTexts(v)
+		if err != nil {
+			return errors.Wrapf(err, "failed to unmarshal %s", k)
+		}
+		changed := false
+		for i, text := range texts.Texts {
+			if text.Position != 0 || text.CurrentChunk == 0 {
+				continue
+			}
+			textBucket := tx.Bucket(text.BucketName)
+			switch {
+			case text.CurrentChunk == NotSelected:
+				texts.Texts[i].Position = NotSelected
+			case textBucket != nil:
+				texts.Texts[i].Position = max(positionAt(getOffsets(textBucket), text.CurrentChunk), 0)
+			default:
+				continue
+			}
+			changed = true
+		}
+		if changed {
+			updated[string(k)] = texts
+		}
+	}
+	// bucket can't be changed while it's iterated
+	for k, texts := range updated {
+		if err := putTexts(b, []byte(k), texts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
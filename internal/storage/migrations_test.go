@@ -0,0 +1,180 @@
+package storage_test
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pechorka/adhd-reader/internal/storage"
+	"github.com/pechorka/adhd-reader/pkg/markup"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+// fixtureDB copies fixture database to temp dir, so tests don't change it.
+// testdata/v0.db is created before schema versioning: user 1 has texts "queued",
+// "reading" and "finished" with 3 chunks, reading is on chunk 1, finished on chunk 2.
+func fixtureDB(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	return path
+}
+
+func TestMigrations(t *testing.T) {
+	path := fixtureDB(t, "v0.db")
+	latest := storage.MigrationReport{From: 0, To: 3, Applied: []string{
+		"set states of texts", "escape markup of plain texts", "set positions of texts",
+	}}
+
+	// dry run doesn't change database
+	for i := 0; i < 2; i++ {
+		report, err := storage.DryRunMigrations(path)
+		require.NoError(t, err)
+		require.Equal(t, latest, report)
+	}
+
+	s, err := storage.NewStorage(path)
+	require.NoError(t, err)
+	version, err := s.SchemaVersion()
+	require.NoError(t, err)
+	require.Equal(t, latest.To, version)
+	texts, err := s.GetTexts(1)
+	require.NoError(t, err)
+	require.Len(t, texts, 3)
+	// texts saved before positions have only current chunk, chunks are "First.", "Second." and "Third."
+	positions := map[string]int64{"queued": storage.NotSelected, "reading": 6, "finished": 13}
+	for _, text := range texts {
+		require.Equal(t, storage.TextState(text.Name), text.State)
+		content, err := s.GetTextContent(1, text.UUID)
+		require.NoError(t, err)
+		require.Equal(t, positions[text.Name], content.Text.Position, text.Name)
+	}
+	chunkSize, err := s.GetChunkSize(1)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), chunkSize)
+	require.NoError(t, s.Close())
+
+	report, err := storage.DryRunMigrations(path)
+	require.NoError(t, err)
+	require.Equal(t, storage.MigrationReport{From: latest.To, To: latest.To}, report)
+}
+
+func TestMigrations_NewDatabase(t *testing.T) {
+	s, err := storage.NewStorage(filepath.Join(t.TempDir(), "new.db"))
+	require.NoError(t, err)
+	defer s.Close()
+	version, err := s.SchemaVersion()
+	require.NoError(t, err)
+	require.Equal(t, int64(3), version)
+}
+
+func TestMigrations_SchemaTooNew(t *testing.T) {
+	path := fixtureDB(t, "v0.db")
+	db, err := bolt.Open(path, 0600, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists([]byte("meta"))
+		if err != nil {
+			return err
+		}
+		return meta.Put([]byte("schema_version"), []byte{0, 0, 0, 0, 0, 0, 0, 100})
+	}))
+	require.NoError(t, db.Close())
+
+	_, err = storage.NewStorage(path)
+	require.ErrorIs(t, err, storage.ErrSchemaTooNew)
+	_, err = storage.DryRunMigrations(path)
+	require.ErrorIs(t, err, storage.ErrSchemaTooNew)
+}
+
+func TestMigrations_EscapePlainTexts(t *testing.T) {
+	path := fixtureDB(t, "v0.db")
+	plain := []string{"- not a list\\", "# not a heading", "__init__ [a](b) `x`"}
+	db, err := bolt.Open(path, 0600, nil)
+	require.NoError(t, err)
+	// bucket of the "reading" text
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("7eba8734-f4e6-4ea3-b5c5-0f7e50360088"))
+		for i, chunk := range plain {
+			if err := b.Put([]byte{0, 0, 0, 0, 0, 0, 0, byte(i)}, []byte(chunk)); err != nil {
+				return err
+			}
+		}
+		return b.Put([]byte("full_text"), []byte(strings.Join(plain, "\n")))
+	}))
+	require.NoError(t, db.Close())
+
+	s, err := storage.NewStorage(path)
+	require.NoError(t, err)
+	defer s.Close()
+	content, err := s.GetTextContent(1, "0dc0441b-74ea-4477-be63-c4648020d5f3")
+	require.NoError(t, err)
+	require.Equal(t, strings.Join(plain, "\n"), markup.PlainText(content.FullText))
+	for i, chunk := range content.Chunks {
+		require.Equal(t, plain[i], markup.PlainText(chunk))
+	}
+	texts, err := s.GetTexts(1)
+	require.NoError(t, err)
+	// white space isn't counted in length
+	require.EqualValues(t, len(strings.Join(strings.Fields(strings.Join(plain, " ")), "")), texts[1].Length)
+}
+
+func TestSQLiteMigrations_EscapePlainTexts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+	s, err := storage.NewSQLiteStorage(path)
+	require.NoError(t, err)
+	plain := "- not a list\\"
+	textID, err := s.AddText(1, storage.NewText{Name: "plain", Text: plain, Chunks: []string{plain}})
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	// contents saved before length was saved, schema version before the migration
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	_, err = db.Exec(`UPDATE contents SET length = NULL, offsets = NULL`)
+	require.NoError(t, err)
+	_, err = db.Exec(`PRAGMA user_version = 7`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	s, err = storage.NewSQLiteStorage(path)
+	require.NoError(t, err)
+	defer s.Close()
+	content, err := s.GetTextContent(1, textID)
+	require.NoError(t, err)
+	require.Equal(t, plain, markup.PlainText(content.FullText))
+	require.Equal(t, plain, markup.PlainText(content.Chunks[0]))
+	texts, err := s.GetTexts(1)
+	require.NoError(t, err)
+	require.EqualValues(t, len(strings.Join(strings.Fields(plain), "")), texts[0].Length)
+}
+
+func TestSQLiteMigrations_SetTextPositions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+	s, err := storage.NewSQLiteStorage(path)
+	require.NoError(t, err)
+	textID, err := s.AddText(1, storage.NewText{Name: "text", Text: "First. Second.", Chunks: []string{"First.", "Second."}})
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	// text saved before positions, schema version before the migration
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	_, err = db.Exec(`UPDATE texts SET current_chunk = 1, position = 0`)
+	require.NoError(t, err)
+	_, err = db.Exec(`PRAGMA user_version = 8`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	s, err = storage.NewSQLiteStorage(path)
+	require.NoError(t, err)
+	defer s.Close()
+	content, err := s.GetTextContent(1, textID)
+	require.NoError(t, err)
+	require.EqualValues(t, len("First."), content.Text.Position)
+}
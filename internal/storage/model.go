@@ -12,14 +12,36 @@ type TextSource string
 const (
 	SourceText TextSource = "text"
 	SourceFile TextSource = "file"
+	SourceURL  TextSource = "url" // web page, texts saved before it have SourceText
 )
 
+// TextState is the place of the text in the user's reading list
+type TextState string
+
+const (
+	StateQueued    TextState = "queued" // added, but not opened yet
+	StateReading   TextState = "reading"
+	StatePaused    TextState = "paused" // put aside by the user for a while
+	StateFinished  TextState = "finished"
+	StateAbandoned TextState = "abandoned" // user is not going to finish it
+)
+
+// TextStates are all states in the order of the reading list
+var TextStates = []TextState{StateQueued, StateReading, StatePaused, StateFinished, StateAbandoned}
+
 type Text struct {
 	UUID         string
 	Name         string
 	Source       TextSource
 	BucketName   []byte
-	CurrentChunk int64
+	CurrentChunk int64  // chunk containing Position
+	Position     int64  // visible characters before the reading position, doesn't change when text is split again
+	Language     string // language code of the text
+	ChunkSize    int64  // chunk size text was split with, 0 for texts split before it was saved
+	CheckSum     []byte // checksum of the file for texts from file
+	State        TextState
+	Tags         []string // lower case tags without #
+	Encrypted    bool     // content is encrypted with the user's data key, see Storage.EncryptTexts
 	CreatedAt    time.Time
 	ModifiedAt   time.Time
 }
@@ -27,22 +49,45 @@ type Text struct {
 type TextWithChunkInfo struct {
 	UUID         string
 	Name         string
+	Source       TextSource
+	Language     string
+	State        TextState
+	Tags         []string
 	CurrentChunk int64
 	TotalChunks  int64
+	Length       int64 // visible characters in the text, see Text.Position
+	CreatedAt    time.Time
+	ModifiedAt   time.Time
 }
 
 type TextWithChunks struct {
 	UUID         string
 	Name         string
+	State        TextState
+	Tags         []string
 	CurrentChunk int64
+	Position     int64
 	Chunks       []string
+	Offsets      []int64 // positions of chunks beginnings
+}
+
+// TextContent is the text with everything saved in its bucket
+type TextContent struct {
+	Text        Text
+	FullText    string
+	Chunks      []string
+	Attachments map[string][]byte
 }
 
 type NewText struct {
-	Name      string
-	Text      string
-	Chunks    []string
-	ChunkSize int64
+	Name        string
+	Source      TextSource // SourceText if empty
+	Text        string
+	Chunks      []string
+	ChunkSize   int64
+	Language    string
+	CheckSum    []byte // checksum of the file for texts from file
+	Attachments map[string][]byte
 }
 
 type UserAnalytics struct {
@@ -54,17 +99,85 @@ type UserAnalytics struct {
 }
 
 type NewProcessedFile struct {
-	Text      string
-	Chunks    []string
-	ChunkSize int64
-	CheckSum  []byte
+	Text        string
+	Chunks      []string
+	ChunkSize   int64
+	Language    string
+	CheckSum    []byte
+	Attachments map[string][]byte
 }
 
 type ProcessedFile struct {
 	UUID       string
 	BucketName []byte
 	ChunkSize  int64
+	Language   string
 	CheckSum   []byte
+	CreatedAt  time.Time // zero for files processed before it was saved
+}
+
+// CurrentChunk is the chunk user reads now
+type CurrentChunk struct {
+	Text     Text
+	Chunk    string
+	Position int64 // position of the beginning of the chunk
+}
+
+// Bookmark is a saved reading position in the text
+type Bookmark struct {
+	UUID      string
+	TextUUID  string
+	Position  int64  // reading position, see Text.Position
+	Label     string // optional
+	CreatedAt time.Time
+}
+
+// Highlight is a saved fragment of the text with a note
+type Highlight struct {
+	UUID      string
+	TextUUID  string
+	Start     int64  // position of the beginning of the fragment, see Text.Position
+	End       int64  // position after the end of the fragment
+	Text      string // fragment of the text without markup
+	Note      string // optional
+	CreatedAt time.Time
+}
+
+// SearchMatch is a chunk of the user's text that contains all words of the search query
+type SearchMatch struct {
+	Text      Text
+	Chunk     int64 // index of the chunk
+	ChunkText string
+}
+
+// Rechunked is text split into chunks again
+type Rechunked struct {
+	Chunks    []string
+	ChunkSize int64
+}
+
+// ListPreferences is how the user sorts and filters the list of texts
+type ListPreferences struct {
+	Sort   string // sort key, see service.TextSort
+	Filter string // filter in the format of service.ParseTextFilter
+}
+
+// Reading holds how the user reads: chunk size in time and reading speeds
+type Reading struct {
+	ChunkSeconds int64            // chunk size in seconds of reading, 0 if chunk size is set in characters
+	Speeds       map[string]Speed // map[language code]speed, empty language is used for all languages
+	LastChunkAt  time.Time        // when the last chunk was shown
+	LastChunkLen int64            // length of the last shown chunk in runes
+	LastLanguage string           // language of the last shown chunk
+	GoalChunks   int64            // daily goal in chunks, 0 if not set
+	GoalMinutes  int64            // daily goal in minutes of reading, 0 if not set
+	Timezone     string           // IANA name or UTC offset like +03:00 where the user's days start, UTC if empty
+}
+
+type Speed struct {
+	Measured float64 // runes per minute measured from time between chunks
+	Samples  int64   // number of measurements
+	Explicit float64 // runes per minute set by user, 0 if not set
 }
 
 type Dust struct {
@@ -103,6 +216,10 @@ type Recipe struct {
 	RecipeDustMax Dust
 	RecipeHerbMin Herb
 	RecipeHerbMax Herb
+	// crafted item gives the buff for the duration
+	Buff       BuffKind
+	Multiplier float64
+	Duration   time.Duration
 }
 
 type UserRecipe struct {
@@ -111,3 +228,56 @@ type UserRecipe struct {
 	IdealDusts Dust
 	IdealHerbs Herb
 }
+
+// BuffKind is what the buff multiplies
+type BuffKind string
+
+const (
+	BuffLoot BuffKind = "loot" // dust and herbs found in chunks
+	BuffExp  BuffKind = "exp"  // experience for chunks
+)
+
+// Buff is a temporary effect of the crafted item
+type Buff struct {
+	Recipe     string
+	Kind       BuffKind
+	Multiplier float64
+	Until      time.Time
+}
+
+// ReminderMode is the text which chunk is sent by the reminder
+type ReminderMode string
+
+const (
+	ReminderCurrent ReminderMode = "current" // current text, random unread text if it's finished or not selected
+	ReminderRandom  ReminderMode = "random"  // random unread text
+)
+
+// Reminder sends the user the next chunk on schedule
+type Reminder struct {
+	UUID      string
+	Weekdays  []time.Weekday // days when the reminder is sent, every day if empty
+	Hour      int
+	Minute    int
+	Timezone  string // IANA name or UTC offset like +03:00
+	Mode      ReminderMode
+	Language  string    // language code of the reminder messages
+	NextAt    time.Time // when the reminder is sent next time
+	CreatedAt time.Time
+}
+
+// UserReminder is a reminder with the user it belongs to
+type UserReminder struct {
+	UserID   int64
+	Reminder Reminder
+}
+
+// ReadingEvent is a chunk shown to the user by the next chunk button
+type ReadingEvent struct {
+	At       time.Time
+	TextUUID string
+	Chunk    int64 // index of the shown chunk
+	Chars    int64 // visible characters of the chunk
+	Words    int64 // words of the chunk, 0 for events logged before words were counted
+	Seconds  int64 // time to read the chunk at the user's reading speed
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var bktReminders = []byte("reminders")
+
+// AddReminder saves reminder of the user, NextAt of the reminder must be set
+func (s *Storage) AddReminder(userID int64, reminder Reminder) (Reminder, error) {
+	reminder.UUID = uuid.NewString()
+	reminder.CreatedAt = time.Now()
+	err := s.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bktReminders)
+		if err != nil {
+			return err
+		}
+		id := int64ToBytes(userID)
+		reminders, err := getReminders(b, id)
+		if err != nil {
+			return err
+		}
+		return putReminders(b, id, append(reminders, reminder))
+	})
+	return reminder, err
+}
+
+// GetReminders returns reminders of the user in order they were added
+func (s *Storage) GetReminders(userID int64) ([]Reminder, error) {
+	var reminders []Reminder
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktReminders)
+		if b == nil {
+			return nil
+		}
+		var err error
+		reminders, err = getReminders(b, int64ToBytes(userID))
+		return err
+	})
+	return reminders, err
+}
+
+func (s *Storage) DeleteReminder(userID int64, reminderUUID string) error {
+	return s.updateReminder(userID, reminderUUID, func(reminders []Reminder, i int) []Reminder {
+		return slices.Delete(reminders, i, i+1)
+	})
+}
+
+// RescheduleReminder sets when the reminder is sent next time
+func (s *Storage) RescheduleReminder(userID int64, reminderUUID string, nextAt time.Time) error {
+	return s.updateReminder(userID, reminderUUID, func(reminders []Reminder, i int) []Reminder {
+		reminders[i].NextAt = nextAt
+		return reminders
+	})
+}
+
+// updateReminder replaces reminders of the user with result of updFunc, i is index of the reminder.
+// Returns ErrNotFound if the user doesn't have the reminder.
+func (s *Storage) updateReminder(userID int64, reminderUUID string, updFunc func(reminders []Reminder, i int) []Reminder) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktReminders)
+		if b == nil {
+			return ErrNotFound
+		}
+		id := int64ToBytes(userID)
+		reminders, err := getReminders(b, id)
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(reminders, func(reminder Reminder) bool {
+			return reminder.UUID == reminderUUID
+		})
+		if i < 0 {
+			return ErrNotFound
+		}
+		return putReminders(b, id, updFunc(reminders, i))
+	})
+}
+
+// DueReminders returns reminders of all users that should be sent at now, the most overdue first
+func (s *Storage) DueReminders(now time.Time) ([]UserReminder, error) {
+	var due []UserReminder
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktReminders)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(id, _ []byte) error {
+			reminders, err := getReminders(b, id)
+			if err != nil {
+				return err
+			}
+			for _, reminder := range reminders {
+				if !reminder.NextAt.After(now) {
+					due = append(due, UserReminder{UserID: bytesToInt64(id), Reminder: reminder})
+				}
+			}
+			return nil
+		})
+	})
+	slices.SortStableFunc(due, compareDueReminders)
+	return due, err
+}
+
+func compareDueReminders(a, b UserReminder) int {
+	return a.Reminder.NextAt.Compare(b.Reminder.NextAt)
+}
+
+func getReminders(b *bolt.Bucket, id []byte) (reminders []Reminder, err error) {
+	v := b.Get(id)
+	if v == nil {
+		return reminders, nil
+	}
+	err = json.Unmarshal(v, &reminders)
+	if err != nil {
+		return reminders, errors.Wrap(err, "failed to unmarshal reminders")
+	}
+	return reminders, nil
+}
+
+func putReminders(b *bolt.Bucket, id []byte, reminders []Reminder) error {
+	if len(reminders) == 0 {
+		return b.Delete(id)
+	}
+	encoded, err := json.Marshal(reminders)
+	if err != nil {
+		return err
+	}
+	return b.Put(id, encoded)
+}
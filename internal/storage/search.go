@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"bytes"
+
+	"github.com/pechorka/adhd-reader/pkg/textspliter"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Search index maps words of the user's texts to chunks containing them.
+// Key is user id + text uuid + 0 + word, value is sorted indexes of chunks with the word.
+// Key with only user id marks that all user's texts are indexed: texts added before
+// search was introduced are indexed on the first search. Words of encrypted texts are
+// saved as their HMAC, see textCipher.term.
+
+var indexedMark = []byte{1}
+
+// Search returns page of chunks that contain all words of the query, pages start from 1.
+// Chunks are ordered as user's texts and then by index. More is true if there are more pages.
+func (s *Storage) Search(userID int64, query string, page, pageSize int) (matches []SearchMatch, more bool, err error) {
+	if err = s.ensureIndexed(userID); err != nil {
+		return nil, false, errors.Wrap(err, "failed to index texts")
+	}
+	err = s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktUserInfo)
+		index := tx.Bucket(bktSearchIndex)
+		if b == nil || index == nil {
+			return nil
+		}
+		texts, err := getTexts(b, textsId(userID))
+		if err != nil {
+			return err
+		}
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		skip := (page - 1) * pageSize
+		for _, text := range texts.Texts {
+			textBucket := tx.Bucket(text.BucketName)
+			if textBucket == nil {
+				return errors.New("unexpected error: text bucket not found")
+			}
+			tc := c.of(text)
+			language, err := textLanguage(text, textBucket, tc)
+			if err != nil {
+				return err
+			}
+			terms := textspliter.Terms(query, language)
+			for i, term := range terms {
+				terms[i] = tc.term(term)
+			}
+			for _, chunk := range searchText(index, userID, text.UUID, terms) {
+				if skip > 0 {
+					skip--
+					continue
+				}
+				if len(matches) == pageSize {
+					more = true
+					return nil
+				}
+				chunkText, err := getChunk(textBucket, chunk, tc)
+				if err != nil {
+					return err
+				}
+				matches = append(matches, SearchMatch{
+					Text:      text,
+					Chunk:     chunk,
+					ChunkText: chunkText,
+				})
+			}
+		}
+		return nil
+	})
+	return matches, more, errors.Wrap(err, "failed to search texts")
+}
+
+// searchText returns indexes of the text chunks that contain all terms
+func searchText(index *bolt.Bucket, userID int64, textUUID string, terms []string) []int64 {
+	if len(terms) == 0 {
+		return nil
+	}
+	prefix := searchIndexPrefix(userID, textUUID)
+	var chunks []int64
+	for i, term := range terms {
+		v := index.Get(append(prefix, term...))
+		if v == nil {
+			return nil
+		}
+		if i == 0 {
+			chunks = decodeInt64s(v)
+			continue
+		}
+		chunks = intersectSorted(chunks, decodeInt64s(v))
+	}
+	return chunks
+}
+
+func intersectSorted(a, b []int64) []int64 {
+	var result []int64
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+// ensureIndexed indexes all user's texts if they weren't indexed yet
+func (s *Storage) ensureIndexed(userID int64) error {
+	var indexed bool
+	err := s.view(func(tx *bolt.Tx) error {
+		index := tx.Bucket(bktSearchIndex)
+		indexed = index != nil && index.Get(int64ToBytes(userID)) != nil
+		return nil
+	})
+	if err != nil || indexed {
+		return err
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		index, err := tx.CreateBucketIfNotExists(bktSearchIndex)
+		if err != nil {
+			return err
+		}
+		if b := tx.Bucket(bktUserInfo); b != nil {
+			texts, err := getTexts(b, textsId(userID))
+			if err != nil {
+				return err
+			}
+			c, err := s.userCipher(tx, userID)
+			if err != nil {
+				return err
+			}
+			for _, text := range texts.Texts {
+				if err = indexText(tx, userID, text, c); err != nil {
+					return err
+				}
+			}
+		}
+		return index.Put(int64ToBytes(userID), indexedMark)
+	})
+}
+
+// indexText replaces words of the text in the search index with words of its current chunks,
+// c is cipher of the text owner
+func indexText(tx *bolt.Tx, userID int64, text Text, c *textCipher) error {
+	if err := unindexText(tx, userID, text.UUID); err != nil {
+		return err
+	}
+	index, err := tx.CreateBucketIfNotExists(bktSearchIndex)
+	if err != nil {
+		return err
+	}
+	textBucket := tx.Bucket(text.BucketName)
+	if textBucket == nil {
+		return errors.New("unexpected error: text bucket not found")
+	}
+	c = c.of(text)
+	language, err := textLanguage(text, textBucket, c)
+	if err != nil {
+		return err
+	}
+	chunks, err := getChunks(textBucket, c)
+	if err != nil {
+		return err
+	}
+	termChunks := make(map[string][]int64)
+	for i, chunk := range chunks {
+		for _, term := range textspliter.Terms(chunk, language) {
+			term = c.term(term)
+			chunks := termChunks[term]
+			if len(chunks) == 0 || chunks[len(chunks)-1] != int64(i) {
+				termChunks[term] = append(chunks, int64(i))
+			}
+		}
+	}
+	prefix := searchIndexPrefix(userID, text.UUID)
+	for term, chunks := range termChunks {
+		if err = index.Put(append(prefix, term...), encodeInt64s(chunks)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unindexText removes words of the text from the search index
+func unindexText(tx *bolt.Tx, userID int64, textUUID string) error {
+	index := tx.Bucket(bktSearchIndex)
+	if index == nil {
+		return nil
+	}
+	prefix := searchIndexPrefix(userID, textUUID)
+	var keys [][]byte
+	c := index.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, bytes.Clone(k))
+	}
+	for _, k := range keys {
+		if err := index.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// searchIndexPrefix returns prefix of the index keys of the text, capacity is exact,
+// so appending a word to the prefix doesn't change it
+func searchIndexPrefix(userID int64, textUUID string) []byte {
+	prefix := append(int64ToBytes(userID), textUUID...)
+	prefix = append(prefix, 0)
+	return prefix[:len(prefix):len(prefix)]
+}
+
+// textLanguage returns language of the text, it's detected for texts saved without language
+func textLanguage(text Text, textBucket *bolt.Bucket, c *textCipher) (string, error) {
+	if text.Language != "" {
+		return text.Language, nil
+	}
+	chunk, err := getChunk(textBucket, 0, c)
+	if err != nil {
+		return "", err
+	}
+	return textspliter.DetectLanguage(chunk).Language(), nil
+}
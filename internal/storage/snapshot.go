@@ -0,0 +1,15 @@
+package storage
+
+import (
+	"io"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Snapshot writes consistent copy of the database to w, writes are not blocked while it's written
+func (s *Storage) Snapshot(w io.Writer) error {
+	return s.view(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(w)
+		return err
+	})
+}
@@ -0,0 +1,92 @@
+package storage_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/pechorka/adhd-reader/internal/storage"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestSnapshot_ConcurrentWrites(t *testing.T) {
+	tests := []struct {
+		name  string
+		store storage.Store
+		open  func(path string) (storage.Store, error)
+	}{
+		{"bolt", tempStorage(t), func(path string) (storage.Store, error) {
+			// snapshot must pass consistency check of bbolt
+			db, err := bolt.Open(path, 0600, nil)
+			if err != nil {
+				return nil, err
+			}
+			err = db.View(func(tx *bolt.Tx) error {
+				for err := range tx.Check() {
+					return err
+				}
+				return nil
+			})
+			if closeErr := db.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return nil, err
+			}
+			return storage.NewStorage(path)
+		}},
+		{"sqlite", sqliteStorage(t), func(path string) (storage.Store, error) {
+			return storage.NewSQLiteStorage(path)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const writers, textsPerWriter = 4, 25
+			var wg sync.WaitGroup
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < textsPerWriter; i++ {
+						_, err := tt.store.AddText(int64(w), storage.NewText{
+							Name:   fmt.Sprintf("text %d", i),
+							Text:   "First. Second.",
+							Chunks: []string{"First.", "Second."},
+						})
+						require.NoError(t, err)
+					}
+				}()
+			}
+
+			var paths []string
+			for i := 0; i < 5; i++ {
+				path := filepath.Join(t.TempDir(), "snapshot.db")
+				f, err := os.Create(path)
+				require.NoError(t, err)
+				require.NoError(t, tt.store.Snapshot(f))
+				require.NoError(t, f.Close())
+				paths = append(paths, path)
+			}
+			wg.Wait()
+
+			for _, path := range paths {
+				s, err := tt.open(path)
+				require.NoError(t, err)
+				for w := 0; w < writers; w++ {
+					texts, err := s.GetTexts(int64(w))
+					require.NoError(t, err)
+					require.LessOrEqual(t, len(texts), textsPerWriter)
+					for _, text := range texts {
+						content, err := s.GetTextContent(int64(w), text.UUID)
+						require.NoError(t, err)
+						require.Equal(t, []string{"First.", "Second."}, content.Chunks)
+					}
+				}
+				require.NoError(t, s.Close())
+			}
+		})
+	}
+}
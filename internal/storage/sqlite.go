@@ -0,0 +1,2114 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pechorka/adhd-reader/pkg/markup"
+	"github.com/pechorka/adhd-reader/pkg/textspliter"
+	"github.com/pkg/errors"
+	_ "modernc.org/sqlite" // pure go driver, no cgo needed
+)
+
+// SQLiteStorage keeps the same data as Storage in SQLite, so it can be queried with SQL.
+// Text buckets of Storage are rows of contents with their chunks and attachments,
+// user texts are rows of texts in the order of the user's list.
+type SQLiteStorage struct {
+	db   *sql.DB
+	keys KeyWrapper // nil if encryption is not configured
+}
+
+// sqliteMigration changes schema with the query or data with migrate, when it can't be done by a query
+type sqliteMigration struct {
+	query   string
+	migrate func(tx *sql.Tx) error
+}
+
+// sqliteMigrations are applied in order, schema version is kept in user_version pragma.
+// New migrations are appended to the end of the list, applied migrations must not be changed.
+var sqliteMigrations = []sqliteMigration{
+	{query: `
+CREATE TABLE users (
+	user_id      INTEGER PRIMARY KEY,
+	chunk_size   INTEGER NOT NULL DEFAULT 0,
+	current_text TEXT -- uuid of the selected text
+);
+
+CREATE TABLE contents (
+	id           TEXT PRIMARY KEY, -- bucket name in bbolt storage
+	full_text    TEXT NOT NULL,
+	total_chunks INTEGER NOT NULL DEFAULT 0,
+	offsets      BLOB -- positions of chunks beginnings, see Text.Position
+);
+
+CREATE TABLE chunks (
+	content_id TEXT NOT NULL REFERENCES contents (id) ON DELETE CASCADE,
+	idx        INTEGER NOT NULL,
+	text       TEXT NOT NULL,
+	PRIMARY KEY (content_id, idx)
+);
+
+CREATE TABLE attachments (
+	content_id TEXT NOT NULL REFERENCES contents (id) ON DELETE CASCADE,
+	name       TEXT NOT NULL,
+	data       BLOB NOT NULL,
+	PRIMARY KEY (content_id, name)
+);
+
+CREATE TABLE texts (
+	user_id       INTEGER NOT NULL,
+	idx           INTEGER NOT NULL, -- order in the user's list
+	uuid          TEXT NOT NULL,
+	name          TEXT NOT NULL,
+	source        TEXT NOT NULL,
+	content_id    TEXT NOT NULL,
+	current_chunk INTEGER NOT NULL,
+	position      INTEGER NOT NULL,
+	language      TEXT NOT NULL,
+	chunk_size    INTEGER NOT NULL,
+	checksum      BLOB,
+	state         TEXT NOT NULL,
+	tags          TEXT NOT NULL, -- json array
+	created_at    INTEGER NOT NULL, -- unix nanoseconds
+	modified_at   INTEGER NOT NULL,
+	PRIMARY KEY (user_id, uuid)
+);
+CREATE INDEX texts_content_id ON texts (content_id);
+
+-- files shared between users, first saved variant of the file is the one with the smallest rowid
+CREATE TABLE processed_files (
+	checksum   BLOB NOT NULL,
+	chunk_size INTEGER NOT NULL,
+	uuid       TEXT NOT NULL,
+	content_id TEXT NOT NULL,
+	language   TEXT NOT NULL,
+	PRIMARY KEY (checksum, chunk_size)
+);
+
+CREATE TABLE search_terms (
+	user_id   INTEGER NOT NULL,
+	text_uuid TEXT NOT NULL,
+	term      TEXT NOT NULL,
+	chunk     INTEGER NOT NULL,
+	PRIMARY KEY (user_id, text_uuid, term, chunk)
+) WITHOUT ROWID;
+
+CREATE TABLE bookmarks (
+	user_id    INTEGER NOT NULL,
+	uuid       TEXT NOT NULL,
+	text_uuid  TEXT NOT NULL,
+	position   INTEGER NOT NULL,
+	label      TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	PRIMARY KEY (user_id, uuid)
+);
+
+CREATE TABLE highlights (
+	user_id        INTEGER NOT NULL,
+	uuid           TEXT NOT NULL,
+	text_uuid      TEXT NOT NULL,
+	start_position INTEGER NOT NULL,
+	end_position   INTEGER NOT NULL,
+	text           TEXT NOT NULL,
+	note           TEXT NOT NULL,
+	created_at     INTEGER NOT NULL,
+	PRIMARY KEY (user_id, uuid)
+);
+
+CREATE TABLE readings (
+	user_id INTEGER PRIMARY KEY,
+	data    TEXT NOT NULL -- json of Reading
+);
+
+CREATE TABLE list_preferences (
+	user_id INTEGER PRIMARY KEY,
+	sort    TEXT NOT NULL,
+	filter  TEXT NOT NULL
+);
+
+CREATE TABLE dust (
+	user_id INTEGER PRIMARY KEY,
+	red     INTEGER NOT NULL,
+	orange  INTEGER NOT NULL,
+	yellow  INTEGER NOT NULL,
+	green   INTEGER NOT NULL,
+	blue    INTEGER NOT NULL,
+	indigo  INTEGER NOT NULL,
+	purple  INTEGER NOT NULL,
+	white   INTEGER NOT NULL,
+	black   INTEGER NOT NULL
+);
+
+CREATE TABLE herbs (
+	user_id INTEGER PRIMARY KEY,
+	lavanda INTEGER NOT NULL,
+	melissa INTEGER NOT NULL
+);
+
+CREATE TABLE levels (
+	user_id    INTEGER PRIMARY KEY,
+	experience INTEGER NOT NULL
+);
+
+CREATE TABLE stats (
+	user_id         INTEGER PRIMARY KEY,
+	free            INTEGER NOT NULL,
+	luck            INTEGER NOT NULL,
+	accuracy        INTEGER NOT NULL,
+	attention       INTEGER NOT NULL,
+	time_management INTEGER NOT NULL,
+	charizma        INTEGER NOT NULL
+);
+
+CREATE TABLE recipes (
+	name TEXT PRIMARY KEY,
+	data TEXT NOT NULL -- json of Recipe
+);
+
+CREATE TABLE user_recipes (
+	user_id     INTEGER NOT NULL,
+	recipe_name TEXT NOT NULL,
+	data        TEXT NOT NULL, -- json of UserRecipe
+	PRIMARY KEY (user_id, recipe_name)
+);
+
+CREATE TABLE auth_tokens (
+	user_id INTEGER PRIMARY KEY,
+	token   TEXT NOT NULL UNIQUE
+);
+`},
+	{query: `ALTER TABLE processed_files ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;`},
+	{query: `
+-- data keys of users with encrypted texts, encrypted by KeyWrapper
+CREATE TABLE data_keys (
+	user_id INTEGER PRIMARY KEY,
+	wrapped BLOB NOT NULL
+);
+ALTER TABLE texts ADD COLUMN encrypted INTEGER NOT NULL DEFAULT 0;
+-- visible characters in the text, chunks of encrypted contents can't be measured by query
+ALTER TABLE contents ADD COLUMN length INTEGER;
+`},
+	{query: `
+CREATE TABLE reminders (
+	user_id    INTEGER NOT NULL,
+	uuid       TEXT NOT NULL,
+	weekdays   TEXT NOT NULL, -- json array, every day if empty
+	hour       INTEGER NOT NULL,
+	minute     INTEGER NOT NULL,
+	timezone   TEXT NOT NULL,
+	mode       TEXT NOT NULL,
+	language   TEXT NOT NULL,
+	next_at    INTEGER NOT NULL, -- unix nanoseconds
+	created_at INTEGER NOT NULL,
+	PRIMARY KEY (user_id, uuid)
+);
+CREATE INDEX reminders_next_at ON reminders (next_at);
+`},
+	{query: `
+CREATE TABLE reading_events (
+	user_id   INTEGER NOT NULL,
+	at        INTEGER NOT NULL, -- unix nanoseconds
+	text_uuid TEXT NOT NULL,
+	chunk     INTEGER NOT NULL,
+	chars     INTEGER NOT NULL,
+	seconds   INTEGER NOT NULL
+);
+CREATE INDEX reading_events_user_id_at ON reading_events (user_id, at);
+`},
+	{query: `ALTER TABLE reading_events ADD COLUMN words INTEGER NOT NULL DEFAULT 0;`},
+	{query: `
+CREATE TABLE buffs (
+	user_id INTEGER PRIMARY KEY,
+	data    TEXT NOT NULL -- json array of Buff
+);
+`},
+	{migrate: sqliteEscapePlainTexts},
+	{migrate: sqliteSetTextPositions},
+}
+
+// NewSQLiteStorage opens SQLite database and applies pending migrations
+func NewSQLiteStorage(path string) (*SQLiteStorage, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// writes are serialized by sqlite anyway, one connection avoids busy errors
+	db.SetMaxOpenConns(1)
+	s := &SQLiteStorage{db: db}
+	if err = s.migrate(); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "failed to migrate database")
+	}
+	return s, nil
+}
+
+func (s *SQLiteStorage) migrate() error {
+	return s.update(func(tx *sql.Tx) error {
+		var version int
+		if err := tx.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+			return err
+		}
+		if version > len(sqliteMigrations) {
+			return errors.Wrapf(ErrSchemaTooNew, "database version %d, supported version %d", version, len(sqliteMigrations))
+		}
+		for i, migration := range sqliteMigrations[version:] {
+			var err error
+			if migration.migrate != nil {
+				err = migration.migrate(tx)
+			} else {
+				_, err = tx.Exec(migration.query)
+			}
+			if err != nil {
+				return errors.Wrapf(err, "migration %d failed", version+i+1)
+			}
+		}
+		// pragma doesn't accept parameters
+		_, err := tx.Exec(`PRAGMA user_version = ` + strconv.Itoa(len(sqliteMigrations)))
+		return err
+	})
+}
+
+// sqliteEscapePlainTexts escapes special characters of contents saved before length was saved, see escapePlainTexts.
+// Contents copied from bbolt storage are escaped by its migrations before they are copied.
+func sqliteEscapePlainTexts(tx *sql.Tx) error {
+	ids, err := sqliteStrings(tx, `SELECT id FROM contents WHERE length IS NULL`)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		fullText, err := sqliteGetFullText(tx, id, nil)
+		if err != nil {
+			return err
+		}
+		chunks, err := sqliteGetChunks(tx, id, nil)
+		if err != nil {
+			return err
+		}
+		for i, chunk := range chunks {
+			chunks[i] = markup.Escape(chunk)
+		}
+		if _, err = tx.Exec(`UPDATE contents SET full_text = ? WHERE id = ?`, markup.Escape(fullText), id); err != nil {
+			return err
+		}
+		if err = sqlitePutChunks(tx, id, chunks, nil); err != nil {
+			return errors.Wrapf(err, "failed to escape content %s", id)
+		}
+	}
+	return nil
+}
+
+// sqliteSetTextPositions sets reading positions of texts that have only current chunk, see setTextPositions
+func sqliteSetTextPositions(tx *sql.Tx) error {
+	if _, err := tx.Exec(`UPDATE texts SET position = ? WHERE position = 0 AND current_chunk = ?`, NotSelected, NotSelected); err != nil {
+		return err
+	}
+	rows, err := tx.Query(`
+		SELECT t.user_id, t.uuid, t.current_chunk, c.offsets FROM texts t JOIN contents c ON c.id = t.content_id
+		WHERE t.position = 0 AND t.current_chunk > 0`,
+	)
+	if err != nil {
+		return err
+	}
+	type textPosition struct {
+		userID   int64
+		uuid     string
+		position int64
+	}
+	var positions []textPosition
+	for rows.Next() {
+		var (
+			p            textPosition
+			currentChunk int64
+			offsets      []byte
+		)
+		if err = rows.Scan(&p.userID, &p.uuid, &currentChunk, &offsets); err != nil {
+			rows.Close()
+			return err
+		}
+		p.position = max(positionAt(decodeInt64s(offsets), currentChunk), 0)
+		positions = append(positions, p)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, p := range positions {
+		if _, err = tx.Exec(`UPDATE texts SET position = ? WHERE user_id = ? AND uuid = ?`, p.position, p.userID, p.uuid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the storage
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStorage) update(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) view(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return fn(tx)
+}
+
+func (s *SQLiteStorage) GetCurrentFullText(userID int64) (FullText, error) {
+	var fullText FullText
+	err := s.view(func(tx *sql.Tx) error {
+		texts, err := sqliteGetTexts(tx, userID)
+		if err != nil {
+			return err
+		}
+		if texts.Current == NotSelected {
+			return errors.New("no text selected")
+		}
+		text := texts.Texts[texts.Current]
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		fullText.Text, err = sqliteGetFullText(tx, string(text.BucketName), c.of(text))
+		if err == sql.ErrNoRows {
+			return errors.New("current text has incorrect bucket")
+		}
+		fullText.Name = text.Name
+		return err
+	})
+	return fullText, errors.Wrap(err, "failed to get full text")
+}
+
+func (s *SQLiteStorage) AddText(userID int64, newText NewText) (string, error) {
+	textUUID := uuid.New().String()
+	err := s.update(func(tx *sql.Tx) error {
+		texts, err := sqliteGetTexts(tx, userID)
+		if err != nil {
+			return err
+		}
+		if err = validateUserTexts(texts, textNameUnique(newText.Name)); err != nil {
+			return err
+		}
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		contentID, err := sqliteFillContent(tx, newText.Text, newText.Chunks, newText.Attachments, c)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		source := newText.Source
+		if source == "" {
+			source = SourceText
+		}
+		text := Text{
+			UUID:         textUUID,
+			Name:         newText.Name,
+			Source:       source,
+			BucketName:   []byte(contentID),
+			CurrentChunk: NotSelected,
+			Position:     NotSelected,
+			Language:     newText.Language,
+			State:        StateQueued,
+			ChunkSize:    newText.ChunkSize,
+			CheckSum:     newText.CheckSum,
+			Encrypted:    c != nil,
+			CreatedAt:    now,
+			ModifiedAt:   now,
+		}
+		texts.Texts = append(texts.Texts, text)
+		if err = sqlitePutTexts(tx, userID, texts); err != nil {
+			return err
+		}
+		return sqliteIndexText(tx, userID, text, c)
+	})
+	return textUUID, err
+}
+
+// AddTextFromProcessedFile adds text sharing content with the file, see Storage.AddTextFromProcessedFile
+func (s *SQLiteStorage) AddTextFromProcessedFile(userID int64, name string, pf ProcessedFile) (string, error) {
+	return pf.UUID, s.update(func(tx *sql.Tx) error {
+		texts, err := sqliteGetTexts(tx, userID)
+		if err != nil {
+			return err
+		}
+		if err = validateUserTexts(
+			texts,
+			textNameUnique(name),
+			textUUIDUnique(pf.UUID),
+		); err != nil {
+			return err
+		}
+		var exists bool
+		err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM contents WHERE id = ?)`, string(pf.BucketName)).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrNotFound
+		}
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		bucketName := pf.BucketName
+		if c != nil {
+			if bucketName, err = sqliteCopyContent(tx, string(pf.BucketName), c); err != nil {
+				return err
+			}
+		}
+		now := time.Now()
+		text := Text{
+			UUID:         pf.UUID,
+			Name:         name,
+			Source:       SourceFile,
+			BucketName:   bucketName,
+			CurrentChunk: NotSelected,
+			Position:     NotSelected,
+			Language:     pf.Language,
+			State:        StateQueued,
+			ChunkSize:    pf.ChunkSize,
+			CheckSum:     pf.CheckSum,
+			Encrypted:    c != nil,
+			CreatedAt:    now,
+			ModifiedAt:   now,
+		}
+		texts.Texts = append(texts.Texts, text)
+		if err = sqlitePutTexts(tx, userID, texts); err != nil {
+			return err
+		}
+		return sqliteIndexText(tx, userID, text, c)
+	})
+}
+
+func (s *SQLiteStorage) GetTexts(userID int64) ([]TextWithChunkInfo, error) {
+	var result []TextWithChunkInfo
+	err := s.view(func(tx *sql.Tx) error {
+		infos, err := sqliteTextInfos(tx, `t.user_id = ?`, userID)
+		if err != nil {
+			return err
+		}
+		result = make([]TextWithChunkInfo, 0, len(infos))
+		for _, info := range infos {
+			result = append(result, info.TextWithChunkInfo)
+		}
+		return nil
+	})
+	return result, err
+}
+
+func (s *SQLiteStorage) GetCurrentText(userID int64) (TextWithChunkInfo, error) {
+	var result TextWithChunkInfo
+	err := s.view(func(tx *sql.Tx) error {
+		infos, err := sqliteTextInfos(tx, `t.user_id = ? AND t.uuid = (SELECT current_text FROM users WHERE user_id = ?)`, userID, userID)
+		if err != nil {
+			return errors.Wrap(err, "failed to enrich text")
+		}
+		if len(infos) == 0 {
+			return errors.New("no selected text")
+		}
+		result = infos[0].TextWithChunkInfo
+		return nil
+	})
+	return result, err
+}
+
+func (s *SQLiteStorage) GetFullTexts(userID int64, after *time.Time, page, pageSize int) ([]TextWithChunks, error) {
+	var result []TextWithChunks
+	err := s.view(func(tx *sql.Tx) error {
+		texts, err := sqliteGetTexts(tx, userID)
+		if err != nil {
+			return err
+		}
+		if after != nil {
+			texts.Texts = filterTexts(texts.Texts, func(text Text) bool {
+				return text.CreatedAt.After(*after)
+			})
+		}
+		if page >= 0 {
+			from := min(page*pageSize, len(texts.Texts))
+			to := min(from+pageSize, len(texts.Texts))
+			texts.Texts = texts.Texts[from:to]
+		}
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		result = make([]TextWithChunks, 0, len(texts.Texts))
+		for _, text := range texts.Texts {
+			chunks, err := sqliteGetChunks(tx, string(text.BucketName), c.of(text))
+			if err != nil {
+				return err
+			}
+			_, offsets, err := sqliteContentInfo(tx, string(text.BucketName))
+			if err != nil {
+				return err
+			}
+			result = append(result, TextWithChunks{
+				UUID:         text.UUID,
+				Name:         text.Name,
+				State:        text.State,
+				Tags:         text.Tags,
+				CurrentChunk: text.CurrentChunk,
+				Position:     text.Position,
+				Chunks:       chunks,
+				Offsets:      offsets,
+			})
+		}
+		return nil
+	})
+	return result, err
+}
+
+func (s *SQLiteStorage) UpdateTexts(userID int64, updFunc UpdateTextsFunc) error {
+	return s.update(func(tx *sql.Tx) error {
+		texts, err := sqliteGetTexts(tx, userID)
+		if err != nil {
+			return err
+		}
+		before := make(map[string]Text, len(texts.Texts))
+		for _, text := range texts.Texts {
+			before[text.UUID] = text
+		}
+		if err = updFunc(&texts); err != nil {
+			return err
+		}
+		// keep position and current chunk in sync, whichever was changed
+		for i := range texts.Texts {
+			text := &texts.Texts[i]
+			old, ok := before[text.UUID]
+			if !ok || old.Position == text.Position && old.CurrentChunk == text.CurrentChunk {
+				continue
+			}
+			_, offsets, err := sqliteContentInfo(tx, string(text.BucketName))
+			if err != nil {
+				return err
+			}
+			if old.Position != text.Position {
+				text.CurrentChunk = chunkAt(offsets, text.Position)
+			} else {
+				text.Position = positionAt(offsets, text.CurrentChunk)
+			}
+		}
+		return sqlitePutTexts(tx, userID, texts)
+	})
+}
+
+// SelectChunk moves reading position to the beginning of the chunk selected by updFunc.
+// Returns the text with the new position and the chunk.
+func (s *SQLiteStorage) SelectChunk(userID int64, updFunc SelectChunkFunc) (Text, string, error) {
+	var chunkText string
+	var curText Text
+	err := s.update(func(tx *sql.Tx) error {
+		texts, err := sqliteGetTexts(tx, userID)
+		if err != nil {
+			return err
+		}
+		if texts.Current == NotSelected {
+			return errors.New("no text selected")
+		}
+		curText = texts.Texts[texts.Current]
+		contentID := string(curText.BucketName)
+		totalChunks, offsets, err := sqliteContentInfo(tx, contentID)
+		if err != nil {
+			return err
+		}
+		nextChunk, err := updFunc(curText, curText.CurrentChunk, totalChunks)
+		if err != nil {
+			return err
+		}
+		curText.CurrentChunk = nextChunk
+		curText.Position = positionAt(offsets, nextChunk)
+		curText.State = readingState(curText.State, nextChunk, totalChunks)
+		curText.ModifiedAt = time.Now()
+		texts.Texts[texts.Current] = curText
+		if err = sqlitePutTexts(tx, userID, texts); err != nil {
+			return err
+		}
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		chunkText, err = sqliteGetChunk(tx, contentID, nextChunk, c.of(curText))
+		return err
+	})
+	return curText, chunkText, err
+}
+
+// GetCurrentChunk returns current chunk of the current text without changing reading position
+func (s *SQLiteStorage) GetCurrentChunk(userID int64) (CurrentChunk, error) {
+	var current CurrentChunk
+	err := s.view(func(tx *sql.Tx) error {
+		texts, err := sqliteGetTexts(tx, userID)
+		if err != nil {
+			return err
+		}
+		if texts.Current == NotSelected {
+			return errors.New("no text selected")
+		}
+		curText := texts.Texts[texts.Current]
+		_, offsets, err := sqliteContentInfo(tx, string(curText.BucketName))
+		if err != nil {
+			return err
+		}
+		current.Text = curText
+		current.Position = max(positionAt(offsets, curText.CurrentChunk), 0)
+		if curText.CurrentChunk == NotSelected {
+			return nil
+		}
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		current.Chunk, err = sqliteGetChunk(tx, string(curText.BucketName), curText.CurrentChunk, c.of(curText))
+		return err
+	})
+	return current, err
+}
+
+func (s *SQLiteStorage) GetChunkSize(userID int64) (int64, error) {
+	var chunkSize int64
+	err := s.view(func(tx *sql.Tx) error {
+		var err error
+		chunkSize, err = sqliteGetChunkSize(tx, userID)
+		return err
+	})
+	return chunkSize, err
+}
+
+func (s *SQLiteStorage) SetChunkSize(userID int64, chunkSize int64) error {
+	return s.update(func(tx *sql.Tx) error {
+		return sqlitePutChunkSize(tx, userID, chunkSize)
+	})
+}
+
+func (s *SQLiteStorage) DeleteTextByUUID(userID int64, textUUID string) error {
+	return s.deleteTextBy(userID, func(text Text) bool {
+		return text.UUID == textUUID
+	})
+}
+
+func (s *SQLiteStorage) DeleteTextByName(userID int64, textName string) error {
+	return s.deleteTextBy(userID, func(text Text) bool {
+		return text.Name == textName
+	})
+}
+
+func (s *SQLiteStorage) deleteTextBy(userID int64, predicate func(Text) bool) error {
+	return s.update(func(tx *sql.Tx) error {
+		texts, err := sqliteGetTexts(tx, userID)
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(texts.Texts, predicate)
+		if i < 0 {
+			return ErrNotFound
+		}
+		text := texts.Texts[i]
+		texts.Texts = slices.Delete(texts.Texts, i, i+1)
+		if texts.Current == i {
+			texts.Current = NotSelected
+		}
+		if texts.Current > i {
+			texts.Current--
+		}
+		if err = sqlitePutTexts(tx, userID, texts); err != nil {
+			return err
+		}
+		// texts from file share the same content between users
+		if ownsBucket(text) {
+			if _, err = tx.Exec(`DELETE FROM contents WHERE id = ?`, string(text.BucketName)); err != nil {
+				return err
+			}
+		}
+		if err = sqliteDeleteTextMarks(tx, userID, text.UUID); err != nil {
+			return err
+		}
+		return sqliteUnindexText(tx, userID, text.UUID)
+	})
+}
+
+// GetTextContent returns full text, chunks and attachments of the user's text
+func (s *SQLiteStorage) GetTextContent(userID int64, textUUID string) (TextContent, error) {
+	var content TextContent
+	err := s.view(func(tx *sql.Tx) error {
+		texts, err := sqliteGetTexts(tx, userID)
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(texts.Texts, func(text Text) bool { return text.UUID == textUUID })
+		if i < 0 {
+			return ErrNotFound
+		}
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		content, err = sqliteTextContent(tx, texts.Texts[i], c)
+		return err
+	})
+	return content, err
+}
+
+// GetAttachment returns file referenced from the user's text by name
+func (s *SQLiteStorage) GetAttachment(userID int64, textUUID, name string) ([]byte, error) {
+	var content []byte
+	err := s.view(func(tx *sql.Tx) error {
+		var encrypted bool
+		err := tx.QueryRow(`
+			SELECT a.data, t.encrypted FROM texts t JOIN attachments a ON a.content_id = t.content_id
+			WHERE t.user_id = ? AND t.uuid = ? AND a.name = ?`,
+			userID, textUUID, name,
+		).Scan(&content, &encrypted)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		content, err = c.of(Text{Encrypted: encrypted}).open(content)
+		return err
+	})
+	return content, err
+}
+
+func (s *SQLiteStorage) AddProcessedFile(newPf NewProcessedFile) (ProcessedFile, error) {
+	var pf ProcessedFile
+	err := s.update(func(tx *sql.Tx) error {
+		contentID, err := sqliteFillContent(tx, newPf.Text, newPf.Chunks, newPf.Attachments, nil)
+		if err != nil {
+			return err
+		}
+		pf = ProcessedFile{
+			UUID:       uuid.NewString(),
+			BucketName: []byte(contentID),
+			ChunkSize:  newPf.ChunkSize,
+			Language:   newPf.Language,
+			CheckSum:   newPf.CheckSum,
+			CreatedAt:  time.Now(),
+		}
+		return sqlitePutProcessedFile(tx, pf)
+	})
+	return pf, err
+}
+
+// GetProcessedFileByChecksum returns the file as it was processed first time
+func (s *SQLiteStorage) GetProcessedFileByChecksum(checksum []byte) (ProcessedFile, error) {
+	var pf ProcessedFile
+	err := s.view(func(tx *sql.Tx) error {
+		var err error
+		pf, err = sqliteGetProcessedFile(tx, `checksum = ? ORDER BY rowid LIMIT 1`, checksum)
+		return err
+	})
+	return pf, err
+}
+
+// GetProcessedFile returns the file split into chunks of chunkSize
+func (s *SQLiteStorage) GetProcessedFile(checksum []byte, chunkSize int64) (ProcessedFile, error) {
+	var pf ProcessedFile
+	err := s.view(func(tx *sql.Tx) error {
+		var err error
+		pf, err = sqliteGetProcessedFile(tx, `checksum = ? AND chunk_size = ?`, checksum, chunkSize)
+		return err
+	})
+	return pf, err
+}
+
+// RechunkTexts replaces chunks of user's texts selected by predicate with chunks returned by rechunk,
+// see Storage.RechunkTexts.
+func (s *SQLiteStorage) RechunkTexts(userID int64, predicate func(Text) bool, rechunk RechunkFunc) (int, error) {
+	var rechunked int
+	err := s.update(func(tx *sql.Tx) error {
+		texts, err := sqliteGetTexts(tx, userID)
+		if err != nil {
+			return err
+		}
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		for i, text := range texts.Texts {
+			if !predicate(text) {
+				continue
+			}
+			fullText, err := sqliteGetFullText(tx, string(text.BucketName), c.of(text))
+			if err == sql.ErrNoRows {
+				return errors.New("unexpected error: text bucket not found")
+			}
+			if err != nil {
+				return err
+			}
+			result, err := rechunk(text, fullText)
+			if err != nil {
+				return err
+			}
+			if len(result.Chunks) == 0 {
+				continue
+			}
+			if ownsBucket(text) {
+				err = sqlitePutChunks(tx, string(text.BucketName), result.Chunks, c.of(text))
+			} else {
+				text.BucketName, err = sqliteForkProcessedFile(tx, text, fullText, result)
+			}
+			if err != nil {
+				return err
+			}
+			// position stays the same, it points to another chunk now
+			text.ChunkSize = result.ChunkSize
+			text.CurrentChunk = chunkAt(textspliter.Offsets(result.Chunks), text.Position)
+			text.ModifiedAt = time.Now()
+			texts.Texts[i] = text
+			if err = sqliteIndexText(tx, userID, text, c); err != nil {
+				return err
+			}
+			rechunked++
+		}
+		return sqlitePutTexts(tx, userID, texts)
+	})
+	return rechunked, errors.Wrap(err, "failed to rechunk texts")
+}
+
+// sqliteForkProcessedFile returns content of the file split into new chunks, see forkProcessedFile
+func sqliteForkProcessedFile(tx *sql.Tx, text Text, fullText string, result Rechunked) ([]byte, error) {
+	checksum := text.CheckSum
+	if len(checksum) == 0 { // texts added before checksum was saved
+		pf, err := sqliteGetProcessedFile(tx, `content_id = ?`, string(text.BucketName))
+		if err != nil && err != ErrNotFound {
+			return nil, err
+		}
+		checksum = pf.CheckSum
+	}
+	if len(checksum) > 0 {
+		pf, err := sqliteGetProcessedFile(tx, `checksum = ? AND chunk_size = ?`, checksum, result.ChunkSize)
+		switch err {
+		case nil:
+			return pf.BucketName, nil
+		case ErrNotFound:
+		default:
+			return nil, err
+		}
+	}
+
+	attachments, err := sqliteGetAttachments(tx, string(text.BucketName), nil)
+	if err != nil {
+		return nil, err
+	}
+	contentID, err := sqliteFillContent(tx, fullText, result.Chunks, attachments, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(checksum) == 0 {
+		return []byte(contentID), nil
+	}
+	return []byte(contentID), sqlitePutProcessedFile(tx, ProcessedFile{
+		UUID:       uuid.NewString(),
+		BucketName: []byte(contentID),
+		ChunkSize:  result.ChunkSize,
+		Language:   text.Language,
+		CheckSum:   checksum,
+		CreatedAt:  time.Now(),
+	})
+}
+
+// Search returns page of chunks that contain all words of the query, see Storage.Search
+func (s *SQLiteStorage) Search(userID int64, query string, page, pageSize int) (matches []SearchMatch, more bool, err error) {
+	err = s.view(func(tx *sql.Tx) error {
+		texts, err := sqliteGetTexts(tx, userID)
+		if err != nil {
+			return err
+		}
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		skip := (page - 1) * pageSize
+		for _, text := range texts.Texts {
+			tc := c.of(text)
+			language, err := sqliteTextLanguage(tx, text, tc)
+			if err != nil {
+				return err
+			}
+			terms := textspliter.Terms(query, language)
+			for i, term := range terms {
+				terms[i] = tc.term(term)
+			}
+			slices.Sort(terms)
+			terms = slices.Compact(terms)
+			if len(terms) == 0 {
+				continue
+			}
+			args := []any{userID, text.UUID}
+			for _, term := range terms {
+				args = append(args, term)
+			}
+			args = append(args, len(terms))
+			chunks, err := sqliteInt64s(tx, `
+				SELECT chunk FROM search_terms
+				WHERE user_id = ? AND text_uuid = ? AND term IN (`+sqlPlaceholders(len(terms))+`)
+				GROUP BY chunk HAVING COUNT(*) = ?
+				ORDER BY chunk`,
+				args...,
+			)
+			if err != nil {
+				return err
+			}
+			for _, chunk := range chunks {
+				if skip > 0 {
+					skip--
+					continue
+				}
+				if len(matches) == pageSize {
+					more = true
+					return nil
+				}
+				chunkText, err := sqliteGetChunk(tx, string(text.BucketName), chunk, tc)
+				if err != nil {
+					return err
+				}
+				matches = append(matches, SearchMatch{
+					Text:      text,
+					Chunk:     chunk,
+					ChunkText: chunkText,
+				})
+			}
+		}
+		return nil
+	})
+	return matches, more, errors.Wrap(err, "failed to search texts")
+}
+
+// CollectGarbage removes contents that are not referenced by texts of any user, together with
+// processed files pointing to them, and vacuums the database, see Storage.CollectGarbage
+func (s *SQLiteStorage) CollectGarbage(before time.Time) (GCReport, error) {
+	var report GCReport
+	var err error
+	if report.SizeBefore, err = s.size(); err != nil {
+		return report, err
+	}
+	err = s.update(func(tx *sql.Tx) error {
+		res, err := tx.Exec(
+			`DELETE FROM processed_files WHERE created_at < ? AND content_id NOT IN (SELECT content_id FROM texts)`,
+			before.UnixNano(),
+		)
+		if err != nil {
+			return err
+		}
+		files, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		report.Files = int(files)
+		res, err = tx.Exec(`
+			DELETE FROM contents WHERE id NOT IN (SELECT content_id FROM texts)
+			AND id NOT IN (SELECT content_id FROM processed_files)`,
+		)
+		if err != nil {
+			return err
+		}
+		texts, err := res.RowsAffected()
+		report.Texts = int(texts)
+		return err
+	})
+	if err != nil {
+		return report, errors.Wrap(err, "failed to collect garbage")
+	}
+	if _, err = s.db.Exec(`VACUUM`); err != nil {
+		return report, errors.Wrap(err, "failed to vacuum database")
+	}
+	report.SizeAfter, err = s.size()
+	return report, err
+}
+
+// Snapshot writes consistent copy of the database to w, see Storage.Snapshot
+func (s *SQLiteStorage) Snapshot(w io.Writer) error {
+	dir, err := os.MkdirTemp("", "adhd-reader-snapshot")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot.sqlite")
+	if _, err = s.db.Exec(`VACUUM INTO ?`, path); err != nil {
+		return errors.Wrap(err, "failed to copy database")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func (s *SQLiteStorage) size() (int64, error) {
+	var size int64
+	err := s.db.QueryRow(`SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()`).Scan(&size)
+	return size, err
+}
+
+// Analytics returns texts of all users that have texts, it's one query instead of walking all keys
+func (s *SQLiteStorage) Analytics() ([]UserAnalytics, error) {
+	var result []UserAnalytics
+	err := s.view(func(tx *sql.Tx) error {
+		infos, err := sqliteTextInfos(tx, `1 = 1`)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			if len(result) == 0 || result[len(result)-1].UserID != info.userID {
+				result = append(result, UserAnalytics{UserID: info.userID, CurrentText: NotSelected})
+			}
+			user := &result[len(result)-1]
+			if info.current {
+				user.CurrentText = len(user.Texts)
+			}
+			user.Texts = append(user.Texts, info.TextWithChunkInfo)
+			user.TotalTextCount++
+		}
+		for i := range result {
+			if result[i].ChunkSize, err = sqliteGetChunkSize(tx, result[i].UserID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+// UserIDs returns sorted ids of all users that have anything saved
+func (s *SQLiteStorage) UserIDs() ([]int64, error) {
+	var ids []int64
+	err := s.view(func(tx *sql.Tx) error {
+		var err error
+		ids, err = sqliteInt64s(tx, `
+			SELECT user_id FROM users
+			UNION SELECT user_id FROM texts
+			UNION SELECT user_id FROM readings
+			UNION SELECT user_id FROM list_preferences
+			UNION SELECT user_id FROM bookmarks
+			UNION SELECT user_id FROM highlights
+			UNION SELECT user_id FROM dust
+			UNION SELECT user_id FROM herbs
+			UNION SELECT user_id FROM levels
+			UNION SELECT user_id FROM stats
+			UNION SELECT user_id FROM user_recipes
+			UNION SELECT user_id FROM auth_tokens
+			UNION SELECT user_id FROM data_keys
+			UNION SELECT user_id FROM reminders
+			UNION SELECT user_id FROM reading_events
+			ORDER BY 1`,
+		)
+		return err
+	})
+	return ids, errors.Wrap(err, "failed to list users")
+}
+
+func (s *SQLiteStorage) UpdateReading(userID int64, updFunc func(*Reading)) (*Reading, error) {
+	var reading Reading
+	err := s.update(func(tx *sql.Tx) error {
+		var err error
+		if reading, err = sqliteGetReading(tx, userID); err != nil {
+			return err
+		}
+		updFunc(&reading)
+		return sqlitePutReading(tx, userID, reading)
+	})
+	return &reading, err
+}
+
+func (s *SQLiteStorage) GetReadingByUserID(userID int64) (reading Reading, err error) {
+	err = s.view(func(tx *sql.Tx) error {
+		reading, err = sqliteGetReading(tx, userID)
+		return err
+	})
+	return reading, err
+}
+
+func (s *SQLiteStorage) UpdateListPreferences(userID int64, updFunc func(*ListPreferences)) (*ListPreferences, error) {
+	var prefs ListPreferences
+	err := s.update(func(tx *sql.Tx) error {
+		var err error
+		if prefs, err = sqliteGetListPreferences(tx, userID); err != nil {
+			return err
+		}
+		updFunc(&prefs)
+		return sqlitePutListPreferences(tx, userID, prefs)
+	})
+	return &prefs, err
+}
+
+func (s *SQLiteStorage) GetListPreferencesByUserID(userID int64) (prefs ListPreferences, err error) {
+	err = s.view(func(tx *sql.Tx) error {
+		prefs, err = sqliteGetListPreferences(tx, userID)
+		return err
+	})
+	return prefs, err
+}
+
+// AddBookmark saves bookmark of the user's text, returns ErrNotFound if user doesn't have the text
+func (s *SQLiteStorage) AddBookmark(userID int64, bookmark Bookmark) (Bookmark, error) {
+	bookmark.UUID = uuid.NewString()
+	bookmark.CreatedAt = time.Now()
+	err := s.update(func(tx *sql.Tx) error {
+		if err := sqliteCheckUserText(tx, userID, bookmark.TextUUID); err != nil {
+			return err
+		}
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		return sqlitePutBookmark(tx, userID, bookmark, c)
+	})
+	return bookmark, err
+}
+
+// GetBookmarks returns bookmarks of the user in order they were added.
+// Only bookmarks of the text are returned if textUUID is not empty.
+func (s *SQLiteStorage) GetBookmarks(userID int64, textUUID string) ([]Bookmark, error) {
+	var bookmarks []Bookmark
+	err := s.view(func(tx *sql.Tx) error {
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		bookmarks, err = sqliteGetBookmarks(tx, userID, textUUID, c)
+		return err
+	})
+	return bookmarks, err
+}
+
+func (s *SQLiteStorage) DeleteBookmark(userID int64, bookmarkUUID string) error {
+	return s.update(func(tx *sql.Tx) error {
+		return sqliteDeleteOne(tx, `DELETE FROM bookmarks WHERE user_id = ? AND uuid = ?`, userID, bookmarkUUID)
+	})
+}
+
+// AddHighlight saves highlight of the user's text, returns ErrNotFound if user doesn't have the text
+func (s *SQLiteStorage) AddHighlight(userID int64, highlight Highlight) (Highlight, error) {
+	highlight.UUID = uuid.NewString()
+	highlight.CreatedAt = time.Now()
+	err := s.update(func(tx *sql.Tx) error {
+		if err := sqliteCheckUserText(tx, userID, highlight.TextUUID); err != nil {
+			return err
+		}
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		return sqlitePutHighlight(tx, userID, highlight, c)
+	})
+	return highlight, err
+}
+
+// GetHighlights returns highlights of the user in order they were added.
+// Only highlights of the text are returned if textUUID is not empty.
+func (s *SQLiteStorage) GetHighlights(userID int64, textUUID string) ([]Highlight, error) {
+	var highlights []Highlight
+	err := s.view(func(tx *sql.Tx) error {
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		highlights, err = sqliteGetHighlights(tx, userID, textUUID, c)
+		return err
+	})
+	return highlights, err
+}
+
+func (s *SQLiteStorage) DeleteHighlight(userID int64, highlightUUID string) error {
+	return s.update(func(tx *sql.Tx) error {
+		return sqliteDeleteOne(tx, `DELETE FROM highlights WHERE user_id = ? AND uuid = ?`, userID, highlightUUID)
+	})
+}
+
+func (s *SQLiteStorage) UpdateDust(userID int64, updFunc func(*Dust)) (*Dust, error) {
+	var dust Dust
+	err := s.update(func(tx *sql.Tx) error {
+		var err error
+		if dust, err = sqliteGetDust(tx, userID); err != nil {
+			return err
+		}
+		updFunc(&dust)
+		return sqlitePutDust(tx, userID, dust)
+	})
+	return &dust, err
+}
+
+func (s *SQLiteStorage) GetDustByUserID(userID int64) (dust Dust, err error) {
+	err = s.view(func(tx *sql.Tx) error {
+		dust, err = sqliteGetDust(tx, userID)
+		return err
+	})
+	return dust, err
+}
+
+func (s *SQLiteStorage) UpdateHerb(userID int64, updFunc func(*Herb)) (*Herb, error) {
+	var herb Herb
+	err := s.update(func(tx *sql.Tx) error {
+		var err error
+		if herb, err = sqliteGetHerb(tx, userID); err != nil {
+			return err
+		}
+		updFunc(&herb)
+		return sqlitePutHerb(tx, userID, herb)
+	})
+	return &herb, err
+}
+
+func (s *SQLiteStorage) GetHerbByUserID(userID int64) (herb Herb, err error) {
+	err = s.view(func(tx *sql.Tx) error {
+		herb, err = sqliteGetHerb(tx, userID)
+		return err
+	})
+	return herb, err
+}
+
+func (s *SQLiteStorage) UpdateLevel(userID int64, updFunc func(*Level)) (*Level, error) {
+	var level Level
+	err := s.update(func(tx *sql.Tx) error {
+		var err error
+		if level, err = sqliteGetLevel(tx, userID); err != nil {
+			return err
+		}
+		updFunc(&level)
+		return sqlitePutLevel(tx, userID, level)
+	})
+	return &level, err
+}
+
+func (s *SQLiteStorage) GetLevelByUserID(userID int64) (level Level, err error) {
+	err = s.view(func(tx *sql.Tx) error {
+		level, err = sqliteGetLevel(tx, userID)
+		return err
+	})
+	return level, err
+}
+
+func (s *SQLiteStorage) UpdateStat(userID int64, updFunc func(*Stat)) (*Stat, error) {
+	var stat Stat
+	err := s.update(func(tx *sql.Tx) error {
+		var err error
+		if stat, err = sqliteGetStat(tx, userID); err != nil {
+			return err
+		}
+		updFunc(&stat)
+		return sqlitePutStat(tx, userID, stat)
+	})
+	return &stat, err
+}
+
+func (s *SQLiteStorage) GetStatByUserID(userID int64) (stat Stat, err error) {
+	err = s.view(func(tx *sql.Tx) error {
+		stat, err = sqliteGetStat(tx, userID)
+		return err
+	})
+	return stat, err
+}
+
+func (s *SQLiteStorage) GetRecipeByName(name string) (recipe Recipe, err error) {
+	err = s.view(func(tx *sql.Tx) error {
+		return sqliteGetJSON(tx, &recipe, `SELECT data FROM recipes WHERE name = ?`, name)
+	})
+	return recipe, errors.Wrap(err, "failed to get recipe")
+}
+
+func (s *SQLiteStorage) GetUserRecipeByUserIDandRecipeName(userID int64, recipeName string) (recipe UserRecipe, err error) {
+	err = s.view(func(tx *sql.Tx) error {
+		recipe, err = sqliteGetUserRecipe(tx, userID, recipeName)
+		return err
+	})
+	return recipe, err
+}
+
+func (s *SQLiteStorage) UpdateUserRecipe(userID int64, recipeName string, updFunc func(*UserRecipe)) (*UserRecipe, error) {
+	var recipe UserRecipe
+	err := s.update(func(tx *sql.Tx) error {
+		var err error
+		if recipe, err = sqliteGetUserRecipe(tx, userID, recipeName); err != nil {
+			return err
+		}
+		recipe.UserID, recipe.RecipeName = userID, recipeName
+		updFunc(&recipe)
+		return sqlitePutUserRecipe(tx, userID, recipeName, recipe)
+	})
+	return &recipe, err
+}
+
+func (s *SQLiteStorage) SetAuthToken(userID int64, token string) error {
+	return s.update(func(tx *sql.Tx) error {
+		var exists bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM auth_tokens WHERE user_id = ? OR token = ?)`, userID, token).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrAlreadyExists
+		}
+		_, err = tx.Exec(`INSERT INTO auth_tokens (user_id, token) VALUES (?, ?)`, userID, token)
+		return errors.Wrap(err, "failed to put token")
+	})
+}
+
+func (s *SQLiteStorage) GetUserIDByAuthToken(token string) (int64, error) {
+	var userID int64
+	err := s.view(func(tx *sql.Tx) error {
+		err := tx.QueryRow(`SELECT user_id FROM auth_tokens WHERE token = ?`, token).Scan(&userID)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return err
+	})
+	return userID, err
+}
+
+func (s *SQLiteStorage) GetTokenByUserID(userID int64) (string, error) {
+	var token string
+	err := s.view(func(tx *sql.Tx) error {
+		err := tx.QueryRow(`SELECT token FROM auth_tokens WHERE user_id = ?`, userID).Scan(&token)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return err
+	})
+	return token, err
+}
+
+func (s *SQLiteStorage) DeleteAuthToken(userID int64) error {
+	return s.update(func(tx *sql.Tx) error {
+		return sqliteDeleteOne(tx, `DELETE FROM auth_tokens WHERE user_id = ?`, userID)
+	})
+}
+
+// helper functions
+
+// textColumns are columns of texts in the order of scanText
+const textColumns = `uuid, name, source, content_id, current_chunk, position, language, chunk_size, checksum, state, tags, created_at, modified_at, encrypted`
+
+type sqlScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanText(row sqlScanner) (Text, error) {
+	var text Text
+	var contentID, tags string
+	var createdAt, modifiedAt int64
+	err := row.Scan(
+		&text.UUID, &text.Name, &text.Source, &contentID, &text.CurrentChunk, &text.Position,
+		&text.Language, &text.ChunkSize, &text.CheckSum, &text.State, &tags, &createdAt, &modifiedAt, &text.Encrypted,
+	)
+	if err != nil {
+		return text, err
+	}
+	text.BucketName = []byte(contentID)
+	text.CreatedAt = fromUnixNano(createdAt)
+	text.ModifiedAt = fromUnixNano(modifiedAt)
+	return text, errors.Wrap(json.Unmarshal([]byte(tags), &text.Tags), "failed to unmarshal tags")
+}
+
+// sqliteGetTexts returns texts of the user in the same form as bbolt storage keeps them
+func sqliteGetTexts(tx *sql.Tx, userID int64) (UserTexts, error) {
+	texts := defaultUserTexts()
+	rows, err := tx.Query(`SELECT `+textColumns+` FROM texts WHERE user_id = ? ORDER BY idx`, userID)
+	if err != nil {
+		return texts, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		text, err := scanText(rows)
+		if err != nil {
+			return texts, err
+		}
+		texts.Texts = append(texts.Texts, text)
+	}
+	if err = rows.Err(); err != nil {
+		return texts, err
+	}
+	var current sql.NullString
+	err = tx.QueryRow(`SELECT current_text FROM users WHERE user_id = ?`, userID).Scan(&current)
+	if err != nil && err != sql.ErrNoRows {
+		return texts, err
+	}
+	if current.Valid {
+		texts.Current = slices.IndexFunc(texts.Texts, func(text Text) bool { return text.UUID == current.String })
+	}
+	return texts, nil
+}
+
+// sqlitePutTexts replaces texts of the user
+func sqlitePutTexts(tx *sql.Tx, userID int64, texts UserTexts) error {
+	if _, err := tx.Exec(`DELETE FROM texts WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for i, text := range texts.Texts {
+		tags, err := json.Marshal(text.Tags)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			`INSERT INTO texts (user_id, idx, `+textColumns+`) VALUES (?, ?, `+sqlPlaceholders(14)+`)`,
+			userID, i, text.UUID, text.Name, text.Source, string(text.BucketName), text.CurrentChunk, text.Position,
+			text.Language, text.ChunkSize, text.CheckSum, text.State, string(tags),
+			unixNano(text.CreatedAt), unixNano(text.ModifiedAt), text.Encrypted,
+		)
+		if err != nil {
+			return errors.Wrapf(err, "failed to put text %q", text.Name)
+		}
+	}
+	var current sql.NullString
+	if texts.Current >= 0 && texts.Current < len(texts.Texts) {
+		current = sql.NullString{String: texts.Texts[texts.Current].UUID, Valid: true}
+	}
+	_, err := tx.Exec(`
+		INSERT INTO users (user_id, current_text) VALUES (?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET current_text = excluded.current_text`,
+		userID, current,
+	)
+	return err
+}
+
+type sqliteTextInfo struct {
+	TextWithChunkInfo
+	userID  int64
+	current bool // text is selected by the user
+}
+
+// sqliteTextInfos returns texts matching the condition on texts t with their chunk info,
+// texts are ordered by user and their order in the user's list
+func sqliteTextInfos(tx *sql.Tx, where string, args ...any) ([]sqliteTextInfo, error) {
+	rows, err := tx.Query(`
+		SELECT t.user_id, t.uuid, t.name, t.source, t.language, t.state, t.tags, t.current_chunk,
+			t.created_at, t.modified_at, c.total_chunks, c.offsets, c.length, COALESCE(last.text, ''),
+			COALESCE(u.current_text = t.uuid, FALSE)
+		FROM texts t
+		JOIN contents c ON c.id = t.content_id
+		LEFT JOIN chunks last ON last.content_id = c.id AND last.idx = c.total_chunks - 1
+		LEFT JOIN users u ON u.user_id = t.user_id
+		WHERE `+where+`
+		ORDER BY t.user_id, t.idx`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []sqliteTextInfo
+	for rows.Next() {
+		var info sqliteTextInfo
+		var tags, lastChunk string
+		var offsets []byte
+		var length sql.NullInt64
+		var createdAt, modifiedAt int64
+		err = rows.Scan(
+			&info.userID, &info.UUID, &info.Name, &info.Source, &info.Language, &info.State, &tags, &info.CurrentChunk,
+			&createdAt, &modifiedAt, &info.TotalChunks, &offsets, &length, &lastChunk, &info.current,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal([]byte(tags), &info.Tags); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal tags")
+		}
+		if length.Valid {
+			info.Length = length.Int64
+		} else if decoded := decodeInt64s(offsets); len(decoded) > 0 {
+			// contents saved before length was saved are not encrypted
+			info.Length = decoded[len(decoded)-1] + textspliter.VisibleLen(lastChunk)
+		}
+		info.CreatedAt = fromUnixNano(createdAt)
+		info.ModifiedAt = fromUnixNano(modifiedAt)
+		result = append(result, info)
+	}
+	return result, rows.Err()
+}
+
+func sqliteGetChunkSize(tx *sql.Tx, userID int64) (int64, error) {
+	var chunkSize int64
+	err := tx.QueryRow(`SELECT chunk_size FROM users WHERE user_id = ?`, userID).Scan(&chunkSize)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return chunkSize, err
+}
+
+func sqlitePutChunkSize(tx *sql.Tx, userID int64, chunkSize int64) error {
+	_, err := tx.Exec(`
+		INSERT INTO users (user_id, chunk_size) VALUES (?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET chunk_size = excluded.chunk_size`,
+		userID, chunkSize,
+	)
+	return err
+}
+
+// sqliteFillContent saves text with its chunks and attachments encrypted with c, returns id of the content
+func sqliteFillContent(tx *sql.Tx, text string, chunks []string, attachments map[string][]byte, c *textCipher) (string, error) {
+	contentID := uuid.New().String()
+	sealed, err := sqliteSealString(text, c)
+	if err != nil {
+		return "", err
+	}
+	if _, err = tx.Exec(`INSERT INTO contents (id, full_text) VALUES (?, ?)`, contentID, sealed); err != nil {
+		return "", err
+	}
+	if err = sqlitePutChunks(tx, contentID, chunks, c); err != nil {
+		return "", err
+	}
+	for name, data := range attachments {
+		if data, err = c.seal(data); err != nil {
+			return "", err
+		}
+		if _, err = tx.Exec(`INSERT INTO attachments (content_id, name, data) VALUES (?, ?, ?)`, contentID, name, data); err != nil {
+			return "", err
+		}
+	}
+	return contentID, nil
+}
+
+// sqlitePutChunks replaces chunks of the content
+func sqlitePutChunks(tx *sql.Tx, contentID string, chunks []string, c *textCipher) error {
+	if _, err := tx.Exec(`DELETE FROM chunks WHERE content_id = ?`, contentID); err != nil {
+		return err
+	}
+	for i, chunk := range chunks {
+		sealed, err := sqliteSealString(chunk, c)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec(`INSERT INTO chunks (content_id, idx, text) VALUES (?, ?, ?)`, contentID, i, sealed); err != nil {
+			return err
+		}
+	}
+	offsets := textspliter.Offsets(chunks)
+	_, err := tx.Exec(
+		`UPDATE contents SET total_chunks = ?, offsets = ?, length = ? WHERE id = ?`,
+		len(chunks), encodeInt64s(offsets), textLength(chunks, offsets), contentID,
+	)
+	return err
+}
+
+// sqliteSealString returns value of the text column, plain texts stay strings to keep them readable by queries
+func sqliteSealString(text string, c *textCipher) (any, error) {
+	if c == nil {
+		return text, nil
+	}
+	return c.sealString(text)
+}
+
+// sqliteGetFullText returns full text of the content, sql.ErrNoRows if there is no such content
+func sqliteGetFullText(tx *sql.Tx, contentID string, c *textCipher) (string, error) {
+	var fullText []byte
+	if err := tx.QueryRow(`SELECT full_text FROM contents WHERE id = ?`, contentID).Scan(&fullText); err != nil {
+		return "", err
+	}
+	return c.openString(fullText)
+}
+
+// sqliteCopyContent copies content that is not encrypted into a new content encrypted with c
+func sqliteCopyContent(tx *sql.Tx, contentID string, c *textCipher) ([]byte, error) {
+	fullText, err := sqliteGetFullText(tx, contentID, nil)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := sqliteGetChunks(tx, contentID, nil)
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := sqliteGetAttachments(tx, contentID, nil)
+	if err != nil {
+		return nil, err
+	}
+	copyID, err := sqliteFillContent(tx, fullText, chunks, attachments, c)
+	return []byte(copyID), err
+}
+
+// sqliteContentInfo returns number of chunks of the content and positions of their beginnings
+func sqliteContentInfo(tx *sql.Tx, contentID string) (int64, []int64, error) {
+	var totalChunks int64
+	var offsets []byte
+	err := tx.QueryRow(`SELECT total_chunks, offsets FROM contents WHERE id = ?`, contentID).Scan(&totalChunks, &offsets)
+	if err == sql.ErrNoRows {
+		return 0, nil, errors.New("unexpected error: text bucket not found")
+	}
+	return totalChunks, decodeInt64s(offsets), err
+}
+
+func sqliteGetChunk(tx *sql.Tx, contentID string, idx int64, c *textCipher) (string, error) {
+	var chunk []byte
+	err := tx.QueryRow(`SELECT text FROM chunks WHERE content_id = ? AND idx = ?`, contentID, idx).Scan(&chunk)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return c.openString(chunk)
+}
+
+func sqliteGetChunks(tx *sql.Tx, contentID string, c *textCipher) ([]string, error) {
+	rows, err := tx.Query(`SELECT text FROM chunks WHERE content_id = ? ORDER BY idx`, contentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	chunks := []string{}
+	for rows.Next() {
+		var sealed []byte
+		if err = rows.Scan(&sealed); err != nil {
+			return nil, err
+		}
+		chunk, err := c.openString(sealed)
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, rows.Err()
+}
+
+func sqliteGetAttachments(tx *sql.Tx, contentID string, c *textCipher) (map[string][]byte, error) {
+	rows, err := tx.Query(`SELECT name, data FROM attachments WHERE content_id = ?`, contentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var attachments map[string][]byte
+	for rows.Next() {
+		var name string
+		var data []byte
+		if err = rows.Scan(&name, &data); err != nil {
+			return nil, err
+		}
+		if data, err = c.open(data); err != nil {
+			return nil, err
+		}
+		if attachments == nil {
+			attachments = make(map[string][]byte)
+		}
+		attachments[name] = data
+	}
+	return attachments, rows.Err()
+}
+
+// sqliteTextContent returns content of the text, c is cipher of the text owner
+func sqliteTextContent(tx *sql.Tx, text Text, c *textCipher) (TextContent, error) {
+	c = c.of(text)
+	fullText, err := sqliteGetFullText(tx, string(text.BucketName), c)
+	if err == sql.ErrNoRows {
+		return TextContent{}, errors.New("unexpected error: text bucket not found")
+	}
+	if err != nil {
+		return TextContent{}, err
+	}
+	chunks, err := sqliteGetChunks(tx, string(text.BucketName), c)
+	if err != nil {
+		return TextContent{}, err
+	}
+	attachments, err := sqliteGetAttachments(tx, string(text.BucketName), c)
+	if err != nil {
+		return TextContent{}, err
+	}
+	return TextContent{
+		Text:        text,
+		FullText:    fullText,
+		Chunks:      chunks,
+		Attachments: attachments,
+	}, nil
+}
+
+// sqlitePutProcessedFile saves the file for its checksum and chunk size
+func sqlitePutProcessedFile(tx *sql.Tx, pf ProcessedFile) error {
+	// update keeps rowid, so the first variant stays the first
+	_, err := tx.Exec(`
+		INSERT INTO processed_files (checksum, chunk_size, uuid, content_id, language, created_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (checksum, chunk_size) DO UPDATE SET
+			uuid = excluded.uuid, content_id = excluded.content_id, language = excluded.language, created_at = excluded.created_at`,
+		pf.CheckSum, pf.ChunkSize, pf.UUID, string(pf.BucketName), pf.Language, unixNano(pf.CreatedAt),
+	)
+	return err
+}
+
+func sqliteGetProcessedFile(tx *sql.Tx, where string, args ...any) (ProcessedFile, error) {
+	var pf ProcessedFile
+	var contentID string
+	var createdAt int64
+	err := tx.QueryRow(
+		`SELECT checksum, chunk_size, uuid, content_id, language, created_at FROM processed_files WHERE `+where,
+		args...,
+	).Scan(&pf.CheckSum, &pf.ChunkSize, &pf.UUID, &contentID, &pf.Language, &createdAt)
+	if err == sql.ErrNoRows {
+		return pf, ErrNotFound
+	}
+	pf.BucketName = []byte(contentID)
+	pf.CreatedAt = fromUnixNano(createdAt)
+	return pf, err
+}
+
+// sqliteIndexText replaces words of the text in the search index with words of its current chunks,
+// c is cipher of the text owner
+func sqliteIndexText(tx *sql.Tx, userID int64, text Text, c *textCipher) error {
+	if err := sqliteUnindexText(tx, userID, text.UUID); err != nil {
+		return err
+	}
+	c = c.of(text)
+	language, err := sqliteTextLanguage(tx, text, c)
+	if err != nil {
+		return err
+	}
+	chunks, err := sqliteGetChunks(tx, string(text.BucketName), c)
+	if err != nil {
+		return err
+	}
+	for i, chunk := range chunks {
+		for _, term := range textspliter.Terms(chunk, language) {
+			_, err = tx.Exec(
+				`INSERT OR IGNORE INTO search_terms (user_id, text_uuid, term, chunk) VALUES (?, ?, ?, ?)`,
+				userID, text.UUID, c.term(term), i,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func sqliteUnindexText(tx *sql.Tx, userID int64, textUUID string) error {
+	_, err := tx.Exec(`DELETE FROM search_terms WHERE user_id = ? AND text_uuid = ?`, userID, textUUID)
+	return err
+}
+
+// sqliteTextLanguage returns language of the text, it's detected for texts saved without language
+func sqliteTextLanguage(tx *sql.Tx, text Text, c *textCipher) (string, error) {
+	if text.Language != "" {
+		return text.Language, nil
+	}
+	chunk, err := sqliteGetChunk(tx, string(text.BucketName), 0, c)
+	if err != nil {
+		return "", err
+	}
+	return textspliter.DetectLanguage(chunk).Language(), nil
+}
+
+func sqliteCheckUserText(tx *sql.Tx, userID int64, textUUID string) error {
+	var exists bool
+	err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM texts WHERE user_id = ? AND uuid = ?)`, userID, textUUID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// sqliteDeleteTextMarks deletes bookmarks and highlights of the deleted text
+func sqliteDeleteTextMarks(tx *sql.Tx, userID int64, textUUID string) error {
+	if _, err := tx.Exec(`DELETE FROM bookmarks WHERE user_id = ? AND text_uuid = ?`, userID, textUUID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`DELETE FROM highlights WHERE user_id = ? AND text_uuid = ?`, userID, textUUID)
+	return err
+}
+
+// sqliteDeleteOne executes delete query, returns ErrNotFound if nothing was deleted
+func sqliteDeleteOne(tx *sql.Tx, query string, args ...any) error {
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func sqlitePutBookmark(tx *sql.Tx, userID int64, bookmark Bookmark, c *textCipher) error {
+	label, err := sqliteSealString(bookmark.Label, c)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`INSERT INTO bookmarks (user_id, uuid, text_uuid, position, label, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		userID, bookmark.UUID, bookmark.TextUUID, bookmark.Position, label, unixNano(bookmark.CreatedAt),
+	)
+	return err
+}
+
+func sqliteGetBookmarks(tx *sql.Tx, userID int64, textUUID string, c *textCipher) ([]Bookmark, error) {
+	rows, err := tx.Query(`
+		SELECT uuid, text_uuid, position, label, created_at FROM bookmarks
+		WHERE user_id = ? AND (? = '' OR text_uuid = ?)
+		ORDER BY rowid`,
+		userID, textUUID, textUUID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var bookmarks []Bookmark
+	for rows.Next() {
+		var bookmark Bookmark
+		var label []byte
+		var createdAt int64
+		if err = rows.Scan(&bookmark.UUID, &bookmark.TextUUID, &bookmark.Position, &label, &createdAt); err != nil {
+			return nil, err
+		}
+		if bookmark.Label, err = c.openString(label); err != nil {
+			return nil, err
+		}
+		bookmark.CreatedAt = fromUnixNano(createdAt)
+		bookmarks = append(bookmarks, bookmark)
+	}
+	return bookmarks, rows.Err()
+}
+
+func sqlitePutHighlight(tx *sql.Tx, userID int64, highlight Highlight, c *textCipher) error {
+	text, err := sqliteSealString(highlight.Text, c)
+	if err != nil {
+		return err
+	}
+	note, err := sqliteSealString(highlight.Note, c)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`INSERT INTO highlights (user_id, uuid, text_uuid, start_position, end_position, text, note, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, highlight.UUID, highlight.TextUUID, highlight.Start, highlight.End,
+		text, note, unixNano(highlight.CreatedAt),
+	)
+	return err
+}
+
+func sqliteGetHighlights(tx *sql.Tx, userID int64, textUUID string, c *textCipher) ([]Highlight, error) {
+	rows, err := tx.Query(`
+		SELECT uuid, text_uuid, start_position, end_position, text, note, created_at FROM highlights
+		WHERE user_id = ? AND (? = '' OR text_uuid = ?)
+		ORDER BY rowid`,
+		userID, textUUID, textUUID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var highlights []Highlight
+	for rows.Next() {
+		var highlight Highlight
+		var text, note []byte
+		var createdAt int64
+		err = rows.Scan(
+			&highlight.UUID, &highlight.TextUUID, &highlight.Start, &highlight.End,
+			&text, &note, &createdAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if highlight.Text, err = c.openString(text); err != nil {
+			return nil, err
+		}
+		if highlight.Note, err = c.openString(note); err != nil {
+			return nil, err
+		}
+		highlight.CreatedAt = fromUnixNano(createdAt)
+		highlights = append(highlights, highlight)
+	}
+	return highlights, rows.Err()
+}
+
+// sqliteGetJSON unmarshals the only column of the query result into v, v stays as is if there are no rows
+func sqliteGetJSON(tx *sql.Tx, v any, query string, args ...any) error {
+	var data string
+	err := tx.QueryRow(query, args...).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(data), v)
+}
+
+func sqliteGetReading(tx *sql.Tx, userID int64) (reading Reading, err error) {
+	err = sqliteGetJSON(tx, &reading, `SELECT data FROM readings WHERE user_id = ?`, userID)
+	return reading, errors.Wrap(err, "failed to get reading")
+}
+
+func sqlitePutReading(tx *sql.Tx, userID int64, reading Reading) error {
+	data, err := json.Marshal(reading)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO readings (user_id, data) VALUES (?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET data = excluded.data`,
+		userID, string(data),
+	)
+	return err
+}
+
+func sqliteGetListPreferences(tx *sql.Tx, userID int64) (prefs ListPreferences, err error) {
+	err = tx.QueryRow(`SELECT sort, filter FROM list_preferences WHERE user_id = ?`, userID).Scan(&prefs.Sort, &prefs.Filter)
+	if err == sql.ErrNoRows {
+		return prefs, nil
+	}
+	return prefs, err
+}
+
+func sqlitePutListPreferences(tx *sql.Tx, userID int64, prefs ListPreferences) error {
+	_, err := tx.Exec(`
+		INSERT INTO list_preferences (user_id, sort, filter) VALUES (?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET sort = excluded.sort, filter = excluded.filter`,
+		userID, prefs.Sort, prefs.Filter,
+	)
+	return err
+}
+
+func sqliteGetDust(tx *sql.Tx, userID int64) (dust Dust, err error) {
+	err = tx.QueryRow(
+		`SELECT red, orange, yellow, green, blue, indigo, purple, white, black FROM dust WHERE user_id = ?`, userID,
+	).Scan(
+		&dust.RedCount, &dust.OrangeCount, &dust.YellowCount, &dust.GreenCount, &dust.BlueCount,
+		&dust.IndigoCount, &dust.PurpleCount, &dust.WhiteCount, &dust.BlackCount,
+	)
+	if err == sql.ErrNoRows {
+		return dust, nil
+	}
+	return dust, err
+}
+
+func sqlitePutDust(tx *sql.Tx, userID int64, dust Dust) error {
+	_, err := tx.Exec(`
+		INSERT OR REPLACE INTO dust (user_id, red, orange, yellow, green, blue, indigo, purple, white, black)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, dust.RedCount, dust.OrangeCount, dust.YellowCount, dust.GreenCount, dust.BlueCount,
+		dust.IndigoCount, dust.PurpleCount, dust.WhiteCount, dust.BlackCount,
+	)
+	return err
+}
+
+func sqliteGetHerb(tx *sql.Tx, userID int64) (herb Herb, err error) {
+	err = tx.QueryRow(`SELECT lavanda, melissa FROM herbs WHERE user_id = ?`, userID).Scan(&herb.LavandaCount, &herb.MelissaCount)
+	if err == sql.ErrNoRows {
+		return herb, nil
+	}
+	return herb, err
+}
+
+func sqlitePutHerb(tx *sql.Tx, userID int64, herb Herb) error {
+	_, err := tx.Exec(
+		`INSERT OR REPLACE INTO herbs (user_id, lavanda, melissa) VALUES (?, ?, ?)`,
+		userID, herb.LavandaCount, herb.MelissaCount,
+	)
+	return err
+}
+
+func sqliteGetLevel(tx *sql.Tx, userID int64) (level Level, err error) {
+	err = tx.QueryRow(`SELECT experience FROM levels WHERE user_id = ?`, userID).Scan(&level.Experience)
+	if err == sql.ErrNoRows {
+		return level, nil
+	}
+	return level, err
+}
+
+func sqlitePutLevel(tx *sql.Tx, userID int64, level Level) error {
+	_, err := tx.Exec(`INSERT OR REPLACE INTO levels (user_id, experience) VALUES (?, ?)`, userID, level.Experience)
+	return err
+}
+
+func sqliteGetStat(tx *sql.Tx, userID int64) (stat Stat, err error) {
+	err = tx.QueryRow(
+		`SELECT free, luck, accuracy, attention, time_management, charizma FROM stats WHERE user_id = ?`, userID,
+	).Scan(&stat.Free, &stat.Luck, &stat.Accuracy, &stat.Attention, &stat.TimeManagement, &stat.Charizma)
+	if err == sql.ErrNoRows {
+		return stat, nil
+	}
+	return stat, err
+}
+
+func sqlitePutStat(tx *sql.Tx, userID int64, stat Stat) error {
+	_, err := tx.Exec(`
+		INSERT OR REPLACE INTO stats (user_id, free, luck, accuracy, attention, time_management, charizma)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		userID, stat.Free, stat.Luck, stat.Accuracy, stat.Attention, stat.TimeManagement, stat.Charizma,
+	)
+	return err
+}
+
+func sqliteGetUserRecipe(tx *sql.Tx, userID int64, recipeName string) (recipe UserRecipe, err error) {
+	err = sqliteGetJSON(tx, &recipe, `SELECT data FROM user_recipes WHERE user_id = ? AND recipe_name = ?`, userID, recipeName)
+	return recipe, errors.Wrap(err, "failed to get user recipe")
+}
+
+func sqlitePutUserRecipe(tx *sql.Tx, userID int64, recipeName string, recipe UserRecipe) error {
+	data, err := json.Marshal(recipe)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`INSERT OR REPLACE INTO user_recipes (user_id, recipe_name, data) VALUES (?, ?, ?)`,
+		userID, recipeName, string(data),
+	)
+	return err
+}
+
+func sqliteInt64s(tx *sql.Tx, query string, args ...any) ([]int64, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var values []int64
+	for rows.Next() {
+		var v int64
+		if err = rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+func sqlPlaceholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// unixNano returns time as unix nanoseconds, zero time is 0
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
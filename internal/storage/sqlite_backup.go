@@ -0,0 +1,421 @@
+package storage
+
+import (
+	"database/sql"
+	"slices"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ExportUser returns backup of everything that is stored for the user
+func (s *SQLiteStorage) ExportUser(userID int64) (Backup, error) {
+	backup := Backup{
+		Version:    BackupVersion,
+		CreatedAt:  time.Now(),
+		Texts:      []BackupText{},
+		Bookmarks:  []Bookmark{},
+		Highlights: []Highlight{},
+		Recipes:    []UserRecipe{},
+		Reminders:  []Reminder{},
+		History:    []ReadingEvent{},
+	}
+	err := s.view(func(tx *sql.Tx) error {
+		texts, err := sqliteGetTexts(tx, userID)
+		if err != nil {
+			return err
+		}
+		if backup.ChunkSize, err = sqliteGetChunkSize(tx, userID); err != nil {
+			return err
+		}
+		if texts.Current != NotSelected {
+			backup.CurrentText = texts.Texts[texts.Current].UUID
+		}
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		for _, text := range texts.Texts {
+			content, err := sqliteTextContent(tx, text, c)
+			if err != nil {
+				return errors.Wrapf(err, "failed to export text %q", text.Name)
+			}
+			backup.Texts = append(backup.Texts, BackupText{
+				UUID:         text.UUID,
+				Name:         text.Name,
+				Source:       text.Source,
+				CurrentChunk: text.CurrentChunk,
+				Position:     content.Text.Position,
+				Language:     text.Language,
+				ChunkSize:    text.ChunkSize,
+				CheckSum:     text.CheckSum,
+				State:        text.State,
+				Tags:         text.Tags,
+				CreatedAt:    text.CreatedAt,
+				ModifiedAt:   text.ModifiedAt,
+				FullText:     content.FullText,
+				Chunks:       content.Chunks,
+				Attachments:  content.Attachments,
+			})
+		}
+		if backup.Reading, err = sqliteGetReading(tx, userID); err != nil {
+			return err
+		}
+		if backup.List, err = sqliteGetListPreferences(tx, userID); err != nil {
+			return err
+		}
+		bookmarks, err := sqliteGetBookmarks(tx, userID, "", c)
+		if err != nil {
+			return err
+		}
+		backup.Bookmarks = append(backup.Bookmarks, bookmarks...)
+		highlights, err := sqliteGetHighlights(tx, userID, "", c)
+		if err != nil {
+			return err
+		}
+		backup.Highlights = append(backup.Highlights, highlights...)
+		if backup.Dust, err = sqliteGetDust(tx, userID); err != nil {
+			return err
+		}
+		if backup.Herb, err = sqliteGetHerb(tx, userID); err != nil {
+			return err
+		}
+		if backup.Level, err = sqliteGetLevel(tx, userID); err != nil {
+			return err
+		}
+		if backup.Stat, err = sqliteGetStat(tx, userID); err != nil {
+			return err
+		}
+		recipeNames, err := sqliteStrings(tx, `SELECT recipe_name FROM user_recipes WHERE user_id = ? ORDER BY recipe_name`, userID)
+		if err != nil {
+			return err
+		}
+		for _, name := range recipeNames {
+			recipe, err := sqliteGetUserRecipe(tx, userID, name)
+			if err != nil {
+				return err
+			}
+			backup.Recipes = append(backup.Recipes, recipe)
+		}
+		reminders, err := sqliteQueryReminders(tx, `WHERE user_id = ? ORDER BY rowid`, userID)
+		if err != nil {
+			return err
+		}
+		for _, reminder := range reminders {
+			backup.Reminders = append(backup.Reminders, reminder.Reminder)
+		}
+		events, err := sqliteGetReadingEvents(tx, userID, time.Time{})
+		if err != nil {
+			return err
+		}
+		backup.History = append(backup.History, events...)
+		return nil
+	})
+	return backup, errors.Wrap(err, "failed to export user")
+}
+
+// ImportUser restores backup of the user, returns number of restored texts
+func (s *SQLiteStorage) ImportUser(userID int64, backup Backup, mode RestoreMode) (int, error) {
+	if err := checkRestore(backup, mode); err != nil {
+		return 0, err
+	}
+	replace := mode != RestoreMerge
+	var restored int
+	err := s.update(func(tx *sql.Tx) error {
+		texts, err := sqliteGetTexts(tx, userID)
+		if err != nil {
+			return err
+		}
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		var deletedContents []string
+		if replace {
+			for _, text := range texts.Texts {
+				if err = sqliteUnindexText(tx, userID, text.UUID); err != nil {
+					return err
+				}
+				if ownsBucket(text) {
+					deletedContents = append(deletedContents, string(text.BucketName))
+				}
+			}
+			texts = defaultUserTexts()
+		}
+
+		// texts from file can get uuid of the same file already saved by other users
+		textUUIDs := make(map[string]string, len(backup.Texts))
+		var imported []Text
+		for _, backupText := range backup.Texts {
+			if slices.ContainsFunc(texts.Texts, func(text Text) bool { return text.UUID == backupText.UUID }) {
+				textUUIDs[backupText.UUID] = backupText.UUID
+				continue
+			}
+			text, err := sqliteImportText(tx, backupText, c, mode == RestoreCopy)
+			if err != nil {
+				return errors.Wrapf(err, "failed to import text %q", backupText.Name)
+			}
+			if slices.ContainsFunc(texts.Texts, func(t Text) bool { return t.UUID == text.UUID }) {
+				textUUIDs[backupText.UUID] = text.UUID
+				continue
+			}
+			text.Name = uniqueTextName(texts, text.Name)
+			texts.Texts = append(texts.Texts, text)
+			imported = append(imported, text)
+			textUUIDs[backupText.UUID] = text.UUID
+			restored++
+		}
+		if texts.Current == NotSelected && backup.CurrentText != "" {
+			texts.Current = slices.IndexFunc(texts.Texts, func(text Text) bool {
+				return text.UUID == textUUIDs[backup.CurrentText]
+			})
+		}
+		if err = sqlitePutTexts(tx, userID, texts); err != nil {
+			return err
+		}
+		for _, contentID := range deletedContents {
+			if _, err = tx.Exec(`DELETE FROM contents WHERE id = ?`, contentID); err != nil {
+				return err
+			}
+		}
+		for _, text := range imported {
+			if err = sqliteIndexText(tx, userID, text, c); err != nil {
+				return err
+			}
+		}
+		chunkSize, err := sqliteGetChunkSize(tx, userID)
+		if err != nil {
+			return err
+		}
+		if replace || chunkSize == 0 {
+			if err = sqlitePutChunkSize(tx, userID, backup.ChunkSize); err != nil {
+				return err
+			}
+		}
+
+		if err = sqliteImportMarks(tx, userID, backup, textUUIDs, replace, c); err != nil {
+			return err
+		}
+		if mode == RestoreCopy {
+			if err = sqliteImportReadingEvents(tx, userID, backup, textUUIDs, replace); err != nil {
+				return err
+			}
+			if err = sqliteImportGame(tx, userID, backup, replace); err != nil {
+				return err
+			}
+		}
+		return sqliteImportProgress(tx, userID, backup, replace)
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to import user")
+	}
+	return restored, nil
+}
+
+// sqliteImportText saves chunks of the text, see importText
+func sqliteImportText(tx *sql.Tx, backupText BackupText, c *textCipher, copied bool) (Text, error) {
+	text, err := importedText(backupText)
+	if err != nil {
+		return Text{}, err
+	}
+	if !copied {
+		text = ownedText(text)
+	}
+	text.Encrypted = c != nil
+	if !ownsBucket(text) {
+		pf, err := sqliteGetProcessedFile(tx, `checksum = ? AND chunk_size = ?`, text.CheckSum, text.ChunkSize)
+		switch err {
+		case nil:
+			text.UUID = pf.UUID
+			text.BucketName = pf.BucketName
+			return text, nil
+		case ErrNotFound:
+		default:
+			return Text{}, err
+		}
+	}
+	contentID, err := sqliteFillContent(tx, backupText.FullText, backupText.Chunks, backupText.Attachments, c)
+	if err != nil {
+		return Text{}, err
+	}
+	text.BucketName = []byte(contentID)
+	if ownsBucket(text) {
+		return text, nil
+	}
+	return text, sqlitePutProcessedFile(tx, ProcessedFile{
+		UUID:       text.UUID,
+		BucketName: text.BucketName,
+		ChunkSize:  text.ChunkSize,
+		Language:   text.Language,
+		CheckSum:   text.CheckSum,
+		CreatedAt:  time.Now(),
+	})
+}
+
+// sqliteImportMarks restores bookmarks and highlights of restored texts
+func sqliteImportMarks(tx *sql.Tx, userID int64, backup Backup, textUUIDs map[string]string, replace bool, c *textCipher) error {
+	if replace {
+		if _, err := tx.Exec(`DELETE FROM bookmarks WHERE user_id = ?`, userID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM highlights WHERE user_id = ?`, userID); err != nil {
+			return err
+		}
+	}
+	bookmarks, err := sqliteGetBookmarks(tx, userID, "", c)
+	if err != nil {
+		return err
+	}
+	for _, bookmark := range backup.Bookmarks {
+		textUUID, ok := textUUIDs[bookmark.TextUUID]
+		if !ok || slices.ContainsFunc(bookmarks, func(m Bookmark) bool { return m.UUID == bookmark.UUID }) {
+			continue
+		}
+		bookmark.TextUUID = textUUID
+		if err = sqlitePutBookmark(tx, userID, bookmark, c); err != nil {
+			return err
+		}
+		bookmarks = append(bookmarks, bookmark)
+	}
+
+	highlights, err := sqliteGetHighlights(tx, userID, "", c)
+	if err != nil {
+		return err
+	}
+	for _, highlight := range backup.Highlights {
+		textUUID, ok := textUUIDs[highlight.TextUUID]
+		if !ok || slices.ContainsFunc(highlights, func(h Highlight) bool { return h.UUID == highlight.UUID }) {
+			continue
+		}
+		highlight.TextUUID = textUUID
+		if err = sqlitePutHighlight(tx, userID, highlight, c); err != nil {
+			return err
+		}
+		highlights = append(highlights, highlight)
+	}
+	return nil
+}
+
+// sqliteImportProgress restores reading and list settings, loot, level and stats.
+// When merging, values are restored only if the user has none.
+func sqliteImportProgress(tx *sql.Tx, userID int64, backup Backup, replace bool) error {
+	reading, err := sqliteGetReading(tx, userID)
+	if err != nil {
+		return err
+	}
+	if replace || (reading.ChunkSeconds == 0 && len(reading.Speeds) == 0) {
+		if err = sqlitePutReading(tx, userID, backup.Reading); err != nil {
+			return err
+		}
+	}
+
+	prefs, err := sqliteGetListPreferences(tx, userID)
+	if err != nil {
+		return err
+	}
+	if replace || prefs == (ListPreferences{}) {
+		if err = sqlitePutListPreferences(tx, userID, backup.List); err != nil {
+			return err
+		}
+	}
+
+	var reminders []Reminder
+	if replace {
+		if _, err = tx.Exec(`DELETE FROM reminders WHERE user_id = ?`, userID); err != nil {
+			return err
+		}
+	} else {
+		existing, err := sqliteQueryReminders(tx, `WHERE user_id = ? ORDER BY rowid`, userID)
+		if err != nil {
+			return err
+		}
+		for _, reminder := range existing {
+			reminders = append(reminders, reminder.Reminder)
+		}
+	}
+	for _, reminder := range mergeReminders(reminders, backup.Reminders)[len(reminders):] {
+		if err = sqlitePutReminder(tx, userID, reminder); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqliteImportGame restores loot, level, stats and recipes, see importGame
+func sqliteImportGame(tx *sql.Tx, userID int64, backup Backup, replace bool) error {
+	dust, err := sqliteGetDust(tx, userID)
+	if err != nil {
+		return err
+	}
+	if replace || dust == (Dust{}) {
+		if err = sqlitePutDust(tx, userID, backup.Dust); err != nil {
+			return err
+		}
+	}
+
+	herb, err := sqliteGetHerb(tx, userID)
+	if err != nil {
+		return err
+	}
+	if replace || herb == (Herb{}) {
+		if err = sqlitePutHerb(tx, userID, backup.Herb); err != nil {
+			return err
+		}
+	}
+
+	level, err := sqliteGetLevel(tx, userID)
+	if err != nil {
+		return err
+	}
+	if replace || level == (Level{}) {
+		if err = sqlitePutLevel(tx, userID, backup.Level); err != nil {
+			return err
+		}
+	}
+
+	stat, err := sqliteGetStat(tx, userID)
+	if err != nil {
+		return err
+	}
+	if replace || stat == (Stat{}) {
+		if err = sqlitePutStat(tx, userID, backup.Stat); err != nil {
+			return err
+		}
+	}
+
+	for _, recipe := range backup.Recipes {
+		var exists bool
+		err = tx.QueryRow(
+			`SELECT EXISTS (SELECT 1 FROM user_recipes WHERE user_id = ? AND recipe_name = ?)`,
+			userID, recipe.RecipeName,
+		).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !replace && exists {
+			continue
+		}
+		recipe.UserID = userID
+		if err = sqlitePutUserRecipe(tx, userID, recipe.RecipeName, recipe); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sqliteStrings(tx *sql.Tx, query string, args ...any) ([]string, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var v string
+		if err = rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
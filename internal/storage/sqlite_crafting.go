@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// SaveRecipe adds the recipe or replaces the recipe with the same name
+func (s *SQLiteStorage) SaveRecipe(recipe Recipe) error {
+	data, err := json.Marshal(recipe)
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT OR REPLACE INTO recipes (name, data) VALUES (?, ?)`, recipe.Name, string(data))
+		return err
+	})
+}
+
+// GetRecipes returns all recipes sorted by name
+func (s *SQLiteStorage) GetRecipes() ([]Recipe, error) {
+	var recipes []Recipe
+	err := s.view(func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT data FROM recipes ORDER BY name`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var data string
+			if err = rows.Scan(&data); err != nil {
+				return err
+			}
+			var recipe Recipe
+			if err = json.Unmarshal([]byte(data), &recipe); err != nil {
+				return errors.Wrap(err, "failed to unmarshal recipe")
+			}
+			recipes = append(recipes, recipe)
+		}
+		return rows.Err()
+	})
+	return recipes, err
+}
+
+// UpdateLoot updates dust and herbs of the user in one transaction, see Storage.UpdateLoot
+func (s *SQLiteStorage) UpdateLoot(userID int64, updFunc func(*Dust, *Herb) error) error {
+	return s.update(func(tx *sql.Tx) error {
+		dust, err := sqliteGetDust(tx, userID)
+		if err != nil {
+			return err
+		}
+		herb, err := sqliteGetHerb(tx, userID)
+		if err != nil {
+			return err
+		}
+		if err = updFunc(&dust, &herb); err != nil {
+			return err
+		}
+		if err = sqlitePutDust(tx, userID, dust); err != nil {
+			return err
+		}
+		return sqlitePutHerb(tx, userID, herb)
+	})
+}
+
+// UpdateBuffs replaces buffs of the user with result of updFunc
+func (s *SQLiteStorage) UpdateBuffs(userID int64, updFunc func([]Buff) []Buff) ([]Buff, error) {
+	var buffs []Buff
+	err := s.update(func(tx *sql.Tx) error {
+		var err error
+		if buffs, err = sqliteGetBuffs(tx, userID); err != nil {
+			return err
+		}
+		buffs = updFunc(buffs)
+		if len(buffs) == 0 {
+			_, err = tx.Exec(`DELETE FROM buffs WHERE user_id = ?`, userID)
+			return err
+		}
+		data, err := json.Marshal(buffs)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT OR REPLACE INTO buffs (user_id, data) VALUES (?, ?)`, userID, string(data))
+		return err
+	})
+	return buffs, err
+}
+
+// GetBuffs returns buffs of the user, see Storage.GetBuffs
+func (s *SQLiteStorage) GetBuffs(userID int64) (buffs []Buff, err error) {
+	err = s.view(func(tx *sql.Tx) error {
+		buffs, err = sqliteGetBuffs(tx, userID)
+		return err
+	})
+	return buffs, err
+}
+
+func sqliteGetBuffs(tx *sql.Tx, userID int64) (buffs []Buff, err error) {
+	err = sqliteGetJSON(tx, &buffs, `SELECT data FROM buffs WHERE user_id = ?`, userID)
+	return buffs, errors.Wrap(err, "failed to get buffs")
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// SetKeyWrapper enables encryption of texts, see Storage.SetKeyWrapper
+func (s *SQLiteStorage) SetKeyWrapper(keys KeyWrapper) {
+	s.keys = keys
+}
+
+// EncryptTexts encrypts texts, bookmarks and highlights of the user with a new data key
+// and vacuums the database, see Storage.EncryptTexts
+func (s *SQLiteStorage) EncryptTexts(userID int64) error {
+	err := s.update(func(tx *sql.Tx) error {
+		from, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		to, wrapped, err := newWrappedDataKey(s.keys)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO data_keys (user_id, wrapped) VALUES (?, ?)
+			ON CONFLICT (user_id) DO UPDATE SET wrapped = excluded.wrapped`,
+			userID, wrapped,
+		)
+		if err != nil {
+			return err
+		}
+		return sqliteReencryptTexts(tx, userID, from, to)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to encrypt texts")
+	}
+	_, err = s.db.Exec(`VACUUM`)
+	return errors.Wrap(err, "failed to vacuum database")
+}
+
+// DecryptTexts stores texts, bookmarks and highlights of the user without encryption
+func (s *SQLiteStorage) DecryptTexts(userID int64) error {
+	err := s.update(func(tx *sql.Tx) error {
+		from, err := s.userCipher(tx, userID)
+		if err != nil || from == nil {
+			return err
+		}
+		if err = sqliteReencryptTexts(tx, userID, from, nil); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM data_keys WHERE user_id = ?`, userID)
+		return err
+	})
+	return errors.Wrap(err, "failed to decrypt texts")
+}
+
+// TextsEncrypted returns true if texts of the user are encrypted
+func (s *SQLiteStorage) TextsEncrypted(userID int64) (bool, error) {
+	var encrypted bool
+	err := s.view(func(tx *sql.Tx) error {
+		return tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM data_keys WHERE user_id = ?)`, userID).Scan(&encrypted)
+	})
+	return encrypted, err
+}
+
+// RewrapDataKeys encrypts data keys of all users with the newest key, see Storage.RewrapDataKeys
+func (s *SQLiteStorage) RewrapDataKeys() (int, error) {
+	var count int
+	err := s.update(func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT user_id, wrapped FROM data_keys`)
+		if err != nil {
+			return err
+		}
+		rewrapped := make(map[int64][]byte)
+		for rows.Next() {
+			var userID int64
+			var wrapped []byte
+			if err := rows.Scan(&userID, &wrapped); err != nil {
+				rows.Close()
+				return err
+			}
+			if rewrapped[userID], err = rewrapDataKey(s.keys, wrapped); err != nil {
+				rows.Close()
+				return errors.Wrapf(err, "failed to rewrap data key of user %d", userID)
+			}
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for userID, wrapped := range rewrapped {
+			if _, err := tx.Exec(`UPDATE data_keys SET wrapped = ? WHERE user_id = ?`, wrapped, userID); err != nil {
+				return err
+			}
+		}
+		count = len(rewrapped)
+		return nil
+	})
+	return count, errors.Wrap(err, "failed to rewrap data keys")
+}
+
+// userCipher returns cipher of the user's texts, nil if the user's texts are not encrypted
+func (s *SQLiteStorage) userCipher(tx *sql.Tx, userID int64) (*textCipher, error) {
+	var wrapped []byte
+	err := tx.QueryRow(`SELECT wrapped FROM data_keys WHERE user_id = ?`, userID).Scan(&wrapped)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return unwrapTextCipher(s.keys, wrapped)
+}
+
+// sqliteReencryptTexts moves content of the user's texts to new contents encrypted with to cipher
+func sqliteReencryptTexts(tx *sql.Tx, userID int64, from, to *textCipher) error {
+	texts, err := sqliteGetTexts(tx, userID)
+	if err != nil {
+		return err
+	}
+	for i, text := range texts.Texts {
+		content, err := sqliteTextContent(tx, text, from)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read text %q", text.Name)
+		}
+		contentID, err := sqliteFillContent(tx, content.FullText, content.Chunks, content.Attachments, to)
+		if err != nil {
+			return err
+		}
+		if ownsBucket(text) {
+			if _, err = tx.Exec(`DELETE FROM contents WHERE id = ?`, string(text.BucketName)); err != nil {
+				return err
+			}
+		}
+		text.BucketName = []byte(contentID)
+		text.Encrypted = to != nil
+		texts.Texts[i] = text
+		if err = sqliteIndexText(tx, userID, text, to); err != nil {
+			return err
+		}
+	}
+	if err = sqlitePutTexts(tx, userID, texts); err != nil {
+		return err
+	}
+
+	bookmarks, err := sqliteGetBookmarks(tx, userID, "", from)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM bookmarks WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for _, bookmark := range bookmarks {
+		if err = sqlitePutBookmark(tx, userID, bookmark, to); err != nil {
+			return err
+		}
+	}
+	highlights, err := sqliteGetHighlights(tx, userID, "", from)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM highlights WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for _, highlight := range highlights {
+		if err = sqlitePutHighlight(tx, userID, highlight, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
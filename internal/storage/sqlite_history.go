@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+)
+
+// AddReadingEvent appends event to the reading history of the user
+func (s *SQLiteStorage) AddReadingEvent(userID int64, event ReadingEvent) error {
+	return s.update(func(tx *sql.Tx) error {
+		return sqliteAddReadingEvent(tx, userID, event)
+	})
+}
+
+// GetReadingEvents returns events of the user which happened at or after since, the oldest first
+func (s *SQLiteStorage) GetReadingEvents(userID int64, since time.Time) ([]ReadingEvent, error) {
+	var events []ReadingEvent
+	err := s.view(func(tx *sql.Tx) error {
+		var err error
+		events, err = sqliteGetReadingEvents(tx, userID, since)
+		return err
+	})
+	return events, err
+}
+
+func sqliteAddReadingEvent(tx *sql.Tx, userID int64, event ReadingEvent) error {
+	_, err := tx.Exec(
+		`INSERT INTO reading_events (user_id, at, text_uuid, chunk, chars, words, seconds) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		userID, unixNano(event.At), event.TextUUID, event.Chunk, event.Chars, event.Words, event.Seconds,
+	)
+	return err
+}
+
+func sqliteGetReadingEvents(tx *sql.Tx, userID int64, since time.Time) ([]ReadingEvent, error) {
+	rows, err := tx.Query(`
+		SELECT at, text_uuid, chunk, chars, words, seconds FROM reading_events
+		WHERE user_id = ? AND at >= ? ORDER BY at, rowid`,
+		userID, unixNano(since),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []ReadingEvent
+	for rows.Next() {
+		var (
+			event ReadingEvent
+			at    int64
+		)
+		if err = rows.Scan(&at, &event.TextUUID, &event.Chunk, &event.Chars, &event.Words, &event.Seconds); err != nil {
+			return nil, err
+		}
+		event.At = fromUnixNano(at)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// sqliteImportReadingEvents adds restored events which the user doesn't have yet, see importReadingEvents
+func sqliteImportReadingEvents(tx *sql.Tx, userID int64, backup Backup, textUUIDs map[string]string, replace bool) error {
+	var events []ReadingEvent
+	if replace {
+		if _, err := tx.Exec(`DELETE FROM reading_events WHERE user_id = ?`, userID); err != nil {
+			return err
+		}
+	} else {
+		var err error
+		if events, err = sqliteGetReadingEvents(tx, userID, time.Time{}); err != nil {
+			return err
+		}
+	}
+	for _, event := range restoredReadingEvents(events, backup.History, textUUIDs) {
+		if err := sqliteAddReadingEvent(tx, userID, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// AddReminder saves reminder of the user, see Storage.AddReminder
+func (s *SQLiteStorage) AddReminder(userID int64, reminder Reminder) (Reminder, error) {
+	reminder.UUID = uuid.NewString()
+	reminder.CreatedAt = time.Now()
+	err := s.update(func(tx *sql.Tx) error {
+		return sqlitePutReminder(tx, userID, reminder)
+	})
+	return reminder, err
+}
+
+// GetReminders returns reminders of the user in order they were added
+func (s *SQLiteStorage) GetReminders(userID int64) ([]Reminder, error) {
+	var reminders []Reminder
+	err := s.view(func(tx *sql.Tx) error {
+		due, err := sqliteQueryReminders(tx, `WHERE user_id = ? ORDER BY rowid`, userID)
+		for _, reminder := range due {
+			reminders = append(reminders, reminder.Reminder)
+		}
+		return err
+	})
+	return reminders, err
+}
+
+func (s *SQLiteStorage) DeleteReminder(userID int64, reminderUUID string) error {
+	return s.update(func(tx *sql.Tx) error {
+		return sqliteDeleteOne(tx, `DELETE FROM reminders WHERE user_id = ? AND uuid = ?`, userID, reminderUUID)
+	})
+}
+
+// RescheduleReminder sets when the reminder is sent next time
+func (s *SQLiteStorage) RescheduleReminder(userID int64, reminderUUID string, nextAt time.Time) error {
+	return s.update(func(tx *sql.Tx) error {
+		result, err := tx.Exec(
+			`UPDATE reminders SET next_at = ? WHERE user_id = ? AND uuid = ?`,
+			unixNano(nextAt), userID, reminderUUID,
+		)
+		if err != nil {
+			return err
+		}
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// DueReminders returns reminders of all users that should be sent at now, the most overdue first
+func (s *SQLiteStorage) DueReminders(now time.Time) ([]UserReminder, error) {
+	var due []UserReminder
+	err := s.view(func(tx *sql.Tx) error {
+		var err error
+		due, err = sqliteQueryReminders(tx, `WHERE next_at <= ? ORDER BY next_at, rowid`, unixNano(now))
+		return err
+	})
+	return due, err
+}
+
+func sqlitePutReminder(tx *sql.Tx, userID int64, reminder Reminder) error {
+	weekdays, err := json.Marshal(reminder.Weekdays)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO reminders (user_id, uuid, weekdays, hour, minute, timezone, mode, language, next_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, reminder.UUID, string(weekdays), reminder.Hour, reminder.Minute, reminder.Timezone,
+		string(reminder.Mode), reminder.Language, unixNano(reminder.NextAt), unixNano(reminder.CreatedAt),
+	)
+	return err
+}
+
+// sqliteQueryReminders returns reminders selected by the where clause with ordering
+func sqliteQueryReminders(tx *sql.Tx, where string, args ...any) ([]UserReminder, error) {
+	rows, err := tx.Query(`
+		SELECT user_id, uuid, weekdays, hour, minute, timezone, mode, language, next_at, created_at
+		FROM reminders `+where,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var reminders []UserReminder
+	for rows.Next() {
+		var (
+			reminder          UserReminder
+			weekdays, mode    string
+			nextAt, createdAt int64
+		)
+		err = rows.Scan(
+			&reminder.UserID, &reminder.Reminder.UUID, &weekdays, &reminder.Reminder.Hour, &reminder.Reminder.Minute,
+			&reminder.Reminder.Timezone, &mode, &reminder.Reminder.Language, &nextAt, &createdAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal([]byte(weekdays), &reminder.Reminder.Weekdays); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal weekdays of reminder")
+		}
+		reminder.Reminder.Mode = ReminderMode(mode)
+		reminder.Reminder.NextAt = fromUnixNano(nextAt)
+		reminder.Reminder.CreatedAt = fromUnixNano(createdAt)
+		reminders = append(reminders, reminder)
+	}
+	return reminders, rows.Err()
+}
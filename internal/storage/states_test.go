@@ -0,0 +1,48 @@
+package storage_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/pechorka/adhd-reader/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTextStates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := storage.NewStorage(path)
+	require.NoError(t, err)
+	defer s.Close()
+	userID := int64(1)
+
+	chunks := []string{"First.", "Second.", "Third."}
+	for _, name := range []string{"queued", "reading", "finished"} {
+		_, err = s.AddText(userID, storage.NewText{Name: name, Text: "First. Second. Third.", Chunks: chunks})
+		require.NoError(t, err)
+	}
+	texts, err := s.GetTexts(userID)
+	require.NoError(t, err)
+	for _, text := range texts {
+		require.Equal(t, storage.StateQueued, text.State)
+	}
+
+	// reading changes state
+	selectChunk := func(text int, chunk int64) {
+		t.Helper()
+		require.NoError(t, s.UpdateTexts(userID, func(texts *storage.UserTexts) error {
+			texts.Current = text
+			return nil
+		}))
+		_, _, err = s.SelectChunk(userID, func(storage.Text, int64, int64) (int64, error) {
+			return chunk, nil
+		})
+		require.NoError(t, err)
+	}
+	selectChunk(1, 1)
+	selectChunk(2, 2)
+	texts, err = s.GetTexts(userID)
+	require.NoError(t, err)
+	require.Equal(t, storage.StateReading, texts[1].State)
+	require.Equal(t, storage.StateFinished, texts[2].State)
+
+}
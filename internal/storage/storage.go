@@ -7,10 +7,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/pechorka/adhd-reader/pkg/textspliter"
 	"github.com/pkg/errors"
 	bolt "go.etcd.io/bbolt"
 )
@@ -32,16 +35,26 @@ var (
 	bktRecipe         = []byte("recipe")
 	bktUserRecipe     = []byte("user_recipe")
 	bktAuth           = []byte("auth")
+	bktReading        = []byte("reading")
+	bktBookmarks      = []byte("bookmarks")
+	bktHighlights     = []byte("highlights")
+	bktSearchIndex    = []byte("search_index")
+	bktListPrefs      = []byte("list_preferences")
 )
 
 var (
-	fullTextKey    = []byte("full_text")
-	totalChunksKey = []byte("total_chunks")
+	fullTextKey     = []byte("full_text")
+	totalChunksKey  = []byte("total_chunks")
+	chunkOffsetsKey = []byte("chunk_offsets") // positions of chunks beginnings, see Text.Position
+	lengthKey       = []byte("length")        // visible characters in the text, missing for texts saved before it
+	attachmentsKey  = []byte("attachments")   // nested bucket with files referenced from text
 )
 
 // Storage is a wrapper around bolt.DB
 type Storage struct {
+	mu        sync.RWMutex // db is replaced by Compact
 	db        *bolt.DB
+	keys      KeyWrapper // nil if encryption is not configured
 	closeFunc func() error
 }
 
@@ -51,10 +64,13 @@ func NewStorage(path string) (*Storage, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Storage{
-		db:        db,
-		closeFunc: db.Close,
-	}, nil
+	if _, err = migrate(db); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "failed to migrate database")
+	}
+	s := &Storage{db: db}
+	s.closeFunc = s.closeDB
+	return s, nil
 }
 
 func NewTempStorage() (*Storage, error) {
@@ -78,6 +94,24 @@ func (s *Storage) Close() error {
 	return s.closeFunc()
 }
 
+func (s *Storage) closeDB() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.Close()
+}
+
+func (s *Storage) view(fn func(tx *bolt.Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db.View(fn)
+}
+
+func (s *Storage) update(fn func(tx *bolt.Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db.Update(fn)
+}
+
 type FullText struct {
 	Name string
 	Text string
@@ -85,7 +119,7 @@ type FullText struct {
 
 func (s *Storage) GetCurrentFullText(userID int64) (FullText, error) {
 	var fullText FullText
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bktUserInfo)
 		if b == nil {
 			return nil
@@ -99,16 +133,22 @@ func (s *Storage) GetCurrentFullText(userID int64) (FullText, error) {
 			return errors.New("no text selected")
 		}
 
-		tb := tx.Bucket(texts.Texts[texts.Current].BucketName)
+		curText := texts.Texts[texts.Current]
+		tb := tx.Bucket(curText.BucketName)
 		if tb == nil {
 			return errors.New("current text has incorrect bucket")
 		}
-		bFullText := tb.Get(fullTextKey)
-		if bFullText == nil {
+		if tb.Get(fullTextKey) == nil {
 			return errors.New("new text is missing")
 		}
-		fullText.Text = string(bFullText)
-		fullText.Name = texts.Texts[texts.Current].Name
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		if fullText.Text, err = getFullText(tb, c.of(curText)); err != nil {
+			return err
+		}
+		fullText.Name = curText.Name
 
 		return nil
 	})
@@ -118,7 +158,7 @@ func (s *Storage) GetCurrentFullText(userID int64) (FullText, error) {
 
 func (s *Storage) AddText(userID int64, newText NewText) (string, error) {
 	textUUID := uuid.New().String()
-	err := s.db.Update(func(tx *bolt.Tx) error {
+	err := s.update(func(tx *bolt.Tx) error {
 		// update user bucket
 		b, err := tx.CreateBucketIfNotExists(bktUserInfo)
 		if err != nil {
@@ -132,30 +172,47 @@ func (s *Storage) AddText(userID int64, newText NewText) (string, error) {
 		if err = validateUserTexts(texts, textNameUnique(newText.Name)); err != nil {
 			return err
 		}
-		textBucketName, err := fillTextBucket(tx, newText.Text, newText.Chunks)
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		textBucketName, err := fillTextBucket(tx, newText.Text, newText.Chunks, newText.Attachments, c)
 		if err != nil {
 			return err
 		}
 		now := time.Now()
-		texts.Texts = append(texts.Texts, Text{
+		source := newText.Source
+		if source == "" {
+			source = SourceText
+		}
+		text := Text{
 			UUID:         textUUID,
 			Name:         newText.Name,
-			Source:       SourceText,
+			Source:       source,
 			BucketName:   textBucketName,
 			CurrentChunk: NotSelected,
+			Position:     NotSelected,
+			Language:     newText.Language,
+			State:        StateQueued,
+			ChunkSize:    newText.ChunkSize,
+			CheckSum:     newText.CheckSum,
+			Encrypted:    c != nil,
 			CreatedAt:    now,
 			ModifiedAt:   now,
-		})
+		}
+		texts.Texts = append(texts.Texts, text)
 		if err = putTexts(b, id, texts); err != nil {
 			return err
 		}
-		return nil
+		return indexText(tx, userID, text, c)
 	})
 	return textUUID, err
 }
 
+// AddTextFromProcessedFile adds text sharing bucket with the file, returns ErrNotFound if the file was removed.
+// Users with encrypted texts get an encrypted copy of the file instead.
 func (s *Storage) AddTextFromProcessedFile(userId int64, name string, pf ProcessedFile) (string, error) {
-	return pf.UUID, s.db.Update(func(tx *bolt.Tx) error {
+	return pf.UUID, s.update(func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists(bktUserInfo)
 		if err != nil {
 			return err
@@ -172,20 +229,41 @@ func (s *Storage) AddTextFromProcessedFile(userId int64, name string, pf Process
 		); err != nil {
 			return err
 		}
+		// file could be removed by garbage collection after it was found
+		if tx.Bucket(pf.BucketName) == nil {
+			return ErrNotFound
+		}
+		c, err := s.userCipher(tx, userId)
+		if err != nil {
+			return err
+		}
+		bucketName := pf.BucketName
+		if c != nil {
+			if bucketName, err = copyTextBucket(tx, pf.BucketName, c); err != nil {
+				return err
+			}
+		}
 		now := time.Now()
-		texts.Texts = append(texts.Texts, Text{
+		text := Text{
 			UUID:         pf.UUID,
 			Name:         name,
 			Source:       SourceFile,
-			BucketName:   pf.BucketName,
+			BucketName:   bucketName,
 			CurrentChunk: NotSelected,
+			Position:     NotSelected,
+			Language:     pf.Language,
+			State:        StateQueued,
+			ChunkSize:    pf.ChunkSize,
+			CheckSum:     pf.CheckSum,
+			Encrypted:    c != nil,
 			CreatedAt:    now,
 			ModifiedAt:   now,
-		})
+		}
+		texts.Texts = append(texts.Texts, text)
 		if err = putTexts(b, id, texts); err != nil {
 			return err
 		}
-		return nil
+		return indexText(tx, userId, text, c)
 	})
 }
 
@@ -230,7 +308,7 @@ func textUUIDUnique(textUUID string) textValidatorFunc {
 
 func (s *Storage) GetTexts(id int64) ([]TextWithChunkInfo, error) {
 	var result []TextWithChunkInfo
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bktUserInfo)
 		if b == nil {
 			return nil
@@ -248,7 +326,7 @@ func (s *Storage) GetTexts(id int64) ([]TextWithChunkInfo, error) {
 
 func (s *Storage) GetCurrentText(id int64) (TextWithChunkInfo, error) {
 	var result TextWithChunkInfo
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bktUserInfo)
 		if b == nil {
 			return nil
@@ -273,7 +351,7 @@ func (s *Storage) GetCurrentText(id int64) (TextWithChunkInfo, error) {
 
 func (s *Storage) GetFullTexts(id int64, after *time.Time, page, pageSize int) ([]TextWithChunks, error) {
 	var result []TextWithChunks
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bktUserInfo)
 		if b == nil {
 			return nil
@@ -288,8 +366,12 @@ func (s *Storage) GetFullTexts(id int64, after *time.Time, page, pageSize int) (
 				return text.CreatedAt.After(*after)
 			})
 		}
+		c, err := s.userCipher(tx, id)
+		if err != nil {
+			return err
+		}
 		if page < 0 {
-			result, err = fullTexts(tx, texts)
+			result, err = fullTexts(tx, texts, c)
 			return err
 		}
 
@@ -303,7 +385,7 @@ func (s *Storage) GetFullTexts(id int64, after *time.Time, page, pageSize int) (
 		}
 
 		texts.Texts = texts.Texts[from:to]
-		result, err = fullTexts(tx, texts)
+		result, err = fullTexts(tx, texts, c)
 		return err
 	})
 	return result, err
@@ -312,7 +394,7 @@ func (s *Storage) GetFullTexts(id int64, after *time.Time, page, pageSize int) (
 type UpdateTextsFunc func(*UserTexts) error
 
 func (s *Storage) UpdateTexts(userID int64, updFunc UpdateTextsFunc) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
+	return s.update(func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists(bktUserInfo)
 		if err != nil {
 			return err
@@ -322,18 +404,42 @@ func (s *Storage) UpdateTexts(userID int64, updFunc UpdateTextsFunc) error {
 		if err != nil {
 			return err
 		}
+		before := make(map[string]Text, len(texts.Texts))
+		for _, text := range texts.Texts {
+			before[text.UUID] = text
+		}
 		if err = updFunc(&texts); err != nil {
 			return err
 		}
+		// keep position and current chunk in sync, whichever was changed
+		for i := range texts.Texts {
+			text := &texts.Texts[i]
+			old, ok := before[text.UUID]
+			if !ok || old.Position == text.Position && old.CurrentChunk == text.CurrentChunk {
+				continue
+			}
+			offsets, err := textOffsets(tx, *text)
+			if err != nil {
+				return err
+			}
+			if old.Position != text.Position {
+				text.CurrentChunk = chunkAt(offsets, text.Position)
+			} else {
+				text.Position = positionAt(offsets, text.CurrentChunk)
+			}
+		}
 		return putTexts(b, id, texts)
 	})
 }
 
 type SelectChunkFunc func(text Text, curChunk, totalChunks int64) (nextChunk int64, err error)
 
-func (s *Storage) SelectChunk(userID int64, updFunc SelectChunkFunc) (string, error) {
+// SelectChunk moves reading position to the beginning of the chunk selected by updFunc.
+// Returns the text with the new position and the chunk.
+func (s *Storage) SelectChunk(userID int64, updFunc SelectChunkFunc) (Text, string, error) {
 	var chunkText string
-	err := s.db.Update(func(tx *bolt.Tx) error {
+	var curText Text
+	err := s.update(func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists(bktUserInfo)
 		if err != nil {
 			return err
@@ -346,7 +452,7 @@ func (s *Storage) SelectChunk(userID int64, updFunc SelectChunkFunc) (string, er
 		if texts.Current == NotSelected {
 			return errors.New("no text selected")
 		}
-		curText := texts.Texts[texts.Current]
+		curText = texts.Texts[texts.Current]
 		textBucket := tx.Bucket(curText.BucketName)
 		if textBucket == nil { // should not happen
 			return errors.New("unexpected error: text bucket not found")
@@ -356,21 +462,67 @@ func (s *Storage) SelectChunk(userID int64, updFunc SelectChunkFunc) (string, er
 		if err != nil {
 			return err
 		}
+		offsets, err := textOffsets(tx, curText)
+		if err != nil {
+			return err
+		}
 		curText.CurrentChunk = nextChunk
+		curText.Position = positionAt(offsets, nextChunk)
+		curText.State = readingState(curText.State, nextChunk, totalChunks)
 		curText.ModifiedAt = time.Now()
 		texts.Texts[texts.Current] = curText
 		if err = putTexts(b, id, texts); err != nil {
 			return err
 		}
-		chunkText = string(textBucket.Get(int64ToBytes(nextChunk)))
-		return nil
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		chunkText, err = getChunk(textBucket, nextChunk, c.of(curText))
+		return err
+	})
+	return curText, chunkText, err
+}
+
+// GetCurrentChunk returns current chunk of the current text without changing reading position
+func (s *Storage) GetCurrentChunk(userID int64) (CurrentChunk, error) {
+	var current CurrentChunk
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktUserInfo)
+		if b == nil {
+			return errors.New("no text selected")
+		}
+		texts, err := getTexts(b, textsId(userID))
+		if err != nil {
+			return err
+		}
+		if texts.Current == NotSelected {
+			return errors.New("no text selected")
+		}
+		curText := texts.Texts[texts.Current]
+		textBucket := tx.Bucket(curText.BucketName)
+		if textBucket == nil { // should not happen
+			return errors.New("unexpected error: text bucket not found")
+		}
+		offsets := getOffsets(textBucket)
+		current.Text = curText
+		current.Position = max(positionAt(offsets, curText.CurrentChunk), 0)
+		if curText.CurrentChunk == NotSelected {
+			return nil
+		}
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		current.Chunk, err = getChunk(textBucket, curText.CurrentChunk, c.of(curText))
+		return err
 	})
-	return chunkText, err
+	return current, err
 }
 
 func (s *Storage) GetChunkSize(userID int64) (int64, error) {
 	var chunkSize int64
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bktUserInfo)
 		if b == nil {
 			return nil
@@ -383,7 +535,7 @@ func (s *Storage) GetChunkSize(userID int64) (int64, error) {
 }
 
 func (s *Storage) SetChunkSize(userID int64, chunkSize int64) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
+	return s.update(func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists(bktUserInfo)
 		if err != nil {
 			return err
@@ -406,7 +558,7 @@ func (s *Storage) DeleteTextByName(userID int64, textName string) error {
 }
 
 func (s *Storage) deleteTextBy(userID int64, predicate func(Text) bool) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
+	return s.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bktUserInfo)
 		if b == nil {
 			return ErrNotFound
@@ -416,15 +568,25 @@ func (s *Storage) deleteTextBy(userID int64, predicate func(Text) bool) error {
 		if err != nil {
 			return err
 		}
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
 		var found bool
 		for i, text := range texts.Texts {
 			if predicate(text) {
 				// texts from file share the same bucket between users
-				if text.Source != SourceFile {
+				if ownsBucket(text) {
 					if err = tx.DeleteBucket(text.BucketName); err != nil && err != bolt.ErrBucketNotFound {
 						return err
 					}
 				}
+				if err = deleteTextMarks(tx, userID, text.UUID, c); err != nil {
+					return err
+				}
+				if err = unindexText(tx, userID, text.UUID); err != nil {
+					return err
+				}
 				texts.Texts = append(texts.Texts[:i], texts.Texts[i+1:]...)
 				if texts.Current == i {
 					texts.Current = NotSelected
@@ -443,14 +605,83 @@ func (s *Storage) deleteTextBy(userID int64, predicate func(Text) bool) error {
 	})
 }
 
+// GetTextContent returns full text, chunks and attachments of the user's text
+func (s *Storage) GetTextContent(userID int64, textUUID string) (TextContent, error) {
+	var content TextContent
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktUserInfo)
+		if b == nil {
+			return ErrNotFound
+		}
+		texts, err := getTexts(b, textsId(userID))
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(texts.Texts, func(text Text) bool { return text.UUID == textUUID })
+		if i < 0 {
+			return ErrNotFound
+		}
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		content, err = textContent(tx, texts.Texts[i], c)
+		return err
+	})
+	return content, err
+}
+
+// GetAttachment returns file referenced from the user's text by name
+func (s *Storage) GetAttachment(userID int64, textUUID, name string) ([]byte, error) {
+	var content []byte
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktUserInfo)
+		if b == nil {
+			return ErrNotFound
+		}
+		texts, err := getTexts(b, textsId(userID))
+		if err != nil {
+			return err
+		}
+		for _, text := range texts.Texts {
+			if text.UUID != textUUID {
+				continue
+			}
+			textBucket := tx.Bucket(text.BucketName)
+			if textBucket == nil {
+				return ErrNotFound
+			}
+			attachmentsBucket := textBucket.Bucket(attachmentsKey)
+			if attachmentsBucket == nil {
+				return ErrNotFound
+			}
+			v := attachmentsBucket.Get([]byte(name))
+			if v == nil {
+				return ErrNotFound
+			}
+			c, err := s.userCipher(tx, userID)
+			if err != nil {
+				return err
+			}
+			if v, err = c.of(text).open(v); err != nil {
+				return err
+			}
+			content = append([]byte(nil), v...)
+			return nil
+		}
+		return ErrNotFound
+	})
+	return content, err
+}
+
 func (s *Storage) AddProcessedFile(newPf NewProcessedFile) (ProcessedFile, error) {
 	var pf ProcessedFile
-	err := s.db.Update(func(tx *bolt.Tx) error {
+	err := s.update(func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists(bktProcessedFiles)
 		if err != nil {
 			return err
 		}
-		textBucketName, err := fillTextBucket(tx, newPf.Text, newPf.Chunks)
+		textBucketName, err := fillTextBucket(tx, newPf.Text, newPf.Chunks, newPf.Attachments, nil)
 		if err != nil {
 			return err
 		}
@@ -458,16 +689,19 @@ func (s *Storage) AddProcessedFile(newPf NewProcessedFile) (ProcessedFile, error
 			UUID:       uuid.NewString(),
 			BucketName: textBucketName,
 			ChunkSize:  newPf.ChunkSize,
+			Language:   newPf.Language,
 			CheckSum:   newPf.CheckSum,
+			CreatedAt:  time.Now(),
 		}
 		return putProcessedFile(b, pf)
 	})
 	return pf, err
 }
 
+// GetProcessedFileByChecksum returns the file as it was processed first time
 func (s *Storage) GetProcessedFileByChecksum(checksum []byte) (ProcessedFile, error) {
 	var pf ProcessedFile
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bktProcessedFiles)
 		if b == nil {
 			return ErrNotFound
@@ -479,9 +713,137 @@ func (s *Storage) GetProcessedFileByChecksum(checksum []byte) (ProcessedFile, er
 	return pf, err
 }
 
+// GetProcessedFile returns the file split into chunks of chunkSize
+func (s *Storage) GetProcessedFile(checksum []byte, chunkSize int64) (ProcessedFile, error) {
+	var pf ProcessedFile
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktProcessedFiles)
+		if b == nil {
+			return ErrNotFound
+		}
+		var err error
+		pf, err = getProcessedFileVariant(b, checksum, chunkSize)
+		return err
+	})
+	return pf, err
+}
+
+type RechunkFunc func(text Text, fullText string) (Rechunked, error)
+
+// RechunkTexts replaces chunks of user's texts selected by predicate with chunks returned by rechunk.
+// Rechunk returns no chunks if text should stay as is. Reading position is kept, current chunk is the chunk
+// containing it. Texts from files share buckets between users, so such text is moved to a copy of the file
+// split with the new chunk size, unless it's encrypted. Returns number of changed texts.
+func (s *Storage) RechunkTexts(userID int64, predicate func(Text) bool, rechunk RechunkFunc) (int, error) {
+	var rechunked int
+	err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktUserInfo)
+		if b == nil {
+			return nil
+		}
+		id := textsId(userID)
+		texts, err := getTexts(b, id)
+		if err != nil {
+			return err
+		}
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		for i, text := range texts.Texts {
+			if !predicate(text) {
+				continue
+			}
+			textBucket := tx.Bucket(text.BucketName)
+			if textBucket == nil {
+				return errors.New("unexpected error: text bucket not found")
+			}
+			fullText, err := getFullText(textBucket, c.of(text))
+			if err != nil {
+				return err
+			}
+			result, err := rechunk(text, fullText)
+			if err != nil {
+				return err
+			}
+			if len(result.Chunks) == 0 {
+				continue
+			}
+			if ownsBucket(text) {
+				err = putChunks(textBucket, result.Chunks, c.of(text))
+			} else {
+				text.BucketName, err = forkProcessedFile(tx, text, result)
+			}
+			if err != nil {
+				return err
+			}
+			// position stays the same, it points to another chunk now
+			text.ChunkSize = result.ChunkSize
+			text.CurrentChunk = chunkAt(textspliter.Offsets(result.Chunks), text.Position)
+			text.ModifiedAt = time.Now()
+			texts.Texts[i] = text
+			if err = indexText(tx, userID, text, c); err != nil {
+				return err
+			}
+			rechunked++
+		}
+		return putTexts(b, id, texts)
+	})
+	return rechunked, errors.Wrap(err, "failed to rechunk texts")
+}
+
+// forkProcessedFile returns bucket of the file split into new chunks. Bucket is created
+// if no one has split the file with the same chunk size yet.
+func forkProcessedFile(tx *bolt.Tx, text Text, result Rechunked) ([]byte, error) {
+	b, err := tx.CreateBucketIfNotExists(bktProcessedFiles)
+	if err != nil {
+		return nil, err
+	}
+	checksum := text.CheckSum
+	if len(checksum) == 0 { // texts added before checksum was saved
+		pf, err := findProcessedFileByBucket(b, text.BucketName)
+		if err != nil && err != ErrNotFound {
+			return nil, err
+		}
+		checksum = pf.CheckSum
+	}
+	if len(checksum) > 0 {
+		pf, err := getProcessedFileVariant(b, checksum, result.ChunkSize)
+		switch err {
+		case nil:
+			return pf.BucketName, nil
+		case ErrNotFound:
+		default:
+			return nil, err
+		}
+	}
+
+	textBucket := tx.Bucket(text.BucketName)
+	attachments, err := getAttachments(textBucket, nil)
+	if err != nil {
+		return nil, err
+	}
+	bucketName, err := fillTextBucket(tx, string(textBucket.Get(fullTextKey)), result.Chunks, attachments, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(checksum) == 0 {
+		return bucketName, nil
+	}
+	pf := ProcessedFile{
+		UUID:       uuid.NewString(),
+		BucketName: bucketName,
+		ChunkSize:  result.ChunkSize,
+		Language:   text.Language,
+		CheckSum:   checksum,
+		CreatedAt:  time.Now(),
+	}
+	return bucketName, putProcessedFile(b, pf)
+}
+
 func (s *Storage) Analytics() ([]UserAnalytics, error) {
 	var result []UserAnalytics
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bktUserInfo)
 		if b == nil {
 			return nil
@@ -530,93 +892,373 @@ func (s *Storage) Analytics() ([]UserAnalytics, error) {
 	return result, err
 }
 
-func (s *Storage) UpdateDust(userID int64, updFunc func(*Dust)) (*Dust, error) {
-	var dust Dust
-	err := s.db.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists(bktDust)
+// UserIDs returns sorted ids of all users that have anything saved
+func (s *Storage) UserIDs() ([]int64, error) {
+	ids := make(map[int64]struct{})
+	err := s.view(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(bktUserInfo); b != nil {
+			err := b.ForEach(func(k, _ []byte) error {
+				for _, prefix := range [][]byte{textsPrefix, chunkSizePrefix} {
+					if !bytes.HasPrefix(k, prefix) {
+						continue
+					}
+					userID, err := strconv.ParseInt(string(k[len(prefix):]), 10, 64)
+					if err != nil {
+						return errors.Wrapf(err, "failed to parse user id of %s", k)
+					}
+					ids[userID] = struct{}{}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for _, name := range [][]byte{bktReading, bktListPrefs, bktBookmarks, bktHighlights, bktDust, bktHerb, bktLevel, bktStat, bktDataKeys, bktReminders, bktReadingEvents} {
+			if b := tx.Bucket(name); b != nil {
+				err := b.ForEach(func(k, _ []byte) error {
+					ids[bytesToInt64(k)] = struct{}{}
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if b := tx.Bucket(bktAuth); b != nil {
+			// bucket has both token by user id and user id by token
+			err := b.ForEach(func(k, v []byte) error {
+				if len(k) == 8 && bytes.Equal(b.Get(v), k) {
+					ids[bytesToInt64(k)] = struct{}{}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if b := tx.Bucket(bktUserRecipe); b != nil {
+			return b.ForEach(func(k, _ []byte) error {
+				strUserID, _, _ := bytes.Cut(k, []byte("|"))
+				userID, err := strconv.ParseInt(string(strUserID), 10, 64)
+				if err != nil {
+					return errors.Wrapf(err, "failed to parse user id of %s", k)
+				}
+				ids[userID] = struct{}{}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list users")
+	}
+	result := make([]int64, 0, len(ids))
+	for id := range ids {
+		result = append(result, id)
+	}
+	slices.Sort(result)
+	return result, nil
+}
+
+func (s *Storage) UpdateReading(userID int64, updFunc func(*Reading)) (*Reading, error) {
+	var reading Reading
+	err := s.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bktReading)
 		if err != nil {
 			return err
 		}
 		id := int64ToBytes(userID)
-		dust, err = s.getDust(b, id)
+		reading, err = s.getReading(b, id)
 		if err != nil {
 			return err
 		}
-		updFunc(&dust)
-		return s.putDust(b, id, dust)
+		updFunc(&reading)
+		return s.putReading(b, id, reading)
 	})
-	return &dust, err
+	return &reading, err
 }
 
-func (s *Storage) GetDustByUserID(userID int64) (dust Dust, err error) {
-	err = s.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bktDust)
+func (s *Storage) GetReadingByUserID(userID int64) (reading Reading, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktReading)
 		if b == nil {
 			return nil
 		}
 		id := int64ToBytes(userID)
-		dust, err = s.getDust(b, id)
+		reading, err = s.getReading(b, id)
 		return err
 	})
-	return dust, err
+	return reading, err
 }
 
-func (s *Storage) UpdateHerb(userID int64, updFunc func(*Herb)) (*Herb, error) {
-	var herb Herb
-	err := s.db.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists(bktHerb)
+func (s *Storage) UpdateListPreferences(userID int64, updFunc func(*ListPreferences)) (*ListPreferences, error) {
+	var prefs ListPreferences
+	err := s.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bktListPrefs)
 		if err != nil {
 			return err
 		}
 		id := int64ToBytes(userID)
-		herb, err = s.getHerb(b, id)
+		prefs, err = s.getListPreferences(b, id)
 		if err != nil {
 			return err
 		}
-		updFunc(&herb)
-		return s.putHerb(b, id, herb)
+		updFunc(&prefs)
+		return s.putListPreferences(b, id, prefs)
 	})
-	return &herb, err
+	return &prefs, err
 }
 
-func (s *Storage) GetHerbByUserID(userID int64) (herb Herb, err error) {
-	err = s.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bktHerb)
+func (s *Storage) GetListPreferencesByUserID(userID int64) (prefs ListPreferences, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktListPrefs)
 		if b == nil {
 			return nil
 		}
-		id := int64ToBytes(userID)
-		herb, err = s.getHerb(b, id)
+		prefs, err = s.getListPreferences(b, int64ToBytes(userID))
 		return err
 	})
-	return herb, err
+	return prefs, err
 }
 
-func (s *Storage) UpdateLevel(userID int64, updFunc func(*Level)) (*Level, error) {
-	var level Level
-	err := s.db.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists(bktLevel)
+// AddBookmark saves bookmark of the user's text, returns ErrNotFound if user doesn't have the text
+func (s *Storage) AddBookmark(userID int64, bookmark Bookmark) (Bookmark, error) {
+	bookmark.UUID = uuid.NewString()
+	bookmark.CreatedAt = time.Now()
+	err := s.update(func(tx *bolt.Tx) error {
+		if err := checkUserText(tx, userID, bookmark.TextUUID); err != nil {
+			return err
+		}
+		b, err := tx.CreateBucketIfNotExists(bktBookmarks)
+		if err != nil {
+			return err
+		}
+		c, err := s.userCipher(tx, userID)
 		if err != nil {
 			return err
 		}
 		id := int64ToBytes(userID)
-		level, err = s.getLevel(b, id)
+		bookmarks, err := getBookmarks(b, id, c)
 		if err != nil {
 			return err
 		}
-		updFunc(&level)
-		return s.putLevel(b, id, level)
+		return putBookmarks(b, id, append(bookmarks, bookmark), c)
 	})
-	return &level, err
+	return bookmark, err
 }
 
-func (s *Storage) GetLevelByUserID(userID int64) (level Level, err error) {
-	err = s.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bktLevel)
+// GetBookmarks returns bookmarks of the user in order they were added.
+// Only bookmarks of the text are returned if textUUID is not empty.
+func (s *Storage) GetBookmarks(userID int64, textUUID string) ([]Bookmark, error) {
+	var bookmarks []Bookmark
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktBookmarks)
 		if b == nil {
 			return nil
 		}
-		id := int64ToBytes(userID)
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		bookmarks, err = getBookmarks(b, int64ToBytes(userID), c)
+		return err
+	})
+	if textUUID != "" {
+		bookmarks = slices.DeleteFunc(bookmarks, func(bookmark Bookmark) bool {
+			return bookmark.TextUUID != textUUID
+		})
+	}
+	return bookmarks, err
+}
+
+func (s *Storage) DeleteBookmark(userID int64, bookmarkUUID string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktBookmarks)
+		if b == nil {
+			return ErrNotFound
+		}
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		id := int64ToBytes(userID)
+		bookmarks, err := getBookmarks(b, id, c)
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(bookmarks, func(bookmark Bookmark) bool {
+			return bookmark.UUID == bookmarkUUID
+		})
+		if i < 0 {
+			return ErrNotFound
+		}
+		return putBookmarks(b, id, slices.Delete(bookmarks, i, i+1), c)
+	})
+}
+
+// AddHighlight saves highlight of the user's text, returns ErrNotFound if user doesn't have the text
+func (s *Storage) AddHighlight(userID int64, highlight Highlight) (Highlight, error) {
+	highlight.UUID = uuid.NewString()
+	highlight.CreatedAt = time.Now()
+	err := s.update(func(tx *bolt.Tx) error {
+		if err := checkUserText(tx, userID, highlight.TextUUID); err != nil {
+			return err
+		}
+		b, err := tx.CreateBucketIfNotExists(bktHighlights)
+		if err != nil {
+			return err
+		}
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		id := int64ToBytes(userID)
+		highlights, err := getHighlights(b, id, c)
+		if err != nil {
+			return err
+		}
+		return putHighlights(b, id, append(highlights, highlight), c)
+	})
+	return highlight, err
+}
+
+// GetHighlights returns highlights of the user in order they were added.
+// Only highlights of the text are returned if textUUID is not empty.
+func (s *Storage) GetHighlights(userID int64, textUUID string) ([]Highlight, error) {
+	var highlights []Highlight
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktHighlights)
+		if b == nil {
+			return nil
+		}
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		highlights, err = getHighlights(b, int64ToBytes(userID), c)
+		return err
+	})
+	if textUUID != "" {
+		highlights = slices.DeleteFunc(highlights, func(highlight Highlight) bool {
+			return highlight.TextUUID != textUUID
+		})
+	}
+	return highlights, err
+}
+
+func (s *Storage) DeleteHighlight(userID int64, highlightUUID string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktHighlights)
+		if b == nil {
+			return ErrNotFound
+		}
+		c, err := s.userCipher(tx, userID)
+		if err != nil {
+			return err
+		}
+		id := int64ToBytes(userID)
+		highlights, err := getHighlights(b, id, c)
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(highlights, func(highlight Highlight) bool {
+			return highlight.UUID == highlightUUID
+		})
+		if i < 0 {
+			return ErrNotFound
+		}
+		return putHighlights(b, id, slices.Delete(highlights, i, i+1), c)
+	})
+}
+
+func (s *Storage) UpdateDust(userID int64, updFunc func(*Dust)) (*Dust, error) {
+	var dust Dust
+	err := s.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bktDust)
+		if err != nil {
+			return err
+		}
+		id := int64ToBytes(userID)
+		dust, err = s.getDust(b, id)
+		if err != nil {
+			return err
+		}
+		updFunc(&dust)
+		return s.putDust(b, id, dust)
+	})
+	return &dust, err
+}
+
+func (s *Storage) GetDustByUserID(userID int64) (dust Dust, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktDust)
+		if b == nil {
+			return nil
+		}
+		id := int64ToBytes(userID)
+		dust, err = s.getDust(b, id)
+		return err
+	})
+	return dust, err
+}
+
+func (s *Storage) UpdateHerb(userID int64, updFunc func(*Herb)) (*Herb, error) {
+	var herb Herb
+	err := s.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bktHerb)
+		if err != nil {
+			return err
+		}
+		id := int64ToBytes(userID)
+		herb, err = s.getHerb(b, id)
+		if err != nil {
+			return err
+		}
+		updFunc(&herb)
+		return s.putHerb(b, id, herb)
+	})
+	return &herb, err
+}
+
+func (s *Storage) GetHerbByUserID(userID int64) (herb Herb, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktHerb)
+		if b == nil {
+			return nil
+		}
+		id := int64ToBytes(userID)
+		herb, err = s.getHerb(b, id)
+		return err
+	})
+	return herb, err
+}
+
+func (s *Storage) UpdateLevel(userID int64, updFunc func(*Level)) (*Level, error) {
+	var level Level
+	err := s.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bktLevel)
+		if err != nil {
+			return err
+		}
+		id := int64ToBytes(userID)
+		level, err = s.getLevel(b, id)
+		if err != nil {
+			return err
+		}
+		updFunc(&level)
+		return s.putLevel(b, id, level)
+	})
+	return &level, err
+}
+
+func (s *Storage) GetLevelByUserID(userID int64) (level Level, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktLevel)
+		if b == nil {
+			return nil
+		}
+		id := int64ToBytes(userID)
 		level, err = s.getLevel(b, id)
 		return err
 	})
@@ -625,7 +1267,7 @@ func (s *Storage) GetLevelByUserID(userID int64) (level Level, err error) {
 
 func (s *Storage) UpdateStat(userID int64, updFunc func(*Stat)) (*Stat, error) {
 	var stat Stat
-	err := s.db.Update(func(tx *bolt.Tx) error {
+	err := s.update(func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists(bktStat)
 		if err != nil {
 			return err
@@ -642,7 +1284,7 @@ func (s *Storage) UpdateStat(userID int64, updFunc func(*Stat)) (*Stat, error) {
 }
 
 func (s *Storage) GetStatByUserID(userID int64) (stat Stat, err error) {
-	err = s.db.View(func(tx *bolt.Tx) error {
+	err = s.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bktStat)
 		if b == nil {
 			return nil
@@ -655,7 +1297,7 @@ func (s *Storage) GetStatByUserID(userID int64) (stat Stat, err error) {
 }
 
 func (s *Storage) GetRecipeByName(name string) (recipe Recipe, err error) {
-	err = s.db.View(func(tx *bolt.Tx) error {
+	err = s.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bktRecipe)
 		if b == nil {
 			return nil
@@ -667,7 +1309,7 @@ func (s *Storage) GetRecipeByName(name string) (recipe Recipe, err error) {
 }
 
 func (s *Storage) GetUserRecipeByUserIDandRecipeName(userID int64, recipeName string) (recipe UserRecipe, err error) {
-	err = s.db.View(func(tx *bolt.Tx) error {
+	err = s.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bktUserRecipe)
 		if b == nil {
 			return nil
@@ -680,7 +1322,7 @@ func (s *Storage) GetUserRecipeByUserIDandRecipeName(userID int64, recipeName st
 
 func (s *Storage) UpdateUserRecipe(userID int64, recipeName string, updFunc func(*UserRecipe)) (*UserRecipe, error) {
 	var userRecipe UserRecipe
-	err := s.db.Update(func(tx *bolt.Tx) error {
+	err := s.update(func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists(bktUserRecipe)
 		if err != nil {
 			return err
@@ -689,14 +1331,15 @@ func (s *Storage) UpdateUserRecipe(userID int64, recipeName string, updFunc func
 		if err != nil {
 			return err
 		}
+		userRecipe.UserID, userRecipe.RecipeName = userID, recipeName
 		updFunc(&userRecipe)
-		return s.putUserRecipe(b, int64ToBytes(userID), userRecipe)
+		return s.putUserRecipe(b, userRecipeId(userID, recipeName), userRecipe)
 	})
 	return &userRecipe, err
 }
 
 func (s *Storage) SetAuthToken(userID int64, token string) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
+	return s.update(func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists(bktAuth)
 		if err != nil {
 			return err
@@ -724,7 +1367,7 @@ func (s *Storage) SetAuthToken(userID int64, token string) error {
 
 func (s *Storage) GetUserIDByAuthToken(token string) (int64, error) {
 	var userID int64
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bktAuth)
 		if b == nil {
 			return ErrNotFound
@@ -741,7 +1384,7 @@ func (s *Storage) GetUserIDByAuthToken(token string) (int64, error) {
 
 func (s *Storage) GetTokenByUserID(userID int64) (string, error) {
 	var token string
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bktAuth)
 		if b == nil {
 			return ErrNotFound
@@ -757,7 +1400,7 @@ func (s *Storage) GetTokenByUserID(userID int64) (string, error) {
 }
 
 func (s *Storage) DeleteAuthToken(userID int64) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
+	return s.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bktAuth)
 		if b == nil {
 			return ErrNotFound
@@ -820,33 +1463,52 @@ func enrichTexts(tx *bolt.Tx, texts ...Text) ([]TextWithChunkInfo, error) {
 			return nil, errors.New("unexpected error: text bucket not found")
 		}
 		totalChunks := bytesToInt64(textBucket.Get(totalChunksKey))
+		var length int64
+		if v := textBucket.Get(lengthKey); v != nil {
+			length = bytesToInt64(v)
+		} else if offsets := getOffsets(textBucket); len(offsets) > 0 {
+			// texts saved before length was saved are not encrypted
+			lastChunk := string(textBucket.Get(int64ToBytes(int64(len(offsets) - 1))))
+			length = offsets[len(offsets)-1] + textspliter.VisibleLen(lastChunk)
+		}
 		result = append(result, TextWithChunkInfo{
 			UUID:         text.UUID,
 			Name:         text.Name,
+			Source:       text.Source,
+			Language:     text.Language,
+			State:        text.State,
+			Tags:         text.Tags,
 			CurrentChunk: text.CurrentChunk,
 			TotalChunks:  totalChunks,
+			Length:       length,
+			CreatedAt:    text.CreatedAt,
+			ModifiedAt:   text.ModifiedAt,
 		})
 	}
 	return result, nil
 }
 
-func fullTexts(tx *bolt.Tx, texts UserTexts) ([]TextWithChunks, error) {
+func fullTexts(tx *bolt.Tx, texts UserTexts, c *textCipher) ([]TextWithChunks, error) {
 	result := make([]TextWithChunks, 0, len(texts.Texts))
 	for _, text := range texts.Texts {
 		textBucket := tx.Bucket(text.BucketName)
 		if textBucket == nil {
 			return nil, errors.New("unexpected error: text bucket not found")
 		}
-		totalChunks := bytesToInt64(textBucket.Get(totalChunksKey))
-		chunks := make([]string, 0, totalChunks)
-		for i := int64(0); i < totalChunks; i++ {
-			chunks = append(chunks, string(textBucket.Get(int64ToBytes(i))))
+		chunks, err := getChunks(textBucket, c.of(text))
+		if err != nil {
+			return nil, err
 		}
+		offsets := getOffsets(textBucket)
 		result = append(result, TextWithChunks{
 			UUID:         text.UUID,
 			Name:         text.Name,
+			State:        text.State,
+			Tags:         text.Tags,
 			CurrentChunk: text.CurrentChunk,
+			Position:     text.Position,
 			Chunks:       chunks,
+			Offsets:      offsets,
 		})
 	}
 	return result, nil
@@ -878,11 +1540,17 @@ func putChunkSize(b *bolt.Bucket, id []byte, size int64) error {
 	return b.Put(id, int64ToBytes(size))
 }
 
+// putProcessedFile saves the file under its checksum. If the file is already saved
+// with another chunk size, it's saved as a variant of the file for its chunk size.
 func putProcessedFile(b *bolt.Bucket, pf ProcessedFile) error {
 	encoded, err := json.Marshal(pf)
 	if err != nil {
 		return err
 	}
+	first, err := getProcessedFile(b, pf.CheckSum)
+	if err == nil && first.ChunkSize != pf.ChunkSize {
+		return b.Put(processedFileVariantId(pf.CheckSum, pf.ChunkSize), encoded)
+	}
 	return b.Put(pf.CheckSum, encoded)
 }
 
@@ -898,27 +1566,411 @@ func getProcessedFile(b *bolt.Bucket, checksum []byte) (pf ProcessedFile, err er
 	return pf, nil
 }
 
-func fillTextBucket(tx *bolt.Tx, text string, chunks []string) ([]byte, error) {
+func getProcessedFileVariant(b *bolt.Bucket, checksum []byte, chunkSize int64) (ProcessedFile, error) {
+	pf, err := getProcessedFile(b, checksum)
+	if err != nil || pf.ChunkSize == chunkSize {
+		return pf, err
+	}
+	return getProcessedFile(b, processedFileVariantId(checksum, chunkSize))
+}
+
+var processedFileVariantSeparator = []byte("-chunk-size-")
+
+func processedFileVariantId(checksum []byte, chunkSize int64) []byte {
+	id := append(bytes.Clone(checksum), processedFileVariantSeparator...)
+	return strconv.AppendInt(id, chunkSize, 10)
+}
+
+func findProcessedFileByBucket(b *bolt.Bucket, bucketName []byte) (ProcessedFile, error) {
+	var found ProcessedFile
+	err := b.ForEach(func(k, v []byte) error {
+		var pf ProcessedFile
+		if err := json.Unmarshal(v, &pf); err != nil {
+			return errors.Wrap(err, "failed to unmarshal processed file")
+		}
+		if bytes.Equal(pf.BucketName, bucketName) {
+			found = pf
+		}
+		return nil
+	})
+	if err != nil {
+		return found, err
+	}
+	if found.UUID == "" {
+		return found, ErrNotFound
+	}
+	return found, nil
+}
+
+func fillTextBucket(tx *bolt.Tx, text string, chunks []string, attachments map[string][]byte, c *textCipher) ([]byte, error) {
 	textBucketName := []byte(uuid.New().String())
 	textBucket, err := tx.CreateBucketIfNotExists(textBucketName)
 	if err != nil {
 		return nil, err
 	}
-	if err = textBucket.Put(fullTextKey, []byte(text)); err != nil {
+	sealed, err := c.sealString(text)
+	if err != nil {
+		return nil, err
+	}
+	if err = textBucket.Put(fullTextKey, sealed); err != nil {
 		return nil, err
 	}
-	totalChunks := int64(len(chunks))
-	if err = textBucket.Put(totalChunksKey, int64ToBytes(totalChunks)); err != nil {
+	if err = putChunks(textBucket, chunks, c); err != nil {
 		return nil, err
 	}
-	for i, chunk := range chunks {
-		if err = textBucket.Put(int64ToBytes(int64(i)), []byte(chunk)); err != nil {
+	if len(attachments) == 0 {
+		return textBucketName, nil
+	}
+	attachmentsBucket, err := textBucket.CreateBucketIfNotExists(attachmentsKey)
+	if err != nil {
+		return nil, err
+	}
+	for name, content := range attachments {
+		if content, err = c.seal(content); err != nil {
+			return nil, err
+		}
+		if err = attachmentsBucket.Put([]byte(name), content); err != nil {
 			return nil, err
 		}
 	}
 	return textBucketName, nil
 }
 
+// putChunks replaces chunks of the text
+func putChunks(textBucket *bolt.Bucket, chunks []string, c *textCipher) error {
+	var oldTotal int64
+	if v := textBucket.Get(totalChunksKey); v != nil {
+		oldTotal = bytesToInt64(v)
+	}
+	for i := int64(len(chunks)); i < oldTotal; i++ {
+		if err := textBucket.Delete(int64ToBytes(i)); err != nil {
+			return err
+		}
+	}
+	if err := textBucket.Put(totalChunksKey, int64ToBytes(int64(len(chunks)))); err != nil {
+		return err
+	}
+	for i, chunk := range chunks {
+		sealed, err := c.sealString(chunk)
+		if err != nil {
+			return err
+		}
+		if err = textBucket.Put(int64ToBytes(int64(i)), sealed); err != nil {
+			return err
+		}
+	}
+	offsets := textspliter.Offsets(chunks)
+	if err := textBucket.Put(lengthKey, int64ToBytes(textLength(chunks, offsets))); err != nil {
+		return err
+	}
+	return textBucket.Put(chunkOffsetsKey, encodeInt64s(offsets))
+}
+
+// textLength returns number of visible characters in chunks, offsets are returned by textspliter.Offsets
+func textLength(chunks []string, offsets []int64) int64 {
+	if len(chunks) == 0 {
+		return 0
+	}
+	return offsets[len(offsets)-1] + textspliter.VisibleLen(chunks[len(chunks)-1])
+}
+
+// getOffsets returns positions of chunks beginnings. They are computed from chunks
+// for texts saved before positions were introduced, such texts are never encrypted.
+func getOffsets(textBucket *bolt.Bucket) []int64 {
+	v := textBucket.Get(chunkOffsetsKey)
+	if v == nil {
+		return textspliter.Offsets(getPlainChunks(textBucket))
+	}
+	return decodeInt64s(v)
+}
+
+// textOffsets returns positions of chunks beginnings of the text and saves them if they were missing
+func textOffsets(tx *bolt.Tx, text Text) ([]int64, error) {
+	textBucket := tx.Bucket(text.BucketName)
+	if textBucket == nil {
+		return nil, errors.New("unexpected error: text bucket not found")
+	}
+	if textBucket.Get(chunkOffsetsKey) != nil {
+		return getOffsets(textBucket), nil
+	}
+	offsets := textspliter.Offsets(getPlainChunks(textBucket))
+	return offsets, textBucket.Put(chunkOffsetsKey, encodeInt64s(offsets))
+}
+
+func encodeInt64s(values []int64) []byte {
+	encoded := make([]byte, 0, 8*len(values))
+	for _, v := range values {
+		encoded = append(encoded, int64ToBytes(v)...)
+	}
+	return encoded
+}
+
+func decodeInt64s(encoded []byte) []int64 {
+	values := make([]int64, 0, len(encoded)/8)
+	for i := 0; i+8 <= len(encoded); i += 8 {
+		values = append(values, bytesToInt64(encoded[i:i+8]))
+	}
+	return values
+}
+
+// progressState returns state of the text derived from its reading progress
+func progressState(currentChunk, totalChunks int64) TextState {
+	switch {
+	case currentChunk == NotSelected:
+		return StateQueued
+	case currentChunk >= totalChunks-1:
+		return StateFinished
+	default:
+		return StateReading
+	}
+}
+
+// readingState returns state of the text after moving to the chunk, states set by the user are kept
+func readingState(state TextState, currentChunk, totalChunks int64) TextState {
+	if state == StatePaused || state == StateAbandoned {
+		return state
+	}
+	return progressState(currentChunk, totalChunks)
+}
+
+// positionAt returns position of the beginning of the chunk
+func positionAt(offsets []int64, chunk int64) int64 {
+	if chunk < 0 || len(offsets) == 0 {
+		return NotSelected
+	}
+	return offsets[min(chunk, int64(len(offsets)-1))]
+}
+
+// chunkAt returns chunk that contains position
+func chunkAt(offsets []int64, position int64) int64 {
+	if position < 0 {
+		return NotSelected
+	}
+	return textspliter.ChunkAt(offsets, position)
+}
+
+func getChunks(textBucket *bolt.Bucket, c *textCipher) ([]string, error) {
+	totalChunks := bytesToInt64(textBucket.Get(totalChunksKey))
+	chunks := make([]string, 0, totalChunks)
+	for i := int64(0); i < totalChunks; i++ {
+		chunk, err := getChunk(textBucket, i, c)
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+// getPlainChunks returns chunks of the text that is not encrypted
+func getPlainChunks(textBucket *bolt.Bucket) []string {
+	chunks, _ := getChunks(textBucket, nil) // only decryption fails
+	return chunks
+}
+
+func getChunk(textBucket *bolt.Bucket, chunk int64, c *textCipher) (string, error) {
+	v := textBucket.Get(int64ToBytes(chunk))
+	if v == nil {
+		return "", nil
+	}
+	return c.openString(v)
+}
+
+func getFullText(textBucket *bolt.Bucket, c *textCipher) (string, error) {
+	return c.openString(textBucket.Get(fullTextKey))
+}
+
+// textContent returns content of the text, c is cipher of the text owner
+func textContent(tx *bolt.Tx, text Text, c *textCipher) (TextContent, error) {
+	textBucket := tx.Bucket(text.BucketName)
+	if textBucket == nil {
+		return TextContent{}, errors.New("unexpected error: text bucket not found")
+	}
+	c = c.of(text)
+	attachments, err := getAttachments(textBucket, c)
+	if err != nil {
+		return TextContent{}, err
+	}
+	fullText, err := getFullText(textBucket, c)
+	if err != nil {
+		return TextContent{}, err
+	}
+	chunks, err := getChunks(textBucket, c)
+	if err != nil {
+		return TextContent{}, err
+	}
+	return TextContent{
+		Text:        text,
+		FullText:    fullText,
+		Chunks:      chunks,
+		Attachments: attachments,
+	}, nil
+}
+
+// copyTextBucket copies content of the bucket that is not encrypted into a new bucket encrypted with c
+func copyTextBucket(tx *bolt.Tx, bucketName []byte, c *textCipher) ([]byte, error) {
+	textBucket := tx.Bucket(bucketName)
+	attachments, err := getAttachments(textBucket, nil)
+	if err != nil {
+		return nil, err
+	}
+	return fillTextBucket(tx, string(textBucket.Get(fullTextKey)), getPlainChunks(textBucket), attachments, c)
+}
+
+func getAttachments(textBucket *bolt.Bucket, c *textCipher) (map[string][]byte, error) {
+	attachmentsBucket := textBucket.Bucket(attachmentsKey)
+	if attachmentsBucket == nil {
+		return nil, nil
+	}
+	attachments := make(map[string][]byte)
+	err := attachmentsBucket.ForEach(func(k, v []byte) error {
+		content, err := c.open(v)
+		if err != nil {
+			return err
+		}
+		attachments[string(k)] = bytes.Clone(content)
+		return nil
+	})
+	return attachments, err
+}
+
+func (s *Storage) getReading(b *bolt.Bucket, id []byte) (reading Reading, err error) {
+	v := b.Get(id)
+	if v == nil {
+		return reading, nil
+	}
+	err = json.Unmarshal(v, &reading)
+	if err != nil {
+		return reading, errors.Wrap(err, "failed to unmarshal reading")
+	}
+	return reading, nil
+}
+
+func (s *Storage) putReading(b *bolt.Bucket, id []byte, reading Reading) error {
+	encoded, err := json.Marshal(reading)
+	if err != nil {
+		return err
+	}
+	return b.Put(id, encoded)
+}
+
+func (s *Storage) getListPreferences(b *bolt.Bucket, id []byte) (prefs ListPreferences, err error) {
+	v := b.Get(id)
+	if v == nil {
+		return prefs, nil
+	}
+	err = json.Unmarshal(v, &prefs)
+	if err != nil {
+		return prefs, errors.Wrap(err, "failed to unmarshal list preferences")
+	}
+	return prefs, nil
+}
+
+func (s *Storage) putListPreferences(b *bolt.Bucket, id []byte, prefs ListPreferences) error {
+	encoded, err := json.Marshal(prefs)
+	if err != nil {
+		return err
+	}
+	return b.Put(id, encoded)
+}
+
+func checkUserText(tx *bolt.Tx, userID int64, textUUID string) error {
+	b := tx.Bucket(bktUserInfo)
+	if b == nil {
+		return ErrNotFound
+	}
+	texts, err := getTexts(b, textsId(userID))
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(texts.Texts, func(text Text) bool { return text.UUID == textUUID }) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// deleteTextMarks deletes bookmarks and highlights of the deleted text
+func deleteTextMarks(tx *bolt.Tx, userID int64, textUUID string, c *textCipher) error {
+	id := int64ToBytes(userID)
+	if b := tx.Bucket(bktBookmarks); b != nil {
+		bookmarks, err := getBookmarks(b, id, c)
+		if err != nil {
+			return err
+		}
+		bookmarks = slices.DeleteFunc(bookmarks, func(bookmark Bookmark) bool {
+			return bookmark.TextUUID == textUUID
+		})
+		if err = putBookmarks(b, id, bookmarks, c); err != nil {
+			return err
+		}
+	}
+	if b := tx.Bucket(bktHighlights); b != nil {
+		highlights, err := getHighlights(b, id, c)
+		if err != nil {
+			return err
+		}
+		highlights = slices.DeleteFunc(highlights, func(highlight Highlight) bool {
+			return highlight.TextUUID == textUUID
+		})
+		if err = putHighlights(b, id, highlights, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getBookmarks(b *bolt.Bucket, id []byte, c *textCipher) (bookmarks []Bookmark, err error) {
+	v := b.Get(id)
+	if v == nil {
+		return bookmarks, nil
+	}
+	if v, err = c.open(v); err != nil {
+		return bookmarks, err
+	}
+	err = json.Unmarshal(v, &bookmarks)
+	if err != nil {
+		return bookmarks, errors.Wrap(err, "failed to unmarshal bookmarks")
+	}
+	return bookmarks, nil
+}
+
+func putBookmarks(b *bolt.Bucket, id []byte, bookmarks []Bookmark, c *textCipher) error {
+	encoded, err := json.Marshal(bookmarks)
+	if err != nil {
+		return err
+	}
+	if encoded, err = c.seal(encoded); err != nil {
+		return err
+	}
+	return b.Put(id, encoded)
+}
+
+func getHighlights(b *bolt.Bucket, id []byte, c *textCipher) (highlights []Highlight, err error) {
+	v := b.Get(id)
+	if v == nil {
+		return highlights, nil
+	}
+	if v, err = c.open(v); err != nil {
+		return highlights, err
+	}
+	err = json.Unmarshal(v, &highlights)
+	if err != nil {
+		return highlights, errors.Wrap(err, "failed to unmarshal highlights")
+	}
+	return highlights, nil
+}
+
+func putHighlights(b *bolt.Bucket, id []byte, highlights []Highlight, c *textCipher) error {
+	encoded, err := json.Marshal(highlights)
+	if err != nil {
+		return err
+	}
+	if encoded, err = c.seal(encoded); err != nil {
+		return err
+	}
+	return b.Put(id, encoded)
+}
+
 func (s *Storage) getDust(b *bolt.Bucket, id []byte) (dust Dust, err error) {
 	v := b.Get(id)
 	if v == nil {
@@ -1006,34 +2058,24 @@ func (s *Storage) getRecipe(b *bolt.Bucket, id []byte) (recipe Recipe, err error
 	}
 	err = json.Unmarshal(v, &recipe)
 	if err != nil {
-		return recipe, errors.Wrap(err, "failed to unmarshal stat")
+		return recipe, errors.Wrap(err, "failed to unmarshal recipe")
 	}
 	return recipe, nil
 }
 
 func (s *Storage) getUserRecipe(b *bolt.Bucket, userID int64, recipeName string) (userRecipe UserRecipe, err error) {
-	// Create the key by concatenating userID and recipeName
-	key := fmt.Sprintf("%s|%s", strconv.FormatInt(userID, 10), recipeName)
-
-	err = s.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("MyBucket"))
-		v := b.Get([]byte(key))
-
-		if v == nil {
-			return errors.New("No value found for this key")
-		}
-
-		err := json.Unmarshal(v, &userRecipe)
-		if err != nil {
-			return errors.Wrap(err, "failed to unmarshal user recipe")
-		}
-		return nil
-	})
+	v := b.Get(userRecipeId(userID, recipeName))
+	if v == nil {
+		return userRecipe, nil
+	}
+	return unmarshalUserRecipe(v)
+}
 
+func unmarshalUserRecipe(v []byte) (userRecipe UserRecipe, err error) {
+	err = json.Unmarshal(v, &userRecipe)
 	if err != nil {
-		return UserRecipe{}, errors.Wrap(err, "Failed to get user recipe: %v")
+		return userRecipe, errors.Wrap(err, "failed to unmarshal user recipe")
 	}
-
 	return userRecipe, nil
 }
 
@@ -0,0 +1,1232 @@
+// Package storagetest is a conformance suite for implementations of storage.Store
+package storagetest
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pechorka/adhd-reader/internal/storage"
+	"github.com/pechorka/adhd-reader/pkg/encryptor"
+	"github.com/pechorka/adhd-reader/pkg/textspliter"
+	"github.com/stretchr/testify/require"
+)
+
+// Run runs the suite, open returns new empty store that is closed when the test ends
+func Run(t *testing.T, open func(t *testing.T) storage.Store) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s storage.Store)
+	}{
+		{"Texts", testTexts},
+		{"ReadingPosition", testReadingPosition},
+		{"TextContent", testTextContent},
+		{"ProcessedFiles", testProcessedFiles},
+		{"Rechunk", testRechunk},
+		{"Search", testSearch},
+		{"Marks", testMarks},
+		{"Settings", testSettings},
+		{"Loot", testLoot},
+		{"Auth", testAuth},
+		{"Users", testUsers},
+		{"Backup", testBackup},
+		{"GarbageCollection", testGarbageCollection},
+		{"Encryption", testEncryption},
+		{"RewrapDataKeys", testRewrapDataKeys},
+		{"Reminders", testReminders},
+		{"ReadingEvents", testReadingEvents},
+		{"Crafting", testCrafting},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, open(t))
+		})
+	}
+}
+
+var chunks = []string{"First chunk.", "Second chunk.", "Third chunk."}
+
+func newText(name string) storage.NewText {
+	return storage.NewText{Name: name, Text: "First chunk. Second chunk. Third chunk.", Chunks: chunks, ChunkSize: 15, Language: "en"}
+}
+
+func textNames(t *testing.T, s storage.Store, userID int64) []string {
+	t.Helper()
+	texts, err := s.GetTexts(userID)
+	require.NoError(t, err)
+	var names []string
+	for _, text := range texts {
+		names = append(names, text.Name)
+	}
+	return names
+}
+
+func selectText(t *testing.T, s storage.Store, userID int64, textUUID string) {
+	t.Helper()
+	require.NoError(t, s.UpdateTexts(userID, func(texts *storage.UserTexts) error {
+		for i, text := range texts.Texts {
+			if text.UUID == textUUID {
+				texts.Current = i
+			}
+		}
+		return nil
+	}))
+}
+
+func selectChunk(t *testing.T, s storage.Store, userID, chunk int64) (storage.Text, string) {
+	t.Helper()
+	text, chunkText, err := s.SelectChunk(userID, func(storage.Text, int64, int64) (int64, error) {
+		return chunk, nil
+	})
+	require.NoError(t, err)
+	return text, chunkText
+}
+
+func testTexts(t *testing.T, s storage.Store) {
+	userID := int64(1)
+	texts, err := s.GetTexts(userID)
+	require.NoError(t, err)
+	require.Empty(t, texts)
+
+	before := time.Now()
+	firstID, err := s.AddText(userID, newText("first"))
+	require.NoError(t, err)
+	secondText := newText("second")
+	secondText.Source = storage.SourceURL
+	secondID, err := s.AddText(userID, secondText)
+	require.NoError(t, err)
+	_, err = s.AddText(userID, newText("first"))
+	require.Error(t, err)
+	_, err = s.AddText(userID+1, newText("first"))
+	require.NoError(t, err)
+
+	texts, err = s.GetTexts(userID)
+	require.NoError(t, err)
+	require.Len(t, texts, 2)
+	first := texts[0]
+	require.Equal(t, firstID, first.UUID)
+	require.Equal(t, "first", first.Name)
+	require.Equal(t, storage.SourceText, first.Source)
+	require.Equal(t, "en", first.Language)
+	require.Equal(t, storage.StateQueued, first.State)
+	require.EqualValues(t, storage.NotSelected, first.CurrentChunk)
+	require.EqualValues(t, 3, first.TotalChunks)
+	require.EqualValues(t, textspliter.VisibleLen("First chunk. Second chunk. Third chunk."), first.Length)
+	require.False(t, first.CreatedAt.Before(before))
+	require.Equal(t, secondID, texts[1].UUID)
+	require.Equal(t, storage.SourceURL, texts[1].Source)
+	_, err = s.GetCurrentText(userID)
+	require.Error(t, err, "no text selected")
+
+	selectText(t, s, userID, secondID)
+	current, err := s.GetCurrentText(userID)
+	require.NoError(t, err)
+	require.Equal(t, secondID, current.UUID)
+	fullText, err := s.GetCurrentFullText(userID)
+	require.NoError(t, err)
+	require.Equal(t, storage.FullText{Name: "second", Text: "First chunk. Second chunk. Third chunk."}, fullText)
+
+	require.NoError(t, s.UpdateTexts(userID, func(texts *storage.UserTexts) error {
+		texts.Texts[0].Tags = []string{"later"}
+		texts.Texts[0].State = storage.StatePaused
+		return nil
+	}))
+	texts, err = s.GetTexts(userID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"later"}, texts[0].Tags)
+	require.Equal(t, storage.StatePaused, texts[0].State)
+
+	// deleting text before the current one keeps the current text selected
+	require.ErrorIs(t, s.DeleteTextByName(userID, "missing"), storage.ErrNotFound)
+	require.ErrorIs(t, s.DeleteTextByUUID(userID, "missing"), storage.ErrNotFound)
+	require.NoError(t, s.DeleteTextByName(userID, "first"))
+	current, err = s.GetCurrentText(userID)
+	require.NoError(t, err)
+	require.Equal(t, secondID, current.UUID)
+	require.NoError(t, s.DeleteTextByUUID(userID, secondID))
+	require.Empty(t, textNames(t, s, userID))
+	_, err = s.GetCurrentText(userID)
+	require.Error(t, err)
+	require.Equal(t, []string{"first"}, textNames(t, s, userID+1))
+}
+
+func testReadingPosition(t *testing.T, s storage.Store) {
+	userID := int64(1)
+	textID, err := s.AddText(userID, newText("text"))
+	require.NoError(t, err)
+	_, _, err = s.SelectChunk(userID, func(storage.Text, int64, int64) (int64, error) { return 0, nil })
+	require.Error(t, err, "no text selected")
+
+	selectText(t, s, userID, textID)
+	offsets := textspliter.Offsets(chunks)
+	text, chunk := selectChunk(t, s, userID, 1)
+	require.Equal(t, chunks[1], chunk)
+	require.EqualValues(t, 1, text.CurrentChunk)
+	require.Equal(t, offsets[1], text.Position)
+	require.Equal(t, storage.StateReading, text.State)
+	current, err := s.GetCurrentChunk(userID)
+	require.NoError(t, err)
+	require.Equal(t, chunks[1], current.Chunk)
+	require.Equal(t, offsets[1], current.Position)
+
+	// position and current chunk are kept in sync
+	require.NoError(t, s.UpdateTexts(userID, func(texts *storage.UserTexts) error {
+		texts.Texts[0].Position = offsets[2] + 1
+		return nil
+	}))
+	current, err = s.GetCurrentChunk(userID)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, current.Text.CurrentChunk)
+	require.Equal(t, offsets[2]+1, current.Text.Position)
+	require.Equal(t, offsets[2], current.Position)
+	require.NoError(t, s.UpdateTexts(userID, func(texts *storage.UserTexts) error {
+		texts.Texts[0].CurrentChunk = 0
+		return nil
+	}))
+	current, err = s.GetCurrentChunk(userID)
+	require.NoError(t, err)
+	require.Equal(t, offsets[0], current.Text.Position)
+
+	text, _ = selectChunk(t, s, userID, 2)
+	require.Equal(t, storage.StateFinished, text.State)
+
+	// states set by the user are kept while reading
+	for _, state := range []storage.TextState{storage.StatePaused, storage.StateAbandoned} {
+		require.NoError(t, s.UpdateTexts(userID, func(texts *storage.UserTexts) error {
+			texts.Texts[0].State = state
+			return nil
+		}))
+		text, _ = selectChunk(t, s, userID, 1)
+		require.Equal(t, state, text.State)
+	}
+	require.NoError(t, s.UpdateTexts(userID, func(texts *storage.UserTexts) error {
+		texts.Texts[0].State = storage.StateFinished
+		return nil
+	}))
+	text, _ = selectChunk(t, s, userID, 1)
+	require.Equal(t, storage.StateReading, text.State)
+	selectChunk(t, s, userID, 2)
+
+	chunkSize, err := s.GetChunkSize(userID)
+	require.NoError(t, err)
+	require.Zero(t, chunkSize)
+	require.NoError(t, s.SetChunkSize(userID, 100))
+	chunkSize, err = s.GetChunkSize(userID)
+	require.NoError(t, err)
+	require.EqualValues(t, 100, chunkSize)
+
+	_, err = s.AddText(userID, newText("other"))
+	require.NoError(t, err)
+	fullTexts, err := s.GetFullTexts(userID, nil, -1, 0)
+	require.NoError(t, err)
+	require.Len(t, fullTexts, 2)
+	require.Equal(t, chunks, fullTexts[0].Chunks)
+	require.Equal(t, offsets, fullTexts[0].Offsets)
+	require.Equal(t, offsets[2], fullTexts[0].Position)
+	require.EqualValues(t, storage.NotSelected, fullTexts[1].Position)
+	page, err := s.GetFullTexts(userID, nil, 1, 1)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	require.Equal(t, "other", page[0].Name)
+	page, err = s.GetFullTexts(userID, nil, 2, 1)
+	require.NoError(t, err)
+	require.Empty(t, page)
+	future := time.Now().Add(time.Hour)
+	page, err = s.GetFullTexts(userID, &future, -1, 0)
+	require.NoError(t, err)
+	require.Empty(t, page)
+}
+
+func testTextContent(t *testing.T, s storage.Store) {
+	userID := int64(1)
+	text := newText("book")
+	text.Attachments = map[string][]byte{"cover.png": []byte("image")}
+	textID, err := s.AddText(userID, text)
+	require.NoError(t, err)
+
+	content, err := s.GetTextContent(userID, textID)
+	require.NoError(t, err)
+	require.Equal(t, "book", content.Text.Name)
+	require.Equal(t, text.Text, content.FullText)
+	require.Equal(t, chunks, content.Chunks)
+	require.Equal(t, text.Attachments, content.Attachments)
+	_, err = s.GetTextContent(userID+1, textID)
+	require.ErrorIs(t, err, storage.ErrNotFound)
+
+	attachment, err := s.GetAttachment(userID, textID, "cover.png")
+	require.NoError(t, err)
+	require.Equal(t, []byte("image"), attachment)
+	_, err = s.GetAttachment(userID, textID, "missing.png")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = s.GetAttachment(userID+1, textID, "cover.png")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testProcessedFiles(t *testing.T, s storage.Store) {
+	checksum := []byte("checksum")
+	_, err := s.GetProcessedFileByChecksum(checksum)
+	require.ErrorIs(t, err, storage.ErrNotFound)
+
+	pf, err := s.AddProcessedFile(storage.NewProcessedFile{
+		Text:        "First chunk. Second chunk. Third chunk.",
+		Chunks:      chunks,
+		ChunkSize:   15,
+		Language:    "en",
+		CheckSum:    checksum,
+		Attachments: map[string][]byte{"cover.png": []byte("image")},
+	})
+	require.NoError(t, err)
+	found, err := s.GetProcessedFileByChecksum(checksum)
+	require.NoError(t, err)
+	requireSameFile(t, pf, found)
+	found, err = s.GetProcessedFile(checksum, 15)
+	require.NoError(t, err)
+	requireSameFile(t, pf, found)
+	_, err = s.GetProcessedFile(checksum, 100)
+	require.ErrorIs(t, err, storage.ErrNotFound)
+
+	// file is shared between users
+	for _, userID := range []int64{1, 2} {
+		textID, err := s.AddTextFromProcessedFile(userID, "book", pf)
+		require.NoError(t, err)
+		require.Equal(t, pf.UUID, textID)
+		texts, err := s.GetTexts(userID)
+		require.NoError(t, err)
+		require.Len(t, texts, 1)
+		require.Equal(t, storage.SourceFile, texts[0].Source)
+		require.EqualValues(t, 3, texts[0].TotalChunks)
+		attachment, err := s.GetAttachment(userID, textID, "cover.png")
+		require.NoError(t, err)
+		require.Equal(t, []byte("image"), attachment)
+	}
+	_, err = s.AddTextFromProcessedFile(1, "book copy", pf)
+	var existsErr *storage.TextAlreadyExistsError
+	require.ErrorAs(t, err, &existsErr)
+	require.Equal(t, "book", existsErr.ExistingText.Name)
+
+	// deleting shared file keeps it for other users
+	require.NoError(t, s.DeleteTextByUUID(1, pf.UUID))
+	content, err := s.GetTextContent(2, pf.UUID)
+	require.NoError(t, err)
+	require.Equal(t, chunks, content.Chunks)
+}
+
+func testRechunk(t *testing.T, s storage.Store) {
+	userID, otherUserID := int64(1), int64(2)
+	checksum := []byte("checksum")
+	pf, err := s.AddProcessedFile(storage.NewProcessedFile{
+		Text:      "First chunk. Second chunk. Third chunk.",
+		Chunks:    chunks,
+		ChunkSize: 15,
+		Language:  "en",
+		CheckSum:  checksum,
+	})
+	require.NoError(t, err)
+	for _, id := range []int64{userID, otherUserID} {
+		_, err = s.AddTextFromProcessedFile(id, "book", pf)
+		require.NoError(t, err)
+	}
+	textID, err := s.AddText(userID, newText("note"))
+	require.NoError(t, err)
+	selectText(t, s, userID, textID)
+	selectChunk(t, s, userID, 2)
+
+	newChunks := []string{"First chunk. Second chunk.", "Third chunk."}
+	rechunked, err := s.RechunkTexts(userID, func(storage.Text) bool { return true }, func(text storage.Text, fullText string) (storage.Rechunked, error) {
+		require.Equal(t, "First chunk. Second chunk. Third chunk.", fullText)
+		return storage.Rechunked{Chunks: newChunks, ChunkSize: 30}, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, rechunked)
+
+	// position is kept, current chunk is the chunk containing it
+	current, err := s.GetCurrentChunk(userID)
+	require.NoError(t, err)
+	require.Equal(t, "Third chunk.", current.Chunk)
+	require.EqualValues(t, 1, current.Text.CurrentChunk)
+	require.EqualValues(t, 30, current.Text.ChunkSize)
+
+	// file is forked, other user reads the old chunks
+	content, err := s.GetTextContent(userID, pf.UUID)
+	require.NoError(t, err)
+	require.Equal(t, newChunks, content.Chunks)
+	content, err = s.GetTextContent(otherUserID, pf.UUID)
+	require.NoError(t, err)
+	require.Equal(t, chunks, content.Chunks)
+	variant, err := s.GetProcessedFile(checksum, 30)
+	require.NoError(t, err)
+	require.Equal(t, content.Text.Language, variant.Language)
+	first, err := s.GetProcessedFileByChecksum(checksum)
+	require.NoError(t, err)
+	requireSameFile(t, pf, first)
+
+	// text split the same way is left as is
+	rechunked, err = s.RechunkTexts(userID, func(storage.Text) bool { return true }, func(storage.Text, string) (storage.Rechunked, error) {
+		return storage.Rechunked{}, nil
+	})
+	require.NoError(t, err)
+	require.Zero(t, rechunked)
+}
+
+func testSearch(t *testing.T, s storage.Store) {
+	userID := int64(1)
+	first, err := s.AddText(userID, storage.NewText{
+		Name:     "first",
+		Text:     "Cats sleep. Dogs bark. Cats and dogs play.",
+		Chunks:   []string{"Cats sleep.", "Dogs bark.", "Cats and dogs play."},
+		Language: "en",
+	})
+	require.NoError(t, err)
+	_, err = s.AddText(userID, storage.NewText{
+		Name:   "second",
+		Text:   "Cat's toy. Cats run.",
+		Chunks: []string{"Cat's toy.", "Cats run."},
+	})
+	require.NoError(t, err)
+
+	search := func(query string, page, pageSize int) ([]string, bool) {
+		t.Helper()
+		matches, more, err := s.Search(userID, query, page, pageSize)
+		require.NoError(t, err)
+		var found []string
+		for _, match := range matches {
+			found = append(found, match.Text.Name+": "+match.ChunkText)
+		}
+		return found, more
+	}
+	found, more := search("cats", 1, 10)
+	require.Equal(t, []string{"first: Cats sleep.", "first: Cats and dogs play.", "second: Cats run."}, found)
+	require.False(t, more)
+	found, _ = search("Dogs CATS", 1, 10)
+	require.Equal(t, []string{"first: Cats and dogs play."}, found)
+	found, more = search("cats", 1, 2)
+	require.Len(t, found, 2)
+	require.True(t, more)
+	found, more = search("cats", 2, 2)
+	require.Equal(t, []string{"second: Cats run."}, found)
+	require.False(t, more)
+	found, _ = search("horses", 1, 10)
+	require.Empty(t, found)
+	found, _ = search("...", 1, 10)
+	require.Empty(t, found)
+	matches, _, err := s.Search(userID+1, "cats", 1, 10)
+	require.NoError(t, err)
+	require.Empty(t, matches)
+
+	require.NoError(t, s.DeleteTextByUUID(userID, first))
+	found, _ = search("cats", 1, 10)
+	require.Equal(t, []string{"second: Cats run."}, found)
+}
+
+func testMarks(t *testing.T, s storage.Store) {
+	userID := int64(1)
+	_, err := s.AddBookmark(userID, storage.Bookmark{TextUUID: "missing"})
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = s.AddHighlight(userID, storage.Highlight{TextUUID: "missing"})
+	require.ErrorIs(t, err, storage.ErrNotFound)
+
+	first, err := s.AddText(userID, newText("first"))
+	require.NoError(t, err)
+	second, err := s.AddText(userID, newText("second"))
+	require.NoError(t, err)
+
+	var bookmarks []storage.Bookmark
+	for _, textID := range []string{first, second, first} {
+		bookmark, err := s.AddBookmark(userID, storage.Bookmark{TextUUID: textID, Position: 5, Label: "label"})
+		require.NoError(t, err)
+		require.NotEmpty(t, bookmark.UUID)
+		require.False(t, bookmark.CreatedAt.IsZero())
+		bookmarks = append(bookmarks, bookmark)
+	}
+	saved, err := s.GetBookmarks(userID, "")
+	require.NoError(t, err)
+	requireSameMarks(t, bookmarks, saved)
+	saved, err = s.GetBookmarks(userID, first)
+	require.NoError(t, err)
+	requireSameMarks(t, []storage.Bookmark{bookmarks[0], bookmarks[2]}, saved)
+	saved, err = s.GetBookmarks(userID+1, "")
+	require.NoError(t, err)
+	require.Empty(t, saved)
+	require.ErrorIs(t, s.DeleteBookmark(userID+1, bookmarks[0].UUID), storage.ErrNotFound)
+	require.NoError(t, s.DeleteBookmark(userID, bookmarks[0].UUID))
+	require.ErrorIs(t, s.DeleteBookmark(userID, bookmarks[0].UUID), storage.ErrNotFound)
+
+	var highlights []storage.Highlight
+	for _, textID := range []string{first, second} {
+		highlight, err := s.AddHighlight(userID, storage.Highlight{TextUUID: textID, Start: 1, End: 5, Text: "irst", Note: "note"})
+		require.NoError(t, err)
+		highlights = append(highlights, highlight)
+	}
+	savedHighlights, err := s.GetHighlights(userID, second)
+	require.NoError(t, err)
+	requireSameMarks(t, highlights[1:], savedHighlights)
+	require.NoError(t, s.DeleteHighlight(userID, highlights[1].UUID))
+	require.ErrorIs(t, s.DeleteHighlight(userID, highlights[1].UUID), storage.ErrNotFound)
+
+	// marks are deleted with the text
+	require.NoError(t, s.DeleteTextByUUID(userID, first))
+	saved, err = s.GetBookmarks(userID, "")
+	require.NoError(t, err)
+	requireSameMarks(t, bookmarks[1:2], saved)
+	savedHighlights, err = s.GetHighlights(userID, "")
+	require.NoError(t, err)
+	require.Empty(t, savedHighlights)
+}
+
+// requireSameFile compares processed files, time is compared separately as it loses monotonic clock
+func requireSameFile(t *testing.T, expected, actual storage.ProcessedFile) {
+	t.Helper()
+	require.True(t, expected.CreatedAt.Equal(actual.CreatedAt))
+	expected.CreatedAt, actual.CreatedAt = time.Time{}, time.Time{}
+	require.Equal(t, expected, actual)
+}
+
+// requireSameMarks compares bookmarks or highlights, time is compared separately as it loses monotonic clock
+func requireSameMarks[T storage.Bookmark | storage.Highlight](t *testing.T, expected, actual []T) {
+	t.Helper()
+	require.Len(t, actual, len(expected))
+	for i := range expected {
+		var expectedTime, actualTime *time.Time
+		switch e := any(&expected[i]).(type) {
+		case *storage.Bookmark:
+			expectedTime, actualTime = &e.CreatedAt, &any(&actual[i]).(*storage.Bookmark).CreatedAt
+		case *storage.Highlight:
+			expectedTime, actualTime = &e.CreatedAt, &any(&actual[i]).(*storage.Highlight).CreatedAt
+		}
+		require.True(t, expectedTime.Equal(*actualTime))
+		e, a := expected[i], actual[i]
+		switch e := any(&e).(type) {
+		case *storage.Bookmark:
+			e.CreatedAt = time.Time{}
+		case *storage.Highlight:
+			e.CreatedAt = time.Time{}
+		}
+		switch a := any(&a).(type) {
+		case *storage.Bookmark:
+			a.CreatedAt = time.Time{}
+		case *storage.Highlight:
+			a.CreatedAt = time.Time{}
+		}
+		require.Equal(t, e, a)
+	}
+}
+
+func testSettings(t *testing.T, s storage.Store) {
+	userID := int64(1)
+	reading, err := s.GetReadingByUserID(userID)
+	require.NoError(t, err)
+	require.Zero(t, reading.ChunkSeconds)
+	updated, err := s.UpdateReading(userID, func(reading *storage.Reading) {
+		reading.ChunkSeconds = 60
+		reading.Speeds = map[string]storage.Speed{"en": {Measured: 900, Samples: 3}}
+	})
+	require.NoError(t, err)
+	require.EqualValues(t, 60, updated.ChunkSeconds)
+	reading, err = s.GetReadingByUserID(userID)
+	require.NoError(t, err)
+	require.Equal(t, *updated, reading)
+
+	prefs, err := s.GetListPreferencesByUserID(userID)
+	require.NoError(t, err)
+	require.Zero(t, prefs)
+	_, err = s.UpdateListPreferences(userID, func(prefs *storage.ListPreferences) {
+		prefs.Sort = "recent"
+		prefs.Filter = "#later"
+	})
+	require.NoError(t, err)
+	prefs, err = s.GetListPreferencesByUserID(userID)
+	require.NoError(t, err)
+	require.Equal(t, storage.ListPreferences{Sort: "recent", Filter: "#later"}, prefs)
+	prefs, err = s.GetListPreferencesByUserID(userID + 1)
+	require.NoError(t, err)
+	require.Zero(t, prefs)
+}
+
+func testLoot(t *testing.T, s storage.Store) {
+	userID := int64(1)
+	for i := 0; i < 2; i++ {
+		_, err := s.UpdateDust(userID, func(dust *storage.Dust) {
+			dust.RedCount++
+			dust.BlackCount += 2
+		})
+		require.NoError(t, err)
+	}
+	dust, err := s.GetDustByUserID(userID)
+	require.NoError(t, err)
+	require.Equal(t, storage.Dust{RedCount: 2, BlackCount: 4}, dust)
+	dust, err = s.GetDustByUserID(userID + 1)
+	require.NoError(t, err)
+	require.Zero(t, dust)
+
+	herb, err := s.UpdateHerb(userID, func(herb *storage.Herb) { herb.MelissaCount = 3 })
+	require.NoError(t, err)
+	require.Equal(t, storage.Herb{MelissaCount: 3}, *herb)
+	savedHerb, err := s.GetHerbByUserID(userID)
+	require.NoError(t, err)
+	require.Equal(t, *herb, savedHerb)
+
+	level, err := s.UpdateLevel(userID, func(level *storage.Level) { level.Experience += 10 })
+	require.NoError(t, err)
+	savedLevel, err := s.GetLevelByUserID(userID)
+	require.NoError(t, err)
+	require.Equal(t, *level, savedLevel)
+	require.EqualValues(t, 10, savedLevel.Experience)
+
+	stat, err := s.UpdateStat(userID, func(stat *storage.Stat) {
+		stat.Free = 1
+		stat.TimeManagement = 2
+	})
+	require.NoError(t, err)
+	savedStat, err := s.GetStatByUserID(userID)
+	require.NoError(t, err)
+	require.Equal(t, *stat, savedStat)
+	require.Equal(t, storage.Stat{Free: 1, TimeManagement: 2}, savedStat)
+
+	recipe, err := s.GetRecipeByName("missing")
+	require.NoError(t, err)
+	require.Zero(t, recipe)
+}
+
+func testAuth(t *testing.T, s storage.Store) {
+	userID := int64(1)
+	_, err := s.GetTokenByUserID(userID)
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = s.GetUserIDByAuthToken("token")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	require.ErrorIs(t, s.DeleteAuthToken(userID), storage.ErrNotFound)
+
+	require.NoError(t, s.SetAuthToken(userID, "token"))
+	require.ErrorIs(t, s.SetAuthToken(userID, "other"), storage.ErrAlreadyExists)
+	require.ErrorIs(t, s.SetAuthToken(userID+1, "token"), storage.ErrAlreadyExists)
+	token, err := s.GetTokenByUserID(userID)
+	require.NoError(t, err)
+	require.Equal(t, "token", token)
+	id, err := s.GetUserIDByAuthToken("token")
+	require.NoError(t, err)
+	require.Equal(t, userID, id)
+
+	require.NoError(t, s.DeleteAuthToken(userID))
+	_, err = s.GetUserIDByAuthToken("token")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	require.NoError(t, s.SetAuthToken(userID+1, "token"))
+}
+
+func testUsers(t *testing.T, s storage.Store) {
+	ids, err := s.UserIDs()
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	textID, err := s.AddText(3, newText("text"))
+	require.NoError(t, err)
+	selectText(t, s, 3, textID)
+	require.NoError(t, s.SetChunkSize(3, 100))
+	_, err = s.UpdateDust(1, func(dust *storage.Dust) { dust.RedCount++ })
+	require.NoError(t, err)
+	require.NoError(t, s.SetAuthToken(2, "token"))
+	ids, err = s.UserIDs()
+	require.NoError(t, err)
+	require.Equal(t, []int64{1, 2, 3}, ids)
+
+	analytics, err := s.Analytics()
+	require.NoError(t, err)
+	require.Len(t, analytics, 1)
+	require.Equal(t, int64(3), analytics[0].UserID)
+	require.EqualValues(t, 100, analytics[0].ChunkSize)
+	require.EqualValues(t, 1, analytics[0].TotalTextCount)
+	require.Equal(t, 0, analytics[0].CurrentText)
+	require.Equal(t, "text", analytics[0].Texts[0].Name)
+	require.EqualValues(t, 3, analytics[0].Texts[0].TotalChunks)
+}
+
+func testBackup(t *testing.T, s storage.Store) {
+	userID, restoredID := int64(1), int64(2)
+	pf, err := s.AddProcessedFile(storage.NewProcessedFile{
+		Text:      "First chunk. Second chunk. Third chunk.",
+		Chunks:    chunks,
+		ChunkSize: 15,
+		CheckSum:  []byte("checksum"),
+	})
+	require.NoError(t, err)
+	_, err = s.AddTextFromProcessedFile(userID, "book", pf)
+	require.NoError(t, err)
+	text := newText("note")
+	text.Attachments = map[string][]byte{"cover.png": []byte("image")}
+	textID, err := s.AddText(userID, text)
+	require.NoError(t, err)
+	selectText(t, s, userID, textID)
+	selectChunk(t, s, userID, 1)
+	require.NoError(t, s.SetChunkSize(userID, 15))
+	_, err = s.AddBookmark(userID, storage.Bookmark{TextUUID: textID, Position: 3})
+	require.NoError(t, err)
+	_, err = s.AddHighlight(userID, storage.Highlight{TextUUID: pf.UUID, Start: 1, End: 3, Text: "ir"})
+	require.NoError(t, err)
+	_, err = s.UpdateReading(userID, func(reading *storage.Reading) { reading.ChunkSeconds = 30 })
+	require.NoError(t, err)
+	_, err = s.UpdateListPreferences(userID, func(prefs *storage.ListPreferences) { prefs.Sort = "name" })
+	require.NoError(t, err)
+	_, err = s.UpdateDust(userID, func(dust *storage.Dust) { dust.BlueCount = 7 })
+	require.NoError(t, err)
+	_, err = s.UpdateStat(userID, func(stat *storage.Stat) { stat.Luck = 2 })
+	require.NoError(t, err)
+	_, err = s.AddReminder(userID, newReminder(time.Now()))
+	require.NoError(t, err)
+	require.NoError(t, s.AddReadingEvent(userID, storage.ReadingEvent{At: time.Now(), TextUUID: textID, Chunk: 1, Chars: 13, Seconds: 1}))
+
+	backup, err := s.ExportUser(userID)
+	require.NoError(t, err)
+	require.Equal(t, storage.BackupVersion, backup.Version)
+	require.Equal(t, textID, backup.CurrentText)
+	require.Len(t, backup.Texts, 2)
+	require.Equal(t, chunks, backup.Texts[1].Chunks)
+	require.Equal(t, text.Attachments, backup.Texts[1].Attachments)
+	require.Equal(t, textspliter.Offsets(chunks)[1], backup.Texts[1].Position)
+	require.Len(t, backup.Reminders, 1)
+	require.Len(t, backup.History, 1)
+
+	_, err = s.ImportUser(restoredID, storage.Backup{Version: storage.BackupVersion + 1}, storage.RestoreReplace)
+	require.ErrorIs(t, err, storage.ErrUnsupportedBackup)
+	_, err = s.AddText(restoredID, newText("old"))
+	require.NoError(t, err)
+	restored, err := s.ImportUser(restoredID, backup, storage.RestoreCopy)
+	require.NoError(t, err)
+	require.Equal(t, 2, restored)
+
+	restoredBackup, err := s.ExportUser(restoredID)
+	require.NoError(t, err)
+	requireSameBackups(t, backup, restoredBackup)
+
+	// uploaded backups can be edited, so texts from files become texts of the user
+	restored, err = s.ImportUser(restoredID, backup, storage.RestoreReplace)
+	require.NoError(t, err)
+	require.Equal(t, 2, restored)
+	restoredBackup, err = s.ExportUser(restoredID)
+	require.NoError(t, err)
+	require.Equal(t, storage.SourceText, restoredBackup.Texts[0].Source)
+	require.Empty(t, restoredBackup.Texts[0].CheckSum)
+	restoredBackup.Texts[0].Source, restoredBackup.Texts[0].CheckSum = backup.Texts[0].Source, backup.Texts[0].CheckSum
+	requireSameBackups(t, backup, restoredBackup)
+	current, err := s.GetCurrentChunk(restoredID)
+	require.NoError(t, err)
+	require.Equal(t, chunks[1], current.Chunk)
+	matches, _, err := s.Search(restoredID, "second", 1, 10)
+	require.NoError(t, err)
+	require.Len(t, matches, 2)
+
+	// loot, stats and history are earned by reading, so uploaded backups don't restore them
+	uploadedID := restoredID + 1
+	_, err = s.ImportUser(uploadedID, backup, storage.RestoreReplace)
+	require.NoError(t, err)
+	dust, err := s.GetDustByUserID(uploadedID)
+	require.NoError(t, err)
+	require.Zero(t, dust)
+	stat, err := s.GetStatByUserID(uploadedID)
+	require.NoError(t, err)
+	require.Zero(t, stat)
+	events, err := s.GetReadingEvents(uploadedID, time.Time{})
+	require.NoError(t, err)
+	require.Empty(t, events)
+
+	// merge adds only missing texts and keeps progress of the user
+	_, err = s.UpdateDust(restoredID, func(dust *storage.Dust) { dust.BlueCount = 1 })
+	require.NoError(t, err)
+	restored, err = s.ImportUser(restoredID, backup, storage.RestoreMerge)
+	require.NoError(t, err)
+	require.Zero(t, restored)
+	dust, err = s.GetDustByUserID(restoredID)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, dust.BlueCount)
+	require.Equal(t, []string{"book", "note"}, textNames(t, s, restoredID))
+	reminders, err := s.GetReminders(restoredID)
+	require.NoError(t, err)
+	requireSameReminders(t, backup.Reminders, reminders)
+	events, err = s.GetReadingEvents(restoredID, time.Time{})
+	require.NoError(t, err)
+	requireSameReadingEvents(t, backup.History, events)
+
+	// restored content is never shared with other users uploading a file with the same checksum
+	poisoned := storage.Backup{Version: storage.BackupVersion, Texts: []storage.BackupText{{
+		UUID:         "poisoned",
+		Name:         "book",
+		Source:       storage.SourceFile,
+		CheckSum:     []byte("other checksum"),
+		ChunkSize:    15,
+		CurrentChunk: storage.NotSelected,
+		FullText:     "EVIL CONTENT.",
+		Chunks:       []string{"EVIL CONTENT."},
+	}}}
+	for _, mode := range []storage.RestoreMode{storage.RestoreMerge, storage.RestoreReplace} {
+		_, err = s.ImportUser(restoredID, poisoned, mode)
+		require.NoError(t, err)
+		_, err = s.GetProcessedFileByChecksum([]byte("other checksum"))
+		require.ErrorIs(t, err, storage.ErrNotFound)
+	}
+	poisoned.Texts[0].CheckSum = []byte("checksum")
+	_, err = s.ImportUser(restoredID, poisoned, storage.RestoreReplace)
+	require.NoError(t, err)
+	book, err := s.GetProcessedFile([]byte("checksum"), 15)
+	require.NoError(t, err)
+	require.Equal(t, pf.UUID, book.UUID)
+	content, err := s.GetTextContent(userID, pf.UUID)
+	require.NoError(t, err)
+	require.Equal(t, chunks, content.Chunks)
+}
+
+// requireSameBackups compares backups of the same data, time is compared separately as it loses monotonic clock
+func requireSameBackups(t *testing.T, expected, actual storage.Backup) {
+	t.Helper()
+	expected.CreatedAt, actual.CreatedAt = time.Time{}, time.Time{}
+	require.Len(t, actual.Texts, len(expected.Texts))
+	for i := range expected.Texts {
+		e, a := &expected.Texts[i], &actual.Texts[i]
+		require.True(t, e.CreatedAt.Equal(a.CreatedAt))
+		require.True(t, e.ModifiedAt.Equal(a.ModifiedAt))
+		e.CreatedAt, e.ModifiedAt, a.CreatedAt, a.ModifiedAt = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+	}
+	requireSameMarks(t, expected.Bookmarks, actual.Bookmarks)
+	requireSameMarks(t, expected.Highlights, actual.Highlights)
+	requireSameReminders(t, expected.Reminders, actual.Reminders)
+	requireSameReadingEvents(t, expected.History, actual.History)
+	expected.Bookmarks, actual.Bookmarks = nil, nil
+	expected.Highlights, actual.Highlights = nil, nil
+	expected.Reminders, actual.Reminders = nil, nil
+	expected.History, actual.History = nil, nil
+	require.Equal(t, expected, actual)
+}
+
+// requireSameReminders compares reminders, time is compared separately as it loses monotonic clock and location
+func requireSameReminders(t *testing.T, expected, actual []storage.Reminder) {
+	t.Helper()
+	require.Len(t, actual, len(expected))
+	for i := range expected {
+		e, a := expected[i], actual[i]
+		require.True(t, e.NextAt.Equal(a.NextAt))
+		require.True(t, e.CreatedAt.Equal(a.CreatedAt))
+		e.NextAt, e.CreatedAt, a.NextAt, a.CreatedAt = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+		require.Equal(t, e, a)
+	}
+}
+
+func testGarbageCollection(t *testing.T, s storage.Store) {
+	userID, otherUserID := int64(1), int64(2)
+	checksum := []byte("checksum")
+	newFile := storage.NewProcessedFile{
+		Text:      strings.Repeat("First chunk. Second chunk. Third chunk. ", 1000),
+		Chunks:    chunks,
+		ChunkSize: 15,
+		CheckSum:  checksum,
+	}
+	pf, err := s.AddProcessedFile(newFile)
+	require.NoError(t, err)
+	for _, id := range []int64{userID, otherUserID} {
+		_, err = s.AddTextFromProcessedFile(id, "book", pf)
+		require.NoError(t, err)
+	}
+	_, err = s.RechunkTexts(userID, func(storage.Text) bool { return true }, func(storage.Text, string) (storage.Rechunked, error) {
+		return storage.Rechunked{Chunks: []string{"First chunk. Second chunk.", "Third chunk."}, ChunkSize: 30}, nil
+	})
+	require.NoError(t, err)
+	newFile.CheckSum = []byte("orphan")
+	orphan, err := s.AddProcessedFile(newFile)
+	require.NoError(t, err)
+
+	// files processed after the given time are kept
+	report, err := s.CollectGarbage(orphan.CreatedAt)
+	require.NoError(t, err)
+	require.Zero(t, report.Texts)
+	require.Zero(t, report.Files)
+	_, err = s.GetProcessedFileByChecksum([]byte("orphan"))
+	require.NoError(t, err)
+
+	report, err = s.CollectGarbage(time.Now())
+	require.NoError(t, err)
+	require.Equal(t, storage.GCReport{Texts: 1, Files: 1, SizeBefore: report.SizeBefore, SizeAfter: report.SizeAfter}, report)
+	require.Positive(t, report.Reclaimed())
+	_, err = s.GetProcessedFileByChecksum([]byte("orphan"))
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = s.AddTextFromProcessedFile(userID, "orphan", orphan)
+	require.ErrorIs(t, err, storage.ErrNotFound)
+
+	// variant of the file is found by checksum when the first one is removed
+	require.NoError(t, s.DeleteTextByUUID(otherUserID, pf.UUID))
+	report, err = s.CollectGarbage(time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Files)
+	require.Equal(t, 1, report.Texts)
+	first, err := s.GetProcessedFileByChecksum(checksum)
+	require.NoError(t, err)
+	require.EqualValues(t, 30, first.ChunkSize)
+	_, err = s.GetProcessedFile(checksum, 15)
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	content, err := s.GetTextContent(userID, pf.UUID)
+	require.NoError(t, err)
+	require.Len(t, content.Chunks, 2)
+
+	require.NoError(t, s.DeleteTextByUUID(userID, pf.UUID))
+	report, err = s.CollectGarbage(time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Files)
+	_, err = s.GetProcessedFileByChecksum(checksum)
+	require.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testEncryption(t *testing.T, s storage.Store) {
+	userID, otherUserID := int64(1), int64(2)
+	require.ErrorIs(t, s.EncryptTexts(userID), storage.ErrEncryptionDisabled)
+	s.SetKeyWrapper(encryptor.NewEncryptor("secret"))
+
+	text := newText("before")
+	text.Attachments = map[string][]byte{"cover.png": []byte("image")}
+	before, err := s.AddText(userID, text)
+	require.NoError(t, err)
+	selectText(t, s, userID, before)
+	selectChunk(t, s, userID, 1)
+	bookmark, err := s.AddBookmark(userID, storage.Bookmark{TextUUID: before, Position: 13, Label: "label"})
+	require.NoError(t, err)
+	highlight, err := s.AddHighlight(userID, storage.Highlight{TextUUID: before, Start: 1, End: 5, Text: "irst", Note: "note"})
+	require.NoError(t, err)
+	pf, err := s.AddProcessedFile(storage.NewProcessedFile{
+		Text: "First chunk. Second chunk. Third chunk.", Chunks: chunks, ChunkSize: 15, Language: "en", CheckSum: []byte("checksum"),
+	})
+	require.NoError(t, err)
+	_, err = s.AddTextFromProcessedFile(otherUserID, "book", pf)
+	require.NoError(t, err)
+
+	encrypted, err := s.TextsEncrypted(userID)
+	require.NoError(t, err)
+	require.False(t, encrypted)
+	require.NoError(t, s.EncryptTexts(userID))
+	encrypted, err = s.TextsEncrypted(userID)
+	require.NoError(t, err)
+	require.True(t, encrypted)
+	after, err := s.AddText(userID, newText("after"))
+	require.NoError(t, err)
+	_, err = s.AddTextFromProcessedFile(userID, "book", pf)
+	require.NoError(t, err)
+
+	requireReadable := func() {
+		t.Helper()
+		for _, textID := range []string{before, after, pf.UUID} {
+			content, err := s.GetTextContent(userID, textID)
+			require.NoError(t, err)
+			require.True(t, content.Text.Encrypted)
+			require.Equal(t, chunks, content.Chunks)
+			require.Equal(t, "First chunk. Second chunk. Third chunk.", content.FullText)
+		}
+		texts, err := s.GetTexts(userID)
+		require.NoError(t, err)
+		require.Len(t, texts, 3)
+		require.EqualValues(t, textspliter.VisibleLen(strings.Join(chunks, "")), texts[0].Length)
+		current, err := s.GetCurrentChunk(userID)
+		require.NoError(t, err)
+		require.Equal(t, chunks[1], current.Chunk)
+		fullText, err := s.GetCurrentFullText(userID)
+		require.NoError(t, err)
+		require.Equal(t, "First chunk. Second chunk. Third chunk.", fullText.Text)
+		attachment, err := s.GetAttachment(userID, before, "cover.png")
+		require.NoError(t, err)
+		require.Equal(t, []byte("image"), attachment)
+		matches, _, err := s.Search(userID, "second", 1, 10)
+		require.NoError(t, err)
+		require.Len(t, matches, 3)
+		require.Equal(t, chunks[1], matches[0].ChunkText)
+		bookmarks, err := s.GetBookmarks(userID, "")
+		require.NoError(t, err)
+		requireSameMarks(t, []storage.Bookmark{bookmark}, bookmarks)
+		highlights, err := s.GetHighlights(userID, "")
+		require.NoError(t, err)
+		requireSameMarks(t, []storage.Highlight{highlight}, highlights)
+	}
+	requireReadable()
+	_, chunk := selectChunk(t, s, userID, 2)
+	require.Equal(t, chunks[2], chunk)
+	selectChunk(t, s, userID, 1)
+
+	// key is rotated
+	require.NoError(t, s.EncryptTexts(userID))
+	requireReadable()
+
+	// backup is not encrypted and is restored encrypted
+	backup, err := s.ExportUser(userID)
+	require.NoError(t, err)
+	require.Equal(t, chunks, backup.Texts[0].Chunks)
+	_, err = s.ImportUser(userID, backup, storage.RestoreReplace)
+	require.NoError(t, err)
+	requireReadable()
+
+	// encrypted copy of the shared file is deleted, other user still reads the file
+	require.NoError(t, s.DeleteTextByUUID(userID, pf.UUID))
+	content, err := s.GetTextContent(otherUserID, pf.UUID)
+	require.NoError(t, err)
+	require.False(t, content.Text.Encrypted)
+	require.Equal(t, chunks, content.Chunks)
+
+	// texts can't be read without the key
+	s.SetKeyWrapper(nil)
+	_, err = s.GetCurrentChunk(userID)
+	require.ErrorIs(t, err, storage.ErrEncryptionDisabled)
+	_, err = s.GetTexts(userID)
+	require.NoError(t, err)
+	s.SetKeyWrapper(encryptor.NewEncryptor("secret"))
+
+	require.NoError(t, s.DecryptTexts(userID))
+	encrypted, err = s.TextsEncrypted(userID)
+	require.NoError(t, err)
+	require.False(t, encrypted)
+	content, err = s.GetTextContent(userID, before)
+	require.NoError(t, err)
+	require.False(t, content.Text.Encrypted)
+	require.Equal(t, chunks, content.Chunks)
+	bookmarks, err := s.GetBookmarks(userID, "")
+	require.NoError(t, err)
+	requireSameMarks(t, []storage.Bookmark{bookmark}, bookmarks)
+	matches, _, err := s.Search(userID, "second", 1, 10)
+	require.NoError(t, err)
+	require.Len(t, matches, 2)
+}
+
+func testRewrapDataKeys(t *testing.T, s storage.Store) {
+	userID := int64(1)
+	keyring := filepath.Join(t.TempDir(), "keyring.json")
+	keys, err := encryptor.LoadEncryptor("secret", keyring)
+	require.NoError(t, err)
+	s.SetKeyWrapper(keys)
+	textID, err := s.AddText(userID, newText("text"))
+	require.NoError(t, err)
+	require.NoError(t, s.EncryptTexts(userID))
+
+	_, err = keys.Rotate()
+	require.NoError(t, err)
+	count, err := s.RewrapDataKeys()
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	// data keys don't need the retired config key anymore
+	_, err = keys.Retire()
+	require.NoError(t, err)
+	s.SetKeyWrapper(encryptor.NewEncryptor("secret"))
+	_, err = s.GetTextContent(userID, textID)
+	require.Error(t, err)
+	keys, err = encryptor.LoadEncryptor("secret", keyring)
+	require.NoError(t, err)
+	s.SetKeyWrapper(keys)
+	content, err := s.GetTextContent(userID, textID)
+	require.NoError(t, err)
+	require.True(t, content.Text.Encrypted)
+	require.Equal(t, chunks, content.Chunks)
+}
+
+func testReminders(t *testing.T, s storage.Store) {
+	userID, otherUserID := int64(1), int64(2)
+	now := time.Now()
+	reminders, err := s.GetReminders(userID)
+	require.NoError(t, err)
+	require.Empty(t, reminders)
+
+	later, err := s.AddReminder(userID, newReminder(now.Add(time.Hour)))
+	require.NoError(t, err)
+	require.NotEmpty(t, later.UUID)
+	soon, err := s.AddReminder(userID, newReminder(now.Add(time.Minute)))
+	require.NoError(t, err)
+	overdue, err := s.AddReminder(otherUserID, newReminder(now.Add(-time.Minute)))
+	require.NoError(t, err)
+	reminders, err = s.GetReminders(userID)
+	require.NoError(t, err)
+	requireSameReminders(t, []storage.Reminder{later, soon}, reminders)
+
+	due, err := s.DueReminders(now)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	require.Equal(t, otherUserID, due[0].UserID)
+	requireSameReminders(t, []storage.Reminder{overdue}, []storage.Reminder{due[0].Reminder})
+	due, err = s.DueReminders(now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, due, 3)
+	require.Equal(t, []string{overdue.UUID, soon.UUID, later.UUID}, []string{due[0].Reminder.UUID, due[1].Reminder.UUID, due[2].Reminder.UUID})
+
+	require.NoError(t, s.RescheduleReminder(otherUserID, overdue.UUID, now.Add(24*time.Hour)))
+	due, err = s.DueReminders(now)
+	require.NoError(t, err)
+	require.Empty(t, due)
+	require.ErrorIs(t, s.RescheduleReminder(userID, overdue.UUID, now), storage.ErrNotFound)
+
+	require.NoError(t, s.DeleteReminder(userID, soon.UUID))
+	require.ErrorIs(t, s.DeleteReminder(userID, soon.UUID), storage.ErrNotFound)
+	reminders, err = s.GetReminders(userID)
+	require.NoError(t, err)
+	requireSameReminders(t, []storage.Reminder{later}, reminders)
+	ids, err := s.UserIDs()
+	require.NoError(t, err)
+	require.Equal(t, []int64{userID, otherUserID}, ids)
+}
+
+func newReminder(nextAt time.Time) storage.Reminder {
+	return storage.Reminder{
+		Weekdays: []time.Weekday{time.Monday, time.Friday},
+		Hour:     8,
+		Minute:   30,
+		Timezone: "Europe/Moscow",
+		Mode:     storage.ReminderCurrent,
+		Language: "en",
+		NextAt:   nextAt,
+	}
+}
+
+func testReadingEvents(t *testing.T, s storage.Store) {
+	userID, otherUserID := int64(1), int64(2)
+	now := time.Now()
+	events, err := s.GetReadingEvents(userID, time.Time{})
+	require.NoError(t, err)
+	require.Empty(t, events)
+
+	expected := []storage.ReadingEvent{
+		{At: now.Add(-48 * time.Hour), TextUUID: "first", Chunk: 0, Chars: 12, Words: 2, Seconds: 1},
+		{At: now.Add(-time.Hour), TextUUID: "first", Chunk: 1, Chars: 13, Seconds: 1},
+		{At: now.Add(-time.Hour), TextUUID: "second", Chunk: 0, Chars: 12, Seconds: 1},
+	}
+	// events are returned in order of time, not in order they were added
+	for _, i := range []int{1, 0, 2} {
+		require.NoError(t, s.AddReadingEvent(userID, expected[i]))
+	}
+	require.NoError(t, s.AddReadingEvent(otherUserID, storage.ReadingEvent{At: now, TextUUID: "other", Chars: 5}))
+
+	events, err = s.GetReadingEvents(userID, time.Time{})
+	require.NoError(t, err)
+	requireSameReadingEvents(t, expected, events)
+	events, err = s.GetReadingEvents(userID, now.Add(-time.Hour))
+	require.NoError(t, err)
+	requireSameReadingEvents(t, expected[1:], events)
+	events, err = s.GetReadingEvents(userID, now)
+	require.NoError(t, err)
+	require.Empty(t, events)
+	ids, err := s.UserIDs()
+	require.NoError(t, err)
+	require.Equal(t, []int64{userID, otherUserID}, ids)
+}
+
+func testCrafting(t *testing.T, s storage.Store) {
+	userID := int64(1)
+	recipes, err := s.GetRecipes()
+	require.NoError(t, err)
+	require.Empty(t, recipes)
+
+	focus := storage.Recipe{
+		Name:          "focus",
+		RecipeDustMin: storage.Dust{RedCount: 1},
+		RecipeDustMax: storage.Dust{RedCount: 3},
+		RecipeHerbMax: storage.Herb{MelissaCount: 1},
+		Buff:          storage.BuffExp,
+		Multiplier:    1.5,
+		Duration:      30 * time.Minute,
+	}
+	fortune := storage.Recipe{Name: "fortune", Buff: storage.BuffLoot, Multiplier: 2, Duration: time.Hour}
+	require.NoError(t, s.SaveRecipe(fortune))
+	require.NoError(t, s.SaveRecipe(storage.Recipe{Name: "focus"}))
+	require.NoError(t, s.SaveRecipe(focus)) // replaces the recipe
+	recipes, err = s.GetRecipes()
+	require.NoError(t, err)
+	require.Equal(t, []storage.Recipe{focus, fortune}, recipes)
+	recipe, err := s.GetRecipeByName("focus")
+	require.NoError(t, err)
+	require.Equal(t, focus, recipe)
+
+	userRecipe, err := s.GetUserRecipeByUserIDandRecipeName(userID, "focus")
+	require.NoError(t, err)
+	require.Zero(t, userRecipe)
+	for _, red := range []int64{2, 3} {
+		_, err = s.UpdateUserRecipe(userID, "focus", func(recipe *storage.UserRecipe) {
+			recipe.IdealDusts.RedCount = red
+		})
+		require.NoError(t, err)
+	}
+	_, err = s.UpdateUserRecipe(userID, "fortune", func(recipe *storage.UserRecipe) {
+		recipe.IdealHerbs.LavandaCount = 1
+	})
+	require.NoError(t, err)
+	expected := storage.UserRecipe{UserID: userID, RecipeName: "focus", IdealDusts: storage.Dust{RedCount: 3}}
+	userRecipe, err = s.GetUserRecipeByUserIDandRecipeName(userID, "focus")
+	require.NoError(t, err)
+	require.Equal(t, expected, userRecipe)
+	userRecipe, err = s.GetUserRecipeByUserIDandRecipeName(userID+1, "focus")
+	require.NoError(t, err)
+	require.Zero(t, userRecipe)
+	ids, err := s.UserIDs()
+	require.NoError(t, err)
+	require.Equal(t, []int64{userID}, ids)
+
+	backup, err := s.ExportUser(userID)
+	require.NoError(t, err)
+	require.Len(t, backup.Recipes, 2)
+	_, err = s.ImportUser(userID+1, backup, storage.RestoreReplace)
+	require.NoError(t, err)
+	userRecipe, err = s.GetUserRecipeByUserIDandRecipeName(userID+1, "focus")
+	require.NoError(t, err)
+	require.Zero(t, userRecipe, "uploaded backups don't restore recipes")
+	_, err = s.ImportUser(userID+1, backup, storage.RestoreCopy)
+	require.NoError(t, err)
+	userRecipe, err = s.GetUserRecipeByUserIDandRecipeName(userID+1, "focus")
+	require.NoError(t, err)
+	expected.UserID = userID + 1
+	require.Equal(t, expected, userRecipe)
+
+	buffs, err := s.GetBuffs(userID)
+	require.NoError(t, err)
+	require.Empty(t, buffs)
+	until := time.Now().Add(time.Hour)
+	buff := storage.Buff{Recipe: "focus", Kind: storage.BuffExp, Multiplier: 1.5, Until: until}
+	buffs, err = s.UpdateBuffs(userID, func(buffs []storage.Buff) []storage.Buff {
+		require.Empty(t, buffs)
+		return append(buffs, buff)
+	})
+	require.NoError(t, err)
+	require.Len(t, buffs, 1)
+	buffs, err = s.GetBuffs(userID)
+	require.NoError(t, err)
+	require.Len(t, buffs, 1)
+	require.True(t, until.Equal(buffs[0].Until))
+	buffs[0].Until = buff.Until
+	require.Equal(t, buff, buffs[0])
+	buffs, err = s.GetBuffs(userID + 1)
+	require.NoError(t, err)
+	require.Empty(t, buffs)
+	_, err = s.UpdateBuffs(userID, func([]storage.Buff) []storage.Buff { return nil })
+	require.NoError(t, err)
+	buffs, err = s.GetBuffs(userID)
+	require.NoError(t, err)
+	require.Empty(t, buffs)
+
+	_, err = s.UpdateDust(userID, func(dust *storage.Dust) { dust.RedCount = 3 })
+	require.NoError(t, err)
+	err = s.UpdateLoot(userID, func(dust *storage.Dust, herb *storage.Herb) error {
+		dust.RedCount -= 2
+		herb.MelissaCount++
+		return nil
+	})
+	require.NoError(t, err)
+	errFailed := errors.New("failed")
+	err = s.UpdateLoot(userID, func(dust *storage.Dust, herb *storage.Herb) error {
+		dust.RedCount -= 2
+		herb.MelissaCount--
+		return errFailed
+	})
+	require.ErrorIs(t, err, errFailed)
+	dust, err := s.GetDustByUserID(userID)
+	require.NoError(t, err)
+	require.Equal(t, storage.Dust{RedCount: 1}, dust, "loot isn't changed by the failed update")
+	herb, err := s.GetHerbByUserID(userID)
+	require.NoError(t, err)
+	require.Equal(t, storage.Herb{MelissaCount: 1}, herb)
+}
+
+// requireSameReadingEvents compares events, time is compared separately as it loses monotonic clock and location
+func requireSameReadingEvents(t *testing.T, expected, actual []storage.ReadingEvent) {
+	t.Helper()
+	require.Len(t, actual, len(expected))
+	for i := range expected {
+		e, a := expected[i], actual[i]
+		require.True(t, e.At.Equal(a.At))
+		e.At, a.At = time.Time{}, time.Time{}
+		require.Equal(t, e, a)
+	}
+}
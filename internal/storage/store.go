@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"io"
+	"time"
+)
+
+// Store is everything the service keeps about users and their texts.
+// Storage keeps it in bbolt, SQLiteStorage keeps it in SQLite.
+type Store interface {
+	// texts and chunks
+	AddText(userID int64, newText NewText) (string, error)
+	AddTextFromProcessedFile(userID int64, name string, pf ProcessedFile) (string, error)
+	GetTexts(userID int64) ([]TextWithChunkInfo, error)
+	GetCurrentText(userID int64) (TextWithChunkInfo, error)
+	GetCurrentFullText(userID int64) (FullText, error)
+	GetFullTexts(userID int64, after *time.Time, page, pageSize int) ([]TextWithChunks, error)
+	GetTextContent(userID int64, textUUID string) (TextContent, error)
+	GetAttachment(userID int64, textUUID, name string) ([]byte, error)
+	UpdateTexts(userID int64, updFunc UpdateTextsFunc) error
+	SelectChunk(userID int64, updFunc SelectChunkFunc) (Text, string, error)
+	GetCurrentChunk(userID int64) (CurrentChunk, error)
+	GetChunkSize(userID int64) (int64, error)
+	SetChunkSize(userID int64, chunkSize int64) error
+	RechunkTexts(userID int64, predicate func(Text) bool, rechunk RechunkFunc) (int, error)
+	DeleteTextByUUID(userID int64, textUUID string) error
+	DeleteTextByName(userID int64, textName string) error
+	Search(userID int64, query string, page, pageSize int) ([]SearchMatch, bool, error)
+
+	// files shared between users
+	AddProcessedFile(newPf NewProcessedFile) (ProcessedFile, error)
+	GetProcessedFileByChecksum(checksum []byte) (ProcessedFile, error)
+	GetProcessedFile(checksum []byte, chunkSize int64) (ProcessedFile, error)
+
+	// reading settings
+	UpdateReading(userID int64, updFunc func(*Reading)) (*Reading, error)
+	GetReadingByUserID(userID int64) (Reading, error)
+	UpdateListPreferences(userID int64, updFunc func(*ListPreferences)) (*ListPreferences, error)
+	GetListPreferencesByUserID(userID int64) (ListPreferences, error)
+
+	// bookmarks and highlights
+	AddBookmark(userID int64, bookmark Bookmark) (Bookmark, error)
+	GetBookmarks(userID int64, textUUID string) ([]Bookmark, error)
+	DeleteBookmark(userID int64, bookmarkUUID string) error
+	AddHighlight(userID int64, highlight Highlight) (Highlight, error)
+	GetHighlights(userID int64, textUUID string) ([]Highlight, error)
+	DeleteHighlight(userID int64, highlightUUID string) error
+
+	// loot and stats
+	UpdateDust(userID int64, updFunc func(*Dust)) (*Dust, error)
+	GetDustByUserID(userID int64) (Dust, error)
+	UpdateHerb(userID int64, updFunc func(*Herb)) (*Herb, error)
+	GetHerbByUserID(userID int64) (Herb, error)
+	UpdateLevel(userID int64, updFunc func(*Level)) (*Level, error)
+	GetLevelByUserID(userID int64) (Level, error)
+	UpdateStat(userID int64, updFunc func(*Stat)) (*Stat, error)
+	GetStatByUserID(userID int64) (Stat, error)
+
+	// recipes
+	GetRecipeByName(name string) (Recipe, error)
+	GetUserRecipeByUserIDandRecipeName(userID int64, recipeName string) (UserRecipe, error)
+	UpdateUserRecipe(userID int64, recipeName string, updFunc func(*UserRecipe)) (*UserRecipe, error)
+	SaveRecipe(recipe Recipe) error
+	GetRecipes() ([]Recipe, error)
+	UpdateLoot(userID int64, updFunc func(*Dust, *Herb) error) error
+	UpdateBuffs(userID int64, updFunc func([]Buff) []Buff) ([]Buff, error)
+	GetBuffs(userID int64) ([]Buff, error)
+
+	// auth
+	SetAuthToken(userID int64, token string) error
+	GetUserIDByAuthToken(token string) (int64, error)
+	GetTokenByUserID(userID int64) (string, error)
+	DeleteAuthToken(userID int64) error
+
+	// reminders
+	AddReminder(userID int64, reminder Reminder) (Reminder, error)
+	GetReminders(userID int64) ([]Reminder, error)
+	DeleteReminder(userID int64, reminderUUID string) error
+	RescheduleReminder(userID int64, reminderUUID string, nextAt time.Time) error
+	DueReminders(now time.Time) ([]UserReminder, error)
+
+	// reading history
+	AddReadingEvent(userID int64, event ReadingEvent) error
+	GetReadingEvents(userID int64, since time.Time) ([]ReadingEvent, error)
+
+	// users
+	UserIDs() ([]int64, error)
+	Analytics() ([]UserAnalytics, error)
+	ExportUser(userID int64) (Backup, error)
+	ImportUser(userID int64, backup Backup, mode RestoreMode) (int, error)
+	CollectGarbage(before time.Time) (GCReport, error)
+	Snapshot(w io.Writer) error
+
+	// encryption at rest
+	SetKeyWrapper(keys KeyWrapper)
+	EncryptTexts(userID int64) error
+	DecryptTexts(userID int64) error
+	TextsEncrypted(userID int64) (bool, error)
+	RewrapDataKeys() (int, error)
+
+	Close() error
+}
+
+var (
+	_ Store = (*Storage)(nil)
+	_ Store = (*SQLiteStorage)(nil)
+)
+
+// CopyUsers copies all users from src to dst, users in dst are replaced. Texts of users with
+// encryption are encrypted with a new data key, dst must have the key wrapper set for them.
+// Returns number of copied users.
+func CopyUsers(dst, src Store) (int, error) {
+	userIDs, err := src.UserIDs()
+	if err != nil {
+		return 0, err
+	}
+	for i, userID := range userIDs {
+		backup, err := src.ExportUser(userID)
+		if err != nil {
+			return i, err
+		}
+		if _, err = dst.ImportUser(userID, backup, RestoreCopy); err != nil {
+			return i, err
+		}
+		encrypted, err := src.TextsEncrypted(userID)
+		if err != nil {
+			return i, err
+		}
+		if encrypted {
+			err = dst.EncryptTexts(userID)
+		} else {
+			err = dst.DecryptTexts(userID)
+		}
+		if err != nil {
+			return i, err
+		}
+		if err = dst.DeleteAuthToken(userID); err != nil && err != ErrNotFound {
+			return i, err
+		}
+		token, err := src.GetTokenByUserID(userID)
+		switch err {
+		case nil:
+			if err = dst.SetAuthToken(userID, token); err != nil {
+				return i, err
+			}
+		case ErrNotFound:
+		default:
+			return i, err
+		}
+	}
+	return len(userIDs), nil
+}
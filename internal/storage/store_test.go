@@ -0,0 +1,87 @@
+package storage_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/pechorka/adhd-reader/internal/storage"
+	"github.com/pechorka/adhd-reader/internal/storage/storagetest"
+	"github.com/pechorka/adhd-reader/pkg/encryptor"
+	"github.com/stretchr/testify/require"
+)
+
+func sqliteStorage(t *testing.T) *storage.SQLiteStorage {
+	t.Helper()
+	s, err := storage.NewSQLiteStorage(filepath.Join(t.TempDir(), "test.sqlite"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, s.Close())
+	})
+	return s
+}
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Store { return tempStorage(t) })
+}
+
+func TestSQLiteStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Store { return sqliteStorage(t) })
+}
+
+func TestCopyUsers(t *testing.T) {
+	keys := encryptor.NewEncryptor("secret")
+	src := tempStorage(t)
+	src.SetKeyWrapper(keys)
+	pf, err := src.AddProcessedFile(storage.NewProcessedFile{
+		Text:      "Book text.",
+		Chunks:    []string{"Book text."},
+		ChunkSize: 100,
+		CheckSum:  []byte("checksum"),
+	})
+	require.NoError(t, err)
+	for _, userID := range []int64{1, 2} {
+		_, err = src.AddTextFromProcessedFile(userID, "book", pf)
+		require.NoError(t, err)
+		_, err = src.AddText(userID, storage.NewText{Name: "note", Text: "Note.", Chunks: []string{"Note."}})
+		require.NoError(t, err)
+	}
+	_, err = src.UpdateDust(3, func(dust *storage.Dust) { dust.RedCount = 5 })
+	require.NoError(t, err)
+	require.NoError(t, src.SetAuthToken(2, "token"))
+	require.NoError(t, src.EncryptTexts(2))
+
+	dst := sqliteStorage(t)
+	dst.SetKeyWrapper(keys)
+	require.NoError(t, dst.SetAuthToken(2, "old token"))
+	copied, err := storage.CopyUsers(dst, src)
+	require.NoError(t, err)
+	require.Equal(t, 3, copied)
+
+	for _, userID := range []int64{1, 2, 3} {
+		expected, err := src.ExportUser(userID)
+		require.NoError(t, err)
+		actual, err := dst.ExportUser(userID)
+		require.NoError(t, err)
+		require.Len(t, actual.Texts, len(expected.Texts))
+		for i := range expected.Texts {
+			require.Equal(t, expected.Texts[i].UUID, actual.Texts[i].UUID)
+			require.Equal(t, expected.Texts[i].Chunks, actual.Texts[i].Chunks)
+		}
+		require.Equal(t, expected.Dust, actual.Dust)
+	}
+	encrypted, err := dst.TextsEncrypted(2)
+	require.NoError(t, err)
+	require.True(t, encrypted)
+	backup, err := dst.ExportUser(2)
+	require.NoError(t, err)
+	for _, text := range backup.Texts {
+		content, err := dst.GetTextContent(2, text.UUID)
+		require.NoError(t, err)
+		require.Equal(t, text.Chunks, content.Chunks)
+	}
+	userID, err := dst.GetUserIDByAuthToken("token")
+	require.NoError(t, err)
+	require.EqualValues(t, 2, userID)
+	_, err = dst.GetUserIDByAuthToken("old token")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+}
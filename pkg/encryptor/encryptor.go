@@ -1,24 +1,309 @@
+// Package encryptor encrypts tokens and data keys with keys derived from server secrets.
+// Every ciphertext starts with version of its key, so keys can be rotated: data is encrypted
+// with the newest key and decrypted with any key of the keyring until older keys are retired.
 package encryptor
 
 import (
+	"bytes"
+	"crypto/rand"
 	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
 
 	"github.com/gtank/cryptopasta"
 	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
 )
 
+var (
+	ErrRotationDisabled = errors.New("keyring file is not configured")
+	ErrTooManyKeys      = errors.New("all key versions are used")
+	ErrNothingToRetire  = errors.New("only the newest key is in use")
+)
+
+const (
+	// configVersion is version of the key derived from the secret in config, rotated keys follow it
+	configVersion = 1
+	maxVersion    = 255
+)
+
+var (
+	// kdfSalt is fixed, so the same secret always derives the same key
+	kdfSalt = []byte("adhd-reader encryptor")
+	// keyringSalt derives the key sealing secrets in the keyring file, it never encrypts data
+	keyringSalt = []byte("adhd-reader keyring")
+)
+
+// keyring is the keyring file
+type keyring struct {
+	// MinVersion is the oldest key version in use, older keys and the legacy key are retired
+	MinVersion int            `json:"min_version"`
+	Keys       []keyringEntry `json:"keys"`
+}
+
+// keyringEntry is a rotated key saved in the keyring file
+type keyringEntry struct {
+	Version int `json:"version"`
+	// Sealed is the secret encrypted with the key derived from the config secret
+	Sealed string `json:"sealed,omitempty"`
+	// Secret is the plain secret of keyrings saved before secrets were sealed, they are sealed on load
+	Secret string `json:"secret,omitempty"`
+}
+
 type Encryptor struct {
-	secret *[32]byte
+	mu     sync.RWMutex
+	keys   map[byte]*[32]byte
+	newest byte
+	// legacy is the secret copied into the key as is, ciphertexts made with it have no version.
+	// It is kept to decrypt tokens issued before keys were derived until old keys are retired.
+	legacy  *[32]byte
+	sealKey *[32]byte
+	keyring string
+	ring    keyring
 }
 
+// NewEncryptor returns encryptor with the key derived from secret, keys can't be rotated
 func NewEncryptor(secretString string) *Encryptor {
-	secret := &[32]byte{}
-	copy(secret[:], secretString)
-	return &Encryptor{secret: secret}
+	legacy := &[32]byte{}
+	copy(legacy[:], secretString)
+	return &Encryptor{
+		keys:    map[byte]*[32]byte{configVersion: deriveKey(secretString, configVersion)},
+		newest:  configVersion,
+		legacy:  legacy,
+		sealKey: derive(secretString, keyringSalt),
+		ring:    keyring{MinVersion: configVersion},
+	}
+}
+
+// LoadEncryptor returns encryptor with the key derived from secret and keys rotated before,
+// which are saved in keyringPath sealed with secret. The keyring file is created on the first rotation.
+func LoadEncryptor(secretString, keyringPath string) (*Encryptor, error) {
+	e := NewEncryptor(secretString)
+	e.keyring = keyringPath
+	data, err := os.ReadFile(keyringPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return e, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read keyring")
+	}
+	unsealed := false
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		// keyrings were saved as list of plain secrets before
+		err = json.Unmarshal(data, &e.ring.Keys)
+	} else {
+		err = json.Unmarshal(data, &e.ring)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse keyring")
+	}
+	if e.ring.MinVersion < configVersion || e.ring.MinVersion > maxVersion {
+		return nil, errors.Errorf("invalid min version %d in keyring", e.ring.MinVersion)
+	}
+	for i, entry := range e.ring.Keys {
+		if entry.Version <= configVersion || entry.Version > maxVersion {
+			return nil, errors.Errorf("invalid key version %d in keyring", entry.Version)
+		}
+		version := byte(entry.Version)
+		if _, ok := e.keys[version]; ok {
+			return nil, errors.Errorf("duplicate key version %d in keyring", entry.Version)
+		}
+		secret := entry.Secret
+		if secret == "" {
+			if secret, err = e.unseal(entry.Sealed); err != nil {
+				return nil, errors.Wrapf(err, "failed to unseal key version %d, is the secret changed?", entry.Version)
+			}
+		} else {
+			unsealed = true
+			if e.ring.Keys[i], err = e.seal(entry.Version, secret); err != nil {
+				return nil, err
+			}
+		}
+		e.keys[version] = deriveKey(secret, version)
+		e.newest = max(e.newest, version)
+	}
+	if int(e.newest) < e.ring.MinVersion {
+		return nil, errors.Errorf("keyring has no key of min version %d", e.ring.MinVersion)
+	}
+	e.retire()
+	if unsealed {
+		if err = saveKeyring(keyringPath, e.ring); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func deriveKey(secret string, version byte) *[32]byte {
+	return derive(secret, append(kdfSalt[:len(kdfSalt):len(kdfSalt)], version))
+}
+
+func derive(secret string, salt []byte) *[32]byte {
+	key := &[32]byte{}
+	copy(key[:], argon2.IDKey([]byte(secret), salt, 1, 64*1024, 4, 32))
+	return key
+}
+
+func (e *Encryptor) seal(version int, secret string) (keyringEntry, error) {
+	sealed, err := cryptopasta.Encrypt([]byte(secret), e.sealKey)
+	if err != nil {
+		return keyringEntry{}, errors.Wrap(err, "failed to seal secret")
+	}
+	return keyringEntry{Version: version, Sealed: base64.StdEncoding.EncodeToString(sealed)}, nil
+}
+
+func (e *Encryptor) unseal(sealed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	secret, err := cryptopasta.Decrypt(data, e.sealKey)
+	return string(secret), err
+}
+
+// retire forgets keys older than the min version of the keyring
+func (e *Encryptor) retire() {
+	for version := range e.keys {
+		if int(version) < e.ring.MinVersion {
+			delete(e.keys, version)
+		}
+	}
+	if e.ring.MinVersion > configVersion {
+		e.legacy = nil
+	}
+}
+
+// Version returns version of the key used to encrypt
+func (e *Encryptor) Version() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return int(e.newest)
+}
+
+// Rotate adds a new random key to the keyring file and encrypts with it from now on.
+// Data encrypted with older keys is still decrypted until they are retired. Returns version of the new key.
+func (e *Encryptor) Rotate() (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.keyring == "" {
+		return 0, ErrRotationDisabled
+	}
+	if e.newest == maxVersion {
+		return 0, ErrTooManyKeys
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return 0, errors.Wrap(err, "failed to generate secret")
+	}
+	version := e.newest + 1
+	plain := base64.StdEncoding.EncodeToString(secret)
+	entry, err := e.seal(int(version), plain)
+	if err != nil {
+		return 0, err
+	}
+	ring := e.ring
+	ring.Keys = append(ring.Keys[:len(ring.Keys):len(ring.Keys)], entry)
+	if err = saveKeyring(e.keyring, ring); err != nil {
+		return 0, err
+	}
+	e.ring = ring
+	e.keys[version] = deriveKey(plain, version)
+	e.newest = version
+	return int(version), nil
+}
+
+// Retire forgets all keys except the newest one, including the config and legacy keys, and removes
+// them from the keyring file. Data and tokens encrypted with them can't be decrypted anymore,
+// so data keys must be re-encrypted with the newest key before. Returns the retired version.
+func (e *Encryptor) Retire() (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.keyring == "" {
+		return 0, ErrRotationDisabled
+	}
+	if int(e.newest) == e.ring.MinVersion {
+		return 0, ErrNothingToRetire
+	}
+	ring := keyring{MinVersion: int(e.newest)}
+	for _, entry := range e.ring.Keys {
+		if entry.Version >= ring.MinVersion {
+			ring.Keys = append(ring.Keys, entry)
+		}
+	}
+	if err := saveKeyring(e.keyring, ring); err != nil {
+		return 0, err
+	}
+	e.ring = ring
+	e.retire()
+	return ring.MinVersion - 1, nil
+}
+
+// saveKeyring replaces the keyring file, so the keyring is never partially written
+func saveKeyring(path string, ring keyring) error {
+	data, err := json.MarshalIndent(ring, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal keyring")
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create keyring file")
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to write keyring")
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to sync keyring")
+	}
+	if err = f.Close(); err != nil {
+		return errors.Wrap(err, "failed to close keyring file")
+	}
+	return errors.Wrap(os.Rename(f.Name(), path), "failed to replace keyring file")
+}
+
+func (e *Encryptor) Encrypt(plaintext []byte) ([]byte, error) {
+	e.mu.RLock()
+	version, key := e.newest, e.keys[e.newest]
+	e.mu.RUnlock()
+	ciphertext, err := cryptopasta.Encrypt(plaintext, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encrypt")
+	}
+	return append([]byte{version}, ciphertext...), nil
+}
+
+func (e *Encryptor) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) == 0 {
+		return nil, errors.New("failed to decrypt: empty ciphertext")
+	}
+	e.mu.RLock()
+	key, ok := e.keys[ciphertext[0]]
+	e.mu.RUnlock()
+	if ok {
+		plaintext, err := cryptopasta.Decrypt(ciphertext[1:], key)
+		if err == nil {
+			return plaintext, nil
+		}
+	}
+	e.mu.RLock()
+	legacy := e.legacy
+	e.mu.RUnlock()
+	if legacy == nil {
+		return nil, errors.New("failed to decrypt: unknown or retired key")
+	}
+	// first byte of ciphertexts without version is random, so it can match a version by chance
+	plaintext, err := cryptopasta.Decrypt(ciphertext, legacy)
+	return plaintext, errors.Wrap(err, "failed to decrypt")
 }
 
 func (e *Encryptor) EncryptString(plaintext string) (string, error) {
-	encryptedBytes, err := cryptopasta.Encrypt([]byte(plaintext), e.secret)
+	encryptedBytes, err := e.Encrypt([]byte(plaintext))
 	if err != nil {
 		return "", errors.Wrap(err, "failed to encrypt string")
 	}
@@ -31,7 +316,7 @@ func (e *Encryptor) DecryptString(ciphertext string) (string, error) {
 	if err != nil {
 		return "", errors.Wrap(err, "failed to decode string")
 	}
-	decryptedBytes, err := cryptopasta.Decrypt(decodedBytes, e.secret)
+	decryptedBytes, err := e.Decrypt(decodedBytes)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to decrypt string")
 	}
@@ -0,0 +1,138 @@
+package encryptor
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gtank/cryptopasta"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptor_LegacyTokens(t *testing.T) {
+	secret := "secret"
+	legacyKey := &[32]byte{}
+	copy(legacyKey[:], secret)
+	legacy, err := cryptopasta.Encrypt([]byte("token"), legacyKey)
+	require.NoError(t, err)
+
+	e := NewEncryptor(secret)
+	token, err := e.DecryptString(base64.StdEncoding.EncodeToString(legacy))
+	require.NoError(t, err)
+	require.Equal(t, "token", token)
+
+	ciphertext, err := e.Encrypt([]byte("token"))
+	require.NoError(t, err)
+	require.Equal(t, byte(1), ciphertext[0])
+	_, err = cryptopasta.Decrypt(ciphertext[1:], legacyKey)
+	require.Error(t, err, "new ciphertexts must not use the secret as key")
+}
+
+func TestEncryptor_LongSecrets(t *testing.T) {
+	prefix := strings.Repeat("a", 32)
+	ciphertext, err := NewEncryptor(prefix + "b").EncryptString("token")
+	require.NoError(t, err)
+
+	_, err = NewEncryptor(prefix + "c").DecryptString(ciphertext)
+	require.Error(t, err, "secrets must not be truncated")
+}
+
+func TestEncryptor_Rotate(t *testing.T) {
+	keyring := filepath.Join(t.TempDir(), "keyring.json")
+	e, err := LoadEncryptor("secret", keyring)
+	require.NoError(t, err)
+	require.Equal(t, 1, e.Version())
+	old, err := e.EncryptString("old")
+	require.NoError(t, err)
+
+	version, err := e.Rotate()
+	require.NoError(t, err)
+	require.Equal(t, 2, version)
+	rotated, err := e.EncryptString("new")
+	require.NoError(t, err)
+
+	for _, e := range []*Encryptor{e, loadEncryptor(t, "secret", keyring)} {
+		require.Equal(t, 2, e.Version())
+		plaintext, err := e.DecryptString(old)
+		require.NoError(t, err)
+		require.Equal(t, "old", plaintext)
+		plaintext, err = e.DecryptString(rotated)
+		require.NoError(t, err)
+		require.Equal(t, "new", plaintext)
+	}
+
+	_, err = NewEncryptor("secret").DecryptString(rotated)
+	require.Error(t, err)
+	_, err = NewEncryptor("secret").Rotate()
+	require.ErrorIs(t, err, ErrRotationDisabled)
+
+	data, err := os.ReadFile(keyring)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), `"secret"`, "secrets are sealed")
+	_, err = LoadEncryptor("another secret", keyring)
+	require.Error(t, err, "keyring is sealed with the secret")
+}
+
+func TestEncryptor_Retire(t *testing.T) {
+	secret := "secret"
+	legacyKey := &[32]byte{}
+	copy(legacyKey[:], secret)
+	legacy, err := cryptopasta.Encrypt([]byte("token"), legacyKey)
+	require.NoError(t, err)
+	legacyToken := base64.StdEncoding.EncodeToString(legacy)
+
+	keyring := filepath.Join(t.TempDir(), "keyring.json")
+	e := loadEncryptor(t, secret, keyring)
+	_, err = e.Retire()
+	require.ErrorIs(t, err, ErrNothingToRetire)
+	old, err := e.EncryptString("old")
+	require.NoError(t, err)
+	_, err = e.Rotate()
+	require.NoError(t, err)
+	_, err = e.Rotate()
+	require.NoError(t, err)
+	newest, err := e.EncryptString("new")
+	require.NoError(t, err)
+
+	retired, err := e.Retire()
+	require.NoError(t, err)
+	require.Equal(t, 2, retired)
+	for _, e := range []*Encryptor{e, loadEncryptor(t, secret, keyring)} {
+		require.Equal(t, 3, e.Version())
+		_, err = e.DecryptString(old)
+		require.Error(t, err)
+		_, err = e.DecryptString(legacyToken)
+		require.Error(t, err, "legacy key is retired too")
+		plaintext, err := e.DecryptString(newest)
+		require.NoError(t, err)
+		require.Equal(t, "new", plaintext)
+	}
+	_, err = e.Retire()
+	require.ErrorIs(t, err, ErrNothingToRetire)
+}
+
+func TestEncryptor_PlainKeyring(t *testing.T) {
+	keyring := filepath.Join(t.TempDir(), "keyring.json")
+	plain := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	require.NoError(t, os.WriteFile(keyring, []byte(`[{"version": 2, "secret": "`+plain+`"}]`), 0o600))
+	e := loadEncryptor(t, "secret", keyring)
+	require.Equal(t, 2, e.Version())
+	ciphertext, err := e.EncryptString("token")
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(keyring)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), plain, "plain secrets are sealed on load")
+	plaintext, err := loadEncryptor(t, "secret", keyring).DecryptString(ciphertext)
+	require.NoError(t, err)
+	require.Equal(t, "token", plaintext)
+}
+
+func loadEncryptor(t *testing.T, secret, keyring string) *Encryptor {
+	t.Helper()
+	e, err := LoadEncryptor(secret, keyring)
+	require.NoError(t, err)
+	return e
+}
@@ -6,9 +6,12 @@ import (
 	"encoding/xml"
 	"errors"
 	"io"
+	"net/url"
+	"path"
 	"strings"
 
-	"github.com/PuerkitoBio/goquery"
+	"github.com/pechorka/adhd-reader/pkg/markup"
+	"golang.org/x/net/html"
 )
 
 const (
@@ -20,28 +23,42 @@ var (
 )
 
 func PlainText(data []byte) (string, error) {
-	allFiles, err := parseAllFiles(data)
+	doc, err := Parse(data)
 	if err != nil {
 		return "", err
 	}
+	return markup.PlainText(doc.Text), nil
+}
+
+// Parse extracts text in markup from epub. Images are kept as attachments.
+func Parse(data []byte) (markup.Document, error) {
+	allFiles, err := parseAllFiles(data)
+	if err != nil {
+		return markup.Document{}, err
+	}
 
 	container, err := parseContainer(allFiles)
 	if err != nil {
-		return "", err
+		return markup.Document{}, err
 	}
 
 	content, err := parseContent(allFiles, container)
 	if err != nil {
-		return "", err
+		return markup.Document{}, err
 	}
 
 	htmlFiles, err := allHtmlFiles(allFiles, content)
 	if err != nil {
-		return "", err
+		return markup.Document{}, err
 	}
 
 	orderedHtmls := orderHtmlFiles(content, htmlFiles)
-	return plainTextFromHtmls(orderedHtmls)
+	doc, err := documentFromHtmls(allFiles, orderedHtmls)
+	if err != nil {
+		return markup.Document{}, err
+	}
+	doc.Language = strings.TrimSpace(content.Metadata.Language)
+	return doc, nil
 }
 
 func parseAllFiles(data []byte) (map[string]*zip.File, error) {
@@ -74,31 +91,39 @@ func parseContent(files allFiles, c container) (opf opf, err error) {
 	err = files.decodeFile(contentPath, func(r io.Reader) error {
 		return xml.NewDecoder(r).Decode(&opf)
 	})
+	// manifest hrefs are relative to content file
+	opf.dir = path.Dir(contentPath)
 	return opf, err
 }
 
-func allHtmlFiles(files allFiles, o opf) (map[string]string, error) {
-	htmlFiles := make(map[string]string, len(o.Manifest)) // map[id]htmlContent
+type htmlFile struct {
+	path    string
+	content string
+}
+
+func allHtmlFiles(files allFiles, o opf) (map[string]htmlFile, error) {
+	htmlFiles := make(map[string]htmlFile, len(o.Manifest)) // map[id]htmlFile
 	var b bytes.Buffer
 	for _, m := range o.Manifest {
 		if m.MediaType != "application/xhtml+xml" {
 			continue
 		}
-		err := files.decodeFile(m.Href, func(r io.Reader) error {
+		filePath := resolvePath(o.dir, m.Href)
+		err := files.decodeFile(filePath, func(r io.Reader) error {
 			_, err := io.Copy(&b, r)
 			return err
 		})
 		if err != nil {
 			return nil, err
 		}
-		htmlFiles[m.Id] = b.String()
+		htmlFiles[m.Id] = htmlFile{path: filePath, content: b.String()}
 		b.Reset() // reuse buffer
 	}
 	return htmlFiles, nil
 }
 
-func orderHtmlFiles(o opf, htmlFiles map[string]string) []string {
-	var ordered []string
+func orderHtmlFiles(o opf, htmlFiles map[string]htmlFile) []htmlFile {
+	var ordered []htmlFile
 	for _, i := range o.Spine.ItemRefs {
 		if html, ok := htmlFiles[i.Idref]; ok {
 			ordered = append(ordered, html)
@@ -107,24 +132,59 @@ func orderHtmlFiles(o opf, htmlFiles map[string]string) []string {
 	return ordered
 }
 
-func plainTextFromHtmls(htmls []string) (string, error) {
+func documentFromHtmls(files allFiles, htmls []htmlFile) (markup.Document, error) {
 	var totalSize int
 	for _, h := range htmls {
-		totalSize += len(h)
+		totalSize += len(h.content)
 	}
 
-	var b bytes.Buffer
+	doc := markup.Document{Attachments: make(map[string][]byte)}
+	var b strings.Builder
 	b.Grow(totalSize)
 	for _, h := range htmls {
-		doc, err := goquery.NewDocumentFromReader(strings.NewReader(h))
+		root, err := html.Parse(strings.NewReader(h.content))
 		if err != nil {
-			return "", err
+			return markup.Document{}, err
 		}
-		b.WriteString(doc.Text())
+		b.WriteString(markup.FromHTML(markup.HTMLOptions{
+			ResolveImage: func(src string) (string, bool) {
+				return resolveImage(files, doc.Attachments, path.Dir(h.path), src)
+			},
+		}, root))
 		b.WriteByte('\n')
 	}
+	doc.Text = b.String()
+
+	return doc, nil
+}
 
-	return b.String(), nil
+func resolveImage(files allFiles, attachments map[string][]byte, dir, src string) (string, bool) {
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		return src, true
+	}
+	imagePath := resolvePath(dir, src)
+	name := url.PathEscape(imagePath)
+	if _, ok := attachments[name]; ok {
+		return markup.AttachmentURL(name), true
+	}
+	var b bytes.Buffer
+	err := files.decodeFile(imagePath, func(r io.Reader) error {
+		_, err := io.Copy(&b, r)
+		return err
+	})
+	if err != nil {
+		return "", false
+	}
+	attachments[name] = b.Bytes()
+	return markup.AttachmentURL(name), true
+}
+
+func resolvePath(dir, href string) string {
+	href, _, _ = strings.Cut(href, "#")
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		href = unescaped
+	}
+	return path.Join(dir, href)
 }
 
 type allFiles map[string]*zip.File
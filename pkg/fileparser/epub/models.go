@@ -23,6 +23,8 @@ type opf struct {
 	Manifest []manifest  `xml:"manifest>item"`
 	Spine    spine       `xml:"spine"`
 	Guide    []guide     `xml:"guide>reference"`
+
+	dir string // directory of content file inside epub
 }
 
 type opfMetadata struct {
@@ -2,17 +2,142 @@ package fb2
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"strings"
 
-	"github.com/PuerkitoBio/goquery"
+	"github.com/pechorka/adhd-reader/pkg/markup"
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
 func PlainText(data []byte) (string, error) {
-	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(data))
+	doc, err := Parse(data)
 	if err != nil {
 		return "", err
 	}
+	return markup.PlainText(doc.Text), nil
+}
 
-	body := doc.Find("body")
+// Parse extracts text in markup from fb2. Images from binary sections are kept as attachments.
+func Parse(data []byte) (markup.Document, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+	d.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
 
-	return body.Text(), nil
+	// fb2 body is converted to html tree, so it can be converted to markup the same way as html
+	root := &html.Node{Type: html.DocumentNode}
+	var stack []*html.Node
+	binaries := make(map[string][]byte)
+	var lang string
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return markup.Document{}, errors.Wrap(err, "failed to decode fb2")
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch {
+			case t.Name.Local == "binary":
+				id, content, err := decodeBinary(d, t)
+				if err != nil {
+					return markup.Document{}, err
+				}
+				binaries[id] = content
+			case t.Name.Local == "lang" && len(stack) == 0 && lang == "": // first lang is in title-info
+				if err := d.DecodeElement(&lang, &t); err != nil {
+					return markup.Document{}, errors.Wrap(err, "failed to decode lang")
+				}
+			case t.Name.Local == "body" && len(stack) == 0:
+				n := &html.Node{Type: html.ElementNode, Data: "section", DataAtom: atom.Section}
+				root.AppendChild(n)
+				stack = append(stack, n)
+			case len(stack) > 0:
+				n := htmlNode(t)
+				stack[len(stack)-1].AppendChild(n)
+				stack = append(stack, n)
+			}
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].AppendChild(&html.Node{Type: html.TextNode, Data: string(t)})
+			}
+		}
+	}
+
+	doc := markup.Document{Attachments: make(map[string][]byte), Language: strings.TrimSpace(lang)}
+	doc.Text = markup.FromHTML(markup.HTMLOptions{
+		ResolveImage: func(src string) (string, bool) {
+			id, ok := strings.CutPrefix(src, "#")
+			if !ok {
+				return "", false
+			}
+			content, ok := binaries[id]
+			if !ok {
+				return "", false
+			}
+			doc.Attachments[id] = content
+			return markup.AttachmentURL(id), true
+		},
+	}, root)
+	return doc, nil
+}
+
+var htmlTags = map[string]atom.Atom{
+	"title":       atom.H2,
+	"subtitle":    atom.H3,
+	"epigraph":    atom.Blockquote,
+	"cite":        atom.Blockquote,
+	"poem":        atom.Div,
+	"stanza":      atom.Div,
+	"v":           atom.P,
+	"text-author": atom.P,
+	"empty-line":  atom.Br,
+	"emphasis":    atom.Em,
+	"image":       atom.Img,
+}
+
+func htmlNode(t xml.StartElement) *html.Node {
+	a, ok := htmlTags[t.Name.Local]
+	if !ok {
+		a = atom.Lookup([]byte(t.Name.Local))
+	}
+	if a == 0 {
+		a = atom.Span
+	}
+	n := &html.Node{Type: html.ElementNode, Data: a.String(), DataAtom: a}
+	for _, attr := range t.Attr {
+		key := attr.Name.Local
+		if key == "href" && a == atom.Img {
+			key = "src"
+		}
+		n.Attr = append(n.Attr, html.Attribute{Key: key, Val: attr.Value})
+	}
+	return n
+}
+
+func decodeBinary(d *xml.Decoder, start xml.StartElement) (string, []byte, error) {
+	var binary struct {
+		ID   string `xml:"id,attr"`
+		Data string `xml:",chardata"`
+	}
+	if err := d.DecodeElement(&binary, &start); err != nil {
+		return "", nil, errors.Wrap(err, "failed to decode binary")
+	}
+	content, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(binary.Data), ""))
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "failed to decode binary %s", binary.ID)
+	}
+	return binary.ID, content, nil
 }
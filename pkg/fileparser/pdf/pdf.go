@@ -8,6 +8,7 @@ import (
 
 	stdErrs "errors"
 
+	"github.com/pechorka/adhd-reader/pkg/markup"
 	"github.com/pkg/errors"
 )
 
@@ -27,3 +28,11 @@ func PlaintText(data []byte) (_ string, err error) {
 
 	return string(out), nil
 }
+
+func Parse(data []byte) (markup.Document, error) {
+	text, err := PlaintText(data)
+	if err != nil {
+		return markup.Document{}, err
+	}
+	return markup.Document{Text: markup.Escape(text)}, nil
+}
@@ -3,6 +3,7 @@ package plaintext
 import (
 	"unicode/utf8"
 
+	"github.com/pechorka/adhd-reader/pkg/markup"
 	"github.com/pechorka/gostdlib/pkg/errs"
 )
 
@@ -12,3 +13,11 @@ func PlainText(data []byte) (string, error) {
 	}
 	return string(data), nil
 }
+
+func Parse(data []byte) (markup.Document, error) {
+	text, err := PlainText(data)
+	if err != nil {
+		return markup.Document{}, err
+	}
+	return markup.Document{Text: markup.Escape(text)}, nil
+}
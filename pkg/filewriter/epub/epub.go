@@ -0,0 +1,195 @@
+// Package epub writes texts in markup as EPUB 3 books.
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pechorka/adhd-reader/pkg/markup"
+	"github.com/pkg/errors"
+)
+
+// Book is a text split into sections, every section becomes a separate page of the book
+type Book struct {
+	Identifier  string // unique identifier of the book, e.g. urn:uuid:...
+	Title       string
+	Language    string // language code, en if empty
+	Author      string // optional
+	Modified    time.Time
+	Sections    []Section
+	Attachments map[string][]byte // images referenced from sections, see markup.AttachmentURL
+}
+
+type Section struct {
+	Title string
+	Text  string // text in markup
+}
+
+const (
+	mimetype        = "application/epub+zip"
+	contentDir      = "OEBPS"
+	contentPath     = contentDir + "/content.opf"
+	imagesDir       = "images"
+	navPath         = "nav.xhtml"
+	defaultLanguage = "en"
+)
+
+// Write returns book in epub format
+func Write(book Book) ([]byte, error) {
+	if len(book.Sections) == 0 {
+		return nil, errors.New("book has no sections")
+	}
+	if book.Language == "" {
+		book.Language = defaultLanguage
+	}
+	if book.Modified.IsZero() {
+		book.Modified = time.Now()
+	}
+
+	var buf bytes.Buffer
+	w := &writer{zw: zip.NewWriter(&buf)}
+	// mimetype must be the first file and must not be compressed
+	w.writeFile(&zip.FileHeader{Name: "mimetype", Method: zip.Store}, []byte(mimetype))
+	w.write("META-INF/container.xml", []byte(containerXML))
+
+	images := make(map[string]string, len(book.Attachments)) // map[attachment name]image path
+	var manifest []manifestItem
+	names := make([]string, 0, len(book.Attachments))
+	for name := range book.Attachments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		content := book.Attachments[name]
+		item := imageItem(len(manifest), name, content)
+		images[name] = item.Href
+		manifest = append(manifest, item)
+		w.write(path.Join(contentDir, item.Href), content)
+	}
+	resolveImage := func(src string) (string, bool) {
+		if name, ok := markup.AttachmentName(src); ok {
+			href, ok := images[name]
+			return href, ok
+		}
+		// external images aren't shown by most readers without internet
+		return "", false
+	}
+
+	var nav []navItem
+	var spine []string
+	for i, section := range book.Sections {
+		title := strings.TrimSpace(section.Title)
+		if title == "" {
+			title = strconv.Itoa(i + 1)
+		}
+		item := manifestItem{
+			ID:        fmt.Sprintf("section-%d", i+1),
+			Href:      fmt.Sprintf("section-%d.xhtml", i+1),
+			MediaType: "application/xhtml+xml",
+		}
+		manifest = append(manifest, item)
+		spine = append(spine, item.ID)
+		nav = append(nav, navItem{Href: item.Href, Title: title})
+		w.template(path.Join(contentDir, item.Href), sectionTemplate, sectionData{
+			Language: book.Language,
+			Title:    title,
+			Body:     markup.XHTML(section.Text, resolveImage),
+		})
+	}
+	w.template(path.Join(contentDir, navPath), navTemplate, navData{
+		Language: book.Language,
+		Title:    book.Title,
+		Items:    nav,
+	})
+	w.template(contentPath, packageTemplate, packageData{
+		Book:     book,
+		Modified: book.Modified.UTC().Format(time.RFC3339),
+		Manifest: manifest,
+		Spine:    spine,
+	})
+	if w.err != nil {
+		return nil, w.err
+	}
+	if err := w.zw.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to close epub")
+	}
+	return buf.Bytes(), nil
+}
+
+type manifestItem struct {
+	ID        string
+	Href      string
+	MediaType string
+}
+
+// imageItem names image by its index, attachment names can contain characters that aren't allowed in paths
+func imageItem(i int, name string, content []byte) manifestItem {
+	ext := strings.ToLower(path.Ext(name))
+	mediaType, ok := imageTypes[ext]
+	if !ok {
+		mediaType, _, _ = strings.Cut(http.DetectContentType(content), ";")
+		ext = imageExtensions[mediaType]
+	}
+	return manifestItem{
+		ID:        fmt.Sprintf("image-%d", i+1),
+		Href:      fmt.Sprintf("%s/image-%d%s", imagesDir, i+1, ext),
+		MediaType: mediaType,
+	}
+}
+
+var imageTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".svg":  "image/svg+xml",
+	".webp": "image/webp",
+}
+
+var imageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// writer remembers the first error, so files are written without checking every error
+type writer struct {
+	zw  *zip.Writer
+	err error
+}
+
+func (w *writer) write(name string, content []byte) {
+	w.writeFile(&zip.FileHeader{Name: name, Method: zip.Deflate}, content)
+}
+
+func (w *writer) writeFile(header *zip.FileHeader, content []byte) {
+	if w.err != nil {
+		return
+	}
+	f, err := w.zw.CreateHeader(header)
+	if err != nil {
+		w.err = errors.Wrapf(err, "failed to add %s to epub", header.Name)
+		return
+	}
+	if _, err = f.Write(content); err != nil {
+		w.err = errors.Wrapf(err, "failed to write %s to epub", header.Name)
+	}
+}
+
+func (w *writer) template(name string, tmpl *template.Template, data any) {
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		w.err = errors.Wrapf(err, "failed to render %s", name)
+		return
+	}
+	w.write(name, b.Bytes())
+}
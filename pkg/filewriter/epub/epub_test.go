@@ -0,0 +1,64 @@
+package epub
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	epubparser "github.com/pechorka/adhd-reader/pkg/fileparser/epub"
+	"github.com/pechorka/adhd-reader/pkg/markup"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	sections := []Section{
+		{
+			Title: "Chapter <1>",
+			Text: "# Chapter <1>\n" +
+				"Text with **bold**, __italic__ and `code` & [link](https://example.com).\n" +
+				"\n" +
+				"- first item\n" +
+				"- second item\n" +
+				"![image](attachment:OEBPS%2Fimage.png)",
+		},
+		{
+			Text: "Escaped \\*stars\\* and \\# hash\n" +
+				"```\n" +
+				"code <block>\n" +
+				"```\n" +
+				"Last line",
+		},
+	}
+	data, err := Write(Book{
+		Identifier:  "urn:uuid:test",
+		Title:       `Book "title"`,
+		Language:    "ru",
+		Modified:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Sections:    sections,
+		Attachments: map[string][]byte{"OEBPS%2Fimage.png": {0x89, 'P', 'N', 'G'}},
+	})
+	require.NoError(t, err)
+
+	var want []string
+	for _, section := range sections {
+		want = append(want, markup.PlainText(section.Text))
+	}
+	plain, err := epubparser.PlainText(data)
+	require.NoError(t, err)
+	require.Equal(t, strings.Fields(strings.Join(want, "\n")), strings.Fields(plain))
+
+	doc, err := epubparser.Parse(data)
+	require.NoError(t, err)
+	require.Equal(t, "ru", doc.Language)
+	require.Len(t, doc.Attachments, 1)
+	for _, content := range doc.Attachments {
+		require.Equal(t, []byte{0x89, 'P', 'N', 'G'}, content)
+	}
+	require.Contains(t, doc.Text, "**bold**")
+	require.Contains(t, doc.Text, "- first item")
+}
+
+func TestWrite_NoSections(t *testing.T) {
+	_, err := Write(Book{Title: "empty"})
+	require.Error(t, err)
+}
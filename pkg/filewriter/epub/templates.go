@@ -0,0 +1,102 @@
+package epub
+
+import (
+	"encoding/xml"
+	"strings"
+	"text/template"
+)
+
+const containerXML = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="` + contentPath + `" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+var funcs = template.FuncMap{
+	"xml": func(s string) string {
+		var b strings.Builder
+		_ = xml.EscapeText(&b, []byte(s))
+		return b.String()
+	},
+}
+
+type packageData struct {
+	Book
+	Modified string
+	Manifest []manifestItem
+	Spine    []string
+}
+
+var packageTemplate = template.Must(template.New("package").Funcs(funcs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id" xml:lang="{{xml .Language}}">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="book-id">{{xml .Identifier}}</dc:identifier>
+    <dc:title>{{xml .Title}}</dc:title>
+    <dc:language>{{xml .Language}}</dc:language>
+{{- if .Author}}
+    <dc:creator>{{xml .Author}}</dc:creator>
+{{- end}}
+    <meta property="dcterms:modified">{{.Modified}}</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="` + navPath + `" media-type="application/xhtml+xml" properties="nav"/>
+{{- range .Manifest}}
+    <item id="{{.ID}}" href="{{xml .Href}}" media-type="{{xml .MediaType}}"/>
+{{- end}}
+  </manifest>
+  <spine>
+{{- range .Spine}}
+    <itemref idref="{{.}}"/>
+{{- end}}
+  </spine>
+</package>
+`))
+
+type navItem struct {
+	Href  string
+	Title string
+}
+
+type navData struct {
+	Language string
+	Title    string
+	Items    []navItem
+}
+
+var navTemplate = template.Must(template.New("nav").Funcs(funcs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="{{xml .Language}}" lang="{{xml .Language}}">
+<head>
+  <title>{{xml .Title}}</title>
+</head>
+<body>
+  <nav epub:type="toc" id="toc">
+    <h1>{{xml .Title}}</h1>
+    <ol>
+{{- range .Items}}
+      <li><a href="{{xml .Href}}">{{xml .Title}}</a></li>
+{{- end}}
+    </ol>
+  </nav>
+</body>
+</html>
+`))
+
+type sectionData struct {
+	Language string
+	Title    string
+	Body     string // rendered xhtml
+}
+
+var sectionTemplate = template.Must(template.New("section").Funcs(funcs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xml:lang="{{xml .Language}}" lang="{{xml .Language}}">
+<head>
+  <title>{{xml .Title}}</title>
+</head>
+<body>
+{{.Body}}</body>
+</html>
+`))
@@ -0,0 +1,289 @@
+package markup
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// HTMLOptions controls how html is converted to markup
+type HTMLOptions struct {
+	// ResolveImage maps src attribute of img tag to image source.
+	// Only alt text is kept if it returns false. By default only absolute http(s) sources are kept.
+	ResolveImage func(src string) (string, bool)
+	// ResolveLink maps href attribute of a tag to link url.
+	// Only link text is kept if it returns false. By default only absolute http(s) links are kept.
+	ResolveLink func(href string) (string, bool)
+}
+
+// FromHTML converts html nodes to markup. Block elements are separated by new lines,
+// bold, italic, code, headings, list items and preformatted text keep their formatting.
+func FromHTML(opts HTMLOptions, nodes ...*html.Node) string {
+	if opts.ResolveImage == nil {
+		opts.ResolveImage = resolveAbsoluteURL
+	}
+	if opts.ResolveLink == nil {
+		opts.ResolveLink = resolveAbsoluteURL
+	}
+	w := &htmlWriter{opts: opts, lineStart: true}
+	for _, n := range nodes {
+		w.walk(n)
+	}
+	return strings.TrimRightFunc(w.b.String(), unicode.IsSpace)
+}
+
+func resolveAbsoluteURL(url string) (string, bool) {
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		return url, true
+	}
+	return "", false
+}
+
+type htmlWriter struct {
+	opts         HTMLOptions
+	b            Builder
+	lineStart    bool
+	pendingSpace bool
+	preDepth     int
+	styles       []htmlStyle // styles of current inline content, outermost first
+	heading      int         // heading level of the line to start, 0 if not a heading
+	listItem     bool        // if the line to start is a list item
+}
+
+type htmlStyle struct {
+	marker  Marker
+	written bool // opening marker is written only before the first inline content
+}
+
+func (w *htmlWriter) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.text(n.Data)
+		return
+	case html.DocumentNode:
+		w.walkChildren(n)
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Head, atom.Script, atom.Style, atom.Template:
+		return
+	case atom.Br:
+		w.newLine()
+		return
+	case atom.Img:
+		w.image(n)
+		return
+	case atom.A:
+		if w.link(n) {
+			return
+		}
+	case atom.Pre:
+		w.pre(n)
+		return
+	case atom.B, atom.Strong:
+		w.styled(n, MarkerBold)
+		return
+	case atom.I, atom.Em:
+		w.styled(n, MarkerItalic)
+		return
+	case atom.Code, atom.Kbd, atom.Samp, atom.Tt:
+		w.styled(n, MarkerCode)
+		return
+	}
+
+	block := isBlock(n.DataAtom)
+	if block {
+		w.newLine()
+	}
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		w.heading = int(n.Data[1] - '0')
+	case atom.Li:
+		w.listItem = true
+	}
+	w.walkChildren(n)
+	if block {
+		w.newLine()
+		w.heading, w.listItem = 0, false
+	}
+}
+
+func (w *htmlWriter) walkChildren(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.walk(c)
+	}
+}
+
+func (w *htmlWriter) pre(n *html.Node) {
+	w.newLine()
+	if w.preDepth == 0 {
+		w.b.WriteCodeFence()
+	}
+	w.preDepth++
+	w.walkChildren(n)
+	w.preDepth--
+	if w.preDepth == 0 {
+		w.b.WriteCodeFence()
+	}
+	w.lineStart = true
+}
+
+func (w *htmlWriter) styled(n *html.Node, m Marker) {
+	if w.preDepth > 0 || w.hasStyle(m) {
+		w.walkChildren(n)
+		return
+	}
+	w.styles = append(w.styles, htmlStyle{marker: m})
+	w.walkChildren(n)
+	last := w.styles[len(w.styles)-1]
+	w.styles = w.styles[:len(w.styles)-1]
+	if last.written {
+		w.b.WriteMarker(m)
+	}
+}
+
+func (w *htmlWriter) hasStyle(m Marker) bool {
+	for _, s := range w.styles {
+		if s.marker == m {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *htmlWriter) text(text string) {
+	if w.preDepth > 0 {
+		w.b.WriteText(text)
+		w.lineStart = strings.HasSuffix(text, "\n")
+		w.pendingSpace = false
+		return
+	}
+	for len(text) > 0 {
+		i := strings.IndexFunc(text, unicode.IsSpace)
+		if i == 0 {
+			w.pendingSpace = true
+			text = strings.TrimLeftFunc(text, unicode.IsSpace)
+			continue
+		}
+		if i < 0 {
+			i = len(text)
+		}
+		w.beforeInline()
+		w.b.WriteText(text[:i])
+		text = text[i:]
+	}
+}
+
+func (w *htmlWriter) image(n *html.Node) {
+	src, ok := w.opts.ResolveImage(attr(n, "src"))
+	if !ok {
+		w.text(attr(n, "alt"))
+		return
+	}
+	w.beforeInline()
+	w.b.WriteImage(collapseSpaces(attr(n, "alt")), src)
+}
+
+func (w *htmlWriter) link(n *html.Node) bool {
+	href, ok := w.opts.ResolveLink(attr(n, "href"))
+	if !ok || hasDescendant(n, atom.Img) {
+		return false
+	}
+	text := collapseSpaces(textContent(n))
+	if text == "" {
+		return true
+	}
+	w.beforeInline()
+	w.b.WriteLink(text, href)
+	return true
+}
+
+func (w *htmlWriter) beforeInline() {
+	switch {
+	case w.lineStart && w.heading > 0:
+		w.b.WriteHeading(w.heading)
+	case w.lineStart && w.listItem:
+		w.b.WriteListItem()
+	case w.pendingSpace && !w.lineStart:
+		w.b.sb.WriteByte(' ')
+	}
+	for i := range w.styles {
+		if !w.styles[i].written {
+			w.b.WriteMarker(w.styles[i].marker)
+			w.styles[i].written = true
+		}
+	}
+	w.pendingSpace = false
+	w.lineStart = false
+	w.heading, w.listItem = 0, false
+}
+
+// newLine starts new line, inline styles are closed and reopened on the next line
+func (w *htmlWriter) newLine() {
+	if !w.lineStart {
+		for i := len(w.styles) - 1; i >= 0; i-- {
+			if w.styles[i].written {
+				w.b.WriteMarker(w.styles[i].marker)
+				w.styles[i].written = false
+			}
+		}
+		w.b.sb.WriteByte('\n')
+	}
+	w.lineStart = true
+	w.pendingSpace = false
+}
+
+func isBlock(a atom.Atom) bool {
+	switch a {
+	case atom.Address, atom.Article, atom.Aside, atom.Blockquote, atom.Body,
+		atom.Dd, atom.Div, atom.Dl, atom.Dt, atom.Figcaption, atom.Figure,
+		atom.Footer, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6,
+		atom.Header, atom.Hr, atom.Li, atom.Main, atom.Nav, atom.Ol, atom.P,
+		atom.Pre, atom.Section, atom.Table, atom.Tr, atom.Ul:
+		return true
+	}
+	return false
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasDescendant(n *html.Node, a atom.Atom) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.DataAtom == a || hasDescendant(c, a) {
+			return true
+		}
+	}
+	return false
+}
+
+func textContent(n *html.Node) string {
+	var sb strings.Builder
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(n)
+	return sb.String()
+}
+
+func collapseSpaces(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,185 @@
+// Package markup implements the lightweight markup texts are stored in.
+//
+// Plain text is kept as is, except for the special characters that are
+// escaped with a backslash. Formatting is kept with markers similar to markdown:
+//
+//	**bold**, __italic__, `code`
+//	# heading (up to 6 #)
+//	- list item
+//	```
+//	code block
+//	```
+//
+// Inline markers never span multiple lines, so every line can be rendered on its own.
+// Images and links are kept as inline references:
+//
+//	![alternative text](https://example.com/image.png)
+//	![alternative text](attachment:image.png)
+//	[link text](https://example.com)
+//
+// Images that are embedded into the source file (epub, fb2) are referenced
+// with the attachment: scheme and their bytes are kept in Document.Attachments.
+package markup
+
+import (
+	"strings"
+)
+
+const attachmentScheme = "attachment:"
+
+// Document is a text in markup together with files it references
+type Document struct {
+	Text        string
+	Attachments map[string][]byte // map[attachment name]content
+	Language    string            // language declared by the source, empty if unknown
+}
+
+// AttachmentURL returns image source that references attachment by its name
+func AttachmentURL(name string) string {
+	return attachmentScheme + name
+}
+
+// AttachmentName returns attachment name if src references an attachment
+func AttachmentName(src string) (string, bool) {
+	return strings.CutPrefix(src, attachmentScheme)
+}
+
+// Marker is an inline formatting marker, same marker opens and closes formatting
+type Marker string
+
+const (
+	MarkerBold   Marker = "**"
+	MarkerItalic Marker = "__"
+	MarkerCode   Marker = "`"
+)
+
+const (
+	codeFence    = "```"
+	listItem     = "- "
+	headingLevel = 6
+)
+
+const escapeChar = '\\'
+
+func isSpecial(c byte) bool {
+	switch c {
+	case escapeChar, '[', ']', '*', '_', '`':
+		return true
+	}
+	return isLineSpecial(c)
+}
+
+// isLineSpecial reports if c is special only at the beginning of the line
+func isLineSpecial(c byte) bool {
+	return c == '#' || c == '-'
+}
+
+// Escape escapes all special characters in plain text
+func Escape(text string) string {
+	var b Builder
+	b.WriteText(text)
+	return b.String()
+}
+
+// Builder builds text in markup
+type Builder struct {
+	sb strings.Builder
+}
+
+// WriteText writes plain text, escaping special characters
+func (b *Builder) WriteText(text string) {
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if isSpecial(c) && (!isLineSpecial(c) || b.atLineStart()) {
+			b.sb.WriteByte(escapeChar)
+		}
+		b.sb.WriteByte(c)
+	}
+}
+
+// WriteLink writes reference to url with text
+func (b *Builder) WriteLink(text, url string) {
+	b.sb.WriteByte('[')
+	b.writeLabel(text)
+	b.sb.WriteString("](")
+	b.sb.WriteString(escapeURL(url))
+	b.sb.WriteByte(')')
+}
+
+// WriteImage writes reference to image with alternative text
+func (b *Builder) WriteImage(alt, src string) {
+	b.sb.WriteString("![")
+	b.writeLabel(alt)
+	b.sb.WriteString("](")
+	b.sb.WriteString(escapeURL(src))
+	b.sb.WriteByte(')')
+}
+
+// WriteMarker opens or closes inline formatting
+func (b *Builder) WriteMarker(m Marker) {
+	b.sb.WriteString(string(m))
+}
+
+// WriteHeading starts heading line of level from 1 to 6
+func (b *Builder) WriteHeading(level int) {
+	level = max(1, min(level, headingLevel))
+	b.startLine()
+	b.sb.WriteString(strings.Repeat("#", level))
+	b.sb.WriteByte(' ')
+}
+
+// WriteListItem starts list item line
+func (b *Builder) WriteListItem() {
+	b.startLine()
+	b.sb.WriteString(listItem)
+}
+
+// WriteCodeFence opens or closes code block
+func (b *Builder) WriteCodeFence() {
+	b.startLine()
+	b.sb.WriteString(codeFence)
+	b.sb.WriteByte('\n')
+}
+
+func (b *Builder) Len() int {
+	return b.sb.Len()
+}
+
+func (b *Builder) String() string {
+	return b.sb.String()
+}
+
+func (b *Builder) writeLabel(text string) {
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c == '\n' {
+			c = ' '
+		}
+		if isSpecial(c) && !isLineSpecial(c) {
+			b.sb.WriteByte(escapeChar)
+		}
+		b.sb.WriteByte(c)
+	}
+}
+
+func (b *Builder) atLineStart() bool {
+	s := b.sb.String()
+	return len(s) == 0 || s[len(s)-1] == '\n'
+}
+
+func (b *Builder) startLine() {
+	if !b.atLineStart() {
+		b.sb.WriteByte('\n')
+	}
+}
+
+var urlReplacer = strings.NewReplacer(
+	" ", "%20",
+	"\n", "%0A",
+	"(", "%28",
+	")", "%29",
+)
+
+func escapeURL(url string) string {
+	return urlReplacer.Replace(url)
+}
@@ -0,0 +1,175 @@
+package markup
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/html"
+)
+
+func TestSegments(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []Segment
+	}{
+		{name: "empty", text: "", want: nil},
+		{name: "plain text", text: "just text", want: []Segment{
+			{Type: SegmentText, Text: "just text"},
+		}},
+		{name: "escaped brackets", text: `see \[1\] and C:\path`, want: []Segment{
+			{Type: SegmentText, Text: `see [1] and C:\path`},
+		}},
+		{name: "link", text: "go [there](https://example.com) now", want: []Segment{
+			{Type: SegmentText, Text: "go "},
+			{Type: SegmentLink, Text: "there", URL: "https://example.com"},
+			{Type: SegmentText, Text: " now"},
+		}},
+		{name: "image", text: "![a cat](attachment:cat.png)\nmeow", want: []Segment{
+			{Type: SegmentImage, Text: "a cat", URL: "attachment:cat.png"},
+			{Type: SegmentText, Text: "\nmeow"},
+		}},
+		{name: "not a reference", text: "[x] (y) [z](a b)", want: []Segment{
+			{Type: SegmentText, Text: "[x] (y) [z](a b)"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, Segments(tt.text))
+		})
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	var b Builder
+	b.WriteText(`text with [brackets] and \ `)
+	b.WriteLink("link [1]", "https://example.com/a b")
+	b.WriteImage("", "https://example.com/(1).png")
+
+	require.Equal(t, []Segment{
+		{Type: SegmentText, Text: `text with [brackets] and \ `},
+		{Type: SegmentLink, Text: "link [1]", URL: "https://example.com/a%20b"},
+		{Type: SegmentImage, URL: "https://example.com/%281%29.png"},
+	}, Segments(b.String()))
+}
+
+func TestReferenceLen(t *testing.T) {
+	require.Equal(t, 0, ReferenceLen([]byte("text")))
+	require.Equal(t, 0, ReferenceLen([]byte("[text]")))
+	require.Equal(t, len("[a b](c)"), ReferenceLen([]byte("[a b](c) tail")))
+	require.Equal(t, len("![a](c)"), ReferenceLen([]byte("![a](c)")))
+}
+
+func TestPlainText(t *testing.T) {
+	text := `Read [this](https://a.com), [https://b.com](https://b.com) \[1\]` + "\n" +
+		"![](https://c.com/img.png)\n![embedded](attachment:img.png)"
+	require.Equal(t,
+		"Read this (https://a.com), https://b.com [1]\nhttps://c.com/img.png\nembedded",
+		PlainText(text),
+	)
+}
+
+func TestText(t *testing.T) {
+	text := "- Read [this](https://a.com) **now**\n![alt](https://c.com/img.png)\\- not a list"
+	require.Equal(t, "Read this now\n- not a list", Text(text))
+}
+
+func TestFromHTML(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<html><head><title>skip</title></head><body>
+		<h1>Title</h1>
+		<p>Some   <i>text</i> with a <a href="https://example.com">link
+		</a> and [brackets].</p>
+		<figure><img src="/file/cat.png" alt="cat"><figcaption>A cat</figcaption></figure>
+		<p>Relative <a href="#note">note</a><br>next line</p>
+		<pre>keep
+  spaces</pre>
+	</body></html>`))
+	require.NoError(t, err)
+
+	got := FromHTML(HTMLOptions{
+		ResolveImage: func(src string) (string, bool) {
+			return "https://telegra.ph" + src, true
+		},
+	}, doc)
+	require.Equal(t, "# Title\n"+
+		`Some __text__ with a [link](https://example.com) and \[brackets\].`+"\n"+
+		"![cat](https://telegra.ph/file/cat.png)\n"+
+		"A cat\n"+
+		"Relative note\n"+
+		"next line\n"+
+		"```\nkeep\n  spaces\n```",
+		got,
+	)
+}
+
+func TestFromHTMLFormatting(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<body>
+		<p><b>Bold <i>and italic</i> </b>text with <code>a*b</code><b></b></p>
+		<p><strong>multi<br>line</strong></p>
+		<ul><li>first</li><li><p>second</p></li></ul>
+		<p>- not a list, # not a heading</p>
+		<p><img src="data:image/png;base64,AAAA" alt="embedded  cat"> meow</p>
+	</body>`))
+	require.NoError(t, err)
+
+	require.Equal(t, "**Bold __and italic__** text with `a\\*b`\n"+
+		"**multi**\n**line**\n"+
+		"- first\n- second\n"+
+		`\- not a list, # not a heading`+"\n"+
+		"embedded cat meow",
+		FromHTML(HTMLOptions{}, doc),
+	)
+}
+
+func TestSegmentsFormatting(t *testing.T) {
+	text := "## Chapter **1**\n" +
+		"- item with **bold __and italic__** and `code`\n" +
+		"```\nfunc() {}\n\\- line\n```\n" +
+		`\*\*not bold\*\* [**link**](https://example.com)`
+	require.Equal(t, []Segment{
+		{Type: SegmentText, Text: "Chapter ", Style: StyleHeading},
+		{Type: SegmentText, Text: "1", Style: StyleHeading | StyleBold},
+		{Type: SegmentText, Text: "\n• item with "},
+		{Type: SegmentText, Text: "bold ", Style: StyleBold},
+		{Type: SegmentText, Text: "and italic", Style: StyleBold | StyleItalic},
+		{Type: SegmentText, Text: " and "},
+		{Type: SegmentText, Text: "code", Style: StyleCode},
+		{Type: SegmentText, Text: "\n"},
+		{Type: SegmentText, Text: "func() {}\n- line\n", Style: StyleCodeBlock},
+		{Type: SegmentText, Text: "**not bold** "},
+		{Type: SegmentLink, Text: "**link**", URL: "https://example.com"},
+	}, Segments(text))
+}
+
+func TestTelegramHTML(t *testing.T) {
+	text := "# Title <1>\n" +
+		"**bold __both__** `a<b` [link & co](https://example.com?a=1&b=2)\n" +
+		"```\nif a < b {\n}\n```\n" +
+		"![cat](attachment:cat.png) ![](https://example.com/cat.png)"
+	require.Equal(t, "<b>Title &lt;1&gt;</b>\n"+
+		`<b>bold </b><b><i>both</i></b> <code>a&lt;b</code> <a href="https://example.com?a=1&amp;b=2">link &amp; co</a>`+"\n"+
+		"<pre>if a &lt; b {\n}</pre>\n"+
+		`cat <a href="https://example.com/cat.png">https://example.com/cat.png</a>`,
+		TelegramHTML(Segments(text)),
+	)
+}
+
+func TestXHTML(t *testing.T) {
+	text := "## Title <1>\n" +
+		"**bold __both__** `a<b` [link & co](https://example.com?a=1&b=2)\n" +
+		"- one\n- two\n" +
+		"```\nif a < b {\n}\n```\n" +
+		"![cat](attachment:cat.png) ![dog](https://example.com/dog.png)"
+	resolveImage := func(src string) (string, bool) {
+		name, ok := AttachmentName(src)
+		return "images/" + name, ok
+	}
+	require.Equal(t, "<h2>Title &lt;1&gt;</h2>\n"+
+		`<p><strong>bold </strong><strong><em>both</em></strong> <code>a&lt;b</code> <a href="https://example.com?a=1&amp;b=2">link &amp; co</a></p>`+"\n"+
+		"<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n"+
+		"<pre>if a &lt; b {&#xA;}</pre>\n"+
+		`<p><img src="images/cat.png" alt="cat"/> dog</p>`+"\n",
+		XHTML(text, resolveImage),
+	)
+}
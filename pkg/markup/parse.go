@@ -0,0 +1,311 @@
+package markup
+
+import "strings"
+
+type SegmentType string
+
+const (
+	SegmentText  SegmentType = "text"
+	SegmentImage SegmentType = "image"
+	SegmentLink  SegmentType = "link"
+)
+
+// Style is a set of formatting applied to segment
+type Style uint8
+
+const (
+	StyleBold Style = 1 << iota
+	StyleItalic
+	StyleCode
+	StyleHeading
+	StyleCodeBlock
+)
+
+func (s Style) Has(style Style) bool {
+	return s&style != 0
+}
+
+var styleNames = []struct {
+	style Style
+	name  string
+}{
+	{StyleBold, "bold"},
+	{StyleItalic, "italic"},
+	{StyleCode, "code"},
+	{StyleHeading, "heading"},
+	{StyleCodeBlock, "code_block"},
+}
+
+// Names returns names of styles in the set
+func (s Style) Names() []string {
+	var names []string
+	for _, sn := range styleNames {
+		if s.Has(sn.style) {
+			names = append(names, sn.name)
+		}
+	}
+	return names
+}
+
+var markerStyles = map[Marker]Style{
+	MarkerBold:   StyleBold,
+	MarkerItalic: StyleItalic,
+	MarkerCode:   StyleCode,
+}
+
+// listBullet replaces list item marker when text is shown
+const listBullet = "• "
+
+type Segment struct {
+	Type  SegmentType
+	Text  string // plain text, link text or image alternative text
+	URL   string // link url or image source
+	Style Style
+}
+
+// PlainText returns segment as it should be shown when markup is not supported
+func (s Segment) PlainText() string {
+	switch s.Type {
+	case SegmentLink:
+		if s.Text == "" || s.Text == s.URL {
+			return s.URL
+		}
+		return s.Text + " (" + s.URL + ")"
+	case SegmentImage:
+		if _, ok := AttachmentName(s.URL); ok {
+			return s.Text
+		}
+		return s.URL
+	default:
+		return s.Text
+	}
+}
+
+// Segments splits text in markup into plain text, image and link segments
+// with formatting applied to them
+func Segments(text string) []Segment {
+	return segments(text, true)
+}
+
+// segments splits text into segments, list items start with listBullet if bullets is true
+func segments(text string, bullets bool) []Segment {
+	var p segmentsParser
+	inCodeBlock := false
+	for len(text) > 0 {
+		line, rest, newLine := strings.Cut(text, "\n")
+		text = rest
+		if isCodeFence(line) {
+			inCodeBlock = !inCodeBlock
+			continue
+		}
+
+		if inCodeBlock {
+			if newLine {
+				line += "\n"
+			}
+			p.writeText(unescape(line), StyleCodeBlock)
+			continue
+		}
+
+		lineStyle := Style(0)
+		if n := headingPrefixLen(line); n > 0 {
+			lineStyle = StyleHeading
+			line = line[n:]
+		} else if strings.HasPrefix(line, listItem) {
+			if bullets {
+				p.writeText(listBullet, 0)
+			}
+			line = line[len(listItem):]
+		}
+		p.parseLine(line, lineStyle)
+		if newLine {
+			p.writeText("\n", 0)
+		}
+	}
+	return p.segments
+}
+
+// PlainText strips markup from text
+func PlainText(text string) string {
+	var b strings.Builder
+	b.Grow(len(text))
+	for _, s := range Segments(text) {
+		b.WriteString(s.PlainText())
+	}
+	return b.String()
+}
+
+// Text returns text characters of text in markup: list bullets, link urls and images are skipped.
+// Positions in texts are counted in these characters, so they don't depend on how text is shown.
+func Text(text string) string {
+	var b strings.Builder
+	b.Grow(len(text))
+	for _, s := range segments(text, false) {
+		if s.Type != SegmentImage {
+			b.WriteString(s.Text)
+		}
+	}
+	return b.String()
+}
+
+type segmentsParser struct {
+	segments []Segment
+}
+
+// parseLine parses inline markup of a single line, styles are reset at the end of the line
+func (p *segmentsParser) parseLine(line string, style Style) {
+	var plain strings.Builder
+	flush := func() {
+		p.writeText(plain.String(), style)
+		plain.Reset()
+	}
+	for i := 0; i < len(line); {
+		if ref, size := parseReference(line[i:]); size > 0 {
+			flush()
+			ref.Style = style
+			p.segments = append(p.segments, ref)
+			i += size
+			continue
+		}
+		if size := MarkerLen([]byte(line[i:])); size > 0 {
+			flush()
+			style ^= markerStyles[Marker(line[i:i+size])]
+			i += size
+			continue
+		}
+		if isEscapeAt(line, i) {
+			i++
+		}
+		plain.WriteByte(line[i])
+		i++
+	}
+	flush()
+}
+
+func (p *segmentsParser) writeText(text string, style Style) {
+	if text == "" {
+		return
+	}
+	if n := len(p.segments); n > 0 {
+		last := &p.segments[n-1]
+		if last.Type == SegmentText && last.Style == style {
+			last.Text += text
+			return
+		}
+	}
+	p.segments = append(p.segments, Segment{Type: SegmentText, Text: text, Style: style})
+}
+
+// ReferenceLen returns length of image or link reference at the beginning of data
+// or 0 if data doesn't start with reference
+func ReferenceLen(data []byte) int {
+	if len(data) == 0 || (data[0] != '[' && data[0] != '!') {
+		return 0
+	}
+	_, size := parseReference(string(data))
+	return size
+}
+
+// EscapeLen returns length of escape sequence at the beginning of data or 0
+func EscapeLen(data []byte) int {
+	if len(data) > 1 && data[0] == escapeChar && isSpecial(data[1]) {
+		return 2
+	}
+	return 0
+}
+
+// MarkerLen returns length of inline formatting marker at the beginning of data or 0
+func MarkerLen(data []byte) int {
+	for _, m := range []Marker{MarkerBold, MarkerItalic, MarkerCode} {
+		if len(data) >= len(m) && string(data[:len(m)]) == string(m) {
+			return len(m)
+		}
+	}
+	return 0
+}
+
+// LinePrefixLen returns length of heading or list item marker or code fence
+// at the beginning of the line or 0. Code fence takes the whole line.
+func LinePrefixLen(line []byte) int {
+	s := string(line)
+	if end := strings.IndexByte(s, '\n'); end >= 0 {
+		s = s[:end]
+	}
+	switch {
+	case isCodeFence(s):
+		return len(s)
+	case strings.HasPrefix(s, listItem):
+		return len(listItem)
+	default:
+		return headingPrefixLen(s)
+	}
+}
+
+func isCodeFence(line string) bool {
+	return strings.HasPrefix(line, codeFence)
+}
+
+func headingPrefixLen(line string) int {
+	level := 0
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level > headingLevel || level >= len(line) || line[level] != ' ' {
+		return 0
+	}
+	return level + 1
+}
+
+func isEscapeAt(text string, i int) bool {
+	return text[i] == escapeChar && i+1 < len(text) && isSpecial(text[i+1])
+}
+
+func unescape(text string) string {
+	if !strings.ContainsRune(text, escapeChar) {
+		return text
+	}
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		if isEscapeAt(text, i) {
+			i++
+		}
+		b.WriteByte(text[i])
+	}
+	return b.String()
+}
+
+func parseReference(s string) (Segment, int) {
+	ref := Segment{Type: SegmentLink}
+	i := 0
+	if strings.HasPrefix(s, "![") {
+		ref.Type = SegmentImage
+		i++
+	}
+	if i >= len(s) || s[i] != '[' {
+		return Segment{}, 0
+	}
+	i++
+
+	var label strings.Builder
+	for ; i < len(s) && s[i] != ']'; i++ {
+		if s[i] == '[' {
+			return Segment{}, 0
+		}
+		if isEscapeAt(s, i) {
+			i++
+		}
+		label.WriteByte(s[i])
+	}
+	if i+1 >= len(s) || s[i+1] != '(' {
+		return Segment{}, 0
+	}
+	i += 2
+
+	end := strings.IndexAny(s[i:], ") \n")
+	if end <= 0 || s[i+end] != ')' {
+		return Segment{}, 0
+	}
+	ref.Text = label.String()
+	ref.URL = s[i : i+end]
+	return ref, i + end + 1
+}
@@ -0,0 +1,65 @@
+package markup
+
+import (
+	"html"
+	"strings"
+)
+
+// TelegramHTML renders segments with Telegram flavour of html.
+// Images are rendered as links, embedded images as their alternative text.
+func TelegramHTML(segments []Segment) string {
+	var b strings.Builder
+	for i := 0; i < len(segments); i++ {
+		if segments[i].Style.Has(StyleCodeBlock) {
+			var code strings.Builder
+			for ; i < len(segments) && segments[i].Style.Has(StyleCodeBlock); i++ {
+				code.WriteString(segments[i].PlainText())
+			}
+			i--
+			text := code.String()
+			trimmed := strings.TrimRight(text, "\n")
+			b.WriteString("<pre>" + html.EscapeString(trimmed) + "</pre>")
+			b.WriteString(text[len(trimmed):])
+			continue
+		}
+
+		s := segments[i]
+		open, close := telegramTags(s.Style)
+		b.WriteString(open)
+		switch s.Type {
+		case SegmentLink:
+			writeTelegramLink(&b, s.Text, s.URL)
+		case SegmentImage:
+			if _, ok := AttachmentName(s.URL); ok {
+				b.WriteString(html.EscapeString(s.Text))
+			} else {
+				writeTelegramLink(&b, s.Text, s.URL)
+			}
+		default:
+			b.WriteString(html.EscapeString(s.Text))
+		}
+		b.WriteString(close)
+	}
+	return b.String()
+}
+
+func telegramTags(style Style) (open, close string) {
+	// formatting can't be nested into code
+	if style.Has(StyleCode) {
+		return "<code>", "</code>"
+	}
+	if style.Has(StyleBold) || style.Has(StyleHeading) {
+		open, close = "<b>", "</b>"
+	}
+	if style.Has(StyleItalic) {
+		open, close = open+"<i>", "</i>"+close
+	}
+	return open, close
+}
+
+func writeTelegramLink(b *strings.Builder, text, url string) {
+	if text == "" {
+		text = url
+	}
+	b.WriteString(`<a href="` + html.EscapeString(url) + `">` + html.EscapeString(text) + "</a>")
+}
@@ -0,0 +1,86 @@
+package markup
+
+import (
+	"slices"
+	"strings"
+)
+
+// Tracker follows formatting that is open at the current position of the text,
+// so text can be cut at that position and both parts remain valid markup
+type Tracker struct {
+	styles    []Marker // open inline markers, outermost first
+	heading   string   // heading prefix of the current line
+	codeBlock string   // opening fence of the current code block
+}
+
+// Write updates state with inline marker, line prefix or new line.
+// Other text doesn't change formatting and can be skipped.
+func (t *Tracker) Write(token string) {
+	switch {
+	case token == "\n":
+		if t.codeBlock == "" {
+			t.styles = t.styles[:0]
+			t.heading = ""
+		}
+	case isCodeFence(token):
+		if t.codeBlock == "" {
+			t.codeBlock = token
+		} else {
+			t.codeBlock = ""
+		}
+	case headingPrefixLen(token) == len(token):
+		t.heading = token
+	default:
+		m := Marker(token)
+		if _, ok := markerStyles[m]; !ok {
+			return
+		}
+		if i := slices.Index(t.styles, m); i >= 0 {
+			t.styles = slices.Delete(t.styles, i, i+1)
+		} else {
+			t.styles = append(t.styles, m)
+		}
+	}
+}
+
+// Clone returns independent copy of the tracker
+func (t *Tracker) Clone() Tracker {
+	c := *t
+	c.styles = slices.Clone(t.styles)
+	return c
+}
+
+// InCodeBlock reports if current position is inside code block
+func (t *Tracker) InCodeBlock() bool {
+	return t.codeBlock != ""
+}
+
+// IsClosing reports if token closes open inline formatting
+func (t *Tracker) IsClosing(token string) bool {
+	return slices.Contains(t.styles, Marker(token))
+}
+
+// Close returns markup that closes all open formatting
+func (t *Tracker) Close() string {
+	var b strings.Builder
+	for i := len(t.styles) - 1; i >= 0; i-- {
+		b.WriteString(string(t.styles[i]))
+	}
+	if t.codeBlock != "" {
+		b.WriteString("\n" + codeFence)
+	}
+	return b.String()
+}
+
+// Reopen returns markup that opens again formatting closed by Close
+func (t *Tracker) Reopen() string {
+	var b strings.Builder
+	if t.codeBlock != "" {
+		b.WriteString(t.codeBlock + "\n")
+	}
+	b.WriteString(t.heading)
+	for _, m := range t.styles {
+		b.WriteString(string(m))
+	}
+	return b.String()
+}
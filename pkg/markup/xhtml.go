@@ -0,0 +1,115 @@
+package markup
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+)
+
+// XHTML renders text in markup as content of xhtml body. Every line becomes a paragraph,
+// heading or list item, consecutive list items are grouped into a list.
+// ResolveImage maps image source to src attribute, images it returns false for
+// are rendered as their alternative text.
+func XHTML(text string, resolveImage func(src string) (string, bool)) string {
+	var b, code strings.Builder
+	inList, inCodeBlock := false, false
+	closeList := func() {
+		if inList {
+			b.WriteString("</ul>\n")
+			inList = false
+		}
+	}
+	closeCodeBlock := func() {
+		b.WriteString("<pre>" + escapeXML(strings.TrimSuffix(code.String(), "\n")) + "</pre>\n")
+		code.Reset()
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if isCodeFence(line) {
+			if inCodeBlock {
+				closeCodeBlock()
+			} else {
+				closeList()
+			}
+			inCodeBlock = !inCodeBlock
+			continue
+		}
+		if inCodeBlock {
+			code.WriteString(unescape(line) + "\n")
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			closeList()
+			continue
+		}
+
+		if n := headingPrefixLen(line); n > 0 {
+			closeList()
+			tag := "h" + strconv.Itoa(n-1)
+			b.WriteString("<" + tag + ">" + inlineXHTML(line[n:], resolveImage) + "</" + tag + ">\n")
+			continue
+		}
+		if item, ok := strings.CutPrefix(line, listItem); ok {
+			if !inList {
+				b.WriteString("<ul>\n")
+				inList = true
+			}
+			b.WriteString("<li>" + inlineXHTML(item, resolveImage) + "</li>\n")
+			continue
+		}
+		closeList()
+		b.WriteString("<p>" + inlineXHTML(line, resolveImage) + "</p>\n")
+	}
+	if inCodeBlock {
+		closeCodeBlock()
+	}
+	closeList()
+	return b.String()
+}
+
+func inlineXHTML(line string, resolveImage func(src string) (string, bool)) string {
+	var p segmentsParser
+	p.parseLine(line, 0)
+	var b strings.Builder
+	for _, s := range p.segments {
+		open, close := xhtmlTags(s.Style)
+		b.WriteString(open)
+		switch s.Type {
+		case SegmentLink:
+			text := s.Text
+			if text == "" {
+				text = s.URL
+			}
+			b.WriteString(`<a href="` + escapeXML(s.URL) + `">` + escapeXML(text) + "</a>")
+		case SegmentImage:
+			if src, ok := resolveImage(s.URL); ok {
+				b.WriteString(`<img src="` + escapeXML(src) + `" alt="` + escapeXML(s.Text) + `"/>`)
+			} else {
+				b.WriteString(escapeXML(s.Text))
+			}
+		default:
+			b.WriteString(escapeXML(s.Text))
+		}
+		b.WriteString(close)
+	}
+	return b.String()
+}
+
+func xhtmlTags(style Style) (open, close string) {
+	if style.Has(StyleBold) {
+		open, close = "<strong>", "</strong>"
+	}
+	if style.Has(StyleItalic) {
+		open, close = open+"<em>", "</em>"+close
+	}
+	if style.Has(StyleCode) {
+		open, close = open+"<code>", "</code>"+close
+	}
+	return open, close
+}
+
+// escapeXML escapes text for xml, characters that aren't allowed in xml are replaced
+func escapeXML(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,241 @@
+// Package notesexport renders highlights and notes grouped by book
+// into formats other note taking tools can import.
+package notesexport
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type Format string
+
+const (
+	// Markdown is a note with YAML front matter per book, several books are packed into zip archive
+	Markdown Format = "markdown"
+	// CSV is Readwise import format
+	CSV  Format = "csv"
+	JSON Format = "json"
+)
+
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// ParseFormat returns format by its name or by the name of the tool it's meant for
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "md", "markdown", "obsidian":
+		return Markdown, nil
+	case "csv", "readwise":
+		return CSV, nil
+	case "json":
+		return JSON, nil
+	}
+	return "", errors.Wrap(ErrUnknownFormat, name)
+}
+
+type Book struct {
+	Title      string
+	Language   string
+	Highlights []Highlight
+}
+
+type Highlight struct {
+	Text      string
+	Note      string
+	Location  int64 // visible characters before the highlight
+	CreatedAt time.Time
+}
+
+// File is an exported file
+type File struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// Export renders books in format, books without highlights are skipped
+func Export(format Format, books []Book) (File, error) {
+	books = withHighlights(books)
+	switch format {
+	case Markdown:
+		return exportMarkdown(books)
+	case CSV:
+		return exportCSV(books)
+	case JSON:
+		return exportJSON(books)
+	}
+	return File{}, errors.Wrap(ErrUnknownFormat, string(format))
+}
+
+func withHighlights(books []Book) []Book {
+	result := make([]Book, 0, len(books))
+	for _, book := range books {
+		if len(book.Highlights) > 0 {
+			result = append(result, book)
+		}
+	}
+	return result
+}
+
+func exportMarkdown(books []Book) (File, error) {
+	if len(books) == 1 {
+		return File{
+			Name:        fileName(books[0].Title) + ".md",
+			ContentType: "text/markdown; charset=utf-8",
+			Data:        bookMarkdown(books[0]),
+		}, nil
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	used := make(map[string]int, len(books))
+	for _, book := range books {
+		name := fileName(book.Title)
+		// books with the same title would overwrite each other in the notes folder
+		if used[name]++; used[name] > 1 {
+			name = fmt.Sprintf("%s (%d)", name, used[name])
+		}
+		w, err := zw.Create(name + ".md")
+		if err != nil {
+			return File{}, errors.Wrap(err, "failed to add book to archive")
+		}
+		if _, err := w.Write(bookMarkdown(book)); err != nil {
+			return File{}, errors.Wrap(err, "failed to write book to archive")
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return File{}, errors.Wrap(err, "failed to close archive")
+	}
+	return File{
+		Name:        "highlights.zip",
+		ContentType: "application/zip",
+		Data:        buf.Bytes(),
+	}, nil
+}
+
+func bookMarkdown(book Book) []byte {
+	var b strings.Builder
+	b.WriteString("---\n")
+	// double quoted yaml strings use the same escapes as go
+	fmt.Fprintf(&b, "title: %s\n", strconv.Quote(book.Title))
+	if book.Language != "" {
+		fmt.Fprintf(&b, "language: %s\n", book.Language)
+	}
+	fmt.Fprintf(&b, "highlights: %d\n", len(book.Highlights))
+	b.WriteString("tags:\n  - highlights\n")
+	b.WriteString("---\n\n")
+	fmt.Fprintf(&b, "# %s\n", singleLine(book.Title))
+	for _, h := range book.Highlights {
+		b.WriteString("\n")
+		for _, line := range strings.Split(strings.TrimSpace(h.Text), "\n") {
+			b.WriteString(strings.TrimRight("> "+line, " ") + "\n")
+		}
+		if h.Note != "" {
+			b.WriteString("\n" + strings.TrimSpace(h.Note) + "\n")
+		}
+		fmt.Fprintf(&b, "\n*Location: %d · %s*\n", h.Location, h.CreatedAt.Format(time.DateOnly))
+	}
+	return []byte(b.String())
+}
+
+// readwiseDateFormat is the date format Readwise CSV import expects
+const readwiseDateFormat = time.DateTime
+
+func exportCSV(books []Book) (File, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	rows := [][]string{{"Highlight", "Title", "Author", "URL", "Note", "Location", "Date"}}
+	for _, book := range books {
+		for _, h := range book.Highlights {
+			rows = append(rows, []string{
+				h.Text,
+				book.Title,
+				"",
+				"",
+				h.Note,
+				strconv.FormatInt(h.Location, 10),
+				h.CreatedAt.UTC().Format(readwiseDateFormat),
+			})
+		}
+	}
+	if err := w.WriteAll(rows); err != nil {
+		return File{}, errors.Wrap(err, "failed to write csv")
+	}
+	return File{
+		Name:        "highlights.csv",
+		ContentType: "text/csv; charset=utf-8",
+		Data:        buf.Bytes(),
+	}, nil
+}
+
+type jsonExport struct {
+	Books []jsonBook `json:"books"`
+}
+
+type jsonBook struct {
+	Title      string          `json:"title"`
+	Language   string          `json:"language,omitempty"`
+	Highlights []jsonHighlight `json:"highlights"`
+}
+
+type jsonHighlight struct {
+	Text      string `json:"text"`
+	Note      string `json:"note,omitempty"`
+	Location  int64  `json:"location"`
+	CreatedAt string `json:"createdAt"`
+}
+
+func exportJSON(books []Book) (File, error) {
+	out := jsonExport{Books: make([]jsonBook, 0, len(books))}
+	for _, book := range books {
+		jb := jsonBook{
+			Title:      book.Title,
+			Language:   book.Language,
+			Highlights: make([]jsonHighlight, 0, len(book.Highlights)),
+		}
+		for _, h := range book.Highlights {
+			jb.Highlights = append(jb.Highlights, jsonHighlight{
+				Text:      h.Text,
+				Note:      h.Note,
+				Location:  h.Location,
+				CreatedAt: h.CreatedAt.Format(time.RFC3339),
+			})
+		}
+		out.Books = append(out.Books, jb)
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return File{}, errors.Wrap(err, "failed to encode json")
+	}
+	return File{
+		Name:        "highlights.json",
+		ContentType: "application/json",
+		Data:        data,
+	}, nil
+}
+
+// fileName replaces characters that aren't allowed in file names on common systems
+func fileName(title string) string {
+	name := strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|', '\n', '\r', '\t':
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(title))
+	if name == "" {
+		return "highlights"
+	}
+	return name
+}
+
+func singleLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
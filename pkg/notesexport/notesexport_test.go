@@ -0,0 +1,119 @@
+package notesexport
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testCreatedAt = time.Date(2024, 3, 5, 10, 20, 30, 0, time.UTC)
+
+func testBook(title string) Book {
+	return Book{
+		Title:    title,
+		Language: "en",
+		Highlights: []Highlight{
+			{Text: "First quote", Location: 10, CreatedAt: testCreatedAt},
+			{Text: "Second\nquote", Note: "my note", Location: 42, CreatedAt: testCreatedAt},
+		},
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Format
+		wantErr bool
+	}{
+		{name: "", want: Markdown},
+		{name: "obsidian", want: Markdown},
+		{name: " MD ", want: Markdown},
+		{name: "readwise", want: CSV},
+		{name: "json", want: JSON},
+		{name: "pdf", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFormat(tt.name)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrUnknownFormat)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestExport_Markdown(t *testing.T) {
+	file, err := Export(Markdown, []Book{testBook(`Book: "one"`), {Title: "empty"}})
+	require.NoError(t, err)
+	require.Equal(t, "Book_ _one_.md", file.Name)
+	require.Equal(t, `---
+title: "Book: \"one\""
+language: en
+highlights: 2
+tags:
+  - highlights
+---
+
+# Book: "one"
+
+> First quote
+
+*Location: 10 · 2024-03-05*
+
+> Second
+> quote
+
+my note
+
+*Location: 42 · 2024-03-05*
+`, string(file.Data))
+}
+
+func TestExport_MarkdownArchive(t *testing.T) {
+	file, err := Export(Markdown, []Book{testBook("book"), testBook("book"), testBook("other")})
+	require.NoError(t, err)
+	require.Equal(t, "highlights.zip", file.Name)
+
+	zr, err := zip.NewReader(bytes.NewReader(file.Data), int64(len(file.Data)))
+	require.NoError(t, err)
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	require.Equal(t, []string{"book.md", "book (2).md", "other.md"}, names)
+}
+
+func TestExport_CSV(t *testing.T) {
+	file, err := Export(CSV, []Book{testBook("book")})
+	require.NoError(t, err)
+	rows, err := csv.NewReader(bytes.NewReader(file.Data)).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"Highlight", "Title", "Author", "URL", "Note", "Location", "Date"},
+		{"First quote", "book", "", "", "", "10", "2024-03-05 10:20:30"},
+		{"Second\nquote", "book", "", "", "my note", "42", "2024-03-05 10:20:30"},
+	}, rows)
+}
+
+func TestExport_JSON(t *testing.T) {
+	file, err := Export(JSON, []Book{testBook("book")})
+	require.NoError(t, err)
+	var got jsonExport
+	require.NoError(t, json.Unmarshal(file.Data, &got))
+	require.Len(t, got.Books, 1)
+	require.Equal(t, "book", got.Books[0].Title)
+	require.Equal(t, jsonHighlight{
+		Text:      "Second\nquote",
+		Note:      "my note",
+		Location:  42,
+		CreatedAt: "2024-03-05T10:20:30Z",
+	}, got.Books[0].Highlights[1])
+}
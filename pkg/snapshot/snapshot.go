@@ -0,0 +1,268 @@
+// Package snapshot takes online backups of the database into a directory
+package snapshot
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	prefix     = "snapshot-"
+	timeFormat = "20060102-150405.000000"
+	ext        = ".db"
+	gzipExt    = ".gz"
+	// restoredSuffix is added to the database path to save name of the restored snapshot
+	restoredSuffix = ".restored"
+	// replacedSuffix is added to the database path to keep the database replaced by restore
+	replacedSuffix = ".before-restore"
+)
+
+// Source writes consistent copy of the database without blocking writes
+type Source interface {
+	Snapshot(w io.Writer) error
+}
+
+type Config struct {
+	Dir      string
+	Keep     int  // number of kept snapshots, 0 keeps all of them
+	Compress bool // snapshots are compressed with gzip
+}
+
+type Snapshot struct {
+	Name      string
+	Size      int64
+	CreatedAt time.Time
+}
+
+// Manager takes snapshots and removes old ones
+type Manager struct {
+	mu  sync.Mutex
+	src Source
+	cfg Config
+	now func() time.Time
+}
+
+func New(src Source, cfg Config) *Manager {
+	return &Manager{
+		src: src,
+		cfg: cfg,
+		now: time.Now,
+	}
+}
+
+// Take saves a new snapshot and removes snapshots exceeding Config.Keep
+func (m *Manager) Take() (Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := os.MkdirAll(m.cfg.Dir, 0700); err != nil {
+		return Snapshot{}, errors.Wrap(err, "failed to create snapshots directory")
+	}
+	createdAt := m.now().UTC()
+	name := prefix + createdAt.Format(timeFormat) + ext
+	if m.cfg.Compress {
+		name += gzipExt
+	}
+	// snapshot is written to temp file, so partially written snapshots are never listed
+	f, err := os.CreateTemp(m.cfg.Dir, name+".*.tmp")
+	if err != nil {
+		return Snapshot{}, errors.Wrap(err, "failed to create snapshot file")
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err = m.write(f); err != nil {
+		return Snapshot{}, errors.Wrap(err, "failed to write snapshot")
+	}
+	if err = f.Sync(); err != nil {
+		return Snapshot{}, errors.Wrap(err, "failed to sync snapshot")
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if err = f.Close(); err != nil {
+		return Snapshot{}, errors.Wrap(err, "failed to close snapshot")
+	}
+	if err = os.Rename(f.Name(), filepath.Join(m.cfg.Dir, name)); err != nil {
+		return Snapshot{}, errors.Wrap(err, "failed to save snapshot")
+	}
+	if err = m.rotate(); err != nil {
+		return Snapshot{}, err
+	}
+	return Snapshot{Name: name, Size: info.Size(), CreatedAt: createdAt}, nil
+}
+
+func (m *Manager) write(w io.Writer) error {
+	if !m.cfg.Compress {
+		return m.src.Snapshot(w)
+	}
+	gw := gzip.NewWriter(w)
+	if err := m.src.Snapshot(gw); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func (m *Manager) rotate() error {
+	if m.cfg.Keep <= 0 {
+		return nil
+	}
+	snapshots, err := List(m.cfg.Dir)
+	if err != nil {
+		return err
+	}
+	for len(snapshots) > m.cfg.Keep {
+		oldest := snapshots[len(snapshots)-1]
+		if err = os.Remove(filepath.Join(m.cfg.Dir, oldest.Name)); err != nil {
+			return errors.Wrap(err, "failed to remove old snapshot")
+		}
+		snapshots = snapshots[:len(snapshots)-1]
+	}
+	return nil
+}
+
+// List returns snapshots, newest first
+func (m *Manager) List() ([]Snapshot, error) {
+	return List(m.cfg.Dir)
+}
+
+// RunEvery takes snapshots with the interval until stop is called
+func (m *Manager) RunEvery(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				snapshot, err := m.Take()
+				if err != nil {
+					log.Println("failed to take snapshot:", err)
+					continue
+				}
+				log.Printf("snapshot %s taken, %d bytes", snapshot.Name, snapshot.Size)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// List returns snapshots saved in the directory, newest first
+func List(dir string) ([]Snapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read snapshots directory")
+	}
+	var snapshots []Snapshot
+	for _, entry := range entries {
+		createdAt, ok := parseName(entry.Name())
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, Snapshot{Name: entry.Name(), Size: info.Size(), CreatedAt: createdAt})
+	}
+	slices.SortFunc(snapshots, func(a, b Snapshot) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return snapshots, nil
+}
+
+func parseName(name string) (time.Time, bool) {
+	ts, ok := strings.CutPrefix(name, prefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	ts = strings.TrimSuffix(ts, gzipExt)
+	ts, ok = strings.CutSuffix(ts, ext)
+	if !ok {
+		return time.Time{}, false
+	}
+	createdAt, err := time.Parse(timeFormat, ts)
+	return createdAt, err == nil
+}
+
+// Restore replaces database at dbPath with the snapshot saved in dir. The database must not be open.
+// Replaced database is kept next to it with .before-restore suffix. Name of the restored snapshot is saved
+// next to the database too, so the same snapshot is not restored again on the next start.
+// Returns false if the snapshot was already restored.
+func Restore(dir, name, dbPath string) (bool, error) {
+	if _, ok := parseName(name); !ok || filepath.Base(name) != name {
+		return false, errors.Errorf("invalid snapshot name %q", name)
+	}
+	restored, err := os.ReadFile(dbPath + restoredSuffix)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if string(restored) == name {
+		return false, nil
+	}
+
+	src, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to open snapshot")
+	}
+	defer src.Close()
+	var r io.Reader = src
+	if strings.HasSuffix(name, gzipExt) {
+		gr, err := gzip.NewReader(src)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to decompress snapshot")
+		}
+		defer gr.Close()
+		r = gr
+	}
+
+	dst, err := os.CreateTemp(filepath.Dir(dbPath), filepath.Base(dbPath)+".*.tmp")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(dst.Name())
+	defer dst.Close()
+	if _, err = io.Copy(dst, r); err != nil {
+		return false, errors.Wrap(err, "failed to copy snapshot")
+	}
+	if err = dst.Sync(); err != nil {
+		return false, err
+	}
+	if err = dst.Close(); err != nil {
+		return false, err
+	}
+	if err = os.Chmod(dst.Name(), 0600); err != nil {
+		return false, err
+	}
+
+	if err = os.Rename(dbPath, dbPath+replacedSuffix); err != nil && !os.IsNotExist(err) {
+		return false, errors.Wrap(err, "failed to keep replaced database")
+	}
+	// journal files of sqlite database belong to the replaced database
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err = os.Rename(dbPath+suffix, dbPath+replacedSuffix+suffix); err != nil && !os.IsNotExist(err) {
+			return false, errors.Wrap(err, "failed to keep replaced database")
+		}
+	}
+	if err = os.Rename(dst.Name(), dbPath); err != nil {
+		return false, errors.Wrap(err, "failed to replace database")
+	}
+	return true, os.WriteFile(dbPath+restoredSuffix, []byte(name), 0600)
+}
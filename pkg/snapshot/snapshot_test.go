@@ -0,0 +1,80 @@
+package snapshot
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type source string
+
+func (s source) Snapshot(w io.Writer) error {
+	_, err := io.WriteString(w, string(s))
+	return err
+}
+
+func TestManager(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		dir := t.TempDir()
+		m := New(source("database"), Config{Dir: filepath.Join(dir, "snapshots"), Keep: 2, Compress: compress})
+		now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		m.now = func() time.Time { return now }
+
+		var taken []Snapshot
+		for i := 0; i < 3; i++ {
+			snapshot, err := m.Take()
+			require.NoError(t, err)
+			require.Equal(t, now, snapshot.CreatedAt)
+			taken = append(taken, snapshot)
+			now = now.Add(time.Hour)
+		}
+		if compress {
+			require.Equal(t, "snapshot-20240102-030405.000000.db.gz", taken[0].Name)
+		} else {
+			require.Equal(t, "snapshot-20240102-030405.000000.db", taken[0].Name)
+			require.EqualValues(t, len("database"), taken[0].Size)
+		}
+
+		// oldest snapshot is removed
+		snapshots, err := m.List()
+		require.NoError(t, err)
+		require.Equal(t, []Snapshot{taken[2], taken[1]}, snapshots)
+		entries, err := os.ReadDir(m.cfg.Dir)
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+
+		dbPath := filepath.Join(dir, "db.db")
+		require.NoError(t, os.WriteFile(dbPath, []byte("current"), 0600))
+		restored, err := Restore(m.cfg.Dir, taken[1].Name, dbPath)
+		require.NoError(t, err)
+		require.True(t, restored)
+		requireContent(t, "database", dbPath)
+		requireContent(t, "current", dbPath+replacedSuffix)
+
+		// the same snapshot is restored once
+		require.NoError(t, os.WriteFile(dbPath, []byte("changed"), 0600))
+		restored, err = Restore(m.cfg.Dir, taken[1].Name, dbPath)
+		require.NoError(t, err)
+		require.False(t, restored)
+		requireContent(t, "changed", dbPath)
+	}
+}
+
+func TestRestore_Errors(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"db.db", "../snapshot-20240102-030405.000000.db", "snapshot-20240102-030405.000000.db"} {
+		_, err := Restore(dir, name, filepath.Join(dir, "db.db"))
+		require.Error(t, err, name)
+	}
+}
+
+func requireContent(t *testing.T, expected, path string) {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, expected, string(content))
+}
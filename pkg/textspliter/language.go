@@ -0,0 +1,198 @@
+package textspliter
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SentenceRules decide if the end of sentence mark really ends the sentence in the language
+type SentenceRules interface {
+	// Language returns ISO 639-1 code of the language
+	Language() string
+	// EndsSentence reports if mark ("." or "..." or "!?") after word ends the sentence.
+	// Next is the word after the mark, it's empty if mark is followed by something else than a word.
+	EndsSentence(word, mark, next string) bool
+}
+
+// Abbreviations are sentence rules based on lists of abbreviations
+type Abbreviations struct {
+	lang string
+	// titles are always followed by a name, e.g. "Mr."
+	titles map[string]struct{}
+	// abbreviations end the sentence only when the next word is capitalized, e.g. "etc."
+	abbreviations map[string]struct{}
+}
+
+// NewAbbreviations creates sentence rules from lower case abbreviations without the last period
+func NewAbbreviations(lang string, titles, abbreviations []string) *Abbreviations {
+	a := &Abbreviations{
+		lang:          lang,
+		titles:        make(map[string]struct{}, len(titles)),
+		abbreviations: make(map[string]struct{}, len(abbreviations)),
+	}
+	for _, t := range titles {
+		a.titles[t] = struct{}{}
+	}
+	for _, abbr := range abbreviations {
+		a.abbreviations[abbr] = struct{}{}
+	}
+	return a
+}
+
+func (a *Abbreviations) Language() string {
+	return a.lang
+}
+
+func (a *Abbreviations) EndsSentence(word, mark, next string) bool {
+	if strings.ContainsAny(mark, "!?") || next == "" {
+		return true
+	}
+	nextRune, _ := utf8.DecodeRuneInString(next)
+	if unicode.IsLower(nextRune) { // sentence doesn't start with lower case letter
+		return false
+	}
+	if mark != "." { // ellipsis
+		return true
+	}
+	word = strings.ToLower(word)
+	if _, ok := a.titles[word]; ok {
+		return false
+	}
+	if _, ok := a.abbreviations[word]; ok {
+		return unicode.IsUpper(nextRune)
+	}
+	if isInitial(word) && unicode.IsUpper(nextRune) { // e.g. "A. S. Pushkin"
+		return false
+	}
+	return true
+}
+
+func isInitial(word string) bool {
+	r, size := utf8.DecodeRuneInString(word)
+	return size == len(word) && unicode.IsLetter(r)
+}
+
+var (
+	English = NewAbbreviations("en",
+		[]string{
+			"mr", "mrs", "ms", "messrs", "dr", "prof", "rev", "hon", "st", "mt", "ft",
+			"gen", "col", "lt", "sgt", "capt", "cmdr", "gov", "sen", "rep", "jr", "sr",
+		},
+		[]string{
+			"p", "pp", "vol", "vols", "fig", "figs", "ch", "chap", "sec", "no", "nos", "art",
+			"e.g", "i.e", "etc", "vs", "cf", "approx", "ca", "al", "ed", "eds", "op", "cit", "ibid",
+			"dept", "est", "inc", "ltd", "co", "corp", "univ", "ave", "rd", "blvd",
+			"jan", "feb", "mar", "apr", "jun", "jul", "aug", "sep", "sept", "oct", "nov", "dec",
+			"a.m", "p.m", "u.s", "u.k",
+		},
+	)
+	Russian = NewAbbreviations("ru",
+		[]string{
+			"г-н", "г-жа", "гр", "тов", "им", "св", "ул", "пр-т", "пер", "пл", "наб", "акад", "проф", "доц",
+		},
+		[]string{
+			"т", "т.д", "т.п", "т.е", "т.к", "т.н", "др", "пр", "см", "ср", "стр", "с", "рис", "гл",
+			"табл", "прим", "ред", "изд", "ок", "тыс", "млн", "млрд", "руб", "коп", "г", "гг", "в", "вв",
+			"н.э", "обл", "р-н", "д", "кв", "корп", "напр", "англ", "лат", "франц", "нем",
+		},
+	)
+)
+
+var languages = struct {
+	sync.RWMutex
+	rules map[string]SentenceRules
+}{
+	rules: map[string]SentenceRules{
+		English.Language(): English,
+		Russian.Language(): Russian,
+	},
+}
+
+// RegisterLanguage makes sentence rules available by language code
+func RegisterLanguage(rules SentenceRules) {
+	languages.Lock()
+	defer languages.Unlock()
+	languages.rules[rules.Language()] = rules
+}
+
+// LookupLanguage returns sentence rules for language code like "en", "en-US" or "rus"
+func LookupLanguage(code string) (SentenceRules, bool) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if i := strings.IndexAny(code, "-_"); i >= 0 {
+		code = code[:i]
+	}
+	switch code { // ISO 639-2 codes of built-in languages
+	case "eng":
+		code = "en"
+	case "rus":
+		code = "ru"
+	}
+	languages.RLock()
+	defer languages.RUnlock()
+	rules, ok := languages.rules[code]
+	return rules, ok
+}
+
+// DetectLanguage chooses sentence rules by the script of the text
+func DetectLanguage(text string) SentenceRules {
+	const sampleSize = 10_000
+	var cyrillic, latin int
+	for i, r := range text {
+		if i > sampleSize {
+			break
+		}
+		switch {
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		}
+	}
+	if cyrillic > latin {
+		return Russian
+	}
+	return English
+}
+
+// applySentenceRules changes type of end of sentence marks that don't end the sentence
+func applySentenceRules(tokens []Token, rules SentenceRules) {
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i].Type != EndSentence {
+			continue
+		}
+		end := i // multiple marks, e.g. "..." or "?!"
+		for end+1 < len(tokens) && tokens[end+1].Type == EndSentence {
+			end++
+		}
+		var mark strings.Builder
+		for _, t := range tokens[i : end+1] {
+			mark.WriteString(t.Value)
+		}
+		word := ""
+		if i > 0 && tokens[i-1].Type == Word {
+			word = tokens[i-1].Value
+		}
+		if !rules.EndsSentence(word, mark.String(), nextWord(tokens, end+1)) {
+			for j := i; j <= end; j++ {
+				tokens[j].Type = Punctuation
+			}
+		}
+		i = end
+	}
+}
+
+// nextWord returns the word that starts the next sentence, skipping spaces, quotes and formatting
+func nextWord(tokens []Token, from int) string {
+	for _, t := range tokens[from:] {
+		switch t.Type {
+		case Space, Quote, BeginQuote, EndQuote, Markup:
+			continue
+		case Word:
+			return t.Value
+		}
+		return ""
+	}
+	return ""
+}
@@ -6,6 +6,8 @@ import (
 	"strings"
 	"unicode"
 	"unicode/utf8"
+
+	"github.com/pechorka/adhd-reader/pkg/markup"
 )
 
 type TokenType int
@@ -19,6 +21,9 @@ const (
 	Punctuation
 	Link
 	Space
+	Reference // image or link reference in markup
+	Markup    // formatting marker in markup
+	NewLine
 )
 
 type Token struct {
@@ -29,17 +34,33 @@ type Token struct {
 func tokenize(text string) []Token {
 	var tokens []Token
 
-	scanner := bufio.NewScanner(strings.NewReader(text))
-	scanner.Split(tokenizer)
-
+	// whole text is available, so tokenizer can look ahead as far as it needs,
+	// e.g. to find the end of the markup reference
+	data := []byte(text)
 	inTheMiddleOfQuote := false
-	for scanner.Scan() {
-		token := scanner.Text()
+	lineStart := true
+	for len(data) > 0 {
+		if lineStart {
+			lineStart = false
+			// headings, list items and code fences are recognized only at the beginning of the line
+			if size := markup.LinePrefixLen(data); size > 0 {
+				tokens = append(tokens, Token{Type: Markup, Value: string(data[:size])})
+				data = data[size:]
+				continue
+			}
+		}
+		advance, bToken, err := tokenizer(data, true)
+		if advance == 0 || err != nil {
+			break
+		}
+		data = data[advance:]
+		token := string(bToken)
 		if token == "" {
 			continue
 		}
 		tokenType := getTokenType(token)
 		tokenType, inTheMiddleOfQuote = handleQuoteToken(tokenType, inTheMiddleOfQuote)
+		lineStart = tokenType == NewLine
 		tokens = append(tokens, Token{Type: tokenType, Value: token})
 	}
 
@@ -47,23 +68,36 @@ func tokenize(text string) []Token {
 }
 
 var linkRegexp = regexp.MustCompile(`^(http://|https://|ftp://|www\.)`)
-var quoteRegexp = regexp.MustCompile(`^("|'|«|„|“|` + "`" + `|»|”)`)
+var quoteRegexp = regexp.MustCompile(`^("|'|«|„|“|»|”)`)
 
 func tokenizer(data []byte, atEOF bool) (advance int, token []byte, err error) {
 	nextPunctuationIsEndOfSentence := false
 	for i := 0; i < len(data); i++ {
-		if data[i] == ' ' {
+		if data[i] == ' ' || data[i] == '\n' {
 			if i == 0 {
 				return i + 1, data[i : i+1], nil
 			}
 			return i, data[:i], nil // return word first
 		}
-		if i == len(data)-1 && atEOF {
-			return len(data), data[:], nil
+		if size := markup.ReferenceLen(data[i:]); size > 0 {
+			if i == 0 {
+				return size, data[:size], nil
+			}
+			return i, data[:i], nil // return word first
+		}
+		if size := markup.EscapeLen(data[i:]); size > 0 {
+			i += size - 1 // escaped character is a part of the word
+			continue
+		}
+		if size := markup.MarkerLen(data[i:]); size > 0 {
+			if i == 0 {
+				return size, data[:size], nil
+			}
+			return i, data[:i], nil // return word first
 		}
 		if linkRegexp.Match(data[i:]) {
 			j := i
-			for ; j < len(data) && data[j] != ' '; j++ {
+			for ; j < len(data) && data[j] != ' ' && data[j] != '\n'; j++ {
 			}
 
 			if ok, size := isPunctuationBefore(data, j); ok {
@@ -86,6 +120,9 @@ func tokenizer(data []byte, atEOF bool) (advance int, token []byte, err error) {
 			return i, data[:i], nil // return quoted word first
 		}
 		if ok, size := isPunctuationAt(data, i); ok {
+			if isNumberSeparatorAt(data, i) { // e.g. "3.14" or "1,5"
+				continue
+			}
 			if i == 0 { // word before punctuation already parsed
 				return i + size, data[i : i+size], nil
 			}
@@ -102,6 +139,9 @@ func tokenizer(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		}
 	}
 
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil // last word
+	}
 	if atEOF {
 		return 0, nil, bufio.ErrFinalToken
 	}
@@ -130,6 +170,17 @@ func decodeRunBefore(data []byte, i int) (prevRune rune, size int) {
 	return prevRune, size
 }
 
+func isNumberSeparatorAt(data []byte, i int) bool {
+	if data[i] != '.' && data[i] != ',' {
+		return false
+	}
+	return i > 0 && i+1 < len(data) && isDigit(data[i-1]) && isDigit(data[i+1])
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
 func isPunctuationAfterNextRune(data []byte, i int) bool {
 	_, size := utf8.DecodeRune(data[i:])
 	ok, _ := isPunctuationAt(data, i+size)
@@ -148,15 +199,23 @@ func isPunctuationBefore(data []byte, i int) (bool, int) {
 
 func getTokenType(token string) TokenType {
 	switch token {
-	case ".", "!", "?":
+	case ".", "!", "?", "…":
 		return EndSentence
 	case ",", ":", ";", "—", "-":
 		return Punctuation
-	case "\"", "'", "«", "»", "„", "`", "”", "“", "‘", "’":
+	case "\"", "'", "«", "»", "„", "”", "“", "‘", "’":
 		return Quote
 	case " ":
 		return Space
+	case "\n":
+		return NewLine
 	default:
+		if size := markup.MarkerLen([]byte(token)); size > 0 && size == len(token) {
+			return Markup
+		}
+		if size := markup.ReferenceLen([]byte(token)); size > 0 && size == len(token) {
+			return Reference
+		}
 		if strings.HasPrefix(token, "http://") || strings.HasPrefix(token, "https://") ||
 			strings.HasPrefix(token, "ftp://") || strings.HasPrefix(token, "www.") {
 			return Link
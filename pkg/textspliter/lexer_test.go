@@ -53,3 +53,19 @@ func Test_tokenize(t *testing.T) {
 		require.Equal(t, expectTockens[i], tokens[i], "token %d: want %v, got %v", i, expectTockens[i], tokens[i])
 	}
 }
+
+func Test_tokenizeReferences(t *testing.T) {
+	text := `A \[1\] ![img. 1](attachment:a.png) [link](https://a.com).`
+	tokens := tokenize(text)
+	expectTockens := []Token{
+		{Type: Word, Value: "A"},
+		{Type: Space, Value: " "},
+		{Type: Word, Value: `\[1\]`},
+		{Type: Space, Value: " "},
+		{Type: Reference, Value: "![img. 1](attachment:a.png)"},
+		{Type: Space, Value: " "},
+		{Type: Reference, Value: "[link](https://a.com)"},
+		{Type: EndSentence, Value: "."},
+	}
+	require.Equal(t, expectTockens, tokens)
+}
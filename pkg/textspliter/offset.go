@@ -0,0 +1,38 @@
+package textspliter
+
+import (
+	"sort"
+	"unicode"
+
+	"github.com/pechorka/adhd-reader/pkg/markup"
+)
+
+// Offsets returns position of the beginning of every chunk in the text.
+// Position is the number of visible characters before the chunk: only characters of markup.Text
+// except white space are counted, so the same place of the text has the same position however text is split.
+func Offsets(chunks []string) []int64 {
+	offsets := make([]int64, len(chunks))
+	var offset int64
+	for i, chunk := range chunks {
+		offsets[i] = offset
+		offset += VisibleLen(chunk)
+	}
+	return offsets
+}
+
+// VisibleLen returns number of visible characters in text in markup, see Offsets
+func VisibleLen(text string) int64 {
+	var n int64
+	for _, r := range markup.Text(text) {
+		if !unicode.IsSpace(r) {
+			n++
+		}
+	}
+	return n
+}
+
+// ChunkAt returns index of the chunk that contains position, offsets are returned by Offsets
+func ChunkAt(offsets []int64, position int64) int64 {
+	i := sort.Search(len(offsets), func(i int) bool { return offsets[i] > position })
+	return int64(max(i-1, 0))
+}
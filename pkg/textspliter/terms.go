@@ -0,0 +1,49 @@
+package textspliter
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/pechorka/adhd-reader/pkg/markup"
+)
+
+// Terms returns words of the text in markup normalized for search: lower cased,
+// without surrounding punctuation and with language specific spelling variants unified.
+// Links and formatting are skipped, only visible words count.
+func Terms(text, language string) []string {
+	normalize := termNormalizers[languageCode(language)]
+	var terms []string
+	for _, token := range tokenize(markup.PlainText(text)) {
+		if token.Type != Word {
+			continue
+		}
+		term := strings.TrimFunc(strings.ToLower(token.Value), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		term = strings.ReplaceAll(term, "’", "'")
+		if normalize != nil {
+			term = normalize(term)
+		}
+		if term != "" {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// termNormalizers unify spelling variants of the lower cased word, so they are found by each other
+var termNormalizers = map[string]func(string) string{
+	"en": func(term string) string {
+		return strings.TrimSuffix(term, "'s") // possessive: "reader's" is found by "reader"
+	},
+	"ru": func(term string) string {
+		return strings.ReplaceAll(term, "ё", "е") // "ё" is usually written as "е"
+	},
+}
+
+func languageCode(language string) string {
+	if rules, ok := LookupLanguage(language); ok {
+		return rules.Language()
+	}
+	return language
+}
@@ -0,0 +1,40 @@
+package textspliter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTerms(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		language string
+		want     []string
+	}{
+		{
+			name:     "english",
+			text:     `The **reader's** "Guide", see https://example.com or [the site](https://a.com).`,
+			language: "en",
+			want:     []string{"the", "reader", "guide", "see", "or", "the", "site"},
+		},
+		{
+			name:     "russian",
+			text:     "# Ёжик\nЕщё 2 ёлки — и всё!",
+			language: "rus",
+			want:     []string{"ежик", "еще", "2", "елки", "и", "все"},
+		},
+		{
+			name:     "unknown language",
+			text:     "Ёжик reader's",
+			language: "",
+			want:     []string{"ёжик", "reader's"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, Terms(tt.text, tt.language))
+		})
+	}
+}
@@ -1,31 +1,246 @@
 package textspliter
 
 import (
-	"bytes"
 	"strings"
+	"unicode/utf8"
+
+	"github.com/pechorka/adhd-reader/pkg/markup"
+	"github.com/pechorka/adhd-reader/pkg/runeslice"
 )
 
+// MaxChunkLen is the hard limit of chunk length in runes, so every chunk fits into a single Telegram message
+const MaxChunkLen = 4000
+
+// Limits of chunk length in runes
+type Limits struct {
+	// Min is the length after which chunk can be closed at the end of paragraph
+	Min int
+	// Target is the length after which chunk is closed at the nearest end of sentence or paragraph
+	Target int
+	// Max is the length chunk never exceeds. Chunk that would exceed it is cut at the best
+	// break before: paragraph, sentence, clause or word.
+	Max int
+}
+
+// LimitsFor returns limits for chunks of chunkSize runes
+func LimitsFor(chunkSize int) Limits {
+	return Limits{
+		Min:    chunkSize / 2,
+		Target: chunkSize,
+		Max:    MaxChunkLen,
+	}
+}
+
+func (l Limits) normalize() Limits {
+	l.Max = max(l.Max, 1)
+	l.Target = max(min(l.Target, l.Max), 1)
+	l.Min = max(min(l.Min, l.Target), 0)
+	return l
+}
+
+// SplitText splits text in markup into chunks of about chunkSize runes
 func SplitText(text string, chunkSize int) []string {
+	return Split(text, LimitsFor(chunkSize), nil)
+}
+
+// Split splits text in markup into chunks, preferring paragraph boundaries and then sentence boundaries.
+// Formatting open at the end of a chunk is closed and opened again at the beginning of the next chunk.
+// Sentence rules are detected from the text if rules are nil.
+func Split(text string, limits Limits, rules SentenceRules) []string {
+	if rules == nil {
+		rules = DetectLanguage(text)
+	}
 	tokens := tokenize(text)
-	buffer := bytes.NewBuffer(nil)
-	buffer.Grow(chunkSize)
-	chunks := make([]string, 0, len(text)/chunkSize)
-
-	for i, token := range tokens {
-		buffer.WriteString(token.Value)
-		if buffer.Len() >= chunkSize { // got enough text, search for the nearest end of sentence
-			if token.Type == EndSentence {
-				if i+1 < len(tokens) && tokens[i+1].Type == EndSentence { // handle multiple punctuation marks, e.g. "!!!"
-					continue
-				}
-				chunks = append(chunks, strings.TrimSpace(buffer.String()))
-				buffer.Reset()
-				continue
+	applySentenceRules(tokens, rules)
+	s := &splitter{
+		tokens: splitLongTokens(tokens, max(limits.normalize().Max/2, 1)),
+		limits: limits.normalize(),
+	}
+	s.remaining = make([]int, len(s.tokens)+1)
+	for i := len(s.tokens) - 1; i >= 0; i-- {
+		s.remaining[i] = s.remaining[i+1] + utf8.RuneCountInString(s.tokens[i].Value)
+	}
+	return s.split()
+}
+
+type breakKind int
+
+const (
+	noBreak breakKind = iota
+	wordBreak
+	clauseBreak
+	sentenceBreak
+	paragraphBreak
+)
+
+type splitter struct {
+	tokens    []Token
+	remaining []int // remaining[i] is the length of text starting from token i
+	limits    Limits
+	tracker   markup.Tracker // formatting open at the beginning of the current chunk
+}
+
+func (s *splitter) split() []string {
+	var chunks []string
+	for start := s.skipSpaces(0); start < len(s.tokens); start = s.skipSpaces(start) {
+		end := s.chunkEnd(start)
+
+		var chunk strings.Builder
+		chunk.WriteString(s.tracker.Reopen())
+		for _, token := range s.tokens[start:end] {
+			chunk.WriteString(token.Value)
+			s.write(token)
+		}
+		chunk.WriteString(s.tracker.Close())
+		chunks = append(chunks, strings.TrimSpace(chunk.String()))
+		start = end
+	}
+	return chunks
+}
+
+// skipSpaces skips spaces and new lines at the beginning of the chunk
+func (s *splitter) skipSpaces(start int) int {
+	for ; start < len(s.tokens); start++ {
+		token := s.tokens[start]
+		if token.Type != Space && token.Type != NewLine {
+			break
+		}
+		s.write(token)
+	}
+	return start
+}
+
+func (s *splitter) write(token Token) {
+	if token.Type == Markup || token.Type == NewLine {
+		s.tracker.Write(token.Value)
+	}
+}
+
+// chunkEnd returns index of the token after the last token of the chunk starting at start
+func (s *splitter) chunkEnd(start int) int {
+	tracker := s.tracker.Clone()
+	length := utf8.RuneCountInString(tracker.Reopen())
+	atSentenceEnd := false
+	// best break so far, before and after minimal length
+	var bestBefore, bestAfter struct {
+		end  int
+		kind breakKind
+	}
+	for i := start; i < len(s.tokens); i++ {
+		token := s.tokens[i]
+		tokenLen := utf8.RuneCountInString(token.Value)
+		if i > start && length+tokenLen+utf8.RuneCountInString(tracker.Close()) > s.limits.Max {
+			switch {
+			case bestAfter.kind != noBreak:
+				return bestAfter.end
+			case bestBefore.kind != noBreak:
+				return bestBefore.end
+			default:
+				return i
+			}
+		}
+		length += tokenLen
+		if token.Type == Markup || token.Type == NewLine {
+			tracker.Write(token.Value)
+		}
+		switch token.Type {
+		case EndSentence:
+			atSentenceEnd = true
+		case Markup, EndQuote: // formatting or quote closed right after the end of sentence
+		default:
+			atSentenceEnd = false
+		}
+
+		kind := s.breakAfter(i, atSentenceEnd, &tracker)
+		if kind == noBreak {
+			continue
+		}
+		if s.remaining[i+1] > 0 && s.remaining[i+1] < s.limits.Min && length+s.remaining[i+1] <= s.limits.Max {
+			continue // don't leave too short tail
+		}
+		if kind == paragraphBreak && length >= s.limits.Min ||
+			kind >= sentenceBreak && length >= s.limits.Target {
+			return i + 1
+		}
+		if length >= s.limits.Min {
+			if kind >= bestAfter.kind {
+				bestAfter.end, bestAfter.kind = i+1, kind
 			}
+		} else if kind >= bestBefore.kind {
+			bestBefore.end, bestBefore.kind = i+1, kind
 		}
 	}
-	if buffer.Len() > 0 {
-		chunks = append(chunks, buffer.String())
+	return len(s.tokens)
+}
+
+// breakAfter returns kind of break after token i
+func (s *splitter) breakAfter(i int, atSentenceEnd bool, tracker *markup.Tracker) breakKind {
+	var next *Token
+	if i+1 < len(s.tokens) {
+		next = &s.tokens[i+1]
 	}
-	return chunks
+	if next != nil && (next.Type == Markup && tracker.IsClosing(next.Value) || next.Type == EndQuote) {
+		return noBreak // keep closing marker and quote in the same chunk
+	}
+	token := s.tokens[i]
+	switch {
+	case token.Type == NewLine && !tracker.InCodeBlock():
+		if s.isParagraphEnd(i) || next != nil && (next.Type == NewLine || next.Type == Markup) {
+			return paragraphBreak
+		}
+		return clauseBreak // line is wrapped in the middle of the sentence
+	case atSentenceEnd:
+		if next != nil && next.Type == EndSentence { // handle multiple punctuation marks, e.g. "!!!"
+			return noBreak
+		}
+		return sentenceBreak
+	case token.Type == Punctuation && next != nil && next.Type == Space:
+		return clauseBreak
+	case next != nil && next.Type == Space:
+		return wordBreak
+	}
+	return noBreak
+}
+
+// isParagraphEnd reports if new line at i ends the paragraph:
+// it follows the end of sentence or another new line
+func (s *splitter) isParagraphEnd(i int) bool {
+	for j := i - 1; j >= 0; j-- {
+		switch s.tokens[j].Type {
+		case Markup, Space:
+			continue
+		case EndSentence, NewLine:
+			return true
+		}
+		return false
+	}
+	return false
+}
+
+// splitLongTokens splits words and links longer than maxLen runes,
+// so they can be placed into chunks of limited length
+// endsWithEscape reports if s ends with backslash that escapes the next character,
+// backslash escaped by another backslash doesn't
+func endsWithEscape(s string) bool {
+	n := len(s) - len(strings.TrimRight(s, `\`))
+	return n%2 == 1
+}
+
+func splitLongTokens(tokens []Token, maxLen int) []Token {
+	result := tokens[:0:0]
+	for _, token := range tokens {
+		if (token.Type != Word && token.Type != Link) || utf8.RuneCountInString(token.Value) <= maxLen {
+			result = append(result, token)
+			continue
+		}
+		for value := token.Value; value != ""; {
+			part := runeslice.NRunes(value, maxLen)
+			if endsWithEscape(part) && len(part) < len(value) {
+				part = value[:len(part)+1] // don't split escape sequence
+			}
+			value = value[len(part):]
+			result = append(result, Token{Type: token.Type, Value: part})
+		}
+	}
+	return result
 }
@@ -61,14 +61,218 @@ func Test_splitText(t *testing.T) {
 				"Next sentence also has at the end т.д. Next sentence.",
 			},
 		},
+		{
+			name: "don't split inside image and link references",
+			text: "See ![Fig. 1. Chart](attachment:chart.png) and [the docs. Really](https://example.com/a.b) here. Next sentence.",
+			size: 3,
+			want: []string{
+				"See ![Fig. 1. Chart](attachment:chart.png) and [the docs. Really](https://example.com/a.b) here.",
+				"Next sentence.",
+			},
+		},
+		{
+			name: "don't split after titles",
+			text: "I met Mr. Smith and Dr. Watson. Next sentence.",
+			size: 3,
+			want: []string{"I met Mr. Smith and Dr. Watson.", "Next sentence."},
+		},
+		{
+			name: "don't split after abbreviation followed by number",
+			text: "See p. 5 and fig. 3 for details. Next sentence.",
+			size: 3,
+			want: []string{"See p. 5 and fig. 3 for details.", "Next sentence."},
+		},
+		{
+			name: "split after abbreviation at the end of sentence",
+			text: "Apples, pears etc. Next sentence.",
+			size: 3,
+			want: []string{"Apples, pears etc.", "Next sentence."},
+		},
+		{
+			name: "don't split initials",
+			text: "Written by A. S. Pushkin in 1833. Next sentence.",
+			size: 3,
+			want: []string{"Written by A. S. Pushkin in 1833.", "Next sentence."},
+		},
+		{
+			name: "don't split decimal numbers",
+			text: "Pi is 3.14 and e is 2,71. Next sentence.",
+			size: 3,
+			want: []string{"Pi is 3.14 and e is 2,71.", "Next sentence."},
+		},
+		{
+			name: "ellipsis",
+			text: "Wait... what… Really… Next sentence.",
+			size: 3,
+			want: []string{"Wait... what…", "Really…", "Next sentence."},
+		},
+		{
+			name: "keep closing quote after the end of sentence",
+			text: `He said "Stop." Then «Стой.» Next sentence.`,
+			size: 3,
+			want: []string{`He said "Stop."`, "Then «Стой.»", "Next sentence."},
+		},
+		{
+			name: "russian abbreviations",
+			text: "Живёт на ул. Ленина, см. рис. 2. Родился в 1799 г. Потом уехал к проф. Иванову.",
+			size: 3,
+			want: []string{
+				"Живёт на ул. Ленина, см. рис. 2.",
+				"Родился в 1799 г.",
+				"Потом уехал к проф. Иванову.",
+			},
+		},
+		{
+			name: "close and reopen formatting between chunks",
+			text: "**First sentence. __Second__ sentence.** Third **sentence.**\n## Heading. Of chapter.",
+			size: 3,
+			want: []string{
+				"**First sentence.**",
+				"**__Second__ sentence.**",
+				"Third **sentence.**",
+				"## Heading.",
+				"## Of chapter.",
+			},
+		},
+		{
+			name: "close and reopen code block between chunks",
+			text: "```\nfirst();\nsecond(). Third();\n```\nText.",
+			size: 3,
+			want: []string{
+				"```\nfirst();\nsecond().\n```",
+				"```\nThird();\n```",
+				"Text.",
+			},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			got := SplitText(tt.text, tt.size)
-			for i, chunk := range got {
-				require.Equal(t, tt.want[i], chunk)
-			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_split(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		limits Limits
+		want   []string
+	}{
+		{
+			name:   "prefer end of paragraph after min length",
+			text:   "First paragraph. Still first.\nSecond paragraph. Still second.",
+			limits: Limits{Min: 10, Target: 40, Max: 100},
+			want: []string{
+				"First paragraph. Still first.",
+				"Second paragraph. Still second.",
+			},
+		},
+		{
+			name:   "new line in the middle of sentence is not a paragraph",
+			text:   "Wrapped\nline. Next.",
+			limits: Limits{Min: 3, Target: 6, Max: 100},
+			want: []string{
+				"Wrapped\nline.",
+				"Next.",
+			},
+		},
+		{
+			name:   "heading starts new paragraph",
+			text:   "Some text\n# Chapter\nMore text.",
+			limits: Limits{Min: 5, Target: 100, Max: 100},
+			want: []string{
+				"Some text",
+				"# Chapter\nMore text.",
+			},
+		},
+		{
+			name:   "long sentence is cut at clause",
+			text:   "A long sentence, without end of sentence for a long time and then some",
+			limits: Limits{Min: 10, Target: 20, Max: 50},
+			want: []string{
+				"A long sentence,",
+				"without end of sentence for a long time and then",
+				"some",
+			},
+		},
+		{
+			name:   "long sentence is cut at word",
+			text:   "one two three four five six",
+			limits: Limits{Min: 2, Target: 5, Max: 10},
+			want:   []string{"one two", "three four", "five six"},
+		},
+		{
+			name:   "long word is cut",
+			text:   "abcdefghijkl",
+			limits: Limits{Min: 1, Target: 2, Max: 8},
+			want:   []string{"abcdefgh", "ijkl"},
+		},
+		{
+			name:   "formatting is closed when sentence is cut",
+			text:   "**bold words that do not fit**",
+			limits: Limits{Min: 1, Target: 2, Max: 16},
+			want:   []string{"**bold words**", "**that do not**", "**fit**"},
+		},
+		{
+			name:   "size is measured in runes",
+			text:   "Раз два. Три четыре.",
+			limits: Limits{Min: 4, Target: 8, Max: 100},
+			want:   []string{"Раз два.", "Три четыре."},
+		},
+		{
+			name:   "don't leave short tail",
+			text:   "Long enough sentence. Yes.",
+			limits: Limits{Min: 10, Target: 10, Max: 100},
+			want:   []string{"Long enough sentence. Yes."},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, Split(tt.text, tt.limits, nil))
 		})
 	}
 }
+
+func Test_splitLongTokens(t *testing.T) {
+	tokens := []Token{{Type: Word, Value: `abc\*de`}, {Type: Word, Value: `ab\\cd`}}
+	// escape sequence is not cut, escaped backslash at the cut is a complete sequence
+	want := []Token{{Type: Word, Value: `abc\*`}, {Type: Word, Value: "de"}, {Type: Word, Value: `ab\\`}, {Type: Word, Value: "cd"}}
+	require.Equal(t, want, splitLongTokens(tokens, 4))
+}
+
+func Test_lookupLanguage(t *testing.T) {
+	for code, want := range map[string]SentenceRules{"en": English, "en-US": English, "RU": Russian, "rus": Russian} {
+		rules, ok := LookupLanguage(code)
+		require.True(t, ok, code)
+		require.Equal(t, want, rules, code)
+	}
+	_, ok := LookupLanguage("xx")
+	require.False(t, ok)
+
+	require.Equal(t, Russian, DetectLanguage("Текст на русском языке with English"))
+	require.Equal(t, English, DetectLanguage("English text с русским"))
+}
+
+func Test_offsets(t *testing.T) {
+	text := "**First sentence.** Second sentence.\n# Heading\nThird sentence."
+	small := Split(text, Limits{Min: 1, Target: 1, Max: 100}, nil)
+	large := Split(text, Limits{Min: 100, Target: 100, Max: 100}, nil)
+	require.Len(t, large, 1)
+
+	offsets := Offsets(small)
+	require.Equal(t, []int64{0, 14, 29}, offsets) // markup and spaces are not counted
+	for i := range small {
+		require.EqualValues(t, i, ChunkAt(offsets, offsets[i]))
+		require.EqualValues(t, i, ChunkAt(offsets, offsets[i]+1))
+		require.Zero(t, ChunkAt(Offsets(large), offsets[i]))
+	}
+}
+
+func TestVisibleLen(t *testing.T) {
+	// bullets, link urls and images are not text
+	require.EqualValues(t, len("itemlink"), VisibleLen("- item [link](https://a.com)\n![image](attachment:a.png)"))
+}
@@ -11,6 +11,7 @@ import (
 	"unicode/utf8"
 
 	"github.com/PuerkitoBio/goquery"
+	"github.com/pechorka/adhd-reader/pkg/markup"
 	"github.com/pechorka/adhd-reader/pkg/runeslice"
 	"github.com/pechorka/adhd-reader/pkg/webscraper/internal/ua"
 	"github.com/pkg/errors"
@@ -80,5 +81,5 @@ func (s *Scraper) Scrape(ctx context.Context, link string) (string, string, erro
 
 	title += ": " + firstLine
 
-	return title, description, nil
+	return title, markup.Escape(description), nil
 }
@@ -1,18 +1,18 @@
 package telegraph
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
+	"github.com/pechorka/adhd-reader/pkg/markup"
 	"github.com/pechorka/adhd-reader/pkg/webscraper/internal/ua"
 	"github.com/pkg/errors"
-	"golang.org/x/net/html"
 )
 
 const LinkPattern = `https?:\/\/telegra\.ph\/\S+`
@@ -71,42 +71,21 @@ func (s *Scraper) Scrape(ctx context.Context, link string) (string, string, erro
 	return title, article, nil
 }
 
-// text is modified version of goquery.Selection.Text, that concatenates each node with new line
+// text converts article to markup, relative image sources are resolved against telegra.ph,
+// images with other non http(s) sources are replaced by their alt text
 func text(s *goquery.Selection) string {
-	var buf bytes.Buffer
-	var f func(*html.Node)
-	f = func(n *html.Node) {
-		if n.Type == html.TextNode {
-			buf.WriteString(n.Data)
-			buf.WriteByte('\n')
-		}
-		if n.Type == html.ElementNode && n.Data == "img" {
-			if imgLink, ok := findAttr(n, "src"); ok {
-				buf.WriteString(" https://telegra.ph")
-				buf.WriteString(imgLink)
-				buf.WriteByte('\n')
+	return markup.FromHTML(markup.HTMLOptions{
+		ResolveImage: func(src string) (string, bool) {
+			switch {
+			case strings.HasPrefix(src, "//"):
+				src = "https:" + src
+			case strings.HasPrefix(src, "/"):
+				src = "https://telegra.ph" + src
 			}
-		}
-
-		if n.FirstChild != nil {
-			for c := n.FirstChild; c != nil; c = c.NextSibling {
-				f(c)
+			if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+				return src, true
 			}
-		}
-	}
-	for _, n := range s.Nodes {
-		f(n)
-	}
-
-	return buf.String()
-}
-
-func findAttr(n *html.Node, attrKey string) (string, bool) {
-	for _, attr := range n.Attr {
-		if attr.Key == attrKey {
-			return attr.Val, true
-		}
-	}
-
-	return "", false
+			return "", false
+		},
+	}, s.Nodes...)
 }
@@ -18,6 +18,7 @@ var globalRegexp = mustBuildGlobalRegex(
 
 type scraper interface {
 	Support(link string) bool
+	// Scrape returns title and body in markup
 	Scrape(ctx context.Context, link string) (title string, body string, err error)
 }
 